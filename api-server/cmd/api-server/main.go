@@ -0,0 +1,108 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/codelogia/manor/api-server/pkg/auth"
+	"github.com/codelogia/manor/api-server/pkg/server"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(manorv1.AddToScheme(scheme))
+}
+
+func main() {
+	var addr string
+	var tokensFile string
+	var appBuilderPort int
+	var allowedBuilders string
+	flag.StringVar(&addr, "addr", ":8080", "The address the API binds to.")
+	flag.StringVar(&tokensFile, "tokens-file", "",
+		"The YAML file listing the users with their token and the namespaces they have access to.")
+	flag.IntVar(&appBuilderPort, "app-builder-port", server.DefaultAppBuilderPort,
+		"The port the app-builder Pods serve the source uploads on.")
+	flag.StringVar(&allowedBuilders, "allowed-builders", "",
+		"The comma-separated builder images the builds may use, besides the default builder.")
+	flag.Parse()
+
+	if tokensFile == "" {
+		setupLog.Error(fmt.Errorf("--tokens-file must be set"), "invalid flags")
+		os.Exit(1)
+	}
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+
+	authenticator, err := auth.Load(tokensFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load the tokens")
+		os.Exit(1)
+	}
+
+	cfg := ctrl.GetConfigOrDie()
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to create clientset")
+		os.Exit(1)
+	}
+
+	s := server.New(c, clientset, authenticator, ctrl.Log.WithName("api-server"))
+	s.AppBuilderPort = appBuilderPort
+	if allowedBuilders != "" {
+		s.AllowedBuilders = strings.Split(allowedBuilders, ",")
+	}
+	httpServer := &http.Server{Addr: addr, Handler: s.Handler()}
+
+	stop := ctrl.SetupSignalHandler()
+	go func() {
+		<-stop
+		httpServer.Shutdown(context.Background())
+	}()
+
+	setupLog.Info("starting api-server", "addr", addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		setupLog.Error(err, "problem running api-server")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1 contains the types of the version 1 of the manor REST API.
+package v1
+
+import (
+	"time"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// App is an app.
+type App struct {
+	// The name of the app.
+	Name string `json:"name"`
+	// The namespace of the app.
+	Namespace string `json:"namespace"`
+	// The version of the app, which must be sent back when updating it, so concurrent changes are
+	// detected.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// When the app was created.
+	CreatedAt time.Time `json:"createdAt"`
+	// The desired state of the app.
+	Spec manorv1.AppSpec `json:"spec"`
+	// The observed state of the app.
+	Status manorv1.AppStatus `json:"status"`
+}
+
+// AppList is a list of apps.
+type AppList struct {
+	Items []App `json:"items"`
+}
+
+// Artifact is a build of an app, which is deployed once it succeeds.
+type Artifact struct {
+	// The name of the artifact.
+	Name string `json:"name"`
+	// The namespace of the artifact.
+	Namespace string `json:"namespace"`
+	// When the artifact was created.
+	CreatedAt time.Time `json:"createdAt"`
+	// The desired state of the artifact.
+	Spec manorv1.ArtifactSpec `json:"spec"`
+	// The observed state of the artifact.
+	Status manorv1.ArtifactStatus `json:"status"`
+}
+
+// ArtifactList is a list of artifacts.
+type ArtifactList struct {
+	Items []Artifact `json:"items"`
+}
+
+// BuildRequest is the request to build an app, creating an artifact. The source is then uploaded to
+// the artifact.
+type BuildRequest struct {
+	// The image registry to override the default image registry. It can't be set, the artifacts
+	// are pushed to the image registry of the operator.
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// The directory of the app within the uploaded source.
+	Path string `json:"path,omitempty"`
+	// The globs of the files, relative to the app directory, that are built.
+	Include []string `json:"include,omitempty"`
+	// The globs of the files, relative to the app directory, that are left out of the build.
+	Exclude []string `json:"exclude,omitempty"`
+	// The buildpacks builder image building the app, which must be the default builder or one
+	// allowed by the API server.
+	Builder string `json:"builder,omitempty"`
+}
+
+// EnvPatch sets the environment variables with a value and unsets the ones that are null.
+type EnvPatch map[string]*string
+
+// LogLine is a line of the logs of an app, streamed as newline-delimited JSON.
+type LogLine struct {
+	// The replica that wrote the line.
+	Source string `json:"source"`
+	// When the line was written.
+	Time *time.Time `json:"time,omitempty"`
+	// The text of the line.
+	Text string `json:"text"`
+}
+
+// Error is the body of the error responses.
+type Error struct {
+	Error string `json:"error"`
+}
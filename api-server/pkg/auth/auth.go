@@ -0,0 +1,111 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auth authenticates the users of the API server with bearer tokens, each granting access
+// to a set of namespaces.
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// AllNamespaces grants a user access to all the namespaces.
+const AllNamespaces = "*"
+
+// User is an authenticated user.
+type User struct {
+	// The name of the user.
+	Name string `json:"user"`
+	// The token authenticating the user.
+	Token string `json:"token"`
+	// The namespaces the user has access to, or AllNamespaces.
+	Namespaces []string `json:"namespaces"`
+}
+
+// CanAccess returns whether the user has access to the namespace.
+func (u *User) CanAccess(namespace string) bool {
+	for _, ns := range u.Namespaces {
+		if ns == namespace || ns == AllNamespaces {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates users by their tokens.
+type Authenticator struct {
+	users map[[sha256.Size]byte]*User
+}
+
+// New constructs a new Authenticator of the users.
+func New(users []User) (*Authenticator, error) {
+	a := &Authenticator{users: make(map[[sha256.Size]byte]*User, len(users))}
+	for i := range users {
+		user := &users[i]
+		if user.Name == "" || user.Token == "" {
+			return nil, fmt.Errorf("invalid user %d: the user and token are required", i)
+		}
+		key := sha256.Sum256([]byte(user.Token))
+		if _, ok := a.users[key]; ok {
+			return nil, fmt.Errorf("invalid user %q: the token is already used by another user", user.Name)
+		}
+		a.users[key] = user
+	}
+	return a, nil
+}
+
+// Load constructs a new Authenticator of the users listed in the YAML file at path, e.g.
+// [{user: alice, token: <token>, namespaces: [team-a]}, {user: admin, token: <token>, namespaces: ["*"]}].
+func Load(path string) (*Authenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokens: %w", err)
+	}
+	var users []User
+	if err := yaml.UnmarshalStrict(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to load tokens: %w", err)
+	}
+	a, err := New(users)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokens: %w", err)
+	}
+	return a, nil
+}
+
+// Authenticate returns the user of the token, or false if no user has it. The tokens are looked up
+// by their hash, so the lookup doesn't leak the tokens through timing.
+func (a *Authenticator) Authenticate(token string) (*User, bool) {
+	if token == "" {
+		return nil, false
+	}
+	user, ok := a.users[sha256.Sum256([]byte(token))]
+	return user, ok
+}
+
+// AuthenticateRequest authenticates the bearer token of the request.
+func (a *Authenticator) AuthenticateRequest(r *http.Request) (*User, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, false
+	}
+	return a.Authenticate(strings.TrimPrefix(header, "Bearer "))
+}
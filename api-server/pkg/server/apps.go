@@ -0,0 +1,296 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/codelogia/manor/api-server/pkg/api/v1"
+	"github.com/codelogia/manor/cli/pkg/apps"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+func (s *Server) handleApps(w http.ResponseWriter, r *request) {
+	switch {
+	case r.name == "":
+		switch r.Method {
+		case http.MethodGet:
+			s.listApps(w, r)
+		case http.MethodPost:
+			s.createApp(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case r.subresource == "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getApp(w, r)
+		case http.MethodPut:
+			s.updateApp(w, r)
+		case http.MethodDelete:
+			s.deleteApp(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		}
+	case r.subresource == "env":
+		switch r.Method {
+		case http.MethodGet:
+			s.getAppEnv(w, r)
+		case http.MethodPatch:
+			s.patchAppEnv(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPatch)
+		}
+	case r.subresource == "routes":
+		switch r.Method {
+		case http.MethodGet:
+			s.getAppRoutes(w, r)
+		case http.MethodPut:
+			s.updateAppRoutes(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPut)
+		}
+	case r.subresource == "logs":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.appLogs(w, r)
+	case r.subresource == "builds":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.createBuild(w, r)
+	case r.subresource == "artifacts":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.listAppArtifacts(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) listApps(w http.ResponseWriter, r *request) {
+	apps := &manorv1.AppList{}
+	if err := s.client.List(r.Context(), apps, client.InNamespace(r.namespace)); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	list := apiv1.AppList{Items: make([]apiv1.App, 0, len(apps.Items))}
+	for i := range apps.Items {
+		list.Items = append(list.Items, toApp(&apps.Items[i]))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) createApp(w http.ResponseWriter, r *request) {
+	var body apiv1.App
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if body.Namespace != "" && body.Namespace != r.namespace {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("the namespace of the app must be %q", r.namespace))
+		return
+	}
+	app := &manorv1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      body.Name,
+			Namespace: r.namespace,
+		},
+		Spec: body.Spec,
+	}
+	if err := s.client.Create(r.Context(), app); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toApp(app))
+}
+
+func (s *Server) getApp(w http.ResponseWriter, r *request) {
+	app, ok := s.app(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toApp(app))
+}
+
+// updateApp replaces the spec of the app. When the body has a resource version, the update fails if
+// the app was changed since.
+func (s *Server) updateApp(w http.ResponseWriter, r *request) {
+	var body apiv1.App
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if body.Name != "" && body.Name != r.name {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("the name of the app must be %q", r.name))
+		return
+	}
+	app, ok := s.app(w, r)
+	if !ok {
+		return
+	}
+	if body.ResourceVersion != "" {
+		app.ResourceVersion = body.ResourceVersion
+	}
+	app.Spec = body.Spec
+	if err := s.client.Update(r.Context(), app); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toApp(app))
+}
+
+func (s *Server) deleteApp(w http.ResponseWriter, r *request) {
+	app := &manorv1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.name,
+			Namespace: r.namespace,
+		},
+	}
+	if err := s.client.Delete(r.Context(), app); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getAppEnv(w http.ResponseWriter, r *request) {
+	app, ok := s.app(w, r)
+	if !ok {
+		return
+	}
+	env := app.Spec.Env
+	if env == nil {
+		env = []corev1.EnvVar{}
+	}
+	writeJSON(w, http.StatusOK, env)
+}
+
+// patchAppEnv sets the environment variables of the patch with a value, and unsets the null ones.
+func (s *Server) patchAppEnv(w http.ResponseWriter, r *request) {
+	var patch apiv1.EnvPatch
+	if !decodeJSON(w, r, &patch) {
+		return
+	}
+	// The variables are set in the order of their names, so new ones are appended deterministically.
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		if err := apps.ValidateEnvVarName(name); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	app, err := s.patchApp(r.Context(), r, func(app *manorv1.App) {
+		for _, name := range names {
+			value := patch[name]
+			if value == nil {
+				app.Spec.Env = apps.UnsetEnvVar(app.Spec.Env, name)
+				continue
+			}
+			app.Spec.Env = apps.SetEnvVar(app.Spec.Env, corev1.EnvVar{Name: name, Value: *value})
+		}
+	})
+	if err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	env := app.Spec.Env
+	if env == nil {
+		env = []corev1.EnvVar{}
+	}
+	writeJSON(w, http.StatusOK, env)
+}
+
+func (s *Server) getAppRoutes(w http.ResponseWriter, r *request) {
+	app, ok := s.app(w, r)
+	if !ok {
+		return
+	}
+	routes := app.Spec.Routes
+	if routes == nil {
+		routes = []manorv1.Route{}
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+func (s *Server) updateAppRoutes(w http.ResponseWriter, r *request) {
+	var routes []manorv1.Route
+	if !decodeJSON(w, r, &routes) {
+		return
+	}
+	app, err := s.patchApp(r.Context(), r, func(app *manorv1.App) {
+		app.Spec.Routes = routes
+	})
+	if err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	routes = app.Spec.Routes
+	if routes == nil {
+		routes = []manorv1.Route{}
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// app gets the app of the request, writing the error response if it fails.
+func (s *Server) app(w http.ResponseWriter, r *request) (*manorv1.App, bool) {
+	app := &manorv1.App{}
+	if err := s.client.Get(r.Context(), client.ObjectKey{Name: r.name, Namespace: r.namespace}, app); err != nil {
+		s.writeAPIError(w, r, err)
+		return nil, false
+	}
+	return app, true
+}
+
+// patchApp applies mutate to the app of the request, see apps.Patch.
+func (s *Server) patchApp(ctx context.Context, r *request, mutate func(*manorv1.App)) (*manorv1.App, error) {
+	return apps.Patch(ctx, s.client, client.ObjectKey{Name: r.name, Namespace: r.namespace}, mutate)
+}
+
+func toApp(app *manorv1.App) apiv1.App {
+	return apiv1.App{
+		Name:            app.Name,
+		Namespace:       app.Namespace,
+		ResourceVersion: app.ResourceVersion,
+		CreatedAt:       app.CreationTimestamp.Time,
+		Spec:            app.Spec,
+		Status:          app.Status,
+	}
+}
+
+// sortArtifacts sorts the artifacts newest first.
+func sortArtifacts(artifacts []manorv1.Artifact) {
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		a, b := &artifacts[i], &artifacts[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return b.CreationTimestamp.Before(&a.CreationTimestamp)
+		}
+		return a.Name > b.Name
+	})
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/codelogia/manor/api-server/pkg/api/v1"
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/cli/pkg/apps"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+func (s *Server) handleArtifacts(w http.ResponseWriter, r *request) {
+	switch {
+	case r.name == "":
+		writeError(w, http.StatusNotFound, "not found")
+	case r.subresource == "":
+		switch r.Method {
+		case http.MethodGet:
+			s.getArtifact(w, r)
+		case http.MethodDelete:
+			s.deleteArtifact(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+	case r.subresource == "manifest" || r.subresource == "blobs" || r.subresource == "source":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.proxyUpload(w, r)
+	case r.subresource == "logs":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.artifactLogs(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// createBuild creates an artifact of the app, whose source is then uploaded to it. The artifacts
+// are pushed to the image registry of the operator, and built by the default builder or an allowed
+// one, so the users can't run arbitrary images in the app-builders.
+func (s *Server) createBuild(w http.ResponseWriter, r *request) {
+	var body apiv1.BuildRequest
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if body.ImageRegistry != "" {
+		writeError(w, http.StatusUnprocessableEntity, "the image registry of a build can't be set")
+		return
+	}
+	if body.Builder != "" && body.Builder != build.DefaultBuilder && !s.builderAllowed(body.Builder) {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("builder %q is not allowed", body.Builder))
+		return
+	}
+	app, ok := s.app(w, r)
+	if !ok {
+		return
+	}
+	artifact := &manorv1.Artifact{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: app.Name + "-",
+			Namespace:    app.Namespace,
+		},
+		Spec: manorv1.ArtifactSpec{
+			App:     app.Name,
+			Path:    body.Path,
+			Include: body.Include,
+			Exclude: body.Exclude,
+			Builder: body.Builder,
+		},
+	}
+	if err := s.client.Create(r.Context(), artifact); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toArtifact(artifact))
+}
+
+func (s *Server) builderAllowed(builder string) bool {
+	for _, allowed := range s.AllowedBuilders {
+		if builder == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listAppArtifacts(w http.ResponseWriter, r *request) {
+	if _, ok := s.app(w, r); !ok {
+		return
+	}
+	artifacts := &manorv1.ArtifactList{}
+	if err := s.client.List(r.Context(), artifacts, client.InNamespace(r.namespace)); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	sortArtifacts(artifacts.Items)
+	list := apiv1.ArtifactList{Items: []apiv1.Artifact{}}
+	for i := range artifacts.Items {
+		if artifacts.Items[i].Spec.App == r.name {
+			list.Items = append(list.Items, toArtifact(&artifacts.Items[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getArtifact(w http.ResponseWriter, r *request) {
+	artifact, ok := s.artifact(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toArtifact(artifact))
+}
+
+func (s *Server) deleteArtifact(w http.ResponseWriter, r *request) {
+	artifact := &manorv1.Artifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.name,
+			Namespace: r.namespace,
+		},
+	}
+	if err := s.client.Delete(r.Context(), artifact); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// artifactLogs writes the build log of the artifact as plain text.
+func (s *Server) artifactLogs(w http.ResponseWriter, r *request) {
+	artifact, ok := s.artifact(w, r)
+	if !ok {
+		return
+	}
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+	reader := &apps.BuildLogReader{Client: s.client, Clientset: s.clientset, HTTPClient: s.HTTPClient}
+	rc, _, err := reader.Open(r.Context(), artifact, follow)
+	if err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	if rc == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("the build log of artifact %s is not available", artifact.Name))
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(flushWriter{w}, rc)
+}
+
+// artifact gets the artifact of the request, writing the error response if it fails.
+func (s *Server) artifact(w http.ResponseWriter, r *request) (*manorv1.Artifact, bool) {
+	artifact := &manorv1.Artifact{}
+	if err := s.client.Get(r.Context(), client.ObjectKey{Name: r.name, Namespace: r.namespace}, artifact); err != nil {
+		s.writeAPIError(w, r, err)
+		return nil, false
+	}
+	return artifact, true
+}
+
+func toArtifact(artifact *manorv1.Artifact) apiv1.Artifact {
+	return apiv1.Artifact{
+		Name:      artifact.Name,
+		Namespace: artifact.Namespace,
+		CreatedAt: artifact.CreationTimestamp.Time,
+		Spec:      artifact.Spec,
+		Status:    artifact.Status,
+	}
+}
+
+// flushWriter flushes every write, so streamed responses reach the client as they're written.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
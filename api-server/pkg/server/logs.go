@@ -0,0 +1,135 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/codelogia/manor/api-server/pkg/api/v1"
+	"github.com/codelogia/manor/cli/pkg/logs"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// appLogs writes the logs of the running replicas of the app as newline-delimited JSON. The recent
+// lines of all the replicas are sorted chronologically, while the followed lines are written as
+// they come.
+func (s *Server) appLogs(w http.ResponseWriter, r *request) {
+	query := r.URL.Query()
+	opts := &corev1.PodLogOptions{Timestamps: true}
+	if v := query.Get("follow"); v != "" {
+		follow, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid follow parameter %q", v))
+			return
+		}
+		opts.Follow = follow
+	}
+	if v := query.Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil || since <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid since parameter %q", v))
+			return
+		}
+		seconds := int64(since.Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+		opts.SinceSeconds = &seconds
+	}
+
+	if _, ok := s.app(w, r); !ok {
+		return
+	}
+	pods := &corev1.PodList{}
+	if err := s.client.List(r.Context(), pods, client.InNamespace(r.namespace), client.MatchingLabelsSelector{
+		Selector: manorv1.AppReplicasSelector(r.name),
+	}); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	var running []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			running = append(running, pod)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(flushWriter{w})
+
+	if !opts.Follow {
+		var lines []logs.Line
+		for _, pod := range running {
+			if err := s.scanPodLogs(r.Context(), pod, opts, func(line logs.Line) {
+				lines = append(lines, line)
+			}); err != nil {
+				s.log.Error(err, "Failed to read the logs", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+			}
+		}
+		logs.Sort(lines)
+		for _, line := range lines {
+			encoder.Encode(toLogLine(line))
+		}
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, pod := range running {
+		wg.Add(1)
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+			if err := s.scanPodLogs(r.Context(), pod, opts, func(line logs.Line) {
+				mu.Lock()
+				defer mu.Unlock()
+				encoder.Encode(toLogLine(line))
+			}); err != nil && r.Context().Err() == nil {
+				s.log.Error(err, "Failed to stream the logs", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+			}
+		}(pod)
+	}
+	wg.Wait()
+}
+
+// scanPodLogs calls fn with every line of the logs of the Pod.
+func (s *Server) scanPodLogs(ctx context.Context, pod corev1.Pod, opts *corev1.PodLogOptions, fn func(logs.Line)) error {
+	rc, err := s.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return logs.Scan(rc, pod.Name, true, fn)
+}
+
+func toLogLine(line logs.Line) apiv1.LogLine {
+	logLine := apiv1.LogLine{Source: line.Source, Text: line.Text}
+	if !line.Time.IsZero() {
+		t := line.Time
+		logLine.Time = &t
+	}
+	return logLine
+}
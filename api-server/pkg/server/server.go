@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server implements the tenant-facing REST API of manor. The API translates the requests of
+// the users into manor resources, so users don't need access to the Kubernetes API, and proxies the
+// source uploads to the app-builders, so users don't need to reach them.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/codelogia/manor/api-server/pkg/api/v1"
+	"github.com/codelogia/manor/api-server/pkg/auth"
+)
+
+const (
+	// DefaultAppBuilderPort is the port the app-builder Pods serve the source uploads on.
+	DefaultAppBuilderPort = 8081
+	// DefaultUploadTimeout is how long an upload waits for the app-builder to be ready.
+	DefaultUploadTimeout = 2 * time.Minute
+)
+
+// Server is the API server.
+type Server struct {
+	client    client.Client
+	clientset kubernetes.Interface
+	auth      *auth.Authenticator
+	log       logr.Logger
+
+	// AppBuilderPort is the port the app-builder Pods serve the source uploads on.
+	AppBuilderPort int
+	// UploadTimeout is how long an upload waits for the app-builder to be ready.
+	UploadTimeout time.Duration
+	// HTTPClient is the client of the app-builders and the log store.
+	HTTPClient *http.Client
+	// AllowedBuilders are the builder images the builds may use, besides the default builder.
+	AllowedBuilders []string
+}
+
+// New constructs a new Server. The client manages the manor resources, and the clientset streams the
+// Pod logs.
+func New(c client.Client, clientset kubernetes.Interface, authenticator *auth.Authenticator, log logr.Logger) *Server {
+	return &Server{
+		client:         c,
+		clientset:      clientset,
+		auth:           authenticator,
+		log:            log,
+		AppBuilderPort: DefaultAppBuilderPort,
+		UploadTimeout:  DefaultUploadTimeout,
+		HTTPClient:     http.DefaultClient,
+	}
+}
+
+// Handler returns the http.Handler of the API. The requests are authenticated with a bearer token,
+// which grants access to the resources of some namespaces.
+//
+//	GET    /v1/namespaces/<ns>/apps                     lists the apps.
+//	POST   /v1/namespaces/<ns>/apps                     creates an app.
+//	GET    /v1/namespaces/<ns>/apps/<app>               returns an app.
+//	PUT    /v1/namespaces/<ns>/apps/<app>               replaces the spec of an app.
+//	DELETE /v1/namespaces/<ns>/apps/<app>               deletes an app.
+//	GET    /v1/namespaces/<ns>/apps/<app>/env           returns the environment of an app.
+//	PATCH  /v1/namespaces/<ns>/apps/<app>/env           sets and unsets environment variables.
+//	GET    /v1/namespaces/<ns>/apps/<app>/routes        returns the routes of an app.
+//	PUT    /v1/namespaces/<ns>/apps/<app>/routes        replaces the routes of an app.
+//	GET    /v1/namespaces/<ns>/apps/<app>/logs          returns the logs of an app as newline-delimited
+//	                                                    JSON, streaming them with follow=true. The
+//	                                                    since parameter is a duration.
+//	POST   /v1/namespaces/<ns>/apps/<app>/builds        creates an artifact of an app.
+//	GET    /v1/namespaces/<ns>/apps/<app>/artifacts     lists the artifacts of an app, newest first.
+//	GET    /v1/namespaces/<ns>/artifacts/<artifact>     returns an artifact.
+//	DELETE /v1/namespaces/<ns>/artifacts/<artifact>     deletes an artifact.
+//	POST   /v1/namespaces/<ns>/artifacts/<a>/manifest   replies with the blobs of a source manifest
+//	                                                    missing from the cache.
+//	POST   /v1/namespaces/<ns>/artifacts/<a>/blobs      uploads missing blobs as a gzipped tarball.
+//	POST   /v1/namespaces/<ns>/artifacts/<a>/source     uploads the source as a gzipped tarball, or
+//	                                                    its manifest, starting the build.
+//	GET    /v1/namespaces/<ns>/artifacts/<a>/logs       returns the build log, streaming it with
+//	                                                    follow=true.
+//
+// The uploads are proxied to the app-builder of the artifact, waiting for it to be ready. The
+// /healthz endpoint is not authenticated.
+func (s *Server) Handler() http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	router.HandleFunc("/v1/namespaces/", s.handleNamespace)
+	return router
+}
+
+// request is an authorized API request.
+type request struct {
+	*http.Request
+	user      *auth.User
+	namespace string
+	// name is the name of the resource, if any.
+	name string
+	// subresource is the name of the subresource, if any.
+	subresource string
+}
+
+func (s *Server) handleNamespace(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := s.auth.AuthenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/namespaces/"), "/")
+	if len(split) < 2 || len(split) > 4 || split[0] == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	req := &request{Request: r, user: user, namespace: split[0]}
+	if !user.CanAccess(req.namespace) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("user %q has no access to namespace %q", user.Name, req.namespace))
+		return
+	}
+	if len(split) > 2 {
+		req.name = split[2]
+		if req.name == "" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+	}
+	if len(split) > 3 {
+		req.subresource = split[3]
+	}
+
+	switch split[1] {
+	case "apps":
+		s.handleApps(w, req)
+	case "artifacts":
+		s.handleArtifacts(w, req)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiv1.Error{Error: message})
+}
+
+// writeAPIError writes the error of a request to the Kubernetes API, mapping its reason to the
+// status of the response. Unexpected errors are logged rather than exposed.
+func (s *Server) writeAPIError(w http.ResponseWriter, r *request, err error) {
+	switch {
+	case apierrors.IsNotFound(err):
+		writeError(w, http.StatusNotFound, err.Error())
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		writeError(w, http.StatusConflict, err.Error())
+	case apierrors.IsInvalid(err):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case apierrors.IsBadRequest(err):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.log.Error(err, "Request failed", "Method", r.Method, "Path", r.URL.Path, "User", r.user.Name)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// decodeJSON decodes the JSON body of the request into v, writing the error response if it's
+// invalid.
+func decodeJSON(w http.ResponseWriter, r *request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return false
+	}
+	return true
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/codelogia/manor/api-server/pkg/api/v1"
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// call sends a request to the API server, returning the status and the body of the response.
+func call(method, path, token string, body interface{}) (int, []byte) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		Expect(err).ToNot(HaveOccurred())
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, httpServer.URL+path, r)
+	Expect(err).ToNot(HaveOccurred())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	Expect(err).ToNot(HaveOccurred())
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	Expect(err).ToNot(HaveOccurred())
+	return res.StatusCode, data
+}
+
+func ensureNamespace(name string) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := k8sClient.Create(context.Background(), ns); err != nil && !apierrors.IsAlreadyExists(err) {
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
+
+var _ = Describe("API server", func() {
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		ensureNamespace("team")
+		ensureNamespace("other")
+	})
+
+	Context("authentication", func() {
+		It("rejects requests without a valid token", func() {
+			status, _ := call(http.MethodGet, "/v1/namespaces/team/apps", "", nil)
+			Expect(status).To(Equal(http.StatusUnauthorized))
+			status, _ = call(http.MethodGet, "/v1/namespaces/team/apps", "invalid", nil)
+			Expect(status).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("restricts the users to their namespaces", func() {
+			status, _ := call(http.MethodGet, "/v1/namespaces/team/apps", teamToken, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			status, _ = call(http.MethodGet, "/v1/namespaces/other/apps", teamToken, nil)
+			Expect(status).To(Equal(http.StatusForbidden))
+			status, _ = call(http.MethodGet, "/v1/namespaces/other/apps", adminToken, nil)
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		It("serves the health check without a token", func() {
+			res, err := http.Get(httpServer.URL + "/healthz")
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("apps", func() {
+		It("creates, updates and deletes an app", func() {
+			status, body := call(http.MethodPost, "/v1/namespaces/team/apps", teamToken, apiv1.App{Name: "crud"})
+			Expect(status).To(Equal(http.StatusCreated), string(body))
+			var app apiv1.App
+			Expect(json.Unmarshal(body, &app)).To(Succeed())
+			Expect(app.Namespace).To(Equal("team"))
+			Expect(app.ResourceVersion).ToNot(BeEmpty())
+
+			status, _ = call(http.MethodPost, "/v1/namespaces/team/apps", teamToken, apiv1.App{Name: "crud"})
+			Expect(status).To(Equal(http.StatusConflict))
+
+			status, body = call(http.MethodGet, "/v1/namespaces/team/apps", teamToken, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			var list apiv1.AppList
+			Expect(json.Unmarshal(body, &list)).To(Succeed())
+			Expect(list.Items).To(ContainElement(WithTransform(func(a apiv1.App) string { return a.Name }, Equal("crud"))))
+
+			replicas := int32(3)
+			update := app
+			update.Spec.Replicas = &replicas
+			status, body = call(http.MethodPut, "/v1/namespaces/team/apps/crud", teamToken, update)
+			Expect(status).To(Equal(http.StatusOK), string(body))
+			Expect(json.Unmarshal(body, &app)).To(Succeed())
+			Expect(app.Spec.Replicas).To(Equal(&replicas))
+
+			By("rejecting an update based on a stale version")
+			status, _ = call(http.MethodPut, "/v1/namespaces/team/apps/crud", teamToken, update)
+			Expect(status).To(Equal(http.StatusConflict))
+
+			By("rejecting an invalid spec")
+			update = app
+			update.Spec.State = "Paused"
+			status, _ = call(http.MethodPut, "/v1/namespaces/team/apps/crud", teamToken, update)
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+			status, _ = call(http.MethodDelete, "/v1/namespaces/team/apps/crud", teamToken, nil)
+			Expect(status).To(Equal(http.StatusNoContent))
+			status, _ = call(http.MethodGet, "/v1/namespaces/team/apps/crud", teamToken, nil)
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+
+		It("sets and unsets the environment of an app", func() {
+			status, _ := call(http.MethodPost, "/v1/namespaces/team/apps", teamToken, apiv1.App{Name: "env"})
+			Expect(status).To(Equal(http.StatusCreated))
+
+			one, two := "1", "2"
+			status, body := call(http.MethodPatch, "/v1/namespaces/team/apps/env/env", teamToken, apiv1.EnvPatch{"B": &two, "A": &one})
+			Expect(status).To(Equal(http.StatusOK), string(body))
+			var env []corev1.EnvVar
+			Expect(json.Unmarshal(body, &env)).To(Succeed())
+			Expect(env).To(Equal([]corev1.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}))
+
+			status, _ = call(http.MethodPatch, "/v1/namespaces/team/apps/env/env", teamToken, apiv1.EnvPatch{"A": nil})
+			Expect(status).To(Equal(http.StatusOK))
+			status, body = call(http.MethodGet, "/v1/namespaces/team/apps/env/env", teamToken, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(json.Unmarshal(body, &env)).To(Succeed())
+			Expect(env).To(Equal([]corev1.EnvVar{{Name: "B", Value: "2"}}))
+
+			status, _ = call(http.MethodPatch, "/v1/namespaces/team/apps/env/env", teamToken, apiv1.EnvPatch{"PORT": &one})
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("replaces the routes of an app", func() {
+			status, _ := call(http.MethodPost, "/v1/namespaces/team/apps", teamToken, apiv1.App{Name: "routes"})
+			Expect(status).To(Equal(http.StatusCreated))
+
+			routes := []manorv1.Route{{Host: "routes.example.com", Path: "/api"}}
+			status, body := call(http.MethodPut, "/v1/namespaces/team/apps/routes/routes", teamToken, routes)
+			Expect(status).To(Equal(http.StatusOK), string(body))
+
+			app := &manorv1.App{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: "routes", Namespace: "team"}, app)).To(Succeed())
+			Expect(app.Spec.Routes).To(Equal(routes))
+		})
+	})
+
+	Context("builds", func() {
+		var fakeBuilder *httptest.Server
+		var received chan *http.Request
+
+		BeforeEach(func() {
+			received = make(chan *http.Request, 1)
+			fakeBuilder = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+				received <- r
+				w.Write([]byte("building\n"))
+			}))
+			u, err := url.Parse(fakeBuilder.URL)
+			Expect(err).ToNot(HaveOccurred())
+			apiServer.AppBuilderPort, err = strconv.Atoi(u.Port())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			fakeBuilder.Close()
+		})
+
+		// createArtifact creates an artifact of a new app, along with the Secret of its app-builder.
+		createArtifact := func(appName string) apiv1.Artifact {
+			status, _ := call(http.MethodPost, "/v1/namespaces/team/apps", teamToken, apiv1.App{Name: appName})
+			Expect(status).To(Equal(http.StatusCreated))
+			status, body := call(http.MethodPost, "/v1/namespaces/team/apps/"+appName+"/builds", teamToken, apiv1.BuildRequest{Path: "app"})
+			Expect(status).To(Equal(http.StatusCreated), string(body))
+			var artifact apiv1.Artifact
+			Expect(json.Unmarshal(body, &artifact)).To(Succeed())
+			Expect(artifact.Spec.App).To(Equal(appName))
+			Expect(artifact.Spec.Path).To(Equal("app"))
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      artifact.Name + "-app-builder-creds",
+					Namespace: "team",
+					Labels:    map[string]string{manorv1.ArtifactLabel: artifact.Name, manorv1.AppLabel: appName},
+				},
+				Data: map[string][]byte{"token": []byte("builder-token")},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			return artifact
+		}
+
+		It("lists the artifacts of an app", func() {
+			artifact := createArtifact("list")
+
+			status, body := call(http.MethodGet, "/v1/namespaces/team/apps/list/artifacts", teamToken, nil)
+			Expect(status).To(Equal(http.StatusOK))
+			var list apiv1.ArtifactList
+			Expect(json.Unmarshal(body, &list)).To(Succeed())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0].Name).To(Equal(artifact.Name))
+
+			status, _ = call(http.MethodGet, "/v1/namespaces/team/artifacts/"+artifact.Name, teamToken, nil)
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		It("proxies the source upload to the app-builder Pod", func() {
+			artifact := createArtifact("pod")
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      artifact.Name + "-app-builder",
+					Namespace: "team",
+					Labels:    map[string]string{manorv1.ArtifactLabel: artifact.Name},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app-builder", Image: "app-builder"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.PodIP = "127.0.0.1"
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+			status, body := call(http.MethodPost, "/v1/namespaces/team/artifacts/"+artifact.Name+"/source?include=*.go", teamToken, "source")
+			Expect(status).To(Equal(http.StatusOK), string(body))
+			Expect(string(body)).To(Equal("building\n"))
+
+			var req *http.Request
+			Eventually(received).Should(Receive(&req))
+			Expect(req.Method).To(Equal(http.MethodPost))
+			Expect(req.URL.Path).To(Equal("/build"))
+			Expect(req.URL.Query().Get("include")).To(Equal("*.go"))
+			Expect(req.Header.Get("Authorization")).To(Equal("Bearer builder-token"))
+		})
+
+		It("proxies the source upload to the app-builder service job", func() {
+			artifact := createArtifact("job")
+
+			a := &manorv1.Artifact{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: artifact.Name, Namespace: "team"}, a)).To(Succeed())
+			a.Status.BuildJob = fakeBuilder.URL + "/jobs/123"
+			Expect(k8sClient.Status().Update(ctx, a)).To(Succeed())
+
+			status, body := call(http.MethodPost, "/v1/namespaces/team/artifacts/"+artifact.Name+"/source", teamToken, "source")
+			Expect(status).To(Equal(http.StatusOK), string(body))
+
+			var req *http.Request
+			Eventually(received).Should(Receive(&req))
+			Expect(req.Method).To(Equal(http.MethodPut))
+			Expect(req.URL.Path).To(Equal("/jobs/123/source"))
+			Expect(req.Header.Get("Authorization")).To(Equal("Bearer builder-token"))
+		})
+
+		It("rejects builds pushed to another image registry or built by a builder that is not allowed", func() {
+			status, _ := call(http.MethodPost, "/v1/namespaces/team/apps", teamToken, apiv1.App{Name: "restricted"})
+			Expect(status).To(Equal(http.StatusCreated))
+
+			status, body := call(http.MethodPost, "/v1/namespaces/team/apps/restricted/builds", teamToken, apiv1.BuildRequest{ImageRegistry: "registry.example.com"})
+			Expect(status).To(Equal(http.StatusUnprocessableEntity), string(body))
+
+			apiServer.AllowedBuilders = []string{"example.com/builder:tiny"}
+			defer func() { apiServer.AllowedBuilders = nil }()
+			status, body = call(http.MethodPost, "/v1/namespaces/team/apps/restricted/builds", teamToken, apiv1.BuildRequest{Builder: "example.com/builder:evil"})
+			Expect(status).To(Equal(http.StatusUnprocessableEntity), string(body))
+
+			for _, builder := range []string{"", build.DefaultBuilder, "example.com/builder:tiny"} {
+				status, body = call(http.MethodPost, "/v1/namespaces/team/apps/restricted/builds", teamToken, apiv1.BuildRequest{Builder: builder})
+				Expect(status).To(Equal(http.StatusCreated), string(body))
+			}
+		})
+
+		It("rejects uploads to another namespace", func() {
+			status, _ := call(http.MethodPost, "/v1/namespaces/other/artifacts/any/source", teamToken, "source")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+	})
+})
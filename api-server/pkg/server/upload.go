@@ -0,0 +1,145 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/apps"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// uploadPollInterval is the interval at which an upload checks whether the app-builder is ready.
+const uploadPollInterval = time.Second
+
+// uploadTarget is where the uploads of an artifact are proxied to.
+type uploadTarget struct {
+	// url is the URL of the app-builder Pod, or of the job on the app-builder service.
+	url *url.URL
+	// job is whether the build was dispatched to the app-builder service.
+	job   bool
+	token string
+}
+
+// proxyUpload proxies an upload to the app-builder of the artifact once it's ready, authenticating
+// it with the token of the artifact. The source upload is mapped to the build endpoint of the
+// app-builder Pod or to the source endpoint of the job.
+func (s *Server) proxyUpload(w http.ResponseWriter, r *request) {
+	artifact, ok := s.artifact(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.UploadTimeout)
+	defer cancel()
+	target, err := s.uploadTarget(ctx, artifact)
+	switch {
+	case err == wait.ErrWaitTimeout:
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("the app-builder of artifact %s is not ready", artifact.Name))
+		return
+	case err != nil:
+		s.writeAPIError(w, r, err)
+		return
+	}
+
+	method, path := http.MethodPost, "/"+r.subresource
+	if r.subresource == "source" {
+		if target.job {
+			method = http.MethodPut
+		} else {
+			path = "/build"
+		}
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.Method = method
+			req.URL.Scheme = target.url.Scheme
+			req.URL.Host = target.url.Host
+			req.URL.Path = target.url.Path + path
+			req.URL.RawPath = ""
+			req.Host = target.url.Host
+			req.Header.Set("Authorization", "Bearer "+target.token)
+		},
+		Transport: s.HTTPClient.Transport,
+		// The build output is streamed back as it's written.
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			s.log.Error(err, "Upload failed", "Artifact.Namespace", artifact.Namespace, "Artifact.Name", artifact.Name)
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to reach the app-builder of artifact %s", artifact.Name))
+		},
+	}
+	proxy.ServeHTTP(w, r.Request)
+}
+
+// uploadTarget waits for the app-builder of the artifact to be ready to receive the source.
+func (s *Server) uploadTarget(ctx context.Context, artifact *manorv1.Artifact) (*uploadTarget, error) {
+	target := &uploadTarget{}
+	err := wait.PollImmediateUntil(uploadPollInterval, func() (bool, error) {
+		if err := s.client.Get(ctx, client.ObjectKey{Name: artifact.Name, Namespace: artifact.Namespace}, artifact); err != nil {
+			return false, err
+		}
+		if artifact.HasCondition(manorv1.ArtifactCompleted) {
+			return false, apierrors.NewConflict(manorv1.GroupVersion.WithResource("artifacts").GroupResource(), artifact.Name, fmt.Errorf("the artifact was already built"))
+		}
+		if target.token == "" {
+			var err error
+			if target.token, err = apps.ArtifactToken(ctx, s.client, artifact); err != nil || target.token == "" {
+				return false, err
+			}
+		}
+		if artifact.Status.BuildJob != "" {
+			u, err := url.Parse(artifact.Status.BuildJob)
+			if err != nil {
+				return false, err
+			}
+			target.url, target.job = u, true
+			return true, nil
+		}
+		pod, err := apps.AppBuilderPod(ctx, s.client, artifact)
+		if err != nil || pod == nil || pod.Status.PodIP == "" || !podReady(pod) {
+			return false, err
+		}
+		target.url = &url.URL{Scheme: "http", Host: net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(s.AppBuilderPort))}
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -19,24 +19,67 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
 	"github.com/codelogia/manor/app-builder/pkg/server"
+	"github.com/codelogia/manor/app-builder/pkg/sourcecache"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
 )
 
 const timeout = time.Minute * 10
 
 func main() {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "manor-app-builder",
+		Endpoint:    os.Getenv(tracing.EndpointEnv),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer flushTraces(shutdownTracing)
+
+	if os.Getenv("MODE") == modeService {
+		serveBuildService()
+		return
+	}
+
 	addr := os.Getenv("ADDR")
 	buildDir := os.Getenv("BUILD_DIR")
 	token := os.Getenv("TOKEN")
 	appNamespace := os.Getenv("APP_NAMESPACE")
 	appName := os.Getenv("APP_NAME")
 	imageRegistry := os.Getenv("IMAGE_REGISTRY")
+	imageTag := os.Getenv("IMAGE_TAG")
+	builder := os.Getenv("BUILDER")
+	bindingsDir := os.Getenv("BINDINGS_DIR")
+	terminationMessagePath := os.Getenv("TERMINATION_MESSAGE_PATH")
+	logStoreURL := os.Getenv("LOG_STORE")
+	logKey := os.Getenv("LOG_KEY")
+	include, err := build.DecodeGlobs(os.Getenv("SOURCE_INCLUDE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	exclude, err := build.DecodeGlobs(os.Getenv("SOURCE_EXCLUDE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter := build.Filter{
+		Path:    os.Getenv("SOURCE_PATH"),
+		Include: include,
+		Exclude: exclude,
+	}
+	if err := filter.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc,
@@ -50,27 +93,101 @@ func main() {
 
 	log.Printf("build dir: %s\n", buildDir)
 
-	s := server.New()
+	buildLog, err := createBuildLog(logStoreURL, logKey, token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if buildLog != nil {
+		log.Printf("build log: %s\n", logKey)
+	}
+
+	// The sources are only cached on the volume mounted at SOURCE_CACHE. Without one, the Pod
+	// serves a single build, so the uploaded blobs are only staged next to the build dir for it.
+	var cache *sourcecache.Cache
+	if sourceCacheDir := os.Getenv("SOURCE_CACHE"); sourceCacheDir != "" {
+		cache = sourcecache.New(sourceCacheDir)
+		if err := cache.Prune(sourcecache.DefaultMaxAge); err != nil {
+			log.Println(err)
+		}
+	} else {
+		stagingDir := filepath.Join(filepath.Dir(buildDir), "source-staging")
+		defer os.RemoveAll(stagingDir)
+		cache = sourcecache.New(stagingDir)
+	}
+
+	done := make(chan struct{})
+	s := server.New(buildLog, cache)
 	go func() {
-		if err := s.Serve(
-			addr,
-			buildDir,
-			token,
-			appNamespace,
-			appName,
-			imageRegistry,
-		); err != nil {
+		if err := s.Serve(server.Options{
+			Addr:          addr,
+			BuildDir:      buildDir,
+			Token:         token,
+			AppNamespace:  appNamespace,
+			AppName:       appName,
+			ImageRegistry: imageRegistry,
+			ImageTag:      imageTag,
+			Builder:       builder,
+			BindingsDir:   bindingsDir,
+			Filter:        filter,
+		}); err != nil {
 			os.RemoveAll(buildDir)
-			log.Fatal(err)
+			closeBuildLog(buildLog)
+			// The spans of failed builds are flushed before exiting, as log.Fatal skips the deferred calls.
+			log.Println(err)
+			flushTraces(shutdownTracing)
+			os.Exit(1)
 		}
+		// The digest of the pushed image is reported to the operator through the termination message.
+		if terminationMessagePath != "" {
+			if err := ioutil.WriteFile(terminationMessagePath, []byte(s.Status().Digest), 0644); err != nil {
+				log.Println(err)
+			}
+		}
+		close(done)
 	}()
 
 	select {
+	case <-done:
 	case <-sigc:
 		log.Println("terminating...")
 	case <-ctx.Done():
 		os.RemoveAll(buildDir)
-		err := fmt.Errorf("build timed out")
-		log.Fatal(err)
+		closeBuildLog(buildLog)
+		log.Println("build timed out")
+		flushTraces(shutdownTracing)
+		os.Exit(1)
+	}
+	closeBuildLog(buildLog)
+}
+
+// createBuildLog creates the writer for the build log in the remote log store, if one is
+// configured.
+func createBuildLog(logStoreURL, logKey, token string) (io.WriteCloser, error) {
+	if logStoreURL == "" {
+		return nil, nil
+	}
+	if logKey == "" {
+		return nil, fmt.Errorf("LOG_KEY must be set when LOG_STORE is set")
+	}
+	store := logstore.NewHTTPStore(logStoreURL, token)
+	// The build log is not tied to the build timeout, so a timed out build can still complete it.
+	return store.Create(context.Background(), logKey)
+}
+
+func closeBuildLog(buildLog io.WriteCloser) {
+	if buildLog == nil {
+		return
+	}
+	if err := buildLog.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+// flushTraces exports the spans that are still buffered before the app-builder exits.
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		log.Println(err)
 	}
 }
@@ -0,0 +1,88 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/codelogia/manor/app-builder/pkg/service"
+)
+
+const (
+	modeService = "service"
+
+	defaultWorkers   = 2
+	defaultQueueSize = 10
+)
+
+// serveBuildService runs the app-builder as a long-lived build service until it's terminated.
+func serveBuildService() {
+	cfg := service.Config{
+		Addr:           os.Getenv("ADDR"),
+		BuildDir:       os.Getenv("BUILD_DIR"),
+		Token:          os.Getenv("SERVICE_TOKEN"),
+		Workers:        intFromEnv("WORKERS", defaultWorkers),
+		QueueSize:      intFromEnv("QUEUE_SIZE", defaultQueueSize),
+		Timeout:        timeout,
+		LogStoreURL:    os.Getenv("LOG_STORE"),
+		SourceCacheDir: os.Getenv("SOURCE_CACHE"),
+	}
+	if cfg.Token == "" {
+		log.Fatal("SERVICE_TOKEN must be set in service mode")
+	}
+	if cfg.SourceCacheDir == "" {
+		cfg.SourceCacheDir = filepath.Join(filepath.Dir(cfg.BuildDir), "source-cache")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+	go func() {
+		<-sigc
+		log.Println("terminating...")
+		cancel()
+	}()
+
+	log.Printf("serving build service with %d workers\n", cfg.Workers)
+	if err := service.New(cfg).Serve(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func intFromEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 1 {
+		log.Fatalf("%s must be a positive integer, got %q", key, value)
+	}
+	return i
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// platformBindingsDir is the directory the buildpacks read the service bindings from.
+const platformBindingsDir = "/platform/bindings"
+
+// bindingNameRegexp and bindingKeyRegexp match the valid binding names and entry keys.
+var (
+	bindingNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	bindingKeyRegexp  = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// WriteBindings writes the service bindings into dir, each binding in the directory of its name
+// with a file per entry, following the Service Binding specification.
+func WriteBindings(dir string, bindings map[string]map[string][]byte) error {
+	for name, entries := range bindings {
+		if !bindingNameRegexp.MatchString(name) {
+			return fmt.Errorf("failed to write bindings: invalid binding name %q", name)
+		}
+		bindingDir := filepath.Join(dir, name)
+		if err := os.MkdirAll(bindingDir, 0700); err != nil {
+			return fmt.Errorf("failed to write bindings: %w", err)
+		}
+		for key, value := range entries {
+			if !bindingKeyRegexp.MatchString(key) || key == "." || key == ".." {
+				return fmt.Errorf("failed to write bindings: invalid key %q of binding %q", key, name)
+			}
+			if err := ioutil.WriteFile(filepath.Join(bindingDir, key), value, 0600); err != nil {
+				return fmt.Errorf("failed to write bindings: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// createBindingsVolume creates a docker volume holding the service bindings in dir, so they can be
+// mounted into the build containers, which may run on a remote docker daemon. It returns an empty
+// name when there are no bindings.
+func createBindingsVolume(ctx context.Context, dir, builder string, env []string, out io.Writer) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	files, err := bindingFiles(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bindings: %w", err)
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to create bindings volume: %w", err)
+	}
+	volume := fmt.Sprintf("manor-bindings-%x", suffix)
+	cmd := exec.CommandContext(ctx, "docker", "volume", "create", volume)
+	cmd.Env = env
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to create bindings volume: %w", err)
+	}
+
+	// The bindings are streamed into the volume by a container of the builder image, which is
+	// pulled for the build anyway.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBindingsTar(pw, dir, files))
+	}()
+	cmd = exec.CommandContext(
+		ctx,
+		"docker", "run", "--rm", "-i",
+		"--user", "root",
+		"--entrypoint", "tar",
+		"--volume", volume+":"+platformBindingsDir,
+		builder,
+		"-xf", "-", "-C", platformBindingsDir,
+	)
+	cmd.Env = env
+	cmd.Stdin = pr
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	pr.Close()
+	if err != nil {
+		removeBindingsVolume(volume, env, out)
+		return "", fmt.Errorf("failed to populate bindings volume: %w", err)
+	}
+	return volume, nil
+}
+
+// removeBindingsVolume removes the docker volume of the service bindings. It's not tied to the
+// build context, so the credentials are removed from the docker daemon after a canceled build.
+func removeBindingsVolume(volume string, env []string, out io.Writer) {
+	cmd := exec.Command("docker", "volume", "rm", "--force", volume)
+	cmd.Env = env
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(out, "failed to remove bindings volume: %v\n", err)
+	}
+}
+
+// bindingFiles returns the slash-separated paths of the binding entries in dir, sorted. Kubernetes
+// projects the secrets through hidden directories and links, which are followed and skipped.
+func bindingFiles(dir string) ([]string, error) {
+	bindings, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	for _, binding := range bindings {
+		if strings.HasPrefix(binding.Name(), ".") {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(dir, binding.Name()))
+		if err != nil {
+			// Not a directory.
+			continue
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), "..") {
+				continue
+			}
+			files = append(files, binding.Name()+"/"+entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// writeBindingsTar writes the binding files in dir as a tarball readable by the build containers.
+func writeBindingsTar(w io.Writer, dir string, files []string) error {
+	tw := tar.NewWriter(w)
+	dirs := map[string]bool{}
+	for _, file := range files {
+		bindingDir := strings.SplitN(file, "/", 2)[0]
+		if !dirs[bindingDir] {
+			dirs[bindingDir] = true
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     bindingDir + "/",
+				Mode:     0755,
+			}); err != nil {
+				return err
+			}
+		}
+		// Reading the file follows the links of the projected secrets.
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file,
+			Mode:     0644,
+			Size:     int64(len(data)),
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
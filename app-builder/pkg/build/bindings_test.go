@@ -0,0 +1,192 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+// readTar returns the entries of a tarball by name, with the content of the regular files.
+func readTar(b []byte) map[string]string {
+	entries := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		Expect(err).NotTo(HaveOccurred())
+		content, err := ioutil.ReadAll(tr)
+		Expect(err).NotTo(HaveOccurred())
+		entries[header.Name] = string(content)
+	}
+}
+
+var _ = Describe("WriteBindings", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bindings")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("writes each binding in the directory of its name with a file per entry", func() {
+		Expect(build.WriteBindings(dir, map[string]map[string][]byte{
+			"db": {"type": []byte("postgresql"), "password": []byte("secret")},
+		})).To(Succeed())
+		b, err := ioutil.ReadFile(filepath.Join(dir, "db", "type"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal("postgresql"))
+		b, err = ioutil.ReadFile(filepath.Join(dir, "db", "password"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal("secret"))
+	})
+
+	It("rejects an invalid binding name", func() {
+		err := build.WriteBindings(dir, map[string]map[string][]byte{"../db": {"type": []byte("postgresql")}})
+		Expect(err).To(MatchError(ContainSubstring(`invalid binding name "../db"`)))
+	})
+
+	It("rejects an invalid entry key", func() {
+		err := build.WriteBindings(dir, map[string]map[string][]byte{"db": {"..": []byte("postgresql")}})
+		Expect(err).To(MatchError(ContainSubstring(`invalid key ".." of binding "db"`)))
+	})
+})
+
+var _ = Describe("Run with bindings", func() {
+	const (
+		image   = "registry.example.com/default/app:app-1"
+		builder = "registry.example.com/builder:latest"
+		digest  = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	)
+
+	var tools *fakeTools
+	var bindingsDir string
+
+	BeforeEach(func() {
+		tools = newFakeTools()
+		var err error
+		bindingsDir, err = ioutil.TempDir("", "bindings")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		tools.cleanup()
+		os.RemoveAll(bindingsDir)
+	})
+
+	run := func(env ...string) error {
+		var out bytes.Buffer
+		_, err := build.Run(context.Background(), build.Options{
+			Dir:         tools.dir,
+			Image:       image,
+			Builder:     builder,
+			BindingsDir: bindingsDir,
+			Env:         append(os.Environ(), env...),
+		}, &out, func(build.Phase) {})
+		return err
+	}
+
+	// projectSecret lays out a binding the way Kubernetes projects a Secret: the entries are links
+	// to a hidden timestamped directory, through the ..data link.
+	projectSecret := func(name string, entries map[string]string) {
+		bindingDir := filepath.Join(bindingsDir, name)
+		dataDir := filepath.Join(bindingDir, "..2020_01_01_00_00_00.000000000")
+		Expect(os.MkdirAll(dataDir, 0755)).To(Succeed())
+		Expect(os.Symlink(filepath.Base(dataDir), filepath.Join(bindingDir, "..data"))).To(Succeed())
+		for key, value := range entries {
+			Expect(ioutil.WriteFile(filepath.Join(dataDir, key), []byte(value), 0644)).To(Succeed())
+			Expect(os.Symlink(filepath.Join("..data", key), filepath.Join(bindingDir, key))).To(Succeed())
+		}
+	}
+
+	It("mounts a volume of the bindings, populated by tar as root in the builder, into the build", func() {
+		projectSecret("db", map[string]string{"type": "postgresql", "password": "secret"})
+		projectSecret("cache", map[string]string{"type": "redis"})
+		// Hidden files and files outside the binding directories are skipped.
+		Expect(ioutil.WriteFile(filepath.Join(bindingsDir, "README"), []byte("readme"), 0644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(bindingsDir, ".hidden"), 0755)).To(Succeed())
+
+		Expect(run(tools.output("docker", "image", `["registry.example.com/default/app@`+digest+`"]`))).To(Succeed())
+
+		calls := tools.calls()
+		Expect(calls).To(HaveLen(6))
+		volume := regexp.MustCompile(`^docker volume create (manor-bindings-[0-9a-f]{16})$`).FindStringSubmatch(calls[0])
+		Expect(volume).To(HaveLen(2), calls[0])
+		Expect(calls[1:]).To(Equal([]string{
+			"docker run --rm -i --user root --entrypoint tar --volume " + volume[1] + ":/platform/bindings " +
+				builder + " -xf - -C /platform/bindings",
+			"pack build " + image + " --builder " + builder + " --volume " + volume[1] + ":/platform/bindings:ro",
+			"docker volume rm --force " + volume[1],
+			"docker push " + image,
+			"docker image inspect --format {{json .RepoDigests}} " + image,
+		}))
+		Expect(readTar(tools.stdin())).To(Equal(map[string]string{
+			"cache/":      "",
+			"cache/type":  "redis",
+			"db/":         "",
+			"db/password": "secret",
+			"db/type":     "postgresql",
+		}))
+	})
+
+	It("removes the volume when it can't be populated", func() {
+		projectSecret("db", map[string]string{"type": "postgresql"})
+
+		err := run("FAKE_FAIL=run")
+		Expect(err).To(MatchError(ContainSubstring("failed to populate bindings volume")))
+		calls := tools.calls()
+		Expect(calls).To(HaveLen(3))
+		Expect(calls[2]).To(MatchRegexp(`^docker volume rm --force manor-bindings-[0-9a-f]{16}$`))
+	})
+
+	It("removes the volume when the build fails", func() {
+		projectSecret("db", map[string]string{"type": "postgresql"})
+
+		err := run("FAKE_FAIL=build")
+		Expect(err).To(MatchError(ContainSubstring("failed to build image")))
+		calls := tools.calls()
+		Expect(calls).To(HaveLen(4))
+		Expect(calls[3]).To(MatchRegexp(`^docker volume rm --force manor-bindings-[0-9a-f]{16}$`))
+	})
+
+	It("doesn't create a volume without bindings", func() {
+		Expect(run(tools.output("docker", "image", `["registry.example.com/default/app@`+digest+`"]`))).To(Succeed())
+		Expect(tools.calls()).To(Equal([]string{
+			"pack build " + image + " --builder " + builder,
+			"docker push " + image,
+			"docker image inspect --format {{json .RepoDigests}} " + image,
+		}))
+	})
+})
@@ -0,0 +1,304 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package build implements building an app image from its source and pushing it to the image
+// registry.
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+)
+
+// DefaultBuilder is the buildpacks builder used when none is provided.
+const DefaultBuilder = "paketobuildpacks/builder:full"
+
+// Phase is the phase of a build.
+type Phase string
+
+const (
+	// PhasePending means the build is waiting for the source.
+	PhasePending Phase = "Pending"
+	// PhaseReceiving means the source is being received.
+	PhaseReceiving Phase = "Receiving"
+	// PhaseQueued means the source was received and the build is waiting for a worker.
+	PhaseQueued Phase = "Queued"
+	// PhaseBuilding means the image is being built.
+	PhaseBuilding Phase = "Building"
+	// PhasePushing means the image is being pushed to the image registry.
+	PhasePushing Phase = "Pushing"
+	// PhaseSucceeded means the image was built and pushed.
+	PhaseSucceeded Phase = "Succeeded"
+	// PhaseFailed means the build failed.
+	PhaseFailed Phase = "Failed"
+	// PhaseCanceled means the build was canceled.
+	PhaseCanceled Phase = "Canceled"
+)
+
+// Completed returns whether the phase is final.
+func (p Phase) Completed() bool {
+	return p == PhaseSucceeded || p == PhaseFailed || p == PhaseCanceled
+}
+
+// Image returns the image name of an App, tagged when tag is not empty.
+func Image(imageRegistry, appNamespace, appName, tag string) string {
+	image := fmt.Sprintf("%s/%s/%s", imageRegistry, appNamespace, appName)
+	if tag != "" {
+		image += ":" + tag
+	}
+	return image
+}
+
+// digestRegexp matches an image digest.
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Extract extracts the files of a gzipped tarball selected by the filter into dir. The layout of
+// the source is kept, so the app root ends up at AppDir(dir, filter).
+func Extract(r io.Reader, dir string, filter Filter) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to extract source: %w", err)
+	}
+
+	// The links are created last, so no file is written through them.
+	var links []*tar.Header
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract source: %w", err)
+		}
+
+		filePath, err := securePath(dir, hdr.Name)
+		if err != nil {
+			return fmt.Errorf("failed to extract source: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeDir && !filter.MatchDir(hdr.Name) || hdr.Typeflag != tar.TypeDir && !filter.Match(hdr.Name) {
+			continue
+		}
+		fileInfo := hdr.FileInfo()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, fileInfo.Mode()|0700); err != nil {
+				return fmt.Errorf("failed to extract source: %w", err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(filePath, tr, fileInfo.Mode()); err != nil {
+				return fmt.Errorf("failed to extract source: %w", err)
+			}
+		case tar.TypeSymlink:
+			// Links must stay within dir, so no file can be written outside of it through them.
+			if filepath.IsAbs(hdr.Linkname) {
+				return fmt.Errorf("failed to extract source: invalid link %q", hdr.Name)
+			}
+			if _, err := securePath(dir, filepath.Join(filepath.Dir(filepath.FromSlash(hdr.Name)), hdr.Linkname)); err != nil {
+				return fmt.Errorf("failed to extract source: invalid link %q", hdr.Name)
+			}
+			links = append(links, hdr)
+		}
+	}
+
+	// The deepest links are created first, so no link is created through another one.
+	sort.SliceStable(links, func(i, j int) bool {
+		return depth(links[i].Name) > depth(links[j].Name)
+	})
+	for _, hdr := range links {
+		filePath, _ := securePath(dir, hdr.Name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to extract source: %w", err)
+		}
+		if err := os.Symlink(hdr.Linkname, filePath); err != nil {
+			return fmt.Errorf("failed to extract source: %w", err)
+		}
+	}
+
+	if fi, err := os.Stat(AppDir(dir, filter)); err != nil || !fi.IsDir() {
+		return fmt.Errorf("failed to extract source: the path %q is not a directory of the source", filter.Root())
+	}
+
+	return nil
+}
+
+// AppDir returns the directory of the app root within the source extracted into dir.
+func AppDir(dir string, filter Filter) string {
+	return filepath.Join(dir, filepath.FromSlash(filter.Root()))
+}
+
+// depth returns the number of directories in the slash-separated name.
+func depth(name string) int {
+	return strings.Count(path.Clean(name), "/")
+}
+
+// securePath returns the path of name under dir, rejecting names that escape dir.
+func securePath(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if p != filepath.Clean(dir) && !strings.HasPrefix(p, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	return p, nil
+}
+
+func writeFile(filePath string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Options are the options of a build.
+type Options struct {
+	// Dir is the directory of the app root within the source.
+	Dir string
+	// Image is the name of the image to build and push.
+	Image string
+	// Builder is the buildpacks builder image. DefaultBuilder is used when empty.
+	Builder string
+	// Env is the environment of the pack and docker commands. The current process environment is
+	// used when nil.
+	Env []string
+	// BindingsDir is the directory of the service bindings provided to the buildpacks, each in the
+	// directory of its name. No bindings are provided when empty.
+	BindingsDir string
+}
+
+// Run builds the image from the source and pushes it to the image registry, writing the output of
+// both steps to out. The progress is reported through onPhase. It returns the digest of the pushed
+// image. Both steps are traced as children of the span of ctx.
+func Run(ctx context.Context, opts Options, out io.Writer, onPhase func(Phase)) (string, error) {
+	builder := opts.Builder
+	if builder == "" {
+		builder = DefaultBuilder
+	}
+
+	onPhase(PhaseBuilding)
+	if err := pack(ctx, opts, builder, out); err != nil {
+		return "", err
+	}
+
+	onPhase(PhasePushing)
+	return push(ctx, opts, out)
+}
+
+// pack builds the image from the source with the builder.
+func pack(ctx context.Context, opts Options, builder string, out io.Writer) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "pack build")
+	span.SetAttributes(attribute.String("manor.image", opts.Image), attribute.String("manor.builder", builder))
+	defer func() { tracing.End(span, err) }()
+
+	args := []string{"build", opts.Image, "--builder", builder}
+	bindingsVolume, err := createBindingsVolume(ctx, opts.BindingsDir, builder, opts.Env, out)
+	if err != nil {
+		return err
+	}
+	if bindingsVolume != "" {
+		defer removeBindingsVolume(bindingsVolume, opts.Env, out)
+		args = append(args, "--volume", bindingsVolume+":"+platformBindingsDir+":ro")
+	}
+	cmd := exec.CommandContext(ctx, "pack", args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+	return nil
+}
+
+// push pushes the image to the image registry, returning its digest.
+func push(ctx context.Context, opts Options, out io.Writer) (digest string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "docker push")
+	span.SetAttributes(attribute.String("manor.image", opts.Image))
+	defer func() { tracing.End(span, err) }()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"docker", "push", opts.Image,
+	)
+	cmd.Env = opts.Env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to push image: %w", err)
+	}
+
+	digest, err = pushedDigest(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to push image: %w", err)
+	}
+	span.SetAttributes(attribute.String("manor.digest", digest))
+	return digest, nil
+}
+
+// pushedDigest returns the digest of the image pushed to its repository, which docker records in
+// the repository digests of the image once the registry accepted the manifest.
+func pushedDigest(ctx context.Context, opts Options) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(
+		ctx,
+		"docker", "image", "inspect", "--format", "{{json .RepoDigests}}", opts.Image,
+	)
+	cmd.Env = opts.Env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var repoDigests []string
+	if err := json.Unmarshal(stdout.Bytes(), &repoDigests); err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	// The image may have been pushed to other repositories before, so only the digest of its own
+	// repository is relevant.
+	repository := opts.Image
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	for _, repoDigest := range repoDigests {
+		if digest := strings.TrimPrefix(repoDigest, repository+"@"); digest != repoDigest && digestRegexp.MatchString(digest) {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("no digest of the image in repository %s", repository)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+// fakeTools replaces the pack and docker commands with scripts that log their arguments to the
+// calls file and print the content of the file named by the FAKE_<TOOL>_<SUBCOMMAND> environment
+// variable, e.g. FAKE_DOCKER_IMAGE. The standard input of their run subcommand is saved to the
+// run.stdin file. They fail when the FAKE_FAIL environment variable holds their subcommand.
+type fakeTools struct {
+	dir  string
+	path string
+}
+
+func newFakeTools() *fakeTools {
+	dir, err := ioutil.TempDir("", "build-tools")
+	Expect(err).NotTo(HaveOccurred())
+	for _, tool := range []string{"pack", "docker"} {
+		script := `#!/bin/sh
+echo "` + tool + ` $*" >> "` + filepath.Join(dir, "calls") + `"
+if [ "$1" = "run" ]; then
+  cat > "` + filepath.Join(dir, "run.stdin") + `"
+fi
+if [ "$FAKE_FAIL" = "$1" ]; then
+  echo "$1 failed" >&2
+  exit 1
+fi
+output=$(eval echo "\$FAKE_` + strings.ToUpper(tool) + `_$(echo "$1" | tr a-z A-Z)")
+if [ -n "$output" ]; then
+  cat "$output"
+fi
+`
+		Expect(ioutil.WriteFile(filepath.Join(dir, tool), []byte(script), 0755)).To(Succeed())
+	}
+	tools := &fakeTools{dir: dir, path: os.Getenv("PATH")}
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+tools.path)
+	return tools
+}
+
+// output writes the output of the tool subcommand to a file, returning its environment variable.
+func (t *fakeTools) output(tool, subcommand, output string) string {
+	name := "FAKE_" + strings.ToUpper(tool) + "_" + strings.ToUpper(subcommand)
+	p := filepath.Join(t.dir, name)
+	Expect(ioutil.WriteFile(p, []byte(output), 0644)).To(Succeed())
+	return name + "=" + p
+}
+
+// calls returns the command lines the tools were called with.
+func (t *fakeTools) calls() []string {
+	b, err := ioutil.ReadFile(filepath.Join(t.dir, "calls"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	Expect(err).NotTo(HaveOccurred())
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// stdin returns the standard input of the last run subcommand.
+func (t *fakeTools) stdin() []byte {
+	b, err := ioutil.ReadFile(filepath.Join(t.dir, "run.stdin"))
+	Expect(err).NotTo(HaveOccurred())
+	return b
+}
+
+func (t *fakeTools) cleanup() {
+	os.Setenv("PATH", t.path)
+	os.RemoveAll(t.dir)
+}
+
+var _ = Describe("Run", func() {
+	const (
+		image  = "registry.example.com/default/app:app-1"
+		digest = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		other  = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	)
+
+	var tools *fakeTools
+
+	BeforeEach(func() {
+		tools = newFakeTools()
+	})
+
+	AfterEach(func() {
+		tools.cleanup()
+	})
+
+	run := func(env ...string) (string, []build.Phase, error) {
+		var phases []build.Phase
+		var out bytes.Buffer
+		digest, err := build.Run(context.Background(), build.Options{
+			Dir:   tools.dir,
+			Image: image,
+			Env:   append(os.Environ(), env...),
+		}, &out, func(phase build.Phase) {
+			phases = append(phases, phase)
+		})
+		return digest, phases, err
+	}
+
+	It("builds and pushes the image, returning the digest of its repository", func() {
+		got, phases, err := run(
+			// The push output isn't trusted to hold the digest.
+			tools.output("docker", "push", "app-1: digest: "+other+" size: 1\n"),
+			tools.output("docker", "image", `["registry.example.com/default/other@`+other+`",`+
+				`"registry.example.com/default/app@`+digest+`"]`),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal(digest))
+		Expect(phases).To(Equal([]build.Phase{build.PhaseBuilding, build.PhasePushing}))
+		Expect(tools.calls()).To(Equal([]string{
+			"pack build " + image + " --builder " + build.DefaultBuilder,
+			"docker push " + image,
+			"docker image inspect --format {{json .RepoDigests}} " + image,
+		}))
+	})
+
+	It("fails when the repository of the image has no digest", func() {
+		_, _, err := run(tools.output("docker", "image", `["registry.example.com/default/app-other@`+digest+`"]`))
+		Expect(err).To(MatchError(ContainSubstring("no digest of the image in repository registry.example.com/default/app")))
+	})
+
+	It("fails when the image can't be inspected", func() {
+		_, _, err := run("FAKE_FAIL=image")
+		Expect(err).To(MatchError(ContainSubstring("image failed")))
+	})
+
+	It("fails when the image can't be pushed", func() {
+		_, phases, err := run("FAKE_FAIL=push")
+		Expect(err).To(MatchError(ContainSubstring("failed to push image")))
+		Expect(phases).To(Equal([]build.Phase{build.PhaseBuilding, build.PhasePushing}))
+		Expect(tools.calls()).To(HaveLen(2))
+	})
+
+	It("doesn't push the image when the build fails", func() {
+		_, phases, err := run("FAKE_FAIL=build")
+		Expect(err).To(MatchError(ContainSubstring("failed to build image")))
+		Expect(phases).To(Equal([]build.Phase{build.PhaseBuilding}))
+		Expect(tools.calls()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,224 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Filter selects the part of the source that ends up in the build context.
+//
+// Path is the slash-separated directory of the app root within the source, and files outside of
+// it are left out. Include and Exclude are globs relative to the app root, where "**" matches any
+// number of directories and a glob without a slash matches at any depth. When Include is not
+// empty, only the files it matches are kept. Excluded files are left out even when included, and
+// matching a directory matches everything under it.
+type Filter struct {
+	Path    string
+	Include []string
+	Exclude []string
+}
+
+// Validate validates the filter.
+func (f Filter) Validate() error {
+	if f.Path != "" {
+		if path.IsAbs(f.Path) || path.Clean(f.Path) == ".." || strings.HasPrefix(path.Clean(f.Path), "../") {
+			return fmt.Errorf("invalid path %q: it must be relative to the source root", f.Path)
+		}
+	}
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid glob %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Root returns the cleaned path of the app root, which is "." for the source root.
+func (f Filter) Root() string {
+	root := path.Clean("/" + f.Path)
+	if root == "/" {
+		return "."
+	}
+	return root[1:]
+}
+
+// Match returns whether the file or directory at the slash-separated name, relative to the
+// source root, is selected.
+func (f Filter) Match(name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if root := f.Root(); root != "." {
+		if name != root && !strings.HasPrefix(name, root+"/") {
+			return false
+		}
+		name = strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+	}
+	if name == "" {
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDir returns whether the directory at the slash-separated name, relative to the source
+// root, may contain selected files. It allows skipping whole directories when walking the source.
+func (f Filter) MatchDir(name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	root := f.Root()
+	if root != "." {
+		// The directories leading to the app root are walked through.
+		if name == "" || root == name || strings.HasPrefix(root, name+"/") {
+			return true
+		}
+		if !strings.HasPrefix(name, root+"/") {
+			return false
+		}
+		name = strings.TrimPrefix(name, root+"/")
+	}
+	if name == "" {
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob returns whether the pattern matches name or one of its parent directories.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	nameSegments := strings.Split(name, "/")
+	for i := 1; i <= len(nameSegments); i++ {
+		if matchSegments(patternSegments, nameSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// FilterFromQuery returns the filter set by the path, include and exclude upload parameters.
+func FilterFromQuery(query url.Values) Filter {
+	return Filter{
+		Path:    query.Get("path"),
+		Include: query["include"],
+		Exclude: query["exclude"],
+	}
+}
+
+// Query returns the upload parameters setting the filter.
+func (f Filter) Query() url.Values {
+	query := url.Values{}
+	if f.Path != "" {
+		query.Set("path", f.Path)
+	}
+	for _, pattern := range f.Include {
+		query.Add("include", pattern)
+	}
+	for _, pattern := range f.Exclude {
+		query.Add("exclude", pattern)
+	}
+	return query
+}
+
+// EncodeGlobs encodes the globs into a single value, e.g. of an environment variable. The globs are
+// JSON-encoded, as they may contain any separator.
+func EncodeGlobs(globs []string) string {
+	// Encoding a slice of strings never fails.
+	b, _ := json.Marshal(globs)
+	return string(b)
+}
+
+// DecodeGlobs decodes the globs encoded by EncodeGlobs. An empty value holds no globs.
+func DecodeGlobs(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var globs []string
+	if err := json.Unmarshal([]byte(value), &globs); err != nil {
+		return nil, fmt.Errorf("invalid globs %q: %w", value, err)
+	}
+	return globs, nil
+}
+
+// MergeFilter merges the filter set by the upload parameters into the filter configured for the
+// Artifact. The Artifact configuration wins, and an upload parameter conflicting with it is an
+// error.
+func MergeFilter(artifact, upload Filter) (Filter, error) {
+	merged := artifact
+	if upload.Path != "" && path.Clean(upload.Path) != path.Clean(artifact.Path) {
+		if artifact.Path != "" {
+			return Filter{}, fmt.Errorf("the path %q conflicts with the path %q of the Artifact", upload.Path, artifact.Path)
+		}
+		merged.Path = upload.Path
+	}
+	if len(upload.Include) > 0 && !reflect.DeepEqual(upload.Include, artifact.Include) {
+		if len(artifact.Include) > 0 {
+			return Filter{}, fmt.Errorf("the include globs conflict with the include globs of the Artifact")
+		}
+		merged.Include = upload.Include
+	}
+	if len(upload.Exclude) > 0 && !reflect.DeepEqual(upload.Exclude, artifact.Exclude) {
+		if len(artifact.Exclude) > 0 {
+			return Filter{}, fmt.Errorf("the exclude globs conflict with the exclude globs of the Artifact")
+		}
+		merged.Exclude = upload.Exclude
+	}
+	return merged, merged.Validate()
+}
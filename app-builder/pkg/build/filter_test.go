@@ -0,0 +1,159 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package build_test
+
+import (
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+var _ = Describe("Filter", func() {
+	DescribeTable("matching files",
+		func(filter build.Filter, name string, matched bool) {
+			Expect(filter.Match(name)).To(Equal(matched))
+		},
+		Entry("the source root without a filter", build.Filter{}, "", true),
+		Entry("any file without a filter", build.Filter{}, "src/main.go", true),
+		Entry("a file under the path", build.Filter{Path: "web"}, "web/index.js", true),
+		Entry("the path itself", build.Filter{Path: "web/"}, "web", true),
+		Entry("a file outside of the path", build.Filter{Path: "web"}, "api/main.go", false),
+		Entry("a sibling sharing the path prefix", build.Filter{Path: "web"}, "website/index.js", false),
+		Entry("an unclean name", build.Filter{Path: "web"}, "./web/../web/index.js", true),
+		Entry("a glob without a slash at the root",
+			build.Filter{Include: []string{"*.go"}}, "main.go", true),
+		Entry("a glob without a slash at any depth",
+			build.Filter{Include: []string{"*.go"}}, "pkg/a/main.go", true),
+		Entry("a file not included",
+			build.Filter{Include: []string{"*.go"}}, "README.md", false),
+		Entry("a glob with a slash anchored at the app root",
+			build.Filter{Include: []string{"src/*.js"}}, "lib/src/index.js", false),
+		Entry("a double star matching no directory",
+			build.Filter{Include: []string{"src/**/*.js"}}, "src/index.js", true),
+		Entry("a double star matching directories",
+			build.Filter{Include: []string{"src/**/*.js"}}, "src/a/b/index.js", true),
+		Entry("a trailing double star",
+			build.Filter{Include: []string{"src/**"}}, "src/a/index.js", true),
+		Entry("everything under an included directory",
+			build.Filter{Include: []string{"src"}}, "src/a/index.js", true),
+		Entry("everything under an excluded directory",
+			build.Filter{Exclude: []string{"node_modules"}}, "web/node_modules/a/index.js", false),
+		Entry("an excluded file even when included",
+			build.Filter{Include: []string{"src/**"}, Exclude: []string{"*_test.go"}}, "src/main_test.go", false),
+		Entry("globs relative to the path",
+			build.Filter{Path: "web", Include: []string{"src/*.js"}}, "web/src/index.js", true),
+		Entry("globs not relative to the source root",
+			build.Filter{Path: "web", Include: []string{"web/src/*.js"}}, "web/src/index.js", false),
+		Entry("a glob with a comma",
+			build.Filter{Include: []string{"a,b.*"}}, "a,b.txt", true),
+		Entry("a character class",
+			build.Filter{Include: []string{"[ab].txt"}}, "b.txt", true),
+	)
+
+	DescribeTable("matching directories to walk",
+		func(filter build.Filter, name string, matched bool) {
+			Expect(filter.MatchDir(name)).To(Equal(matched))
+		},
+		Entry("the source root", build.Filter{Path: "apps/web"}, "", true),
+		Entry("a directory leading to the path", build.Filter{Path: "apps/web"}, "apps", true),
+		Entry("a directory under the path", build.Filter{Path: "apps/web"}, "apps/web/src", true),
+		Entry("a directory outside of the path", build.Filter{Path: "apps/web"}, "apps/api", false),
+		Entry("a directory not included yet possibly holding included files",
+			build.Filter{Include: []string{"*.go"}}, "pkg", true),
+		Entry("an excluded directory",
+			build.Filter{Exclude: []string{"node_modules"}}, "a/node_modules", false),
+	)
+
+	DescribeTable("validating",
+		func(filter build.Filter, valid bool) {
+			if valid {
+				Expect(filter.Validate()).To(Succeed())
+			} else {
+				Expect(filter.Validate()).NotTo(Succeed())
+			}
+		},
+		Entry("an empty filter", build.Filter{}, true),
+		Entry("a relative path", build.Filter{Path: "apps/web"}, true),
+		Entry("a path cleaned within the source", build.Filter{Path: "apps/../web"}, true),
+		Entry("an absolute path", build.Filter{Path: "/web"}, false),
+		Entry("the parent directory", build.Filter{Path: ".."}, false),
+		Entry("a path outside of the source", build.Filter{Path: "web/../../other"}, false),
+		Entry("valid globs", build.Filter{Include: []string{"**/*.go"}, Exclude: []string{"[a-z]*"}}, true),
+		Entry("a malformed include glob", build.Filter{Include: []string{"["}}, false),
+		Entry("an empty exclude glob", build.Filter{Exclude: []string{""}}, false),
+	)
+
+	It("round-trips through the upload parameters", func() {
+		filter := build.Filter{Path: "web", Include: []string{"src/**", "a,b"}, Exclude: []string{"*.md"}}
+		query, err := url.ParseQuery(filter.Query().Encode())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(build.FilterFromQuery(query)).To(Equal(filter))
+		Expect(build.Filter{}.Query()).To(BeEmpty())
+	})
+
+	DescribeTable("merging the upload parameters",
+		func(artifact, upload, merged build.Filter, valid bool) {
+			got, err := build.MergeFilter(artifact, upload)
+			if !valid {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(merged))
+		},
+		Entry("without upload parameters",
+			build.Filter{Path: "web"}, build.Filter{}, build.Filter{Path: "web"}, true),
+		Entry("completing the Artifact filter",
+			build.Filter{Path: "web"}, build.Filter{Include: []string{"src/**"}},
+			build.Filter{Path: "web", Include: []string{"src/**"}}, true),
+		Entry("repeating the Artifact filter",
+			build.Filter{Path: "web", Exclude: []string{"*.md"}}, build.Filter{Path: "./web", Exclude: []string{"*.md"}},
+			build.Filter{Path: "web", Exclude: []string{"*.md"}}, true),
+		Entry("a conflicting path",
+			build.Filter{Path: "web"}, build.Filter{Path: "api"}, build.Filter{}, false),
+		Entry("conflicting include globs",
+			build.Filter{Include: []string{"src/**"}}, build.Filter{Include: []string{"lib/**"}}, build.Filter{}, false),
+		Entry("conflicting exclude globs",
+			build.Filter{Exclude: []string{"*.md"}}, build.Filter{Exclude: []string{"*.txt"}}, build.Filter{}, false),
+		Entry("an invalid upload path",
+			build.Filter{}, build.Filter{Path: "../other"}, build.Filter{}, false),
+	)
+
+	Describe("encoding globs", func() {
+		It("keeps the globs holding separators whole", func() {
+			globs := []string{"*.{js,ts}", "a b/**", `"quoted"`}
+			decoded, err := build.DecodeGlobs(build.EncodeGlobs(globs))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(Equal(globs))
+		})
+
+		It("decodes an empty value to no globs", func() {
+			decoded, err := build.DecodeGlobs("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoded).To(BeEmpty())
+		})
+
+		It("rejects a value that isn't encoded", func() {
+			_, err := build.DecodeGlobs("src/**,*.go")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,151 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// NewFileStore constructs a new Store that keeps each log as a file under dir. A log is complete
+// once a marker file exists next to it.
+func NewFileStore(dir string) Store {
+	return &fileStore{dir: dir}
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	logPath := s.logPath(key)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log %q: %w", key, err)
+	}
+	os.Remove(s.completePath(key))
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log %q: %w", key, err)
+	}
+	return &fileWriter{File: f, completePath: s.completePath(key)}, nil
+}
+
+func (s *fileStore) Open(ctx context.Context, key string, follow bool) (io.ReadCloser, error) {
+	f, err := os.Open(s.logPath(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to open log %q: %w", key, err)
+		}
+		if !follow {
+			return nil, ErrNotFound
+		}
+	}
+	if !follow {
+		return f, nil
+	}
+	return &fileFollower{ctx: ctx, store: s, key: key, f: f}, nil
+}
+
+func (s *fileStore) Ref(key string) string {
+	return "file://" + s.logPath(key)
+}
+
+func (s *fileStore) Delete(ctx context.Context, key string) error {
+	for _, p := range []string{s.logPath(key), s.completePath(key)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete log %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) logPath(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key)+".log")
+}
+
+func (s *fileStore) completePath(key string) string {
+	return s.logPath(key) + ".complete"
+}
+
+func (s *fileStore) complete(key string) bool {
+	_, err := os.Stat(s.completePath(key))
+	return err == nil
+}
+
+type fileWriter struct {
+	*os.File
+	completePath string
+}
+
+func (w *fileWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	f, err := os.Create(w.completePath)
+	if err != nil {
+		return fmt.Errorf("failed to mark log as complete: %w", err)
+	}
+	return f.Close()
+}
+
+// fileFollower reads a log file as it is written, until the log is marked as complete. The file is
+// opened lazily, so a log can be followed before its build has started.
+type fileFollower struct {
+	ctx   context.Context
+	store *fileStore
+	key   string
+	f     *os.File
+}
+
+func (r *fileFollower) Read(p []byte) (int, error) {
+	for {
+		if r.f == nil {
+			f, err := os.Open(r.store.logPath(r.key))
+			if err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+			r.f = f
+		}
+		if r.f != nil {
+			n, err := r.f.Read(p)
+			if n > 0 || err != io.EOF {
+				return n, err
+			}
+			if r.store.complete(r.key) {
+				// Output may have been written between the last read and the completion.
+				n, err := r.f.Read(p)
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+		}
+		if err := wait(r.ctx); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *fileFollower) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
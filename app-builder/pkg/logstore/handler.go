@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HandlerPrefix is the path prefix under which NewHandler serves the logs.
+const HandlerPrefix = "/logs/"
+
+// Authorizer authorizes a request to read or write the build log of an Artifact.
+type Authorizer func(r *http.Request, namespace, artifact string) error
+
+// NewHandler constructs a new http.Handler that serves the logs in the store under
+// /logs/<namespace>/<artifact>. A GET returns the log, and the follow=true query parameter streams
+// it until it is complete. A PUT writes the request body to the log, completing it once the body
+// ends. A DELETE deletes the log. Every request must be allowed by authorize.
+func NewHandler(store Store, authorize Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		namespace, artifact, err := SplitKey(strings.TrimPrefix(r.URL.Path, HandlerPrefix))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err := authorize(r, namespace, artifact); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		key := Key(namespace, artifact)
+		switch r.Method {
+		case http.MethodGet:
+			serveLog(w, r, store, key)
+		case http.MethodPut:
+			writeLog(w, r, store, key)
+		case http.MethodDelete:
+			if err := store.Delete(r.Context(), key); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func serveLog(w http.ResponseWriter, r *http.Request, store Store, key string) {
+	follow := r.URL.Query().Get("follow") == "true"
+	rc, err := store.Open(r.Context(), key, follow)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// The headers are sent right away, so a client following a log that has no output yet isn't
+	// left waiting for the response.
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeLog(w http.ResponseWriter, r *http.Request, store Store, key string) {
+	// The log must be completed even if the writer goes away, so it's not tied to the request.
+	wc, err := store.Create(context.Background(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, copyErr := io.Copy(wc, r.Body)
+	if err := wc.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if copyErr != nil {
+		http.Error(w, copyErr.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// URL returns the URL under which a log served by NewHandler at baseURL can be retrieved.
+func URL(baseURL, key string) string {
+	return strings.TrimSuffix(baseURL, "/") + HandlerPrefix + key
+}
+
+// NewHTTPStore constructs a new Store that is a client of a store served by NewHandler at baseURL.
+// The token is sent as a bearer token on every request.
+func NewHTTPStore(baseURL, token string) Store {
+	return &httpStore{baseURL: baseURL, token: token, client: http.DefaultClient}
+}
+
+type httpStore struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (s *httpStore) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	req, err := s.newRequest(ctx, http.MethodPut, key, pipeReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log %q: %w", key, err)
+	}
+	w := &httpWriter{PipeWriter: pipeWriter, done: make(chan error, 1)}
+	go func() {
+		res, err := s.client.Do(req)
+		if err != nil {
+			pipeReader.CloseWithError(err)
+			w.done <- err
+			return
+		}
+		defer res.Body.Close()
+		err = checkResponse(res)
+		pipeReader.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (s *httpStore) Open(ctx context.Context, key string, follow bool) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log %q: %w", key, err)
+	}
+	if follow {
+		req.URL.RawQuery = "follow=true"
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log %q: %w", key, err)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if err := checkResponse(res); err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to open log %q: %w", key, err)
+	}
+	return res.Body, nil
+}
+
+func (s *httpStore) Ref(key string) string {
+	return URL(s.baseURL, key)
+}
+
+func (s *httpStore) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete log %q: %w", key, err)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete log %q: %w", key, err)
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return fmt.Errorf("failed to delete log %q: %w", key, err)
+	}
+	return nil
+}
+
+func (s *httpStore) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, URL(s.baseURL, key), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return req, nil
+}
+
+// httpWriter streams the log as the body of a single request, which completes once the writer is
+// closed.
+type httpWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (w *httpWriter) Close() error {
+	w.PipeWriter.Close()
+	return <-w.done
+}
+
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(msg)))
+}
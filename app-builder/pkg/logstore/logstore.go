@@ -0,0 +1,118 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logstore provides durable storage for build logs, so they outlive the app-builder that
+// produced them.
+package logstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a log does not exist in the store.
+var ErrNotFound = errors.New("log not found")
+
+// pollInterval is how often a followed log is checked for new output.
+const pollInterval = time.Millisecond * 500
+
+// Store is the interface that wraps the methods for persisting and retrieving build logs.
+type Store interface {
+	// Create returns a writer for the log identified by key. The log is marked as complete once
+	// the writer is closed.
+	Create(ctx context.Context, key string) (io.WriteCloser, error)
+	// Open returns a reader for the log identified by key. When follow is true, the reader waits
+	// for more output until the log is complete or the context is done.
+	Open(ctx context.Context, key string, follow bool) (io.ReadCloser, error)
+	// Ref returns the reference to the log identified by key.
+	Ref(key string) string
+	// Delete deletes the log identified by key. A log that doesn't exist is deleted already.
+	Delete(ctx context.Context, key string) error
+}
+
+// New constructs a new Store from the given URL. A file:///path URL stores each log as a file under
+// path, e.g. on a mounted PersistentVolumeClaim. A local:///path URL stores each log as a sequence
+// of immutable objects in a bucket under path, standing in for an object store.
+func New(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log store: %w", err)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("failed to create log store: %q has no path", rawURL)
+	}
+	switch u.Scheme {
+	case "file":
+		return NewFileStore(u.Path), nil
+	case "local":
+		return NewObjectStore(rawURL, NewLocalBucket(u.Path)), nil
+	default:
+		return nil, fmt.Errorf("failed to create log store: unsupported scheme %q", u.Scheme)
+	}
+}
+
+// Key returns the key of the build log for an Artifact.
+func Key(namespace, artifact string) string {
+	return path.Join(namespace, artifact)
+}
+
+// SplitKey returns the namespace and the Artifact name of a key returned by Key.
+func SplitKey(key string) (string, string, error) {
+	split := strings.Split(key, "/")
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", fmt.Errorf("log key %q is not in the format <namespace>/<artifact>", key)
+	}
+	return split[0], split[1], nil
+}
+
+// BestEffort wraps a build log writer so that it stops writing after the first error instead of
+// failing the writes, so a failing build log doesn't fail the build.
+func BestEffort(w io.Writer) io.Writer {
+	return &bestEffortWriter{w: w}
+}
+
+type bestEffortWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *bestEffortWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	if _, err := w.w.Write(p); err != nil {
+		log.Printf("failed to write build log: %v\n", err)
+		w.err = err
+	}
+	return len(p), nil
+}
+
+// wait blocks for the poll interval or until the context is done.
+func wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(pollInterval):
+		return nil
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+)
+
+const key = "default/app-1"
+
+// readAll reads the log until it ends, failing after the timeout.
+func readAll(rc io.ReadCloser, timeout time.Duration) string {
+	defer rc.Close()
+	data := make(chan []byte, 1)
+	go func() {
+		b, err := ioutil.ReadAll(rc)
+		Expect(err).NotTo(HaveOccurred())
+		data <- b
+	}()
+	select {
+	case b := <-data:
+		return string(b)
+	case <-time.After(timeout):
+		Fail("the log did not end")
+		return ""
+	}
+}
+
+// writeLog writes the lines to the log and completes it.
+func writeLog(store logstore.Store, lines ...string) {
+	w, err := store.Create(context.Background(), key)
+	Expect(err).NotTo(HaveOccurred())
+	for _, line := range lines {
+		_, err := io.WriteString(w, line)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(w.Close()).To(Succeed())
+}
+
+// storeBehavior describes the behavior shared by every Store, created by newStore in a temporary
+// directory.
+func storeBehavior(newStore func(dir string) logstore.Store) {
+	var (
+		dir   string
+		store logstore.Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "logstore")
+		Expect(err).NotTo(HaveOccurred())
+		store = newStore(dir)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("returns a completed log", func() {
+		writeLog(store, "building\n", "pushing\n")
+
+		rc, err := store.Open(context.Background(), key, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readAll(rc, 5*time.Second)).To(Equal("building\npushing\n"))
+	})
+
+	It("doesn't find a log that was never written", func() {
+		_, err := store.Open(context.Background(), key, false)
+		Expect(errors.Is(err, logstore.ErrNotFound)).To(BeTrue(), "unexpected error %v", err)
+	})
+
+	It("follows a log until it is complete", func() {
+		// The log is followed before it is created, as a client may attach before the build starts.
+		rc, err := store.Open(context.Background(), key, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		w, err := store.Create(context.Background(), key)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.WriteString(w, "building\n")
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(100 * time.Millisecond)
+			_, err := io.WriteString(w, "pushing\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
+		}()
+
+		Expect(readAll(rc, 10*time.Second)).To(Equal("building\npushing\n"))
+	})
+
+	It("stops following a log when the context is done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		rc, err := store.Open(ctx, key, true)
+		Expect(err).NotTo(HaveOccurred())
+		defer rc.Close()
+
+		_, err = ioutil.ReadAll(rc)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("replaces a log that is written again", func() {
+		writeLog(store, "first build\n")
+		writeLog(store, "second build\n")
+
+		rc, err := store.Open(context.Background(), key, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readAll(rc, 5*time.Second)).To(Equal("second build\n"))
+	})
+
+	It("deletes a log", func() {
+		writeLog(store, "building\n")
+
+		Expect(store.Delete(context.Background(), key)).To(Succeed())
+		_, err := store.Open(context.Background(), key, false)
+		Expect(errors.Is(err, logstore.ErrNotFound)).To(BeTrue(), "unexpected error %v", err)
+
+		// A log that doesn't exist is deleted already.
+		Expect(store.Delete(context.Background(), key)).To(Succeed())
+	})
+}
+
+var _ = Describe("Log store", func() {
+	Context("file", func() {
+		storeBehavior(logstore.NewFileStore)
+
+		It("references the log file", func() {
+			Expect(logstore.NewFileStore("/var/lib/logs").Ref(key)).To(Equal("file:///var/lib/logs/default/app-1.log"))
+		})
+	})
+
+	Context("object", func() {
+		storeBehavior(func(dir string) logstore.Store {
+			return logstore.NewObjectStore("local://"+dir, logstore.NewLocalBucket(dir))
+		})
+
+		It("splits a large log into parts", func() {
+			dir, err := ioutil.TempDir("", "logstore")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			store := logstore.NewObjectStore("local://"+dir, logstore.NewLocalBucket(dir))
+
+			line := strings.Repeat("x", 1023) + "\n"
+			var lines []string
+			for i := 0; i < 600; i++ {
+				lines = append(lines, line)
+			}
+			writeLog(store, lines...)
+
+			for _, part := range []string{"part-00000000", "part-00000001", "complete"} {
+				Expect(dir + "/" + key + "/" + part).To(BeAnExistingFile())
+			}
+			rc, err := store.Open(context.Background(), key, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readAll(rc, 5*time.Second)).To(Equal(strings.Join(lines, "")))
+
+			Expect(store.Delete(context.Background(), key)).To(Succeed())
+			Expect(dir + "/" + key + "/part-00000001").NotTo(BeAnExistingFile())
+		})
+
+		It("replaces the parts and the marker of a log that is written again", func() {
+			dir, err := ioutil.TempDir("", "logstore")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			store := logstore.NewObjectStore("local://"+dir, logstore.NewLocalBucket(dir))
+
+			line := strings.Repeat("x", 1023) + "\n"
+			var lines []string
+			for i := 0; i < 600; i++ {
+				lines = append(lines, line)
+			}
+			writeLog(store, lines...)
+			Expect(dir + "/" + key + "/part-00000001").To(BeAnExistingFile())
+
+			// The log written again is followed until it is complete again, without the previous parts.
+			w, err := store.Create(context.Background(), key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dir + "/" + key + "/part-00000001").NotTo(BeAnExistingFile())
+			rc, err := store.Open(context.Background(), key, true)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.WriteString(w, "second build\n")
+			Expect(err).NotTo(HaveOccurred())
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(100 * time.Millisecond)
+				Expect(w.Close()).To(Succeed())
+			}()
+			Expect(readAll(rc, 10*time.Second)).To(Equal("second build\n"))
+		})
+
+		It("references the log object", func() {
+			Expect(logstore.NewObjectStore("local:///logs/", nil).Ref(key)).To(Equal("local:///logs/default/app-1"))
+		})
+	})
+
+	Context("HTTP", func() {
+		var server *httptest.Server
+
+		storeBehavior(func(dir string) logstore.Store {
+			server = httptest.NewServer(logstore.NewHandler(logstore.NewFileStore(dir), authorizeToken("secret")))
+			return logstore.NewHTTPStore(server.URL, "secret")
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("references the log URL", func() {
+			Expect(logstore.NewHTTPStore("http://operator:8082/", "").Ref(key)).To(Equal("http://operator:8082/logs/default/app-1"))
+		})
+
+		It("fails the requests the handler doesn't authorize", func() {
+			store := logstore.NewHTTPStore(server.URL, "wrong")
+
+			w, err := store.Create(context.Background(), key)
+			Expect(err).NotTo(HaveOccurred())
+			_, _ = io.WriteString(w, "building\n")
+			Expect(w.Close()).To(MatchError(ContainSubstring("401")))
+
+			_, err = store.Open(context.Background(), key, false)
+			Expect(err).To(MatchError(ContainSubstring("401")))
+			Expect(store.Delete(context.Background(), key)).To(MatchError(ContainSubstring("401")))
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			dir     string
+			handler http.Handler
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "logstore")
+			Expect(err).NotTo(HaveOccurred())
+			handler = logstore.NewHandler(logstore.NewFileStore(dir), authorizeToken("secret"))
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		serve := func(method, path string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader("building\n"))
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("serves the logs under the namespace and the Artifact", func() {
+			Expect(serve(http.MethodPut, "/logs/default/app-1").Code).To(Equal(http.StatusNoContent))
+
+			rec := serve(http.MethodGet, "/logs/default/app-1")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+			Expect(rec.Body.String()).To(Equal("building\n"))
+
+			Expect(serve(http.MethodDelete, "/logs/default/app-1").Code).To(Equal(http.StatusNoContent))
+			Expect(serve(http.MethodGet, "/logs/default/app-1").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("rejects the paths that are not a log key", func() {
+			for _, path := range []string{"/logs/default", "/logs/default/app-1/part", "/logs//app-1"} {
+				Expect(serve(http.MethodGet, path).Code).To(Equal(http.StatusNotFound), path)
+			}
+		})
+
+		It("rejects the other methods", func() {
+			Expect(serve(http.MethodPost, "/logs/default/app-1").Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("passes the namespace and the Artifact to the authorizer", func() {
+			var authorized []string
+			handler = logstore.NewHandler(logstore.NewFileStore(dir), func(r *http.Request, namespace, artifact string) error {
+				authorized = append(authorized, namespace, artifact)
+				return nil
+			})
+			serve(http.MethodGet, "/logs/default/app-1")
+			Expect(authorized).To(Equal([]string{"default", "app-1"}))
+		})
+	})
+
+	Describe("New", func() {
+		It("creates the store of the URL scheme", func() {
+			store, err := logstore.New("file:///var/lib/logs")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.Ref(key)).To(Equal("file:///var/lib/logs/default/app-1.log"))
+
+			store, err = logstore.New("local:///var/lib/logs")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.Ref(key)).To(Equal("local:///var/lib/logs/default/app-1"))
+		})
+
+		It("rejects the URLs without a path or with another scheme", func() {
+			_, err := logstore.New("file://")
+			Expect(err).To(HaveOccurred())
+			_, err = logstore.New("s3:///bucket")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SplitKey", func() {
+		It("splits the keys returned by Key", func() {
+			namespace, artifact, err := logstore.SplitKey(logstore.Key("default", "app-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace).To(Equal("default"))
+			Expect(artifact).To(Equal("app-1"))
+
+			for _, invalid := range []string{"", "default", "default/", "/app-1", "default/app-1/part"} {
+				_, _, err := logstore.SplitKey(invalid)
+				Expect(err).To(HaveOccurred(), invalid)
+			}
+		})
+	})
+
+	Describe("BestEffort", func() {
+		It("stops writing after the first error without failing the writes", func() {
+			w := &failingWriter{failAt: 2}
+			bestEffort := logstore.BestEffort(w)
+			for i := 0; i < 3; i++ {
+				n, err := io.WriteString(bestEffort, "line\n")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(n).To(Equal(5))
+			}
+			Expect(w.writes).To(Equal(2))
+		})
+	})
+})
+
+// authorizeToken authorizes the requests with the bearer token.
+func authorizeToken(token string) logstore.Authorizer {
+	return func(r *http.Request, namespace, artifact string) error {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			return fmt.Errorf("invalid token")
+		}
+		return nil
+	}
+}
+
+// failingWriter fails the writes from the failAt-th one.
+type failingWriter struct {
+	failAt int
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes >= w.failAt {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
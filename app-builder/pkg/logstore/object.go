@@ -0,0 +1,350 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// partSize is the size after which the buffered output is flushed as a new part.
+	partSize = 256 * 1024
+	// flushInterval is the maximum time output stays buffered before being flushed as a new part.
+	flushInterval = time.Second * 2
+)
+
+// Bucket is the interface that wraps the methods of an object storage bucket. Objects are
+// immutable once put.
+type Bucket interface {
+	// Put stores the object with the given name.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get returns the object with the given name or ErrNotFound if it doesn't exist.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// Delete deletes the object with the given name. An object that doesn't exist is deleted
+	// already.
+	Delete(ctx context.Context, name string) error
+}
+
+// NewObjectStore constructs a new Store that keeps each log in the bucket as a sequence of parts,
+// followed by a marker object once the log is complete. Since objects are immutable, the output is
+// flushed as a new part periodically, which is what allows a log to be read while it is written.
+func NewObjectStore(baseURL string, bucket Bucket) Store {
+	return &objectStore{baseURL: strings.TrimSuffix(baseURL, "/"), bucket: bucket}
+}
+
+type objectStore struct {
+	baseURL string
+	bucket  Bucket
+}
+
+// Create replaces a log written before, e.g. by a previous build of the Artifact. Its marker is
+// deleted first so it's not read as complete, then its parts.
+func (s *objectStore) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	if err := s.bucket.Delete(ctx, completeName(key)); err != nil {
+		return nil, fmt.Errorf("failed to create log %q: %w", key, err)
+	}
+	if err := s.deleteParts(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to create log %q: %w", key, err)
+	}
+	w := &objectWriter{
+		ctx:    ctx,
+		bucket: s.bucket,
+		key:    key,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.flushPeriodically()
+	return w, nil
+}
+
+func (s *objectStore) Open(ctx context.Context, key string, follow bool) (io.ReadCloser, error) {
+	if !follow {
+		exists, err := s.exists(ctx, partName(key, 0))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log %q: %w", key, err)
+		}
+		if !exists {
+			complete, err := s.exists(ctx, completeName(key))
+			if err != nil {
+				return nil, fmt.Errorf("failed to open log %q: %w", key, err)
+			}
+			if !complete {
+				return nil, ErrNotFound
+			}
+		}
+	}
+	return &objectReader{ctx: ctx, store: s, key: key, follow: follow}, nil
+}
+
+func (s *objectStore) Ref(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// Delete deletes the parts of the log, then its marker.
+func (s *objectStore) Delete(ctx context.Context, key string) error {
+	if err := s.deleteParts(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete log %q: %w", key, err)
+	}
+	if err := s.bucket.Delete(ctx, completeName(key)); err != nil {
+		return fmt.Errorf("failed to delete log %q: %w", key, err)
+	}
+	return nil
+}
+
+// deleteParts deletes the parts of the log in order, until the first missing one.
+func (s *objectStore) deleteParts(ctx context.Context, key string) error {
+	for n := 0; ; n++ {
+		exists, err := s.exists(ctx, partName(key, n))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return nil
+		}
+		if err := s.bucket.Delete(ctx, partName(key, n)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *objectStore) exists(ctx context.Context, name string) (bool, error) {
+	rc, err := s.bucket.Get(ctx, name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	rc.Close()
+	return true, nil
+}
+
+func partName(key string, n int) string {
+	return path.Join(key, fmt.Sprintf("part-%08d", n))
+}
+
+func completeName(key string) string {
+	return path.Join(key, "complete")
+}
+
+type objectWriter struct {
+	ctx    context.Context
+	bucket Bucket
+	key    string
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	next int
+	err  error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	if w.buf.Len() >= partSize {
+		w.flush()
+	}
+	return len(p), w.err
+}
+
+func (w *objectWriter) Close() error {
+	close(w.stop)
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.bucket.Put(w.ctx, completeName(w.key), &bytes.Buffer{}); err != nil {
+		return fmt.Errorf("failed to mark log as complete: %w", err)
+	}
+	return nil
+}
+
+func (w *objectWriter) flushPeriodically() {
+	defer close(w.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			w.flush()
+			w.mu.Unlock()
+		}
+	}
+}
+
+// flush must be called with the lock held.
+func (w *objectWriter) flush() {
+	if w.err != nil || w.buf.Len() == 0 {
+		return
+	}
+	if err := w.bucket.Put(w.ctx, partName(w.key, w.next), bytes.NewReader(w.buf.Bytes())); err != nil {
+		w.err = fmt.Errorf("failed to flush log part: %w", err)
+		return
+	}
+	w.buf.Reset()
+	w.next++
+}
+
+type objectReader struct {
+	ctx    context.Context
+	store  *objectStore
+	key    string
+	follow bool
+
+	cur  io.ReadCloser
+	next int
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur != nil {
+			n, err := r.cur.Read(p)
+			if err == io.EOF {
+				r.cur.Close()
+				r.cur = nil
+				r.next++
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+
+		found, err := r.openNext()
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			continue
+		}
+
+		complete, err := r.store.exists(r.ctx, completeName(r.key))
+		if err != nil {
+			return 0, err
+		}
+		if complete {
+			// A last part may have been flushed between the lookup and the completion.
+			found, err := r.openNext()
+			if err != nil {
+				return 0, err
+			}
+			if found {
+				continue
+			}
+			return 0, io.EOF
+		}
+		if !r.follow {
+			return 0, io.EOF
+		}
+		if err := wait(r.ctx); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *objectReader) openNext() (bool, error) {
+	rc, err := r.store.bucket.Get(r.ctx, partName(r.key, r.next))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	r.cur = rc
+	return true, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
+
+// NewLocalBucket constructs a new Bucket backed by a local directory. It stands in for an object
+// store in environments without one.
+func NewLocalBucket(dir string) Bucket {
+	return &localBucket{dir: dir}
+}
+
+type localBucket struct {
+	dir string
+}
+
+func (b *localBucket) Put(ctx context.Context, name string, r io.Reader) error {
+	objectPath := filepath.Join(b.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never observe a partial object.
+	f, err := ioutil.TempFile(filepath.Dir(objectPath), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), objectPath)
+}
+
+func (b *localBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(b.dir, filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (b *localBucket) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(b.dir, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
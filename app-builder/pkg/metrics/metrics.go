@@ -0,0 +1,156 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the Prometheus metrics of the app-builder, served on /metrics in both the
+// per-Artifact and the build service modes.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+// The builder labels of the builds. The builders are not used as labels as is, as any image can be
+// a builder, which would make the number of series unbounded.
+const (
+	// defaultBuilder is the builder label of the builds with the default builder.
+	defaultBuilder = "default"
+	// customBuilder is the builder label of the builds with any other builder.
+	customBuilder = "custom"
+)
+
+var (
+	// Registry is the registry of the app-builder metrics, including the Go runtime and process
+	// metrics.
+	Registry = prometheus.NewRegistry()
+
+	// BuildPhaseDuration is the time the builds spend in each phase, from receiving the source to
+	// pushing the image.
+	BuildPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "manor_app_builder_build_phase_duration_seconds",
+		Help:    "Time spent by the builds in each phase.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"phase"})
+
+	// Builds counts the completed builds by outcome, i.e. their final phase, and builder, i.e.
+	// whether they used the default builder.
+	Builds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "manor_app_builder_builds_total",
+		Help: "Number of completed builds by outcome and builder, default or custom.",
+	}, []string{"outcome", "builder"})
+
+	// UploadSize is the size of the uploaded sources, either as a tarball or as the blobs missing
+	// from the source cache.
+	UploadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "manor_app_builder_upload_size_bytes",
+		Help:    "Size of the source uploads.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"kind"})
+)
+
+// The kinds of source uploads.
+const (
+	UploadTarball = "tarball"
+	UploadBlobs   = "blobs"
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		BuildPhaseDuration,
+		Builds,
+		UploadSize,
+	)
+}
+
+// Handler returns the http.Handler serving the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+var (
+	queueDepthMu sync.Mutex
+	// queueDepth measures the number of jobs waiting for a worker, or is nil until the gauge is
+	// registered.
+	queueDepth func() int
+)
+
+// RegisterQueueDepth registers the gauge of the number of jobs waiting for a worker, which is
+// measured with depth on every scrape. The gauge is registered once, the later calls replacing
+// depth, e.g. when the build service is served again.
+func RegisterQueueDepth(depth func() int) {
+	queueDepthMu.Lock()
+	defer queueDepthMu.Unlock()
+	if queueDepth == nil {
+		Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "manor_app_builder_queue_depth",
+			Help: "Number of build jobs waiting for a worker.",
+		}, func() float64 {
+			queueDepthMu.Lock()
+			defer queueDepthMu.Unlock()
+			return float64(queueDepth())
+		}))
+	}
+	queueDepth = depth
+}
+
+// ObserveBuild counts a completed build with its final phase and builder.
+func ObserveBuild(phase build.Phase, builder string) {
+	Builds.WithLabelValues(string(phase), builderLabel(builder)).Inc()
+}
+
+// builderLabel returns the builder label of the builds with the builder.
+func builderLabel(builder string) string {
+	if builder == "" || builder == build.DefaultBuilder {
+		return defaultBuilder
+	}
+	return customBuilder
+}
+
+// PhaseTimer observes the duration of the phases of a build as it moves through them.
+type PhaseTimer struct {
+	mu      sync.Mutex
+	phase   build.Phase
+	started time.Time
+}
+
+// NewPhaseTimer constructs a new PhaseTimer of a build in the given phase.
+func NewPhaseTimer(phase build.Phase) *PhaseTimer {
+	return &PhaseTimer{phase: phase, started: time.Now()}
+}
+
+// Enter moves the build to the given phase, observing the duration of the previous one. The time
+// spent pending, waiting for the source, and in the completed phases is not observed.
+func (t *PhaseTimer) Enter(phase build.Phase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if phase == t.phase {
+		return
+	}
+	now := time.Now()
+	if t.phase != build.PhasePending && !t.phase.Completed() {
+		BuildPhaseDuration.WithLabelValues(string(t.phase)).Observe(now.Sub(t.started).Seconds())
+	}
+	t.phase = phase
+	t.started = now
+}
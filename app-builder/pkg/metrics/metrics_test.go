@@ -0,0 +1,53 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/metrics"
+)
+
+var _ = Describe("Metrics", func() {
+	table.DescribeTable("counts the builds by outcome and default or custom builder",
+		func(builder, label string) {
+			builds := metrics.Builds.WithLabelValues(string(build.PhaseSucceeded), label)
+			before := testutil.ToFloat64(builds)
+			metrics.ObserveBuild(build.PhaseSucceeded, builder)
+			Expect(testutil.ToFloat64(builds)).To(Equal(before + 1))
+		},
+		table.Entry("no builder", "", "default"),
+		table.Entry("the default builder", build.DefaultBuilder, "default"),
+		table.Entry("another builder", "registry.example.com/builder:latest", "custom"),
+	)
+
+	It("registers the queue depth once, reporting the latest depth", func() {
+		metrics.RegisterQueueDepth(func() int { return 1 })
+		Expect(func() { metrics.RegisterQueueDepth(func() int { return 2 }) }).NotTo(Panic())
+		Expect(testutil.GatherAndCompare(metrics.Registry, strings.NewReader(`
+# HELP manor_app_builder_queue_depth Number of build jobs waiting for a worker.
+# TYPE manor_app_builder_queue_depth gauge
+manor_app_builder_queue_depth 2
+`), "manor_app_builder_queue_depth")).To(Succeed())
+	})
+})
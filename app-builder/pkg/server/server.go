@@ -17,148 +17,441 @@ limitations under the License.
 package server
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	"github.com/codelogia/manor/app-builder/pkg/metrics"
+	"github.com/codelogia/manor/app-builder/pkg/sourcecache"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
 )
 
-// Server is the interface that wraps the Serve method.
+// Server is the interface that wraps the Serve and Status methods.
 type Server interface {
-	Serve(addr, buildDir, token, appNamespace, appName, imageRegistry string) error
+	Serve(opts Options) error
+	Status() Status
+}
+
+// Options configures the build served by an app-builder.
+type Options struct {
+	// The address to listen on.
+	Addr string
+	// The directory the source is extracted to.
+	BuildDir string
+	// The bearer token authorizing the requests.
+	Token string
+	// The namespace of the app.
+	AppNamespace string
+	// The name of the app.
+	AppName string
+	// The registry the image is pushed to.
+	ImageRegistry string
+	// The tag of the image.
+	ImageTag string
+	// The buildpacks builder image, the default one when empty.
+	Builder string
+	// The directory of the service bindings provided to the buildpacks, if any.
+	BindingsDir string
+	// The part of the source that is built. The upload parameters can only complete it.
+	Filter build.Filter
 }
 
-// New constructs a new Server.
-func New() Server {
-	return &server{}
+// Status is the status of the build served by an app-builder.
+type Status struct {
+	// The current phase of the build.
+	Phase build.Phase `json:"phase"`
+	// The number of bytes of the source received so far.
+	BytesReceived int64 `json:"bytesReceived"`
+	// The seconds elapsed since the source upload started, or until the build completed.
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	// The reason of a failure.
+	Message string `json:"message,omitempty"`
+	// The digest of the pushed image, once the build succeeded.
+	Digest string `json:"digest,omitempty"`
 }
 
-type server struct{}
+// New constructs a new Server. The build output is also written to buildLog, and the sources
+// uploaded by manifest are kept in cache.
+func New(buildLog io.Writer, cache *sourcecache.Cache) Server {
+	if buildLog == nil {
+		buildLog = ioutil.Discard
+	}
+	return &server{
+		buildLog: logstore.BestEffort(buildLog),
+		cache:    cache,
+		phase:    build.PhasePending,
+		timer:    metrics.NewPhaseTimer(build.PhasePending),
+	}
+}
+
+type server struct {
+	// bytesReceived is updated atomically while the source is received. It's the first field to
+	// keep it 64-bit aligned.
+	bytesReceived int64
+
+	buildLog io.Writer
+	cache    *sourcecache.Cache
+	timer    *metrics.PhaseTimer
+
+	mu         sync.Mutex
+	phase      build.Phase
+	message    string
+	digest     string
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// Serve serves the build service for an app configured by opts. It returns once the build
+// completes, with the error of a failed build.
+//
+// The source is either uploaded to /build as a gzipped tarball, or incrementally: the manifest of
+// the source is sent to /manifest, which replies with the blobs missing from the cache, the
+// missing blobs are uploaded to /blobs, and the manifest is finally sent to /build.
+//
+// The metrics of the build are served on /metrics, and its status on /status to the holders of
+// the token.
+func (s *server) Serve(opts Options) error {
+	done := make(chan error, 1)
+
+	httpServer := &http.Server{
+		Addr:    opts.Addr,
+		Handler: s.handler(opts, done),
+	}
 
-// Serve serves the build service for an app.
-func (s *server) Serve(addr, buildDir, token, appNamespace, appName, imageRegistry string) error {
-	stop := make(chan struct{}, 1)
-	var once sync.Once
+	stopped := make(chan error, 1)
+	go func() {
+		err := <-done
+		httpServer.Shutdown(context.Background())
+		stopped <- err
+	}()
 
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+
+	return <-stopped
+}
+
+// handler returns the handler of the endpoints of the build configured by opts. The outcome of the
+// build is sent to done once it completes.
+func (s *server) handler(opts Options, done chan<- error) http.Handler {
 	router := http.NewServeMux()
-	router.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	// The app-builder stays ready for the whole build, as it keeps serving its status and metrics. A
+	// second source is rejected by /build instead.
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	router.Handle("/metrics", metrics.Handler())
+	router.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, opts.Token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Status())
+	})
+	router.HandleFunc("/manifest", func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		once.Do(func() {
-			log.Println("receiving source...")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, opts.Token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, missing, ok := s.decodeManifest(w, r, opts.AppNamespace, opts.AppName)
+		if !ok {
+			return
+		}
+		writeMissingBlobs(w, http.StatusOK, missing)
+	})
+	router.HandleFunc("/blobs", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
 
-			zr, err := gzip.NewReader(r.Body)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, opts.Token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if phase := s.Status().Phase; phase != build.PhasePending {
+			http.Error(w, fmt.Sprintf("a build was already started and is %s", phase), http.StatusConflict)
+			return
+		}
+		var n int64
+		body := &countingReader{r: &countingReader{r: r.Body, n: &n}, n: &s.bytesReceived}
+		if err := s.cache.Put(opts.AppNamespace, opts.AppName, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metrics.UploadSize.WithLabelValues(metrics.UploadBlobs).Observe(float64(n))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, opts.Token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		uploadFilter, err := build.MergeFilter(opts.Filter, build.FilterFromQuery(r.URL.Query()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var manifest *sourcecache.Manifest
+		if r.Header.Get("Content-Type") == sourcecache.ContentType {
+			m, missing, ok := s.decodeManifest(w, r, opts.AppNamespace, opts.AppName)
+			if !ok {
 				return
 			}
-
-			tr := tar.NewReader(zr)
-			for {
-				hdr, err := tr.Next()
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-
-				fileInfo := hdr.FileInfo()
-				filePath := path.Join(buildDir, fileInfo.Name())
-				if fileInfo.IsDir() {
-					if err := os.MkdirAll(filePath, fileInfo.Mode()); err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-				} else {
-					f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, fileInfo.Mode())
-					if err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-					if _, err := io.Copy(f, tr); err != nil {
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-				}
+			if len(missing) > 0 {
+				writeMissingBlobs(w, http.StatusPreconditionFailed, missing)
+				return
 			}
+			manifest = &m
+		}
+		if !s.start() {
+			http.Error(w, fmt.Sprintf("a build was already started and is %s", s.Status().Phase), http.StatusConflict)
+			return
+		}
 
-			r.Body.Close()
-
-			log.Println("building...")
+		// The build is traced as part of the trace of the upload, if any. It's not canceled with the
+		// request, as the client going away doesn't stop the build.
+		ctx, span := tracing.Tracer().Start(
+			tracing.ExtractHTTP(context.Background(), r.Header), "app-builder build",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("manor.app", opts.AppNamespace+"/"+opts.AppName),
+				attribute.String("manor.artifact", opts.ImageTag),
+			),
+		)
 
-			appNameWithRegistry := fmt.Sprintf("%s/%s/%s", imageRegistry, appNamespace, appName)
+		log.Println("receiving source...")
 
-			cmd := exec.Command(
-				"pack", "build", appNameWithRegistry,
-				"--builder", "paketobuildpacks/builder:full",
-				// "--builder", "heroku/buildpacks:18",
-			)
-			cmd.Dir = buildDir
-			pipeReader, pipeWriter := io.Pipe()
-			cmd.Stdout = io.MultiWriter(os.Stdout, pipeWriter)
-			cmd.Stderr = io.MultiWriter(os.Stderr, pipeWriter)
-			go write(pipeReader, w)
-			if err := cmd.Run(); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+		_, extractSpan := tracing.Tracer().Start(ctx, "extract source")
+		if manifest != nil {
+			err = s.cache.Materialize(opts.AppNamespace, opts.AppName, *manifest, opts.BuildDir, uploadFilter)
+		} else {
+			var n int64
+			body := &countingReader{r: &countingReader{r: r.Body, n: &n}, n: &s.bytesReceived}
+			if err = build.Extract(body, opts.BuildDir, uploadFilter); err == nil {
+				metrics.UploadSize.WithLabelValues(metrics.UploadTarball).Observe(float64(n))
 			}
+		}
+		tracing.End(extractSpan, err)
+		if err != nil {
+			log.Println(err)
+			s.finish(err)
+			metrics.ObserveBuild(build.PhaseFailed, opts.Builder)
+			tracing.End(span, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			done <- err
+			return
+		}
 
-			log.Println("pushing...")
-
-			cmd = exec.Command(
-				"docker", "push", appNameWithRegistry,
-			)
-			pipeReader, pipeWriter = io.Pipe()
-			cmd.Stdout = io.MultiWriter(os.Stdout, pipeWriter)
-			cmd.Stderr = io.MultiWriter(os.Stderr, pipeWriter)
-			go write(pipeReader, w)
-			if err := cmd.Run(); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		r.Body.Close()
 
-			stop <- struct{}{}
+		log.Println("building...")
+
+		out := io.MultiWriter(os.Stdout, s.buildLog, &flushWriter{w: w})
+		buildOpts := build.Options{
+			Dir:         build.AppDir(opts.BuildDir, uploadFilter),
+			Image:       build.Image(opts.ImageRegistry, opts.AppNamespace, opts.AppName, opts.ImageTag),
+			Builder:     opts.Builder,
+			BindingsDir: opts.BindingsDir,
+		}
+		digest, err := build.Run(ctx, buildOpts, out, func(phase build.Phase) {
+			if phase == build.PhasePushing {
+				log.Println("pushing...")
+			}
+			s.setPhase(phase)
 		})
+		s.setDigest(digest)
+		s.finish(err)
+		metrics.ObserveBuild(s.Status().Phase, opts.Builder)
+		tracing.End(span, err)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		done <- err
 	})
 
-	httpServer := &http.Server{
-		Addr:    addr,
-		Handler: router,
+	return router
+}
+
+// decodeManifest decodes the manifest in the request body and looks up the blobs missing from the
+// cache. It replies with an error and returns false when the manifest is invalid.
+func (s *server) decodeManifest(
+	w http.ResponseWriter,
+	r *http.Request,
+	appNamespace string,
+	appName string,
+) (sourcecache.Manifest, []string, bool) {
+	var manifest sourcecache.Manifest
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %v", err), http.StatusBadRequest)
+		return manifest, nil, false
+	}
+	missing, err := s.cache.Missing(appNamespace, appName, manifest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return manifest, nil, false
 	}
+	return manifest, missing, true
+}
 
-	go func() {
-		<-stop
-		httpServer.Shutdown(context.Background())
-	}()
+// writeMissingBlobs replies with the blobs missing from the cache.
+func writeMissingBlobs(w http.ResponseWriter, status int, missing []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(sourcecache.MissingBlobs{Hashes: missing})
+}
 
-	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("failed to serve: %w", err)
+// Status returns the current status of the build.
+func (s *server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		Phase:         s.phase,
+		BytesReceived: atomic.LoadInt64(&s.bytesReceived),
+		Message:       s.message,
+		Digest:        s.digest,
+	}
+	switch {
+	case !s.finishedAt.IsZero():
+		st.ElapsedSeconds = s.finishedAt.Sub(s.startedAt).Seconds()
+	case !s.startedAt.IsZero():
+		st.ElapsedSeconds = time.Since(s.startedAt).Seconds()
+	}
+	return st
+}
+
+// start moves the build to receiving the source, returning false if it was already started.
+func (s *server) start() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phase != build.PhasePending {
+		return false
 	}
+	s.phase = build.PhaseReceiving
+	s.startedAt = time.Now()
+	s.timer.Enter(s.phase)
+	return true
+}
 
-	return nil
+func (s *server) setPhase(phase build.Phase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase
+	s.timer.Enter(phase)
 }
 
-func write(r io.ReadCloser, w io.Writer) {
-	defer r.Close()
-	buf := make([]byte, 1024)
-	for {
-		n, err := r.Read(buf)
-		if err != nil {
-			break
-		}
+func (s *server) setDigest(digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.digest = digest
+}
 
-		w.Write(buf[0:n])
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
+// finish completes the build, failed if err is not nil.
+func (s *server) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishedAt = time.Now()
+	if err != nil {
+		s.phase = build.PhaseFailed
+		s.message = err.Error()
+	} else {
+		s.phase = build.PhaseSucceeded
+	}
+	s.timer.Enter(s.phase)
+}
+
+// GetStatus returns the status of the build served by the app-builder at baseURL, authenticated
+// with the token of the build.
+func GetStatus(ctx context.Context, baseURL, token string) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/status", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get build status: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get build status: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get build status: unexpected status %s", res.Status)
+	}
+	st := &Status{}
+	if err := json.NewDecoder(res.Body).Decode(st); err != nil {
+		return nil, fmt.Errorf("failed to get build status: %w", err)
 	}
+	return st, nil
+}
+
+// authorized returns whether the request carries the given bearer token.
+func authorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// flushWriter flushes every write to the client, so the build output is streamed as it happens.
+// Write errors are ignored, so the build carries on if the client goes away.
+type flushWriter struct {
+	w io.Writer
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	w.w.Write(p)
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return len(p), nil
+}
+
+// countingReader counts the bytes read into n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
 }
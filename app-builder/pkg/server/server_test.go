@@ -0,0 +1,306 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/sourcecache"
+)
+
+// sourceTarball returns a gzipped tarball with a single file.
+func sourceTarball() []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := []byte("web: node index.js\n")
+	Expect(tw.WriteHeader(&tar.Header{Name: "Procfile", Mode: 0644, Size: int64(len(content))})).To(Succeed())
+	_, err := tw.Write(content)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("Server", func() {
+	const (
+		token  = "build-token"
+		digest = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	)
+
+	ctx := context.Background()
+
+	var (
+		dir        string
+		path       string
+		s          *server
+		done       chan error
+		httpServer *httptest.Server
+	)
+
+	// The pack command is replaced with a script that blocks until the release file exists, and
+	// fails if the fail file exists. The docker command pushes the image with the digest.
+	release := func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "release"), nil, 0644)).To(Succeed())
+	}
+	fail := func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "fail"), nil, 0644)).To(Succeed())
+	}
+	started := func() bool {
+		_, err := os.Stat(filepath.Join(dir, "started"))
+		return err == nil
+	}
+
+	// do sends a request to the server with the token.
+	do := func(method, path, token, contentType string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, httpServer.URL+path, bytes.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return res
+	}
+
+	// readBody reads and closes the response body.
+	readBody := func(res *http.Response) string {
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(b)
+	}
+
+	// startBuild uploads the source to /build in the background, returning the channel of its
+	// response.
+	startBuild := func(contentType string, body []byte) <-chan *http.Response {
+		responses := make(chan *http.Response, 1)
+		go func() {
+			defer GinkgoRecover()
+			responses <- do(http.MethodPost, "/build", token, contentType, body)
+		}()
+		return responses
+	}
+
+	status := func() *Status {
+		st, err := GetStatus(ctx, httpServer.URL, token)
+		Expect(err).NotTo(HaveOccurred())
+		return st
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "server")
+		Expect(err).NotTo(HaveOccurred())
+
+		tools := filepath.Join(dir, "tools")
+		Expect(os.Mkdir(tools, 0755)).To(Succeed())
+		pack := `#!/bin/sh
+touch "` + filepath.Join(dir, "started") + `"
+while [ ! -e "` + filepath.Join(dir, "release") + `" ]; do sleep 0.01; done
+echo "building $2"
+if [ -e "` + filepath.Join(dir, "fail") + `" ]; then
+  exit 1
+fi
+`
+		docker := `#!/bin/sh
+if [ "$1" = "image" ]; then
+  echo '["registry.example.com/default/app@` + digest + `"]'
+fi
+`
+		Expect(ioutil.WriteFile(filepath.Join(tools, "pack"), []byte(pack), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(tools, "docker"), []byte(docker), 0755)).To(Succeed())
+		path = os.Getenv("PATH")
+		os.Setenv("PATH", tools+string(os.PathListSeparator)+path)
+
+		s = New(nil, sourcecache.New(filepath.Join(dir, "cache"))).(*server)
+		done = make(chan error, 1)
+		httpServer = httptest.NewServer(s.handler(Options{
+			BuildDir:      filepath.Join(dir, "build"),
+			Token:         token,
+			AppNamespace:  "default",
+			AppName:       "app",
+			ImageRegistry: "registry.example.com",
+			ImageTag:      "app-1",
+		}, done))
+	})
+
+	AfterEach(func() {
+		// The builds still blocked are released, so the server can be closed.
+		release()
+		httpServer.Close()
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	})
+
+	It("serves the unauthenticated health endpoints", func() {
+		for _, path := range []string{"/healthz", "/readyz"} {
+			res := do(http.MethodGet, path, "", "", nil)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(strings.TrimSpace(readBody(res))).To(Equal("ok"))
+		}
+	})
+
+	It("serves the status to the holders of the token", func() {
+		Expect(do(http.MethodGet, "/status", "", "", nil).StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(do(http.MethodGet, "/status", "other-token", "", nil).StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(do(http.MethodPost, "/status", token, "", nil).StatusCode).To(Equal(http.StatusMethodNotAllowed))
+
+		_, err := GetStatus(ctx, httpServer.URL, "other-token")
+		Expect(err).To(MatchError(ContainSubstring("401")))
+
+		Expect(status()).To(Equal(&Status{Phase: build.PhasePending}))
+	})
+
+	It("requires the token for the uploads", func() {
+		for _, path := range []string{"/manifest", "/blobs", "/build"} {
+			Expect(do(http.MethodPost, path, "", "", nil).StatusCode).To(Equal(http.StatusUnauthorized), path)
+			Expect(do(http.MethodGet, path, token, "", nil).StatusCode).To(Equal(http.StatusMethodNotAllowed), path)
+		}
+		Expect(status().Phase).To(Equal(build.PhasePending))
+	})
+
+	It("reports the progress of the build and rejects a second source", func() {
+		tarball := sourceTarball()
+		responses := startBuild("", tarball)
+		Eventually(started).Should(BeTrue())
+
+		st := status()
+		Expect(st.Phase).To(Equal(build.PhaseBuilding))
+		Expect(st.BytesReceived).To(BeEquivalentTo(len(tarball)))
+		Expect(st.ElapsedSeconds).To(BeNumerically(">", 0))
+
+		// The app-builder stays ready during the build, but doesn't accept another source.
+		Expect(do(http.MethodGet, "/readyz", "", "", nil).StatusCode).To(Equal(http.StatusOK))
+		res := do(http.MethodPost, "/build", token, "", sourceTarball())
+		Expect(res.StatusCode).To(Equal(http.StatusConflict))
+		Expect(readBody(res)).To(ContainSubstring("a build was already started and is Building"))
+		Expect(do(http.MethodPost, "/blobs", token, "", nil).StatusCode).To(Equal(http.StatusConflict))
+
+		release()
+		var built *http.Response
+		Eventually(responses).Should(Receive(&built))
+		Expect(built.StatusCode).To(Equal(http.StatusOK))
+		Expect(readBody(built)).To(ContainSubstring("building registry.example.com/default/app:app-1"))
+		Eventually(done).Should(Receive(BeNil()))
+
+		st = status()
+		Expect(st.Phase).To(Equal(build.PhaseSucceeded))
+		Expect(st.Digest).To(Equal(digest))
+		// The elapsed time stops with the build.
+		Expect(status().ElapsedSeconds).To(Equal(st.ElapsedSeconds))
+	})
+
+	It("reports the failure of the build", func() {
+		fail()
+		release()
+		// The output of the build was already streamed, so the failure is only reported by the status.
+		res := do(http.MethodPost, "/build", token, "", sourceTarball())
+		Expect(readBody(res)).To(ContainSubstring("building"))
+		Eventually(done).Should(Receive(MatchError(ContainSubstring("failed to build image"))))
+
+		st := status()
+		Expect(st.Phase).To(Equal(build.PhaseFailed))
+		Expect(st.Message).To(ContainSubstring("failed to build image"))
+		Expect(st.Digest).To(BeEmpty())
+	})
+
+	It("fails the build of an invalid source", func() {
+		res := do(http.MethodPost, "/build", token, "", []byte("not a tarball"))
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+		Eventually(done).Should(Receive(HaveOccurred()))
+		Expect(status().Phase).To(Equal(build.PhaseFailed))
+	})
+
+	It("builds the source of a manifest once its blobs are uploaded", func() {
+		source := filepath.Join(dir, "source")
+		Expect(os.Mkdir(source, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(source, "Procfile"), []byte("web: node index.js\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(source, "index.js"), []byte("console.log('hello')\n"), 0644)).To(Succeed())
+		manifest, err := sourcecache.BuildManifest(source, build.Filter{})
+		Expect(err).NotTo(HaveOccurred())
+		body, err := json.Marshal(manifest)
+		Expect(err).NotTo(HaveOccurred())
+
+		// missingBlobs decodes the blobs missing from the cache in the response.
+		missingBlobs := func(res *http.Response) []string {
+			defer res.Body.Close()
+			var missing sourcecache.MissingBlobs
+			Expect(json.NewDecoder(res.Body).Decode(&missing)).To(Succeed())
+			return missing.Hashes
+		}
+		uploadBlobs := func(hashes []string) {
+			var blobs bytes.Buffer
+			Expect(sourcecache.WriteBlobs(&blobs, source, manifest, hashes)).To(Succeed())
+			Expect(do(http.MethodPost, "/blobs", token, "", blobs.Bytes()).StatusCode).To(Equal(http.StatusNoContent))
+		}
+
+		res := do(http.MethodPost, "/manifest", token, "", body)
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		hashes := missingBlobs(res)
+		Expect(hashes).To(ConsistOf(manifest.Hashes()))
+
+		// The build of a manifest with missing blobs is rejected with the missing blobs.
+		uploadBlobs(hashes[:1])
+		res = do(http.MethodPost, "/build", token, sourcecache.ContentType, body)
+		Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
+		Expect(missingBlobs(res)).To(ConsistOf(hashes[1:]))
+		Expect(status().Phase).To(Equal(build.PhasePending))
+
+		uploadBlobs(hashes[1:])
+		res = do(http.MethodPost, "/manifest", token, "", body)
+		Expect(missingBlobs(res)).To(BeEmpty())
+
+		release()
+		res = do(http.MethodPost, "/build", token, sourcecache.ContentType, body)
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		readBody(res)
+		Eventually(done).Should(Receive(BeNil()))
+
+		st := status()
+		Expect(st.Phase).To(Equal(build.PhaseSucceeded))
+		Expect(st.BytesReceived).To(BeNumerically(">", 0))
+		content, err := ioutil.ReadFile(filepath.Join(dir, "build", "index.js"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("console.log('hello')\n"))
+	})
+
+	It("rejects an invalid manifest", func() {
+		res := do(http.MethodPost, "/manifest", token, "", []byte(`{"files":[{"path":"../etc/passwd","hash":"x"}]}`))
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+		res = do(http.MethodPost, "/build", token, sourcecache.ContentType, []byte("{"))
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(status().Phase).To(Equal(build.PhasePending))
+	})
+})
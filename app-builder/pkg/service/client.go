@@ -0,0 +1,111 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ErrJobNotFound is returned when a job does not exist in the build service, e.g. because it was
+// restarted.
+var ErrJobNotFound = errors.New("job not found")
+
+// Client is a client of the build service.
+type Client struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient constructs a new Client of the build service at url, authenticated with token.
+func NewClient(url, token string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(url, "/"),
+		token:      token,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// JobURL returns the URL of a job.
+func (c *Client) JobURL(id string) string {
+	return c.url + "/jobs/" + id
+}
+
+// CreateJob creates a job. It returns the existing job if one was already created for the same
+// Artifact.
+func (c *Client) CreateJob(ctx context.Context, req JobRequest) (*Job, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	job := &Job{}
+	if err := c.do(ctx, http.MethodPost, c.url+"/jobs", bytes.NewReader(body), job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return job, nil
+}
+
+// GetJob returns the job at jobURL.
+func (c *Client) GetJob(ctx context.Context, jobURL string) (*Job, error) {
+	job := &Job{}
+	if err := c.do(ctx, http.MethodGet, jobURL, nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CancelJob cancels the job at jobURL.
+func (c *Client) CancelJob(ctx context.Context, jobURL string) error {
+	return c.do(ctx, http.MethodDelete, jobURL, nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, url string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrJobNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
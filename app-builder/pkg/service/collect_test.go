@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+var _ = Describe("Expiring jobs", func() {
+	var s *Service
+
+	// createJob creates a job for the Artifact.
+	createJob := func(artifact string) *job {
+		j, created, err := s.createJob(JobRequest{Namespace: "default", App: "app", Artifact: artifact, Token: "token"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeTrue())
+		return j
+	}
+
+	BeforeEach(func() {
+		s = New(Config{QueueSize: 1})
+	})
+
+	It("forgets the jobs that never received their source", func() {
+		j := createJob("app-1")
+		now := j.status().CreatedAt
+
+		s.forgetExpiredJobs(now.Add(jobTTL / 2))
+		Expect(s.jobs).To(HaveKey(j.state.ID))
+
+		s.forgetExpiredJobs(now.Add(jobTTL + time.Minute))
+		Expect(s.jobs).To(BeEmpty())
+		Expect(s.jobsByBuild).To(BeEmpty())
+		// The followers of its logs are released.
+		Expect(j.status().Phase).To(Equal(build.PhaseCanceled))
+		Expect(j.logs.closed).To(BeTrue())
+	})
+
+	It("keeps the jobs with a received source until they complete", func() {
+		j := createJob("app-1")
+		Expect(j.transition(build.PhasePending, build.PhaseQueued)).To(BeTrue())
+
+		s.forgetExpiredJobs(j.status().CreatedAt.Add(2 * jobTTL))
+		Expect(s.jobs).To(HaveKey(j.state.ID))
+		Expect(j.status().Phase).To(Equal(build.PhaseQueued))
+	})
+
+	It("forgets the completed jobs after the job TTL", func() {
+		j := createJob("app-1")
+		s.cancel(j)
+		finishedAt := *j.status().FinishedAt
+
+		s.forgetExpiredJobs(finishedAt.Add(jobTTL / 2))
+		Expect(s.jobs).To(HaveKey(j.state.ID))
+
+		s.forgetExpiredJobs(finishedAt.Add(jobTTL + time.Minute))
+		Expect(s.jobs).To(BeEmpty())
+		Expect(s.jobsByBuild).To(BeEmpty())
+	})
+
+	It("keeps the job that replaced a completed job of the same Artifact", func() {
+		completed := createJob("app-1")
+		s.cancel(completed)
+		finishedAt := *completed.status().FinishedAt
+
+		replacement := createJob("app-1")
+		// The replacement is created long after the completion.
+		replacement.state.CreatedAt = finishedAt.Add(jobTTL)
+		Expect(replacement.state.ID).NotTo(Equal(completed.state.ID))
+		Expect(s.jobsByBuild).To(HaveKeyWithValue("default/app-1", replacement))
+
+		s.forgetExpiredJobs(finishedAt.Add(jobTTL + time.Minute))
+		Expect(s.jobs).NotTo(HaveKey(completed.state.ID))
+		Expect(s.jobs).To(HaveKey(replacement.state.ID))
+		Expect(s.jobsByBuild).To(HaveKeyWithValue("default/app-1", replacement))
+	})
+})
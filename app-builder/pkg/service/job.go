@@ -0,0 +1,223 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/metrics"
+)
+
+// JobRequest is the request to create a build job.
+type JobRequest struct {
+	// The namespace of the App.
+	Namespace string `json:"namespace"`
+	// The name of the App.
+	App string `json:"app"`
+	// The name of the Artifact being built.
+	Artifact string `json:"artifact"`
+	// The image registry the image is pushed to.
+	ImageRegistry string `json:"imageRegistry"`
+	// The token authenticating the requests to the job, e.g. the source upload.
+	Token string `json:"token"`
+	// The key of the build log in the log store, if build logs are persisted.
+	LogKey string `json:"logKey,omitempty"`
+	// The directory of the app root within the source.
+	Path string `json:"path,omitempty"`
+	// The globs of the files to include in the build context.
+	Include []string `json:"include,omitempty"`
+	// The globs of the files to exclude from the build context.
+	Exclude []string `json:"exclude,omitempty"`
+	// The buildpacks builder image. Defaults to the default builder.
+	Builder string `json:"builder,omitempty"`
+	// The service bindings provided to the buildpacks, by binding name.
+	Bindings map[string]map[string][]byte `json:"bindings,omitempty"`
+}
+
+// filter returns the source filter configured for the Artifact.
+func (r JobRequest) filter() build.Filter {
+	return build.Filter{Path: r.Path, Include: r.Include, Exclude: r.Exclude}
+}
+
+// Job is the state of a build job.
+type Job struct {
+	// The ID of the job.
+	ID string `json:"id"`
+	// The namespace of the App.
+	Namespace string `json:"namespace"`
+	// The name of the App.
+	App string `json:"app"`
+	// The name of the Artifact being built.
+	Artifact string `json:"artifact"`
+	// The current phase of the job.
+	Phase build.Phase `json:"phase"`
+	// The reason of a failure.
+	Message string `json:"message,omitempty"`
+	// The digest of the pushed image, once the build succeeded. The image is tagged with the name of
+	// the Artifact.
+	Digest string `json:"digest,omitempty"`
+	// When the job was created.
+	CreatedAt time.Time `json:"createdAt"`
+	// When the build started.
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	// When the job completed.
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// job is a build job tracked by the service.
+type job struct {
+	request JobRequest
+	dir     string
+	logs    *jobLog
+	// filter is the source filter, set when the source is received.
+	filter build.Filter
+	// spanContext is the trace context of the source upload, which the build is traced in.
+	spanContext trace.SpanContext
+	// timer observes the duration of the phases of the job.
+	timer *metrics.PhaseTimer
+
+	mu     sync.Mutex
+	state  Job
+	cancel context.CancelFunc
+}
+
+// status returns a copy of the job state.
+func (j *job) status() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// setPhase moves the job to the given phase, returning false if the job is already completed.
+func (j *job) setPhase(phase build.Phase, message string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.Phase.Completed() {
+		return false
+	}
+	j.state.Phase = phase
+	j.state.Message = message
+	j.timer.Enter(phase)
+	now := time.Now()
+	switch {
+	case phase == build.PhaseBuilding:
+		j.state.StartedAt = &now
+	case phase.Completed():
+		j.state.FinishedAt = &now
+		metrics.ObserveBuild(phase, j.request.Builder)
+	}
+	return true
+}
+
+func (j *job) setDigest(digest string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Digest = digest
+}
+
+// transition moves the job from one phase to another, returning false if it isn't in the expected
+// phase.
+func (j *job) transition(from, to build.Phase) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.Phase != from {
+		return false
+	}
+	j.state.Phase = to
+	j.timer.Enter(to)
+	return true
+}
+
+// jobLog is the in-memory build output of a job, which can be followed while it is written.
+type jobLog struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func newJobLog() *jobLog {
+	l := &jobLog{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	l.cond.Broadcast()
+	return len(p), nil
+}
+
+func (l *jobLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.cond.Broadcast()
+	return nil
+}
+
+// reader returns a reader of the log from the beginning. When follow is true, the reader waits for
+// more output until the log is closed or the context is done.
+func (l *jobLog) reader(ctx context.Context, follow bool) io.Reader {
+	return &jobLogReader{ctx: ctx, log: l, follow: follow}
+}
+
+type jobLogReader struct {
+	ctx    context.Context
+	log    *jobLog
+	follow bool
+	offset int
+}
+
+func (r *jobLogReader) Read(p []byte) (int, error) {
+	l := r.log
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			l.mu.Lock()
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for r.offset == len(l.buf) {
+		if l.closed || !r.follow {
+			return 0, io.EOF
+		}
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
+		}
+		l.cond.Wait()
+	}
+	n := copy(p, l.buf[r.offset:])
+	r.offset += n
+	return n, nil
+}
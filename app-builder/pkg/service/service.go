@@ -0,0 +1,579 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package service implements the long-lived build service mode of the app-builder, which runs
+// concurrent build jobs for many Apps and tenants.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	"github.com/codelogia/manor/app-builder/pkg/metrics"
+	"github.com/codelogia/manor/app-builder/pkg/sourcecache"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+)
+
+// jobTTL is how long a completed job is kept around for clients to fetch its status and logs, and
+// how long a job waits for its source.
+const jobTTL = time.Hour
+
+// Config is the configuration of the build service.
+type Config struct {
+	// The address the service binds to.
+	Addr string
+	// The directory under which each job gets its own build directory.
+	BuildDir string
+	// The token authenticating the operator, which is the only one allowed to create jobs.
+	Token string
+	// The number of builds running concurrently.
+	Workers int
+	// The number of jobs with a received source that can wait for a worker.
+	QueueSize int
+	// The maximum duration of a build.
+	Timeout time.Duration
+	// The URL of the remote log store the build logs are persisted to. Build logs are not persisted
+	// when empty.
+	LogStoreURL string
+	// The directory of the source cache, shared by the jobs of the same App.
+	SourceCacheDir string
+}
+
+// Service is the build service.
+type Service struct {
+	cfg   Config
+	queue chan *job
+	cache *sourcecache.Cache
+
+	mu          sync.Mutex
+	jobs        map[string]*job
+	jobsByBuild map[string]*job
+}
+
+// New constructs a new Service.
+func New(cfg Config) *Service {
+	return &Service{
+		cfg:         cfg,
+		queue:       make(chan *job, cfg.QueueSize),
+		cache:       sourcecache.New(cfg.SourceCacheDir),
+		jobs:        make(map[string]*job),
+		jobsByBuild: make(map[string]*job),
+	}
+}
+
+// Serve serves the build service until the context is done.
+func (s *Service) Serve(ctx context.Context) error {
+	metrics.RegisterQueueDepth(func() int { return len(s.queue) })
+	for i := 0; i < s.cfg.Workers; i++ {
+		go s.work(ctx)
+	}
+	go s.collectJobs(ctx)
+
+	httpServer := &http.Server{
+		Addr:    s.cfg.Addr,
+		Handler: s.Handler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+
+	return nil
+}
+
+// Handler returns the http.Handler of the job API. Jobs are created by the operator, and the other
+// requests are authenticated with either the operator token or the token of the job.
+//
+//	POST   /jobs                creates a job.
+//	GET    /jobs/<id>           returns the job status.
+//	DELETE /jobs/<id>           cancels the job.
+//	POST   /jobs/<id>/manifest  replies with the blobs of a source manifest missing from the cache.
+//	POST   /jobs/<id>/blobs     uploads missing blobs as a gzipped tarball.
+//	PUT    /jobs/<id>/source    uploads the source as a gzipped tarball, or its manifest, queueing
+//	                            the build. The path, include and exclude parameters complete the
+//	                            source filter.
+//	GET    /jobs/<id>/logs      returns the build output, streaming it with follow=true.
+//
+// The /healthz, /readyz and /metrics endpoints are not authenticated.
+func (s *Service) Handler() http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("/healthz", s.handleHealth)
+	router.HandleFunc("/readyz", s.handleHealth)
+	router.Handle("/metrics", metrics.Handler())
+	router.HandleFunc("/jobs", s.handleCreate)
+	router.HandleFunc("/jobs/", s.handleJob)
+	return router
+}
+
+func (s *Service) handleHealth(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Service) handleCreate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !tokenMatches(r, s.cfg.Token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid job request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Namespace == "" || req.App == "" || req.Artifact == "" || req.ImageRegistry == "" || req.Token == "" {
+		http.Error(w, "invalid job request: namespace, app, artifact, imageRegistry and token are required", http.StatusBadRequest)
+		return
+	}
+	if err := req.filter().Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid job request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	j, created, err := s.createJob(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, j.status())
+}
+
+// createJob creates a job for the request. Creating a job for the same Artifact is idempotent while
+// the job isn't completed, a completed job is replaced by a new one.
+func (s *Service) createJob(req JobRequest) (*job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buildKey := req.Namespace + "/" + req.Artifact
+	if j, ok := s.jobsByBuild[buildKey]; ok && !j.status().Phase.Completed() {
+		return j, false, nil
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, false, err
+	}
+	j := &job{
+		request: req,
+		dir:     filepath.Join(s.cfg.BuildDir, id),
+		logs:    newJobLog(),
+		timer:   metrics.NewPhaseTimer(build.PhasePending),
+		state: Job{
+			ID:        id,
+			Namespace: req.Namespace,
+			App:       req.App,
+			Artifact:  req.Artifact,
+			Phase:     build.PhasePending,
+			CreatedAt: time.Now(),
+		},
+	}
+	s.jobs[id] = j
+	s.jobsByBuild[buildKey] = j
+
+	log.Printf("created job %s for artifact %s\n", id, buildKey)
+
+	return j, true, nil
+}
+
+func (s *Service) handleJob(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if len(split) > 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	j, ok := s.jobs[split[0]]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if !tokenMatches(r, s.cfg.Token) && !tokenMatches(r, j.request.Token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var resource string
+	if len(split) == 2 {
+		resource = split[1]
+	}
+	switch {
+	case resource == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, j.status())
+	case resource == "" && r.Method == http.MethodDelete:
+		s.cancel(j)
+		writeJSON(w, http.StatusOK, j.status())
+	case resource == "source" && r.Method == http.MethodPut:
+		s.handleSource(w, r, j)
+	case resource == "manifest" && r.Method == http.MethodPost:
+		s.handleManifest(w, r, j)
+	case resource == "blobs" && r.Method == http.MethodPost:
+		s.handleBlobs(w, r, j)
+	case resource == "logs" && r.Method == http.MethodGet:
+		s.handleLogs(w, r, j)
+	case resource == "" || resource == "source" || resource == "manifest" || resource == "blobs" || resource == "logs":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Service) handleSource(w http.ResponseWriter, r *http.Request, j *job) {
+	filter, err := build.MergeFilter(j.request.filter(), build.FilterFromQuery(r.URL.Query()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var manifest *sourcecache.Manifest
+	if r.Header.Get("Content-Type") == sourcecache.ContentType {
+		m, missing, ok := s.decodeManifest(w, r, j)
+		if !ok {
+			return
+		}
+		if len(missing) > 0 {
+			writeJSON(w, http.StatusPreconditionFailed, sourcecache.MissingBlobs{Hashes: missing})
+			return
+		}
+		manifest = &m
+	}
+	if !j.transition(build.PhasePending, build.PhaseReceiving) {
+		http.Error(w, fmt.Sprintf("the job is %s, the source was already received", j.status().Phase), http.StatusConflict)
+		return
+	}
+
+	ctx := tracing.ExtractHTTP(r.Context(), r.Header)
+	_, span := tracing.Tracer().Start(ctx, "extract source", trace.WithSpanKind(trace.SpanKindServer), jobAttributes(j))
+
+	sourceDir := filepath.Join(j.dir, "source")
+	if manifest != nil {
+		err = s.cache.Materialize(j.request.Namespace, j.request.App, *manifest, sourceDir, filter)
+	} else {
+		body := &countingReader{r: r.Body}
+		if err = build.Extract(body, sourceDir, filter); err == nil {
+			metrics.UploadSize.WithLabelValues(metrics.UploadTarball).Observe(float64(body.n))
+		}
+	}
+	tracing.End(span, err)
+	if err != nil {
+		os.RemoveAll(j.dir)
+		j.transition(build.PhaseReceiving, build.PhasePending)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j.filter = filter
+	j.spanContext = trace.SpanContextFromContext(ctx)
+	if !j.transition(build.PhaseReceiving, build.PhaseQueued) {
+		// The job was canceled while receiving the source.
+		os.RemoveAll(j.dir)
+		writeJSON(w, http.StatusOK, j.status())
+		return
+	}
+	select {
+	case s.queue <- j:
+	default:
+		os.RemoveAll(j.dir)
+		j.transition(build.PhaseQueued, build.PhasePending)
+		http.Error(w, "the build queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+func (s *Service) handleManifest(w http.ResponseWriter, r *http.Request, j *job) {
+	_, missing, ok := s.decodeManifest(w, r, j)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, sourcecache.MissingBlobs{Hashes: missing})
+}
+
+// decodeManifest decodes the manifest in the request body and looks up the blobs missing from the
+// cache of the App. It replies with an error and returns false when the manifest is invalid.
+func (s *Service) decodeManifest(w http.ResponseWriter, r *http.Request, j *job) (sourcecache.Manifest, []string, bool) {
+	var manifest sourcecache.Manifest
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %v", err), http.StatusBadRequest)
+		return manifest, nil, false
+	}
+	missing, err := s.cache.Missing(j.request.Namespace, j.request.App, manifest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return manifest, nil, false
+	}
+	return manifest, missing, true
+}
+
+func (s *Service) handleBlobs(w http.ResponseWriter, r *http.Request, j *job) {
+	if phase := j.status().Phase; phase != build.PhasePending {
+		http.Error(w, fmt.Sprintf("the job is %s, the source was already received", phase), http.StatusConflict)
+		return
+	}
+	body := &countingReader{r: r.Body}
+	if err := s.cache.Put(j.request.Namespace, j.request.App, body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metrics.UploadSize.WithLabelValues(metrics.UploadBlobs).Observe(float64(body.n))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) handleLogs(w http.ResponseWriter, r *http.Request, j *job) {
+	follow := r.URL.Query().Get("follow") == "true"
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rd := j.logs.reader(r.Context(), follow)
+	buf := make([]byte, 1024)
+	for {
+		n, err := rd.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// cancel cancels the job. A running build is interrupted.
+func (s *Service) cancel(j *job) {
+	j.mu.Lock()
+	cancel := j.cancel
+	j.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if j.setPhase(build.PhaseCanceled, "canceled") {
+		j.logs.Close()
+		log.Printf("canceled job %s\n", j.state.ID)
+	}
+}
+
+// work runs the queued jobs until the context is done.
+func (s *Service) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-s.queue:
+			s.run(ctx, j)
+		}
+	}
+}
+
+func (s *Service) run(ctx context.Context, j *job) {
+	defer os.RemoveAll(j.dir)
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	j.mu.Lock()
+	if j.state.Phase != build.PhaseQueued {
+		// The job was canceled while queued.
+		j.mu.Unlock()
+		return
+	}
+	j.cancel = cancel
+	j.mu.Unlock()
+
+	id := j.status().ID
+	log.Printf("running job %s\n", id)
+
+	ctx, span := tracing.Tracer().Start(trace.ContextWithSpanContext(ctx, j.spanContext), "app-builder build", jobAttributes(j))
+	defer func() {
+		// The job is completed once it runs, and failed unless it succeeded.
+		var err error
+		if status := j.status(); status.Phase != build.PhaseSucceeded {
+			err = errors.New(status.Message)
+		}
+		tracing.End(span, err)
+	}()
+
+	out := io.Writer(j.logs)
+	if s.cfg.LogStoreURL != "" && j.request.LogKey != "" {
+		buildLog, err := logstore.NewHTTPStore(s.cfg.LogStoreURL, j.request.Token).Create(context.Background(), j.request.LogKey)
+		if err != nil {
+			log.Printf("failed to create build log for job %s: %v\n", id, err)
+		} else {
+			defer buildLog.Close()
+			out = io.MultiWriter(j.logs, logstore.BestEffort(buildLog))
+		}
+	}
+
+	// Each job gets its own home, so the pack and docker configurations aren't shared between
+	// tenants.
+	home := filepath.Join(j.dir, "home")
+	if err := os.MkdirAll(home, 0700); err != nil {
+		j.setPhase(build.PhaseFailed, err.Error())
+		j.logs.Close()
+		return
+	}
+	opts := build.Options{
+		Dir:     build.AppDir(filepath.Join(j.dir, "source"), j.filter),
+		Image:   build.Image(j.request.ImageRegistry, j.request.Namespace, j.request.App, j.request.Artifact),
+		Builder: j.request.Builder,
+		Env:     append(os.Environ(), "HOME="+home),
+	}
+	if len(j.request.Bindings) > 0 {
+		opts.BindingsDir = filepath.Join(j.dir, "bindings")
+		if err := build.WriteBindings(opts.BindingsDir, j.request.Bindings); err != nil {
+			j.setPhase(build.PhaseFailed, err.Error())
+			j.logs.Close()
+			return
+		}
+	}
+	digest, err := build.Run(ctx, opts, out, func(phase build.Phase) {
+		j.setPhase(phase, "")
+	})
+	switch {
+	case err == nil:
+		j.setDigest(digest)
+		j.setPhase(build.PhaseSucceeded, "")
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		j.setPhase(build.PhaseFailed, "build timed out")
+	default:
+		// A canceled job is already completed, so this is a no-op for it.
+		j.setPhase(build.PhaseFailed, err.Error())
+	}
+	j.logs.Close()
+
+	log.Printf("job %s completed: %s\n", id, j.status().Phase)
+}
+
+// jobAttributes returns the trace attributes of the job.
+func jobAttributes(j *job) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("manor.app", j.request.Namespace+"/"+j.request.App),
+		attribute.String("manor.artifact", j.request.Artifact),
+		attribute.String("manor.job", j.state.ID),
+	)
+}
+
+// collectJobs forgets the expired jobs and prunes the source cache, until the context is done.
+func (s *Service) collectJobs(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.forgetExpiredJobs(time.Now())
+
+		if err := s.cache.Prune(sourcecache.DefaultMaxAge); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// forgetExpiredJobs forgets the jobs completed for longer than the job TTL, and the jobs still
+// pending, i.e. whose source was never received, created longer than the job TTL ago.
+func (s *Service) forgetExpiredJobs(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		status := j.status()
+		expired := status.FinishedAt != nil && now.Sub(*status.FinishedAt) > jobTTL ||
+			status.Phase == build.PhasePending && now.Sub(status.CreatedAt) > jobTTL
+		if !expired {
+			continue
+		}
+		if j.setPhase(build.PhaseCanceled, "the source was not received in time") {
+			j.logs.Close()
+		}
+		delete(s.jobs, id)
+		// A completed job may have been replaced by a new job for the same Artifact.
+		buildKey := status.Namespace + "/" + status.Artifact
+		if s.jobsByBuild[buildKey] == j {
+			delete(s.jobsByBuild, buildKey)
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// tokenMatches returns whether the request carries the given bearer token.
+func tokenMatches(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/service"
+)
+
+const operatorToken = "operator-token"
+
+// sourceTarball returns a gzipped tarball with a single file.
+func sourceTarball() []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := []byte("web: node index.js\n")
+	Expect(tw.WriteHeader(&tar.Header{Name: "Procfile", Mode: 0644, Size: int64(len(content))})).To(Succeed())
+	_, err := tw.Write(content)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("Build service", func() {
+	ctx := context.Background()
+
+	var (
+		dir    string
+		server *httptest.Server
+		client *service.Client
+	)
+
+	// jobRequest returns a valid request to build the Artifact.
+	jobRequest := func(artifact string) service.JobRequest {
+		return service.JobRequest{
+			Namespace:     "default",
+			App:           "app",
+			Artifact:      artifact,
+			ImageRegistry: "registry.example.com",
+			Token:         artifact + "-token",
+		}
+	}
+
+	// do sends a request to the job API with the token.
+	do := func(method, path, token string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return res
+	}
+
+	// decodeJob decodes the job in the response body.
+	decodeJob := func(res *http.Response) service.Job {
+		defer res.Body.Close()
+		var job service.Job
+		Expect(json.NewDecoder(res.Body).Decode(&job)).To(Succeed())
+		return job
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "service")
+		Expect(err).NotTo(HaveOccurred())
+		// The service isn't served, so no worker picks the queued jobs up.
+		s := service.New(service.Config{
+			BuildDir:       dir + "/build",
+			Token:          operatorToken,
+			Workers:        1,
+			QueueSize:      1,
+			SourceCacheDir: dir + "/source-cache",
+		})
+		server = httptest.NewServer(s.Handler())
+		client = service.NewClient(server.URL, operatorToken)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("creating jobs", func() {
+		It("creates a pending job once per Artifact", func() {
+			job, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(job.ID).NotTo(BeEmpty())
+			Expect(job.Phase).To(Equal(build.PhasePending))
+			Expect(job.Artifact).To(Equal("app-1"))
+
+			again, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.ID).To(Equal(job.ID))
+
+			other, err := client.CreateJob(ctx, jobRequest("app-2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other.ID).NotTo(Equal(job.ID))
+		})
+
+		It("creates a new job once the job of the Artifact completed", func() {
+			job, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.CancelJob(ctx, client.JobURL(job.ID))).To(Succeed())
+
+			again, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.ID).NotTo(Equal(job.ID))
+			Expect(again.Phase).To(Equal(build.PhasePending))
+
+			// The canceled job can still be fetched.
+			job, err = client.GetJob(ctx, client.JobURL(job.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(job.Phase).To(Equal(build.PhaseCanceled))
+		})
+
+		It("only lets the operator create jobs", func() {
+			body, _ := json.Marshal(jobRequest("app-1"))
+			Expect(do(http.MethodPost, "/jobs", "", body).StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(do(http.MethodPost, "/jobs", "app-1-token", body).StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(do(http.MethodGet, "/jobs", operatorToken, nil).StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("rejects the invalid requests", func() {
+			missingToken := jobRequest("app-1")
+			missingToken.Token = ""
+			invalidFilter := jobRequest("app-1")
+			invalidFilter.Path = "../other"
+			for _, req := range []service.JobRequest{missingToken, invalidFilter} {
+				_, err := client.CreateJob(ctx, req)
+				Expect(err).To(MatchError(ContainSubstring("400")))
+			}
+			Expect(do(http.MethodPost, "/jobs", operatorToken, []byte("{")).StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("getting jobs", func() {
+		It("authenticates the operator and the job token", func() {
+			job, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			got, err := client.GetJob(ctx, client.JobURL(job.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.ID).To(Equal(job.ID))
+
+			res := do(http.MethodGet, "/jobs/"+job.ID, "app-1-token", nil)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(decodeJob(res).ID).To(Equal(job.ID))
+
+			// The token of another job doesn't give access to the job.
+			_, err = client.CreateJob(ctx, jobRequest("app-2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(do(http.MethodGet, "/jobs/"+job.ID, "app-2-token", nil).StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("reports the jobs that don't exist", func() {
+			_, err := client.GetJob(ctx, client.JobURL("unknown"))
+			Expect(errors.Is(err, service.ErrJobNotFound)).To(BeTrue(), "unexpected error %v", err)
+			Expect(do(http.MethodGet, "/jobs/unknown/source/more", operatorToken, nil).StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("uploading the source", func() {
+		It("queues the job once, until the queue is full", func() {
+			job, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			res := do(http.MethodPut, "/jobs/"+job.ID+"/source", "app-1-token", sourceTarball())
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(decodeJob(res).Phase).To(Equal(build.PhaseQueued))
+
+			res = do(http.MethodPut, "/jobs/"+job.ID+"/source", "app-1-token", sourceTarball())
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+			// The queue holds a single job, so the next one is left pending.
+			other, err := client.CreateJob(ctx, jobRequest("app-2"))
+			Expect(err).NotTo(HaveOccurred())
+			res = do(http.MethodPut, "/jobs/"+other.ID+"/source", "app-2-token", sourceTarball())
+			Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			other, err = client.GetJob(ctx, client.JobURL(other.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other.Phase).To(Equal(build.PhasePending))
+		})
+
+		It("leaves the job pending when the source is invalid", func() {
+			job, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			res := do(http.MethodPut, "/jobs/"+job.ID+"/source", "app-1-token", []byte("not a tarball"))
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			job, err = client.GetJob(ctx, client.JobURL(job.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(job.Phase).To(Equal(build.PhasePending))
+		})
+	})
+
+	Describe("canceling jobs", func() {
+		It("cancels a queued job and ends its logs", func() {
+			job, err := client.CreateJob(ctx, jobRequest("app-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(do(http.MethodPut, "/jobs/"+job.ID+"/source", "app-1-token", sourceTarball()).StatusCode).
+				To(Equal(http.StatusAccepted))
+
+			Expect(client.CancelJob(ctx, client.JobURL(job.ID))).To(Succeed())
+			job, err = client.GetJob(ctx, client.JobURL(job.ID))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(job.Phase).To(Equal(build.PhaseCanceled))
+			Expect(job.FinishedAt).NotTo(BeNil())
+
+			res := do(http.MethodGet, "/jobs/"+job.ID+"/logs?follow=true", "app-1-token", nil)
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			_, err = ioutil.ReadAll(res.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			// A canceled job can't be built anymore.
+			res = do(http.MethodPut, "/jobs/"+job.ID+"/source", "app-1-token", sourceTarball())
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+		})
+	})
+
+	It("serves the unauthenticated health endpoints", func() {
+		for _, path := range []string{"/healthz", "/readyz"} {
+			res := do(http.MethodGet, path, "", nil)
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(strings.TrimSpace(string(body))).To(Equal("ok"))
+		}
+	})
+})
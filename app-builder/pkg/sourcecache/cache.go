@@ -0,0 +1,247 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sourcecache implements the content-addressed cache of the App sources, which lets
+// clients upload only the files that changed since a previous build.
+//
+// The client sends the Manifest of the source, uploads the blobs the cache is missing with
+// WriteBlobs, and the source is then materialized from the cache.
+package sourcecache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+// DefaultMaxAge is how long the unused blobs are kept by default.
+const DefaultMaxAge = 7 * 24 * time.Hour
+
+// Cache is a content-addressed cache of the source files, partitioned per App.
+type Cache struct {
+	dir string
+}
+
+// New constructs a new Cache stored in dir.
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// appDir returns the directory of the blobs of an App.
+func (c *Cache) appDir(namespace, app string) (string, error) {
+	for _, name := range []string{namespace, app} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return "", fmt.Errorf("invalid App %s/%s", namespace, app)
+		}
+	}
+	return filepath.Join(c.dir, namespace, app), nil
+}
+
+func blobPath(appDir, hash string) string {
+	return filepath.Join(appDir, hash[:2], hash)
+}
+
+// Missing returns the hashes of the manifest files the cache of the App doesn't have.
+func (c *Cache) Missing(namespace, app string, m Manifest) ([]string, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	appDir, err := c.appDir(namespace, app)
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	now := time.Now()
+	for _, hash := range m.Hashes() {
+		// Touching the blob keeps it from being pruned before the build uses it.
+		if err := os.Chtimes(blobPath(appDir, hash), now, now); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to look up blob: %w", err)
+			}
+			missing = append(missing, hash)
+		}
+	}
+	return missing, nil
+}
+
+// Put adds the blobs of a gzipped tarball written by WriteBlobs to the cache of the App. The
+// content of every blob is checked against its hash.
+func (c *Cache) Put(namespace, app string, r io.Reader) error {
+	appDir, err := c.appDir(namespace, app)
+	if err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to put blobs: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to put blobs: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return fmt.Errorf("failed to put blobs: %q is not a regular file", hdr.Name)
+		}
+		if !validHash(hdr.Name) {
+			return fmt.Errorf("failed to put blobs: invalid blob name %q", hdr.Name)
+		}
+		if err := putBlob(appDir, hdr.Name, tr); err != nil {
+			return fmt.Errorf("failed to put blobs: %w", err)
+		}
+	}
+}
+
+func putBlob(appDir, hash string, r io.Reader) error {
+	p := blobPath(appDir, hash)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		return fmt.Errorf("the content of blob %s doesn't match its hash", hash)
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Materialize writes the manifest files selected by the filter into dir from the cache of the App,
+// keeping the layout of the source like build.Extract does.
+func (c *Cache) Materialize(namespace, app string, m Manifest, dir string, filter build.Filter) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	appDir, err := c.appDir(namespace, app)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to materialize source: %w", err)
+	}
+	now := time.Now()
+	var links []File
+	for _, f := range m.Files {
+		if !filter.Match(f.Path) {
+			continue
+		}
+		if f.Link != "" {
+			links = append(links, f)
+			continue
+		}
+		filePath := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to materialize source: %w", err)
+		}
+		blob := blobPath(appDir, f.Hash)
+		if err := copyFile(filePath, blob, f.Mode); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("failed to materialize source: missing blob %s of %q", f.Hash, f.Path)
+			}
+			return fmt.Errorf("failed to materialize source: %w", err)
+		}
+		os.Chtimes(blob, now, now)
+	}
+	// The links are created last, so no file is written through them, and the deepest ones first,
+	// so no link is created through another one.
+	sort.SliceStable(links, func(i, j int) bool {
+		return strings.Count(path.Clean(links[i].Path), "/") > strings.Count(path.Clean(links[j].Path), "/")
+	})
+	for _, f := range links {
+		filePath := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to materialize source: %w", err)
+		}
+		if err := os.Symlink(f.Link, filePath); err != nil {
+			return fmt.Errorf("failed to materialize source: %w", err)
+		}
+	}
+	if fi, err := os.Stat(build.AppDir(dir, filter)); err != nil || !fi.IsDir() {
+		return fmt.Errorf("failed to materialize source: the path %q is not a directory of the source", filter.Root())
+	}
+	return nil
+}
+
+// copyFile copies the blob into a new file, so builds can't alter the cache.
+func copyFile(filePath, blob string, mode os.FileMode) error {
+	src, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if mode == 0 {
+		mode = 0644
+	}
+	dst, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Prune removes the blobs that were not used for maxAge.
+func (c *Cache) Prune(maxAge time.Duration) error {
+	deadline := time.Now().Add(-maxAge)
+	err := filepath.Walk(c.dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() && fi.ModTime().Before(deadline) {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune source cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sourcecache_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/sourcecache"
+)
+
+// hashOf returns the hex-encoded sha256 hash of content.
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// blobsTarball returns a gzipped tarball of blobs with the given names and contents.
+func blobsTarball(blobs map[string]string, typeflag byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range blobs {
+		hdr := &tar.Header{Typeflag: typeflag, Name: name, Mode: 0644}
+		if typeflag == tar.TypeSymlink {
+			hdr.Linkname = content
+		} else {
+			hdr.Size = int64(len(content))
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		if typeflag != tar.TypeSymlink {
+			_, err := tw.Write([]byte(content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(zw.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// writeFiles writes the files with the given slash-separated paths and contents under dir.
+func writeFiles(dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+	}
+}
+
+var _ = Describe("Source cache", func() {
+	var (
+		dir   string
+		cache *sourcecache.Cache
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "sourcecache")
+		Expect(err).NotTo(HaveOccurred())
+		cache = sourcecache.New(filepath.Join(dir, "cache"))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	hash := hashOf("content")
+
+	DescribeTable("validating manifests",
+		func(file sourcecache.File, valid bool) {
+			err := sourcecache.Manifest{Files: []sourcecache.File{file}}.Validate()
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("a file", sourcecache.File{Path: "src/main.go", Hash: hash}, true),
+		Entry("a path cleaned within the source", sourcecache.File{Path: "src/../main.go", Hash: hash}, true),
+		Entry("an empty path", sourcecache.File{Hash: hash}, false),
+		Entry("the source root", sourcecache.File{Path: ".", Hash: hash}, false),
+		Entry("an absolute path", sourcecache.File{Path: "/etc/passwd", Hash: hash}, false),
+		Entry("the parent directory", sourcecache.File{Path: "..", Hash: hash}, false),
+		Entry("a path outside of the source", sourcecache.File{Path: "src/../../main.go", Hash: hash}, false),
+		Entry("a short hash", sourcecache.File{Path: "main.go", Hash: hash[:10]}, false),
+		Entry("an uppercase hash", sourcecache.File{Path: "main.go", Hash: strings.ToUpper(hash)}, false),
+		Entry("a hash that isn't hex", sourcecache.File{Path: "main.go", Hash: strings.Repeat("z", 64)}, false),
+		Entry("a hash escaping the cache", sourcecache.File{Path: "main.go", Hash: "../" + hash[3:]}, false),
+		Entry("a link within the source", sourcecache.File{Path: "src/link", Link: "../main.go"}, true),
+		Entry("a link with a hash", sourcecache.File{Path: "src/link", Link: "../main.go", Hash: hash}, false),
+		Entry("an absolute link", sourcecache.File{Path: "src/link", Link: "/etc/passwd"}, false),
+		Entry("a link outside of the source", sourcecache.File{Path: "src/link", Link: "../../etc/passwd"}, false),
+	)
+
+	DescribeTable("rejecting invalid Apps",
+		func(namespace, app string) {
+			manifest := sourcecache.Manifest{Files: []sourcecache.File{{Path: "main.go", Hash: hash}}}
+			_, err := cache.Missing(namespace, app, manifest)
+			Expect(err).To(MatchError(ContainSubstring("invalid App")))
+			err = cache.Put(namespace, app, bytes.NewReader(blobsTarball(map[string]string{hash: "content"}, tar.TypeReg)))
+			Expect(err).To(MatchError(ContainSubstring("invalid App")))
+			err = cache.Materialize(namespace, app, manifest, filepath.Join(dir, "build"), build.Filter{})
+			Expect(err).To(MatchError(ContainSubstring("invalid App")))
+			Expect(filepath.Join(dir, "cache")).NotTo(BeADirectory())
+		},
+		Entry("an empty namespace", "", "app"),
+		Entry("an empty name", "default", ""),
+		Entry("the current directory", "default", "."),
+		Entry("the parent directory", "..", "app"),
+		Entry("a slash", "default", "../app"),
+		Entry("a backslash", "default", `..\app`),
+	)
+
+	Describe("putting blobs", func() {
+		It("checks the content of the blobs against their hash", func() {
+			err := cache.Put("default", "app", bytes.NewReader(blobsTarball(map[string]string{hash: "tampered"}, tar.TypeReg)))
+			Expect(err).To(MatchError(ContainSubstring("doesn't match its hash")))
+
+			missing, err := cache.Missing("default", "app", sourcecache.Manifest{Files: []sourcecache.File{{Path: "main.go", Hash: hash}}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missing).To(ConsistOf(hash))
+		})
+
+		It("rejects the blobs with an invalid name", func() {
+			err := cache.Put("default", "app", bytes.NewReader(blobsTarball(map[string]string{"../../escaped": "content"}, tar.TypeReg)))
+			Expect(err).To(MatchError(ContainSubstring("invalid blob name")))
+			Expect(filepath.Join(dir, "escaped")).NotTo(BeAnExistingFile())
+		})
+
+		It("rejects the blobs that aren't regular files", func() {
+			err := cache.Put("default", "app", bytes.NewReader(blobsTarball(map[string]string{hash: "/etc/passwd"}, tar.TypeSymlink)))
+			Expect(err).To(MatchError(ContainSubstring("not a regular file")))
+		})
+
+		It("rejects a body that isn't a gzipped tarball", func() {
+			Expect(cache.Put("default", "app", strings.NewReader("content"))).NotTo(Succeed())
+		})
+	})
+
+	It("uploads only the missing blobs and materializes the source", func() {
+		source := filepath.Join(dir, "source")
+		writeFiles(source, map[string]string{
+			"web/index.js":     "index",
+			"web/copy.js":      "index",
+			"web/lib/util.js":  "util",
+			"web/README.md":    "readme",
+			"api/main.go":      "main",
+			"web/node_modules": "",
+		})
+		Expect(os.Symlink("lib/util.js", filepath.Join(source, "web", "util.js"))).To(Succeed())
+
+		manifest, err := sourcecache.BuildManifest(source, build.Filter{Exclude: []string{"node_modules"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Validate()).To(Succeed())
+		Expect(manifest.Hashes()).To(ConsistOf(hashOf("index"), hashOf("util"), hashOf("readme"), hashOf("main")))
+
+		missing, err := cache.Missing("default", "app", manifest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(Equal(manifest.Hashes()))
+
+		var blobs bytes.Buffer
+		Expect(sourcecache.WriteBlobs(&blobs, source, manifest, missing[1:])).To(Succeed())
+		Expect(cache.Put("default", "app", &blobs)).To(Succeed())
+		missing, err = cache.Missing("default", "app", manifest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(Equal(manifest.Hashes()[:1]))
+
+		// The build fails until every blob is uploaded.
+		buildDir := filepath.Join(dir, "build")
+		Expect(cache.Materialize("default", "app", manifest, buildDir, build.Filter{})).
+			To(MatchError(ContainSubstring("missing blob")))
+		os.RemoveAll(buildDir)
+
+		blobs.Reset()
+		Expect(sourcecache.WriteBlobs(&blobs, source, manifest, missing)).To(Succeed())
+		Expect(cache.Put("default", "app", &blobs)).To(Succeed())
+
+		// The cache is partitioned per App.
+		missing, err = cache.Missing("default", "other", manifest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(Equal(manifest.Hashes()))
+
+		filter := build.Filter{Path: "web", Exclude: []string{"*.md"}}
+		Expect(cache.Materialize("default", "app", manifest, buildDir, filter)).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "web", "copy.js"))).To(Equal([]byte("index")))
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "web", "util.js"))).To(Equal([]byte("util")))
+		link, err := os.Readlink(filepath.Join(buildDir, "web", "util.js"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(link).To(Equal("lib/util.js"))
+		Expect(filepath.Join(buildDir, "web", "README.md")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "web", "node_modules")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "api")).NotTo(BeADirectory())
+
+		// A path that isn't a directory of the source can't be built.
+		Expect(cache.Materialize("default", "app", manifest, filepath.Join(dir, "other"), build.Filter{Path: "docs"})).
+			To(MatchError(ContainSubstring("not a directory of the source")))
+	})
+
+	It("doesn't write blobs missing from the manifest", func() {
+		var blobs bytes.Buffer
+		err := sourcecache.WriteBlobs(&blobs, dir, sourcecache.Manifest{}, []string{hash})
+		Expect(err).To(MatchError(ContainSubstring("not in the manifest")))
+	})
+
+	It("prunes the blobs unused for the max age", func() {
+		Expect(cache.Put("default", "app", bytes.NewReader(blobsTarball(map[string]string{
+			hash:             "content",
+			hashOf("recent"): "recent",
+		}, tar.TypeReg)))).To(Succeed())
+		blob := filepath.Join(dir, "cache", "default", "app", hash[:2], hash)
+		old := time.Now().Add(-2 * time.Hour)
+		Expect(os.Chtimes(blob, old, old)).To(Succeed())
+
+		Expect(cache.Prune(time.Hour)).To(Succeed())
+		missing, err := cache.Missing("default", "app", sourcecache.Manifest{Files: []sourcecache.File{
+			{Path: "content", Hash: hash},
+			{Path: "recent", Hash: hashOf("recent")},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(ConsistOf(hash))
+
+		Expect(sourcecache.New(filepath.Join(dir, "none")).Prune(time.Hour)).To(Succeed())
+	})
+})
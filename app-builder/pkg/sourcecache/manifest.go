@@ -0,0 +1,216 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sourcecache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+// ContentType is the content type of a manifest sent in place of the source tarball.
+const ContentType = "application/vnd.manor.manifest+json"
+
+// Manifest lists the files of a source by content.
+type Manifest struct {
+	Files []File `json:"files"`
+}
+
+// MissingBlobs is the reply to a manifest, listing the blobs the client has to upload.
+type MissingBlobs struct {
+	Hashes []string `json:"missing"`
+}
+
+// File is a file of a source.
+type File struct {
+	// The slash-separated path of the file, relative to the source root.
+	Path string `json:"path"`
+	// The hex-encoded sha256 hash of the content of a regular file.
+	Hash string `json:"hash,omitempty"`
+	// The permission bits of a regular file.
+	Mode os.FileMode `json:"mode,omitempty"`
+	// The target of a symbolic link.
+	Link string `json:"link,omitempty"`
+}
+
+// Validate validates the manifest, so it can't reference files outside of the source root.
+func (m Manifest) Validate() error {
+	for _, f := range m.Files {
+		clean := path.Clean(f.Path)
+		if f.Path == "" || path.IsAbs(f.Path) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("invalid manifest: invalid path %q", f.Path)
+		}
+		if f.Link != "" {
+			if f.Hash != "" {
+				return fmt.Errorf("invalid manifest: the link %q has a hash", f.Path)
+			}
+			target := path.Clean(path.Join(path.Dir(clean), f.Link))
+			if path.IsAbs(f.Link) || target == ".." || strings.HasPrefix(target, "../") {
+				return fmt.Errorf("invalid manifest: invalid link %q", f.Path)
+			}
+			continue
+		}
+		if !validHash(f.Hash) {
+			return fmt.Errorf("invalid manifest: invalid hash of %q", f.Path)
+		}
+	}
+	return nil
+}
+
+// Hashes returns the sorted, unique hashes of the manifest files.
+func (m Manifest) Hashes() []string {
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, f := range m.Files {
+		if f.Hash == "" || seen[f.Hash] {
+			continue
+		}
+		seen[f.Hash] = true
+		hashes = append(hashes, f.Hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil && strings.ToLower(hash) == hash
+}
+
+// BuildManifest returns the manifest of the files under dir selected by the filter.
+func BuildManifest(dir string, filter build.Filter) (Manifest, error) {
+	var m Manifest
+	err := filepath.Walk(dir, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if fi.IsDir() {
+			if name != "." && !filter.MatchDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filter.Match(name) {
+			return nil
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			m.Files = append(m.Files, File{Path: name, Link: filepath.ToSlash(link)})
+		case fi.Mode().IsRegular():
+			hash, err := hashFile(filePath)
+			if err != nil {
+				return err
+			}
+			m.Files = append(m.Files, File{Path: name, Hash: hash, Mode: fi.Mode().Perm()})
+		}
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to build manifest: %w", err)
+	}
+	return m, nil
+}
+
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteBlobs writes the content of the manifest files with the given hashes, read from dir, as a
+// gzipped tarball of blobs named by their hash.
+func WriteBlobs(w io.Writer, dir string, m Manifest, hashes []string) error {
+	wanted := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		wanted[hash] = true
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, f := range m.Files {
+		if !wanted[f.Hash] {
+			continue
+		}
+		delete(wanted, f.Hash)
+		if err := writeBlob(tw, filepath.Join(dir, filepath.FromSlash(f.Path)), f.Hash); err != nil {
+			return fmt.Errorf("failed to write blobs: %w", err)
+		}
+	}
+	if len(wanted) > 0 {
+		return fmt.Errorf("failed to write blobs: %d hashes are not in the manifest", len(wanted))
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write blobs: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write blobs: %w", err)
+	}
+	return nil
+}
+
+func writeBlob(tw *tar.Writer, filePath, hash string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     hash,
+		Size:     fi.Size(),
+		Mode:     0644,
+	}); err != nil {
+		return err
+	}
+	// The file can't grow past the size in the header, and a changed file is caught by the hash
+	// check on the receiving end.
+	_, err = io.Copy(tw, io.LimitReader(f, fi.Size()))
+	return err
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up the OpenTelemetry tracing of the manor components, which export their
+// spans to an OTLP/HTTP collector. The trace context of a push is propagated from the CLI to the
+// app-builder through the W3C Trace Context HTTP headers, and to the operator through the
+// annotations of the Artifact.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// EndpointEnv is the environment variable holding the URL of the OTLP/HTTP collector, e.g.
+	// http://otel-collector:4318.
+	EndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+	// annotationPrefix prefixes the trace context keys in the annotations of a resource, e.g.
+	// manor.codelogia.com/traceparent.
+	annotationPrefix = "manor.codelogia.com/"
+
+	tracerName = "github.com/codelogia/manor"
+)
+
+// propagator propagates the trace context between the components. It doesn't depend on Setup, so
+// the trace context is propagated even when the spans aren't exported.
+var propagator = propagation.TraceContext{}
+
+// Config is the tracing configuration of a component.
+type Config struct {
+	// The name of the component, e.g. manor-operator.
+	ServiceName string
+	// The URL of the OTLP/HTTP collector the spans are exported to in batches. The spans are sent
+	// over plain HTTP when the scheme is http.
+	Endpoint string
+	// The exporter the spans are synchronously exported to instead of the collector, e.g. an
+	// in-memory exporter in tests.
+	Exporter sdktrace.SpanExporter
+}
+
+// Setup installs the tracer provider of the component. No span is recorded when neither an
+// endpoint nor an exporter is configured. It returns the function flushing the pending spans and
+// shutting the provider down.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var exportOption sdktrace.TracerProviderOption
+	switch {
+	case cfg.Exporter != nil:
+		exportOption = sdktrace.WithSyncer(cfg.Exporter)
+	case cfg.Endpoint != "":
+		exporter, err := newExporter(ctx, cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		exportOption = sdktrace.WithBatcher(exporter)
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		exportOption,
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newExporter returns the exporter to the OTLP/HTTP collector at endpoint.
+func newExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", endpoint)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if path := strings.TrimSuffix(u.Path, "/"); path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(path+"/v1/traces"))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return exporter, nil
+}
+
+// Tracer returns the tracer of the manor components.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// End ends the span, recording the error of a failed operation.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectHTTP sets the trace context of ctx in the request headers.
+func InjectHTTP(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHTTP returns ctx with the trace context of the request headers, if any.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectAnnotations sets the trace context of ctx in the annotations of a resource, returning them
+// as they are allocated when nil.
+func InjectAnnotations(ctx context.Context, annotations map[string]string) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	propagator.Inject(ctx, annotationCarrier(annotations))
+	return annotations
+}
+
+// ExtractAnnotations returns ctx with the trace context of the annotations of a resource, if any.
+func ExtractAnnotations(ctx context.Context, annotations map[string]string) context.Context {
+	return propagator.Extract(ctx, annotationCarrier(annotations))
+}
+
+// annotationCarrier carries the trace context in the annotations of a resource.
+type annotationCarrier map[string]string
+
+func (c annotationCarrier) Get(key string) string {
+	return c[annotationPrefix+key]
+}
+
+func (c annotationCarrier) Set(key, value string) {
+	c[annotationPrefix+key] = value
+}
+
+func (c annotationCarrier) Keys() []string {
+	var keys []string
+	for key := range c {
+		if strings.HasPrefix(key, annotationPrefix) {
+			keys = append(keys, strings.TrimPrefix(key, annotationPrefix))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+	"github.com/codelogia/manor/cli/pkg/cmd"
+)
+
+func main() {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "manor-cli",
+		Endpoint:    os.Getenv(tracing.EndpointEnv),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = cmd.New().ExecuteContext(context.Background())
+
+	// The spans of the command are flushed before exiting.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err != nil {
+		cancel()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The manifest-schema command generates the JSON Schema of the manor.yml manifest from the Go types
+// of the manifest package, so the schema has the same documentation and validation as the types.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"golang.org/x/tools/go/packages"
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/controller-tools/pkg/crd"
+	crdmarkers "sigs.k8s.io/controller-tools/pkg/crd/markers"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+
+	"github.com/codelogia/manor/cli/pkg/manifest"
+)
+
+const manifestPackage = "github.com/codelogia/manor/cli/pkg/manifest"
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("usage: %s <output>", os.Args[0])
+	}
+	schema, err := generate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(os.Args[1], append(schema, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generate() ([]byte, error) {
+	pkgs, err := loader.LoadRoots(manifestPackage)
+	if err != nil {
+		return nil, err
+	}
+	registry := &markers.Registry{}
+	if err := crdmarkers.Register(registry); err != nil {
+		return nil, err
+	}
+	parser := &crd.Parser{
+		Collector: &markers.Collector{Registry: registry},
+		Checker:   &loader.TypeChecker{},
+	}
+	crd.AddKnownTypes(parser)
+	for _, pkg := range pkgs {
+		parser.NeedPackage(pkg)
+	}
+	// Type errors are ignored, like controller-gen does.
+	if loader.PrintErrors(pkgs, packages.TypeError) {
+		return nil, fmt.Errorf("failed to load %s", manifestPackage)
+	}
+
+	ident := crd.TypeIdent{Package: pkgs[0], Name: "Manifest"}
+	parser.NeedFlattenedSchemaFor(ident)
+	schema := parser.FlattenedSchemata[ident]
+	closeObjects(&schema)
+	schema.Schema = "http://json-schema.org/draft-04/schema#"
+	schema.Title = fmt.Sprintf("manor.yml manifest version %d", manifest.Version)
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// closeObjects rejects the unknown properties of the objects of the schema, as the manifest parser
+// does.
+func closeObjects(schema *apiext.JSONSchemaProps) {
+	if len(schema.Properties) > 0 && schema.AdditionalProperties == nil {
+		schema.AdditionalProperties = &apiext.JSONSchemaPropsOrBool{Allows: false}
+	}
+	for name, property := range schema.Properties {
+		closeObjects(&property)
+		schema.Properties[name] = property
+	}
+	if schema.Items != nil && schema.Items.Schema != nil {
+		closeObjects(schema.Items.Schema)
+	}
+	if schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil {
+		closeObjects(schema.AdditionalProperties.Schema)
+	}
+}
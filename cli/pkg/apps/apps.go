@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apps implements the operations on the apps and their artifacts shared by the CLI and the
+// API server.
+package apps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// ValidateEnvVarName validates the name of an environment variable set on an app.
+func ValidateEnvVarName(name string) error {
+	if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+		return fmt.Errorf("invalid environment variable name %q: %s", name, strings.Join(errs, ", "))
+	}
+	if name == "PORT" {
+		return fmt.Errorf("PORT is set to the first port of the app")
+	}
+	return nil
+}
+
+// SetEnvVar sets the environment variable in env, replacing the one with the same name, if any.
+func SetEnvVar(env []corev1.EnvVar, envVar corev1.EnvVar) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == envVar.Name {
+			env[i] = envVar
+			return env
+		}
+	}
+	return append(env, envVar)
+}
+
+// UnsetEnvVar removes the environment variable from env, if it's set.
+func UnsetEnvVar(env []corev1.EnvVar, name string) []corev1.EnvVar {
+	filtered := env[:0]
+	for _, envVar := range env {
+		if envVar.Name != name {
+			filtered = append(filtered, envVar)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+// Patch patches the App with the changes made by mutate. The patch is rejected if the App was
+// changed since it was read, in which case it's read again and mutate is retried.
+func Patch(ctx context.Context, c client.Client, key client.ObjectKey, mutate func(app *manorv1.App)) (*manorv1.App, error) {
+	app := &manorv1.App{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := c.Get(ctx, key, app); err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(app.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		mutate(app)
+		return c.Patch(ctx, app, patch)
+	})
+	return app, err
+}
+
+// ArtifactToken returns the token authenticating the requests to the app-builder of the Artifact,
+// or an empty string if its Secret wasn't created yet.
+func ArtifactToken(ctx context.Context, c client.Reader, artifact *manorv1.Artifact) (string, error) {
+	secrets := &corev1.SecretList{}
+	if err := c.List(ctx, secrets, client.InNamespace(artifact.Namespace), client.MatchingLabels{manorv1.ArtifactLabel: artifact.Name}); err != nil {
+		return "", err
+	}
+	if len(secrets.Items) == 0 {
+		return "", nil
+	}
+	return string(secrets.Items[0].Data["token"]), nil
+}
+
+// AppBuilderPod returns the app-builder Pod of the Artifact, or nil if there is none.
+func AppBuilderPod(ctx context.Context, c client.Reader, artifact *manorv1.Artifact) (*corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(artifact.Namespace), client.MatchingLabels{manorv1.ArtifactLabel: artifact.Name}); err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+	return &pods.Items[0], nil
+}
+
+// BuildLogReader opens the build logs of Artifacts.
+type BuildLogReader struct {
+	// Client gets the app-builder resources of the Artifacts.
+	Client client.Reader
+	// Clientset reads the logs of the app-builder Pods.
+	Clientset kubernetes.Interface
+	// HTTPClient requests the app-builder service. http.DefaultClient is used when it's nil.
+	HTTPClient *http.Client
+	// ResolveURL returns the URL reaching an in-cluster URL, e.g. through a port-forward. The URLs
+	// are requested as is when it's nil.
+	ResolveURL func(ctx context.Context, rawURL string) (string, error)
+	// Timestamps is whether the lines of the app-builder Pod logs are prefixed with their time.
+	Timestamps bool
+}
+
+// Open opens the build log of the Artifact from the log store, the app-builder service or the
+// app-builder Pod, in that order, returning whether its lines are prefixed with their time. It
+// returns a nil reader when the log is not available.
+func (r *BuildLogReader) Open(ctx context.Context, artifact *manorv1.Artifact, follow bool) (io.ReadCloser, bool, error) {
+	token, err := ArtifactToken(ctx, r.Client, artifact)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch {
+	case artifact.Status.LogRef != "" && token != "":
+		logURL, err := r.resolveURL(ctx, artifact.Status.LogRef)
+		if err != nil {
+			return nil, false, err
+		}
+		key := logstore.Key(artifact.Namespace, artifact.Name)
+		store := logstore.NewHTTPStore(strings.TrimSuffix(logURL, logstore.HandlerPrefix+key), token)
+		rc, err := store.Open(ctx, key, follow)
+		if errors.Is(err, logstore.ErrNotFound) {
+			return nil, false, nil
+		}
+		return rc, false, err
+
+	case artifact.Status.BuildJob != "" && token != "":
+		jobURL, err := r.resolveURL(ctx, artifact.Status.BuildJob)
+		if err != nil {
+			return nil, false, err
+		}
+		logURL := jobURL + "/logs"
+		if follow {
+			logURL += "?follow=true"
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, logURL, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := r.httpClient().Do(req)
+		if err != nil {
+			return nil, false, err
+		}
+		switch {
+		case res.StatusCode == http.StatusNotFound:
+			// The job expired from the app-builder service.
+			res.Body.Close()
+			return nil, false, nil
+		case res.StatusCode < 200 || res.StatusCode >= 300:
+			msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+			res.Body.Close()
+			return nil, false, fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(msg)))
+		}
+		return res.Body, false, nil
+
+	default:
+		pod, err := AppBuilderPod(ctx, r.Client, artifact)
+		if err != nil || pod == nil {
+			return nil, false, err
+		}
+		opts := &corev1.PodLogOptions{
+			Follow:     follow,
+			Timestamps: r.Timestamps,
+		}
+		rc, err := r.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(ctx)
+		return rc, r.Timestamps, err
+	}
+}
+
+func (r *BuildLogReader) resolveURL(ctx context.Context, rawURL string) (string, error) {
+	if r.ResolveURL == nil {
+		return rawURL, nil
+	}
+	return r.ResolveURL(ctx, rawURL)
+}
+
+func (r *BuildLogReader) httpClient() *http.Client {
+	if r.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return r.HTTPClient
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apps_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	"github.com/codelogia/manor/cli/pkg/apps"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("Apps", func() {
+	DescribeTable("validating environment variable names",
+		func(name string, valid bool) {
+			if valid {
+				Expect(apps.ValidateEnvVarName(name)).To(Succeed())
+			} else {
+				Expect(apps.ValidateEnvVarName(name)).NotTo(Succeed())
+			}
+		},
+		Entry("a valid name", "LOG_LEVEL", true),
+		Entry("a name with dots and dashes", "app.log-level", true),
+		Entry("an empty name", "", false),
+		Entry("a name starting with a digit", "1A", false),
+		Entry("a name with an equal sign", "A=B", false),
+		Entry("PORT, which is set to the first port", "PORT", false),
+	)
+
+	It("sets environment variables, replacing the ones with the same name", func() {
+		env := apps.SetEnvVar(nil, corev1.EnvVar{Name: "A", Value: "1"})
+		env = apps.SetEnvVar(env, corev1.EnvVar{Name: "B", Value: "2"})
+		env = apps.SetEnvVar(env, corev1.EnvVar{Name: "A", Value: "3"})
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "A", Value: "3"}, {Name: "B", Value: "2"}}))
+	})
+
+	It("unsets environment variables", func() {
+		env := []corev1.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}
+		env = apps.UnsetEnvVar(env, "C")
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}))
+		env = apps.UnsetEnvVar(env, "A")
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "B", Value: "2"}}))
+		Expect(apps.UnsetEnvVar(env, "B")).To(BeNil())
+	})
+
+	Context("with a cluster", func() {
+		var ctx context.Context
+		var c client.Client
+		var artifact *manorv1.Artifact
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(manorv1.AddToScheme(scheme)).To(Succeed())
+			artifact = &manorv1.Artifact{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "team"},
+				Spec:       manorv1.ArtifactSpec{App: "web"},
+			}
+			c = fake.NewFakeClientWithScheme(scheme)
+			Expect(c.Create(ctx, &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team"}})).To(Succeed())
+		})
+
+		// createAppBuilder creates the Secret and the Pod of the app-builder of the artifact.
+		createAppBuilder := func(token string) {
+			labels := map[string]string{manorv1.ArtifactLabel: artifact.Name}
+			Expect(c.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1-app-builder-creds", Namespace: "team", Labels: labels},
+				Data:       map[string][]byte{"token": []byte(token)},
+			})).To(Succeed())
+			Expect(c.Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1-app-builder", Namespace: "team", Labels: labels},
+			})).To(Succeed())
+		}
+
+		It("patches the app", func() {
+			app, err := apps.Patch(ctx, c, client.ObjectKey{Name: "web", Namespace: "team"}, func(app *manorv1.App) {
+				app.Spec.Env = apps.SetEnvVar(app.Spec.Env, corev1.EnvVar{Name: "A", Value: "1"})
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app.Spec.Env).To(Equal([]corev1.EnvVar{{Name: "A", Value: "1"}}))
+
+			stored := &manorv1.App{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: "web", Namespace: "team"}, stored)).To(Succeed())
+			Expect(stored.Spec.Env).To(Equal(app.Spec.Env))
+
+			_, err = apps.Patch(ctx, c, client.ObjectKey{Name: "api", Namespace: "team"}, func(*manorv1.App) {})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("finds the app-builder of the artifact once it's created", func() {
+			Expect(apps.ArtifactToken(ctx, c, artifact)).To(BeEmpty())
+			Expect(apps.AppBuilderPod(ctx, c, artifact)).To(BeNil())
+
+			createAppBuilder("builder-token")
+			Expect(apps.ArtifactToken(ctx, c, artifact)).To(Equal("builder-token"))
+			pod, err := apps.AppBuilderPod(ctx, c, artifact)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Name).To(Equal("web-1-app-builder"))
+		})
+
+		Context("reading the build log", func() {
+			var reader *apps.BuildLogReader
+			var resolved []string
+
+			BeforeEach(func() {
+				resolved = nil
+				reader = &apps.BuildLogReader{
+					Client: c,
+					ResolveURL: func(ctx context.Context, rawURL string) (string, error) {
+						resolved = append(resolved, rawURL)
+						return rawURL, nil
+					},
+				}
+			})
+
+			// readAll reads the build log of the artifact, which must be available.
+			readAll := func(follow bool) string {
+				rc, timestamped, err := reader.Open(ctx, artifact, follow)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rc).NotTo(BeNil())
+				defer rc.Close()
+				data, err := ioutil.ReadAll(rc)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(timestamped).To(BeFalse())
+				return string(data)
+			}
+
+			expectUnavailable := func() {
+				rc, _, err := reader.Open(ctx, artifact, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rc).To(BeNil())
+			}
+
+			It("reads it from the log store", func() {
+				dir, err := ioutil.TempDir("", "logs")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+				store := logstore.NewFileStore(dir)
+				wc, err := store.Create(ctx, logstore.Key("team", "web-1"))
+				Expect(err).NotTo(HaveOccurred())
+				fmt.Fprintln(wc, "built")
+				Expect(wc.Close()).To(Succeed())
+
+				var authorization string
+				mux := http.NewServeMux()
+				mux.Handle(logstore.HandlerPrefix, logstore.NewHandler(store, func(r *http.Request, namespace, artifact string) error {
+					authorization = r.Header.Get("Authorization")
+					return nil
+				}))
+				server := httptest.NewServer(mux)
+				defer server.Close()
+
+				createAppBuilder("builder-token")
+				artifact.Status.LogRef = server.URL + logstore.HandlerPrefix + logstore.Key("team", "web-1")
+				Expect(readAll(false)).To(Equal("built\n"))
+				Expect(authorization).To(Equal("Bearer builder-token"))
+				Expect(resolved).To(Equal([]string{artifact.Status.LogRef}))
+
+				artifact.Name = "web-2"
+				Expect(c.Create(ctx, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "web-2-app-builder-creds", Namespace: "team", Labels: map[string]string{manorv1.ArtifactLabel: "web-2"}},
+					Data:       map[string][]byte{"token": []byte("builder-token")},
+				})).To(Succeed())
+				artifact.Status.LogRef = server.URL + logstore.HandlerPrefix + logstore.Key("team", "web-2")
+				expectUnavailable()
+			})
+
+			It("reads it from the app-builder service job", func() {
+				var requests []*http.Request
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requests = append(requests, r)
+					if strings.HasPrefix(r.URL.Path, "/jobs/gone/") {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprintln(w, "building")
+				}))
+				defer server.Close()
+
+				createAppBuilder("builder-token")
+				artifact.Status.BuildJob = server.URL + "/jobs/123"
+				Expect(readAll(true)).To(Equal("building\n"))
+				Expect(requests).To(HaveLen(1))
+				Expect(requests[0].URL.String()).To(Equal("/jobs/123/logs?follow=true"))
+				Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer builder-token"))
+
+				artifact.Status.BuildJob = server.URL + "/jobs/gone"
+				expectUnavailable()
+			})
+
+			It("is not available without an app-builder", func() {
+				expectUnavailable()
+			})
+		})
+	})
+})
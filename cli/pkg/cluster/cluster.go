@@ -0,0 +1,243 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cluster implements the access of the manor CLI to the Kubernetes cluster running manor.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// Cluster is a Kubernetes cluster running manor.
+type Cluster struct {
+	client.Client
+	Config    *rest.Config
+	Clientset kubernetes.Interface
+	// The namespace the commands operate on.
+	Namespace string
+}
+
+// New constructs a new Cluster from the kubeconfig. An empty kubeconfig, context or namespace
+// follows the kubectl defaults.
+func New(kubeconfig, kubeContext, namespace string) (*Cluster, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := manorv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return &Cluster{
+		Client:    c,
+		Config:    config,
+		Clientset: clientset,
+		Namespace: namespace,
+	}, nil
+}
+
+// PortForward forwards a local port to the port of a Pod until the context is done. It returns the
+// local port.
+func (c *Cluster) PortForward(ctx context.Context, namespace, pod string, port int) (uint16, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(c.Config)
+	if err != nil {
+		return 0, fmt.Errorf("failed to port-forward: %w", err)
+	}
+	url := c.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	stop := make(chan struct{})
+	ready := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(
+		dialer,
+		[]string{"127.0.0.1"},
+		[]string{fmt.Sprintf("0:%d", port)},
+		stop,
+		ready,
+		ioutil.Discard,
+		ioutil.Discard,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to port-forward: %w", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- forwarder.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-errc:
+		return 0, fmt.Errorf("failed to port-forward: %w", err)
+	case <-ctx.Done():
+		close(stop)
+		return 0, ctx.Err()
+	}
+	go func() {
+		<-ctx.Done()
+		close(stop)
+	}()
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		return 0, fmt.Errorf("failed to port-forward: %w", err)
+	}
+	return ports[0].Local, nil
+}
+
+// ForwardURL forwards a local port to the Service of an in-cluster URL until the context is done.
+// It returns the URL reaching the same path through the local port.
+func (c *Cluster) ForwardURL(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	servicePort := 80
+	if u.Port() != "" {
+		if servicePort, err = strconv.Atoi(u.Port()); err != nil {
+			return "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+		}
+	}
+	pod, podPort, err := c.ServicePod(ctx, u.Hostname(), servicePort)
+	if err != nil {
+		return "", err
+	}
+	localPort, err := c.PortForward(ctx, pod.Namespace, pod.Name, podPort)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = "http"
+	u.Host = fmt.Sprintf("127.0.0.1:%d", localPort)
+	return u.String(), nil
+}
+
+// ServicePod returns a ready Pod behind the Service at host, in the <name>.<namespace>.svc form of
+// in-cluster URLs, with the Pod port the Service port maps to.
+func (c *Cluster) ServicePod(ctx context.Context, host string, servicePort int) (*corev1.Pod, int, error) {
+	split := strings.Split(host, ".")
+	if len(split) < 3 || split[2] != "svc" {
+		return nil, 0, fmt.Errorf("%q is not the host of a Service", host)
+	}
+	service := &corev1.Service{}
+	if err := c.Get(ctx, client.ObjectKey{Name: split[0], Namespace: split[1]}, service); err != nil {
+		return nil, 0, fmt.Errorf("failed to get Service %s/%s: %w", split[1], split[0], err)
+	}
+	targetPort := intstr.FromInt(servicePort)
+	for _, port := range service.Spec.Ports {
+		if int(port.Port) == servicePort && (port.TargetPort.IntVal != 0 || port.TargetPort.StrVal != "") {
+			targetPort = port.TargetPort
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(service.Namespace), client.MatchingLabels(service.Spec.Selector)); err != nil {
+		return nil, 0, fmt.Errorf("failed to list the Pods of Service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !PodReady(pod) {
+			continue
+		}
+		if targetPort.Type == intstr.Int {
+			return pod, targetPort.IntValue(), nil
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.Name == targetPort.StrVal {
+					return pod, int(port.ContainerPort), nil
+				}
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("no ready Pod behind Service %s/%s", service.Namespace, service.Name)
+}
+
+// PodReady returns whether the Pod is ready.
+func PodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll calls condition at every interval until it's done, it fails or the context is done.
+func Poll(ctx context.Context, interval time.Duration, condition func() (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		done, err := condition()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/cluster"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// maxEvents is the number of recent events shown for an app.
+const maxEvents = 10
+
+// appSummary is the overview of an App.
+type appSummary struct {
+	Name          string           `json:"name"`
+	State         manorv1.AppState `json:"state"`
+	Replicas      int32            `json:"replicas"`
+	ReadyReplicas int32            `json:"readyReplicas"`
+	Artifact      string           `json:"artifact,omitempty"`
+	Digest        string           `json:"digest,omitempty"`
+	URLs          []string         `json:"urls,omitempty"`
+	DeployedAt    *metav1.Time     `json:"deployedAt,omitempty"`
+}
+
+// appDetails is the detailed view of an App.
+type appDetails struct {
+	appSummary
+	Image     string                  `json:"image,omitempty"`
+	Pinned    bool                    `json:"pinned,omitempty"`
+	Instances []instanceView          `json:"instances"`
+	History   []manorv1.AppDeployment `json:"history"`
+	Events    []eventView             `json:"events"`
+}
+
+// instanceView is the view of a replica of an App.
+type instanceView struct {
+	Name      string          `json:"name"`
+	Phase     corev1.PodPhase `json:"phase"`
+	Ready     bool            `json:"ready"`
+	Restarts  int32           `json:"restarts"`
+	CPU       string          `json:"cpu,omitempty"`
+	Memory    string          `json:"memory,omitempty"`
+	StartedAt *metav1.Time    `json:"startedAt,omitempty"`
+}
+
+// eventView is the view of an event of an App or of its resources.
+type eventView struct {
+	Time    metav1.Time `json:"time"`
+	Type    string      `json:"type"`
+	Reason  string      `json:"reason"`
+	Object  string      `json:"object"`
+	Message string      `json:"message"`
+}
+
+func newAppsCommand(globalOpts *globalOptions) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "apps",
+		Short: "Lists the apps.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+
+			apps := &manorv1.AppList{}
+			if err := c.List(cmd.Context(), apps, client.InNamespace(c.Namespace)); err != nil {
+				return fmt.Errorf("failed to list apps: %w", err)
+			}
+			summaries := make([]appSummary, 0, len(apps.Items))
+			for i := range apps.Items {
+				summaries = append(summaries, summarizeApp(&apps.Items[i]))
+			}
+			sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+
+			if output != outputTable {
+				return printObject(cmd.OutOrStdout(), output, summaries)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSTATE\tREADY\tARTIFACT\tDIGEST\tURLS\tDEPLOYED")
+			for _, s := range summaries {
+				fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\t%s\t%s\n",
+					s.Name, s.State, s.ReadyReplicas, s.Replicas, orDash(s.Artifact), orDash(shortDigest(s.Digest)),
+					orDash(strings.Join(s.URLs, ",")), age(s.DeployedAt))
+			}
+			return w.Flush()
+		},
+	}
+	addOutputFlag(cmd, &output)
+
+	return cmd
+}
+
+func newAppCommand(globalOpts *globalOptions) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "app APP",
+		Short: "Shows the details of an app.",
+		Long:  "Shows the details of APP, with the resource usage of its replicas and its recent events.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			app := &manorv1.App{}
+			if err := c.Get(ctx, client.ObjectKey{Name: args[0], Namespace: c.Namespace}, app); err != nil {
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("app %s not found", args[0])
+				}
+				return fmt.Errorf("failed to get app %s: %w", args[0], err)
+			}
+			details, err := describeApp(ctx, c, app, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+
+			if output != outputTable {
+				return printObject(cmd.OutOrStdout(), output, details)
+			}
+			printAppDetails(cmd.OutOrStdout(), details)
+			return nil
+		},
+	}
+	addOutputFlag(cmd, &output)
+
+	return cmd
+}
+
+func summarizeApp(app *manorv1.App) appSummary {
+	state := app.Spec.State
+	if state == "" {
+		state = manorv1.AppStarted
+	}
+	return appSummary{
+		Name:          app.Name,
+		State:         state,
+		Replicas:      app.Status.Replicas,
+		ReadyReplicas: app.Status.ReadyReplicas,
+		Artifact:      app.Status.Artifact,
+		Digest:        app.Status.Digest,
+		URLs:          app.Status.URLs,
+		DeployedAt:    app.Status.DeployedAt,
+	}
+}
+
+// describeApp returns the details of the App. The resource usage of the replicas is left out when
+// the metrics API is not available, with a warning written to errOut.
+func describeApp(ctx context.Context, c *cluster.Cluster, app *manorv1.App, errOut io.Writer) (*appDetails, error) {
+	details := &appDetails{
+		appSummary: summarizeApp(app),
+		Image:      app.Status.Image,
+		Pinned:     app.Spec.ArtifactRef != nil,
+		Instances:  []instanceView{},
+		History:    append([]manorv1.AppDeployment{}, app.Status.History...),
+		Events:     []eventView{},
+	}
+	selector := client.MatchingLabelsSelector{Selector: manorv1.AppReplicasSelector(app.Name)}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(app.Namespace), selector); err != nil {
+		return nil, fmt.Errorf("failed to list the replicas of app %s: %w", app.Name, err)
+	}
+	usage, err := podUsage(ctx, c, app.Namespace, app.Name)
+	if err != nil {
+		fmt.Fprintf(errOut, "The resource usage of the replicas is not available: %v\n", err)
+	}
+	involved := map[string]bool{
+		"App/" + app.Name:        true,
+		"Deployment/" + app.Name: true,
+		"Service/" + app.Name:    true,
+		"Ingress/" + app.Name:    true,
+	}
+	for _, pod := range pods.Items {
+		instance := instanceView{
+			Name:      pod.Name,
+			Phase:     pod.Status.Phase,
+			Ready:     cluster.PodReady(&pod),
+			StartedAt: pod.Status.StartTime,
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			instance.Restarts += status.RestartCount
+		}
+		if u, ok := usage[pod.Name]; ok {
+			instance.CPU = fmt.Sprintf("%dm", u.Cpu().MilliValue())
+			instance.Memory = fmt.Sprintf("%dMi", u.Memory().Value()/(1<<20))
+		}
+		details.Instances = append(details.Instances, instance)
+		involved["Pod/"+pod.Name] = true
+	}
+	sort.Slice(details.Instances, func(i, j int) bool { return details.Instances[i].Name < details.Instances[j].Name })
+
+	// The ReplicaSets of the Deployment carry the labels of its Pods.
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := c.List(ctx, replicaSets, client.InNamespace(app.Namespace), selector); err != nil {
+		return nil, fmt.Errorf("failed to list the ReplicaSets of app %s: %w", app.Name, err)
+	}
+	for _, replicaSet := range replicaSets.Items {
+		involved["ReplicaSet/"+replicaSet.Name] = true
+	}
+
+	events := &corev1.EventList{}
+	if err := c.List(ctx, events, client.InNamespace(app.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list the events of app %s: %w", app.Name, err)
+	}
+	for _, event := range events.Items {
+		object := event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name
+		if !involved[object] {
+			continue
+		}
+		t := event.LastTimestamp
+		if t.IsZero() {
+			t = metav1.NewTime(event.EventTime.Time)
+		}
+		if t.IsZero() {
+			t = event.CreationTimestamp
+		}
+		details.Events = append(details.Events, eventView{
+			Time:    t,
+			Type:    event.Type,
+			Reason:  event.Reason,
+			Object:  object,
+			Message: strings.TrimSpace(event.Message),
+		})
+	}
+	sort.SliceStable(details.Events, func(i, j int) bool { return details.Events[i].Time.Before(&details.Events[j].Time) })
+	if len(details.Events) > maxEvents {
+		details.Events = details.Events[len(details.Events)-maxEvents:]
+	}
+
+	return details, nil
+}
+
+// podMetricsList is the subset of the PodMetricsList of the metrics API used by the CLI.
+type podMetricsList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Containers []struct {
+			Usage corev1.ResourceList `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// podUsage returns the resource usage of the replicas of an App by Pod name, from the metrics API.
+func podUsage(ctx context.Context, c *cluster.Cluster, namespace, appName string) (map[string]corev1.ResourceList, error) {
+	data, err := c.Clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", namespace, "pods").
+		Param("labelSelector", manorv1.AppReplicasSelector(appName).String()).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics := &podMetricsList{}
+	if err := json.Unmarshal(data, metrics); err != nil {
+		return nil, fmt.Errorf("invalid pod metrics: %w", err)
+	}
+
+	usage := make(map[string]corev1.ResourceList)
+	for _, item := range metrics.Items {
+		total := corev1.ResourceList{
+			corev1.ResourceCPU:    resource.Quantity{},
+			corev1.ResourceMemory: resource.Quantity{},
+		}
+		for _, container := range item.Containers {
+			for name, quantity := range container.Usage {
+				sum := total[name]
+				sum.Add(quantity)
+				total[name] = sum
+			}
+		}
+		usage[item.Metadata.Name] = total
+	}
+	return usage, nil
+}
+
+func printAppDetails(out io.Writer, details *appDetails) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", details.Name)
+	fmt.Fprintf(w, "State:\t%s\n", details.State)
+	fmt.Fprintf(w, "Ready:\t%d/%d\n", details.ReadyReplicas, details.Replicas)
+	artifact := orDash(details.Artifact)
+	if details.Pinned {
+		artifact += " (pinned)"
+	}
+	fmt.Fprintf(w, "Artifact:\t%s\n", artifact)
+	fmt.Fprintf(w, "Image:\t%s\n", orDash(details.Image))
+	fmt.Fprintf(w, "URLs:\t%s\n", orDash(strings.Join(details.URLs, ", ")))
+	fmt.Fprintf(w, "Deployed:\t%s\n", age(details.DeployedAt))
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tPHASE\tREADY\tRESTARTS\tCPU\tMEMORY\tAGE")
+	for _, instance := range details.Instances {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%s\t%s\t%s\n",
+			instance.Name, instance.Phase, instance.Ready, instance.Restarts,
+			orDash(instance.CPU), orDash(instance.Memory), age(instance.StartedAt))
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ARTIFACT\tDIGEST\tDEPLOYED")
+	for _, deployment := range details.History {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", deployment.Artifact, orDash(shortDigest(deployment.Digest)), age(&deployment.DeployedAt))
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "AGE\tTYPE\tREASON\tOBJECT\tMESSAGE")
+	for _, event := range details.Events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", age(&event.Time), event.Type, event.Reason, event.Object, event.Message)
+	}
+	w.Flush()
+}
+
+// shortDigest returns the abbreviated form of an image digest.
+func shortDigest(digest string) string {
+	const length = len("sha256:") + 12
+	if len(digest) > length {
+		return digest[:length]
+	}
+	return digest
+}
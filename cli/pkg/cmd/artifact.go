@@ -0,0 +1,43 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/cluster"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// latestArtifact returns the most recent Artifact of the App, or nil if there is none.
+func latestArtifact(ctx context.Context, c *cluster.Cluster, app *manorv1.App) (*manorv1.Artifact, error) {
+	artifacts := &manorv1.ArtifactList{}
+	if err := c.List(ctx, artifacts, client.InNamespace(app.Namespace), client.MatchingLabels{manorv1.AppLabel: app.Name}); err != nil {
+		return nil, err
+	}
+	var latest *manorv1.Artifact
+	for i := range artifacts.Items {
+		artifact := &artifacts.Items[i]
+		if latest == nil || latest.CreationTimestamp.Before(&artifact.CreationTimestamp) ||
+			latest.CreationTimestamp.Equal(&artifact.CreationTimestamp) && latest.Name < artifact.Name {
+			latest = artifact
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/apps"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+func newEnvCommand(globalOpts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manages the environment variables of an app.",
+		Long:  "Manages the environment variables of an app. Changing them restarts its replicas.",
+	}
+	cmd.AddCommand(
+		newEnvListCommand(globalOpts),
+		newEnvSetCommand(globalOpts),
+		newEnvUnsetCommand(globalOpts),
+	)
+	return cmd
+}
+
+func newEnvListCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list APP",
+		Short: "Lists the environment variables of an app.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			app := &manorv1.App{}
+			if err := c.Get(cmd.Context(), client.ObjectKey{Name: args[0], Namespace: c.Namespace}, app); err != nil {
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("app %s not found", args[0])
+				}
+				return fmt.Errorf("failed to get app %s: %w", args[0], err)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVALUE")
+			for _, envVar := range app.Spec.Env {
+				fmt.Fprintf(w, "%s\t%s\n", envVar.Name, envVarValue(envVar))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newEnvSetCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "set APP NAME=VALUE...",
+		Short: "Sets environment variables of an app.",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			envVars := make([]corev1.EnvVar, 0, len(args)-1)
+			for _, arg := range args[1:] {
+				split := strings.SplitN(arg, "=", 2)
+				if len(split) != 2 {
+					return fmt.Errorf("invalid environment variable %q, expected NAME=VALUE", arg)
+				}
+				if err := apps.ValidateEnvVarName(split[0]); err != nil {
+					return err
+				}
+				envVars = append(envVars, corev1.EnvVar{Name: split[0], Value: split[1]})
+			}
+
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			app, err := patchApp(cmd.Context(), c, args[0], func(app *manorv1.App) {
+				for _, envVar := range envVars {
+					app.Spec.Env = apps.SetEnvVar(app.Spec.Env, envVar)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Set the environment of app %s\n", app.Name)
+			return nil
+		},
+	}
+}
+
+func newEnvUnsetCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "unset APP NAME...",
+		Short: "Unsets environment variables of an app.",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names := make(map[string]bool)
+			for _, name := range args[1:] {
+				names[name] = true
+			}
+
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			var unset []string
+			app, err := patchApp(cmd.Context(), c, args[0], func(app *manorv1.App) {
+				unset = nil
+				env := app.Spec.Env[:0:0]
+				for _, envVar := range app.Spec.Env {
+					if names[envVar.Name] {
+						unset = append(unset, envVar.Name)
+						continue
+					}
+					env = append(env, envVar)
+				}
+				app.Spec.Env = env
+			})
+			if err != nil {
+				return err
+			}
+			if len(unset) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "App %s has none of the environment variables\n", app.Name)
+				return nil
+			}
+			sort.Strings(unset)
+			fmt.Fprintf(cmd.OutOrStdout(), "Unset %s from the environment of app %s\n", strings.Join(unset, ", "), app.Name)
+			return nil
+		},
+	}
+}
+
+// envVarValue returns the value of an environment variable for display.
+func envVarValue(envVar corev1.EnvVar) string {
+	from := envVar.ValueFrom
+	switch {
+	case from == nil:
+		return envVar.Value
+	case from.SecretKeyRef != nil:
+		return fmt.Sprintf("<secret %s/%s>", from.SecretKeyRef.Name, from.SecretKeyRef.Key)
+	case from.ConfigMapKeyRef != nil:
+		return fmt.Sprintf("<config map %s/%s>", from.ConfigMapKeyRef.Name, from.ConfigMapKeyRef.Key)
+	case from.FieldRef != nil:
+		return fmt.Sprintf("<field %s>", from.FieldRef.FieldPath)
+	case from.ResourceFieldRef != nil:
+		return fmt.Sprintf("<resource %s>", from.ResourceFieldRef.Resource)
+	}
+	return ""
+}
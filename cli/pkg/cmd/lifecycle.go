@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/apps"
+	"github.com/codelogia/manor/cli/pkg/cluster"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+func newScaleCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "scale APP REPLICAS",
+		Short: "Scales an app.",
+		Long:  "Sets the number of replicas of APP. A stopped app keeps no replicas until it's started.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			replicas, err := strconv.ParseInt(args[1], 10, 32)
+			if err != nil || replicas < 0 {
+				return fmt.Errorf("invalid number of replicas %q", args[1])
+			}
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			app, err := patchApp(cmd.Context(), c, args[0], func(app *manorv1.App) {
+				n := int32(replicas)
+				app.Spec.Replicas = &n
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Scaled app %s to %d replicas\n", app.Name, replicas)
+			if app.Spec.State == manorv1.AppStopped {
+				fmt.Fprintf(cmd.OutOrStdout(), "App %s is stopped, start it to run its replicas\n", app.Name)
+			}
+			return nil
+		},
+	}
+}
+
+func newRestartCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restart APP",
+		Short: "Restarts an app.",
+		Long:  "Restarts the replicas of APP one by one, so it keeps serving while it's restarted.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			app, err := patchApp(cmd.Context(), c, args[0], func(app *manorv1.App) {
+				if app.Annotations == nil {
+					app.Annotations = make(map[string]string)
+				}
+				app.Annotations[manorv1.RestartedAtAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Restarting app %s\n", app.Name)
+			return nil
+		},
+	}
+}
+
+func newStopCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop APP",
+		Short: "Stops an app.",
+		Long:  "Stops all the replicas of APP, keeping its configuration until it's started again.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setAppState(cmd, globalOpts, args[0], manorv1.AppStopped)
+		},
+	}
+}
+
+func newStartCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "start APP",
+		Short: "Starts a stopped app.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setAppState(cmd, globalOpts, args[0], manorv1.AppStarted)
+		},
+	}
+}
+
+func setAppState(cmd *cobra.Command, globalOpts *globalOptions, name string, state manorv1.AppState) error {
+	c, err := globalOpts.cluster()
+	if err != nil {
+		return err
+	}
+	app, err := patchApp(cmd.Context(), c, name, func(app *manorv1.App) {
+		app.Spec.State = state
+	})
+	if err != nil {
+		return err
+	}
+	if state == manorv1.AppStopped {
+		fmt.Fprintf(cmd.OutOrStdout(), "Stopped app %s\n", app.Name)
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "Started app %s\n", app.Name)
+	}
+	return nil
+}
+
+// patchApp patches the App with the changes made by mutate, see apps.Patch.
+func patchApp(ctx context.Context, c *cluster.Cluster, name string, mutate func(app *manorv1.App)) (*manorv1.App, error) {
+	app, err := apps.Patch(ctx, c, client.ObjectKey{Name: name, Namespace: c.Namespace}, mutate)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("app %s not found", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update app %s: %w", name, err)
+	}
+	return app, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/apps"
+	"github.com/codelogia/manor/cli/pkg/cluster"
+	"github.com/codelogia/manor/cli/pkg/logs"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// podPollInterval is how often new replicas are looked up while following the logs of an app.
+const podPollInterval = 2 * time.Second
+
+func newLogsCommand(globalOpts *globalOptions) *cobra.Command {
+	var recent bool
+	var since time.Duration
+	var timestamps bool
+	var build bool
+
+	cmd := &cobra.Command{
+		Use:   "logs APP",
+		Short: "Shows the logs of an app.",
+		Long: "Streams the logs of all the replicas of APP, prefixed with the replica they come from, " +
+			"along with the build log of its most recent artifact while it's built. " +
+			"With --recent, the logs written so far are shown instead, including the build log of the most recent artifact.\n\n" +
+			"When the build logs are persisted, they are read from the log store of the operator, which requires " +
+			"being allowed to port-forward to the operator namespace. Other users read them through the API server " +
+			"at /v1/namespaces/<namespace>/artifacts/<artifact>/logs.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if since < 0 {
+				return fmt.Errorf("--since must not be negative")
+			}
+
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			app := &manorv1.App{}
+			if err := c.Get(ctx, client.ObjectKey{Name: args[0], Namespace: c.Namespace}, app); err != nil {
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("app %s not found", args[0])
+				}
+				return fmt.Errorf("failed to get app %s: %w", args[0], err)
+			}
+
+			s := &logStreamer{
+				cluster: c,
+				printer: logs.NewPrinter(cmd.OutOrStdout(), timestamps),
+				errOut:  cmd.ErrOrStderr(),
+				follow:  !recent,
+			}
+			switch {
+			case since > 0:
+				t := metav1.NewTime(time.Now().Add(-since))
+				s.since = &t
+			case !recent:
+				// Only the new lines are streamed by default.
+				t := metav1.Now()
+				s.since = &t
+			}
+
+			if recent {
+				return s.recent(ctx, app, build)
+			}
+			return s.stream(ctx, app, build)
+		},
+	}
+
+	cmd.Flags().BoolVar(&recent, "recent", false, "Whether to show the logs written so far instead of streaming them.")
+	cmd.Flags().DurationVar(&since, "since", 0, "Only show the logs written within this duration, e.g. 5m.")
+	cmd.Flags().BoolVar(&timestamps, "timestamps", false, "Whether to prefix the lines with their timestamp.")
+	cmd.Flags().BoolVar(&build, "build", true, "Whether to include the build log of the most recent artifact.")
+
+	return cmd
+}
+
+// logStreamer reads the logs of an app.
+type logStreamer struct {
+	cluster *cluster.Cluster
+	printer *logs.Printer
+	errOut  io.Writer
+	// Whether the logs are streamed until the command is interrupted.
+	follow bool
+	// The time from which the logs are read, or nil for all of them.
+	since *metav1.Time
+}
+
+// recent prints the logs written so far: the build log of the most recent Artifact first, then the
+// lines of all the replicas in chronological order.
+func (s *logStreamer) recent(ctx context.Context, app *manorv1.App, build bool) error {
+	if build {
+		artifact, err := latestArtifact(ctx, s.cluster, app)
+		if err != nil {
+			return fmt.Errorf("failed to get the artifacts of app %s: %w", app.Name, err)
+		}
+		if artifact != nil && (s.since == nil || !artifact.CreationTimestamp.Before(s.since)) {
+			if err := s.printBuildLog(ctx, artifact); err != nil {
+				return err
+			}
+		}
+	}
+
+	pods, err := s.pods(ctx, app)
+	if err != nil {
+		return err
+	}
+	var lines []logs.Line
+	for i := range pods {
+		pod := &pods[i]
+		rc, err := s.podLog(ctx, pod)
+		if err != nil {
+			fmt.Fprintf(s.errOut, "Failed to read the logs of %s: %v\n", pod.Name, err)
+			continue
+		}
+		err = logs.Scan(rc, pod.Name, true, func(line logs.Line) {
+			lines = append(lines, line)
+		})
+		rc.Close()
+		if err != nil {
+			fmt.Fprintf(s.errOut, "Failed to read the logs of %s: %v\n", pod.Name, err)
+		}
+	}
+	logs.Sort(lines)
+	for _, line := range lines {
+		s.printer.Print(line)
+	}
+	return nil
+}
+
+// stream streams the logs of all the replicas of the App until the context is done, including the
+// ones started later, and the build log of the most recent Artifact if it's being built.
+func (s *logStreamer) stream(ctx context.Context, app *manorv1.App, build bool) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	if build {
+		artifact, err := latestArtifact(ctx, s.cluster, app)
+		if err != nil {
+			return fmt.Errorf("failed to get the artifacts of app %s: %w", app.Name, err)
+		}
+		if artifact != nil && !artifact.HasCondition(manorv1.ArtifactCompleted) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.printBuildLog(ctx, artifact); err != nil && ctx.Err() == nil {
+					fmt.Fprintln(s.errOut, err)
+				}
+			}()
+		}
+	}
+
+	// Every replica is streamed once, as soon as it runs.
+	streamed := make(map[types.UID]bool)
+	err := cluster.Poll(ctx, podPollInterval, func() (bool, error) {
+		pods, err := s.pods(ctx, app)
+		if err != nil {
+			return false, err
+		}
+		for i := range pods {
+			pod := &pods[i]
+			if streamed[pod.UID] || pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			streamed[pod.UID] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.printPodLog(ctx, pod); err != nil && ctx.Err() == nil {
+					fmt.Fprintf(s.errOut, "Failed to stream the logs of %s: %v\n", pod.Name, err)
+				}
+			}()
+		}
+		return false, nil
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// pods returns the replicas of the App.
+func (s *logStreamer) pods(ctx context.Context, app *manorv1.App) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := s.cluster.List(ctx, pods, client.InNamespace(app.Namespace), client.MatchingLabelsSelector{
+		Selector: manorv1.AppReplicasSelector(app.Name),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list the replicas of app %s: %w", app.Name, err)
+	}
+	return pods.Items, nil
+}
+
+func (s *logStreamer) podLog(ctx context.Context, pod *corev1.Pod) (io.ReadCloser, error) {
+	opts := &corev1.PodLogOptions{
+		Follow:     s.follow,
+		Timestamps: true,
+		SinceTime:  s.since,
+	}
+	return s.cluster.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(ctx)
+}
+
+func (s *logStreamer) printPodLog(ctx context.Context, pod *corev1.Pod) error {
+	rc, err := s.podLog(ctx, pod)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return logs.Scan(rc, pod.Name, true, s.printer.Print)
+}
+
+// printBuildLog prints the build log of the Artifact. It's read from the log store when build logs
+// are persisted, and from the app-builder otherwise. The log store runs in the operator namespace,
+// so the persisted logs are only readable by the users allowed to port-forward to it, the others
+// reading them through the API server.
+func (s *logStreamer) printBuildLog(ctx context.Context, artifact *manorv1.Artifact) error {
+	source := "build/" + artifact.Name
+	rc, timestamped, err := s.buildLog(ctx, artifact)
+	if err != nil {
+		return fmt.Errorf("failed to read the build log of artifact %s: %w", artifact.Name, err)
+	}
+	if rc == nil {
+		fmt.Fprintf(s.errOut, "The build log of artifact %s is not available\n", artifact.Name)
+		return nil
+	}
+	defer rc.Close()
+	if err := logs.Scan(rc, source, timestamped, s.printer.Print); err != nil {
+		return fmt.Errorf("failed to read the build log of artifact %s: %w", artifact.Name, err)
+	}
+	return nil
+}
+
+// buildLog opens the build log of the Artifact, returning whether its lines are timestamped. It
+// returns a nil reader when the log is not available.
+func (s *logStreamer) buildLog(ctx context.Context, artifact *manorv1.Artifact) (io.ReadCloser, bool, error) {
+	reader := &apps.BuildLogReader{
+		Client:    s.cluster,
+		Clientset: s.cluster.Clientset,
+		ResolveURL: func(ctx context.Context, rawURL string) (string, error) {
+			forwardURL, err := s.cluster.ForwardURL(ctx, rawURL)
+			if err != nil && rawURL == artifact.Status.LogRef {
+				return "", fmt.Errorf("failed to reach the log store, read the log through the API server if you can't port-forward to the operator namespace: %w", err)
+			}
+			return forwardURL, err
+		},
+		Timestamps: true,
+	}
+	return reader.Open(ctx, artifact, s.follow)
+}
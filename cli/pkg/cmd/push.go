@@ -0,0 +1,399 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+	"github.com/codelogia/manor/cli/pkg/apps"
+	"github.com/codelogia/manor/cli/pkg/cluster"
+	"github.com/codelogia/manor/cli/pkg/manifest"
+	"github.com/codelogia/manor/cli/pkg/upload"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+const (
+	appBuilderPort = 8081
+	pollInterval   = time.Second
+)
+
+func newPushCommand(globalOpts *globalOptions) *cobra.Command {
+	var name string
+	var imageRegistry string
+	var builder string
+	var filter build.Filter
+	var manifestPath string
+	var wait bool
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "push [DIR]",
+		Short: "Builds and deploys an app from its source.",
+		Long: "Builds and deploys an app from the source in DIR, which defaults to the working directory. " +
+			"The app is created if it doesn't exist yet.\n\n" +
+			"When DIR holds a manor.yml manifest, the apps it declares are pushed from the directory of the " +
+			"manifest and configured as declared. --name then selects the app to push.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			dir, err := filepath.Abs(dir)
+			if err != nil {
+				return fmt.Errorf("failed to resolve source directory: %w", err)
+			}
+
+			if manifestPath == "" {
+				if manifestPath, err = manifest.Find(dir); err != nil {
+					return err
+				}
+			}
+			var m *manifest.Manifest
+			if manifestPath != "" {
+				if cmd.Flags().Changed("path") || cmd.Flags().Changed("include") || cmd.Flags().Changed("exclude") || cmd.Flags().Changed("builder") {
+					return fmt.Errorf("--path, --include, --exclude and --builder cannot be combined with the manifest %s", manifestPath)
+				}
+				if m, err = manifest.Load(manifestPath); err != nil {
+					return err
+				}
+				if name != "" && m.App(name) == nil {
+					return fmt.Errorf("the manifest %s doesn't declare app %q", manifestPath, name)
+				}
+				dir = filepath.Dir(manifestPath)
+			} else {
+				if name == "" {
+					name = strings.ToLower(filepath.Base(filepath.Join(dir, filepath.FromSlash(filter.Root()))))
+				}
+				if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+					return fmt.Errorf("invalid app name %q: %s", name, strings.Join(errs, ", "))
+				}
+				if err := filter.Validate(); err != nil {
+					return err
+				}
+			}
+
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+
+			p := &pusher{
+				cluster:       c,
+				out:           cmd.OutOrStdout(),
+				imageRegistry: imageRegistry,
+				wait:          wait,
+			}
+
+			if m == nil {
+				app := &manorv1.App{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: c.Namespace},
+				}
+				artifactSpec := manorv1.ArtifactSpec{
+					App:     name,
+					Path:    filter.Path,
+					Include: filter.Include,
+					Exclude: filter.Exclude,
+					Builder: builder,
+				}
+				return p.push(ctx, dir, app, artifactSpec, false)
+			}
+
+			for i := range m.Apps {
+				manifestApp := &m.Apps[i]
+				if name != "" && manifestApp.Name != name {
+					continue
+				}
+				if err := p.push(ctx, dir, manifestApp.ToApp(c.Namespace), manifestApp.ArtifactSpec(), true); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "The name of the app. Defaults to the name of the app directory.")
+	cmd.Flags().StringVar(&imageRegistry, "image-registry", "", "The image registry overriding the default one.")
+	cmd.Flags().StringVar(&builder, "builder", "", "The buildpacks builder image building the app.")
+	cmd.Flags().StringVar(&filter.Path, "path", "", "The directory of the app within DIR, for sources holding many apps.")
+	cmd.Flags().StringSliceVar(&filter.Include, "include", nil, "The globs of the files to build, relative to the app directory. A ** matches any number of directories.")
+	cmd.Flags().StringSliceVar(&filter.Exclude, "exclude", nil, "The globs of the files to leave out of the build, relative to the app directory.")
+	cmd.Flags().StringVarP(&manifestPath, "manifest", "f", "", "The path of the manifest declaring the apps. Defaults to the manor.yml in DIR, if any.")
+	cmd.Flags().BoolVar(&wait, "wait", true, "Whether to wait for the app to be ready.")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "The maximum duration of the push.")
+
+	return cmd
+}
+
+// pusher pushes apps to the cluster.
+type pusher struct {
+	cluster       *cluster.Cluster
+	out           io.Writer
+	imageRegistry string
+	wait          bool
+}
+
+// push builds an Artifact of the app from the source in dir and deploys it. When declared is true,
+// the spec of the app is applied to the existing App, as it's declared by a manifest. The trace of
+// the push is carried on to the build and the rollout by the annotations of the Artifact.
+func (p *pusher) push(ctx context.Context, dir string, app *manorv1.App, artifactSpec manorv1.ArtifactSpec, declared bool) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "manor push",
+		trace.WithAttributes(attribute.String("manor.app", app.Namespace+"/"+app.Name)))
+	defer func() { tracing.End(span, err) }()
+
+	app.Spec.ImageRegistry = p.imageRegistry
+	app, err = p.ensureApp(ctx, app, declared)
+	if err != nil {
+		return err
+	}
+
+	artifactSpec.ImageRegistry = p.imageRegistry
+	artifact, err := p.ensureArtifact(ctx, app, artifactSpec)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Building artifact %s...\n", artifact.Name)
+	span.SetAttributes(attribute.String("manor.artifact", artifact.Name))
+
+	uploader, err := p.uploader(ctx, artifact)
+	if err != nil {
+		return err
+	}
+	filter := build.Filter{Path: artifactSpec.Path, Include: artifactSpec.Include, Exclude: artifactSpec.Exclude}
+	uploadCtx, uploadSpan := tracing.Tracer().Start(ctx, "upload source")
+	err = uploader.Upload(uploadCtx, dir, filter, p.out)
+	tracing.End(uploadSpan, err)
+	if err != nil {
+		return err
+	}
+
+	if err := cluster.Poll(ctx, pollInterval, func() (bool, error) {
+		if err := p.cluster.Get(ctx, client.ObjectKey{Name: artifact.Name, Namespace: artifact.Namespace}, artifact); err != nil {
+			return false, err
+		}
+		return artifact.HasCondition(manorv1.ArtifactCompleted), nil
+	}); err != nil {
+		return fmt.Errorf("failed to wait for the build: %w", err)
+	}
+	if artifact.HasCondition(manorv1.ArtifactFailed) {
+		return fmt.Errorf("the build of artifact %s failed", artifact.Name)
+	}
+	fmt.Fprintf(p.out, "Built image %s@%s\n", artifact.Status.Image, artifact.Status.Digest)
+
+	if !p.wait {
+		return nil
+	}
+
+	fmt.Fprintf(p.out, "Waiting for app %s to be ready...\n", app.Name)
+	paused := false
+	if err := cluster.Poll(ctx, pollInterval, func() (bool, error) {
+		if err := p.cluster.Get(ctx, client.ObjectKey{Name: app.Name, Namespace: app.Namespace}, app); err != nil {
+			return false, err
+		}
+		// The push unpinned the app, which is pinned again when the artifact is rolled back.
+		if app.Spec.ArtifactRef != nil && app.Spec.ArtifactRef.Name != artifact.Name {
+			return false, fmt.Errorf("artifact %s was rolled back: %s", artifact.Name, appConditionMessage(app, manorv1.AppRolledBack))
+		}
+		// A paused rollout waits for the app to be promoted, which the push doesn't wait for.
+		rollout := app.Status.Rollout
+		paused = rollout != nil && rollout.Artifact == artifact.Name && rollout.Phase == manorv1.RolloutPaused
+		return paused || app.Status.Artifact == artifact.Name && appReady(app), nil
+	}); err != nil {
+		return fmt.Errorf("failed to wait for app %s to be ready: %w", app.Name, err)
+	}
+	if paused {
+		fmt.Fprintf(p.out, "The rollout of artifact %s to app %s is paused, promote it with manor rollout promote %s\n",
+			artifact.Name, app.Name, app.Name)
+		return nil
+	}
+	fmt.Fprintf(p.out, "App %s is ready\n", app.Name)
+
+	return nil
+}
+
+// ensureApp creates the App if it doesn't exist yet. An existing App is updated with the spec of the
+// desired one when it's declared, or only with its image registry otherwise.
+func (p *pusher) ensureApp(ctx context.Context, desired *manorv1.App, declared bool) (*manorv1.App, error) {
+	app := &manorv1.App{}
+	err := p.cluster.Get(ctx, client.ObjectKey{Name: desired.Name, Namespace: desired.Namespace}, app)
+	switch {
+	case errors.IsNotFound(err):
+		if err := p.cluster.Create(ctx, desired); err != nil {
+			return nil, fmt.Errorf("failed to create app %s: %w", desired.Name, err)
+		}
+		fmt.Fprintf(p.out, "Created app %s\n", desired.Name)
+		return desired, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get app %s: %w", desired.Name, err)
+	}
+
+	spec := app.Spec.DeepCopy()
+	if declared {
+		spec.Env = desired.Spec.Env
+		spec.Replicas = desired.Spec.Replicas
+		spec.Resources = desired.Spec.Resources
+		spec.Ports = desired.Spec.Ports
+		spec.HealthCheck = desired.Spec.HealthCheck
+		spec.Routes = desired.Spec.Routes
+	}
+	if desired.Spec.ImageRegistry != "" {
+		spec.ImageRegistry = desired.Spec.ImageRegistry
+	}
+	// The App rolled back to a previous Artifact is unpinned, so it's deployed with the pushed one.
+	if spec.ArtifactRef != nil {
+		fmt.Fprintf(p.out, "Unpinning app %s from artifact %s\n", app.Name, spec.ArtifactRef.Name)
+		spec.ArtifactRef = nil
+	}
+	if !equality.Semantic.DeepEqual(spec, &app.Spec) {
+		app.Spec = *spec
+		if err := p.cluster.Update(ctx, app); err != nil {
+			return nil, fmt.Errorf("failed to update app %s: %w", app.Name, err)
+		}
+		fmt.Fprintf(p.out, "Updated app %s\n", app.Name)
+	}
+	return app, nil
+}
+
+// ensureArtifact creates the Artifact building the source of the App, or updates the one created
+// by a previous push with the same spec that never started building, e.g. because the push was
+// interrupted before the upload, so it carries the trace of this push.
+func (p *pusher) ensureArtifact(ctx context.Context, app *manorv1.App, spec manorv1.ArtifactSpec) (*manorv1.Artifact, error) {
+	desired := &manorv1.Artifact{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: app.Name + "-",
+			Namespace:    app.Namespace,
+			Annotations:  tracing.InjectAnnotations(ctx, nil),
+		},
+		Spec: spec,
+	}
+	// The spec is compared with the defaulted spec of the existing Artifacts.
+	desired.Default()
+
+	artifacts := &manorv1.ArtifactList{}
+	if err := p.cluster.List(ctx, artifacts, client.InNamespace(app.Namespace), client.MatchingLabels{manorv1.AppLabel: app.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list the artifacts of app %s: %w", app.Name, err)
+	}
+	for i := range artifacts.Items {
+		artifact := &artifacts.Items[i]
+		if !artifact.DeletionTimestamp.IsZero() || artifact.HasCondition(manorv1.ArtifactInProgress) ||
+			artifact.HasCondition(manorv1.ArtifactCompleted) || !equality.Semantic.DeepEqual(artifact.Spec, desired.Spec) {
+			continue
+		}
+		if artifact.Annotations == nil {
+			artifact.Annotations = map[string]string{}
+		}
+		for k, v := range desired.Annotations {
+			artifact.Annotations[k] = v
+		}
+		if err := p.cluster.Update(ctx, artifact); err != nil {
+			return nil, fmt.Errorf("failed to update artifact %s: %w", artifact.Name, err)
+		}
+		fmt.Fprintf(p.out, "Reusing artifact %s, which was never built\n", artifact.Name)
+		return artifact, nil
+	}
+
+	if err := p.cluster.Create(ctx, desired); err != nil {
+		return nil, fmt.Errorf("failed to create Artifact: %w", err)
+	}
+	return desired, nil
+}
+
+// uploader waits for the app-builder of the Artifact to be ready and forwards a local port to it.
+func (p *pusher) uploader(ctx context.Context, artifact *manorv1.Artifact) (*upload.Uploader, error) {
+	var token string
+	var pod *corev1.Pod
+	if err := cluster.Poll(ctx, pollInterval, func() (bool, error) {
+		if err := p.cluster.Get(ctx, client.ObjectKey{Name: artifact.Name, Namespace: artifact.Namespace}, artifact); err != nil {
+			return false, err
+		}
+		if token == "" {
+			var err error
+			if token, err = apps.ArtifactToken(ctx, p.cluster, artifact); err != nil || token == "" {
+				return false, err
+			}
+		}
+		if artifact.Status.BuildJob != "" {
+			return true, nil
+		}
+		var err error
+		pod, err = apps.AppBuilderPod(ctx, p.cluster, artifact)
+		if err != nil {
+			return false, err
+		}
+		return pod != nil && cluster.PodReady(pod), nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to wait for the app-builder: %w", err)
+	}
+
+	uploader := &upload.Uploader{Token: token}
+	if artifact.Status.BuildJob == "" {
+		localPort, err := p.cluster.PortForward(ctx, pod.Namespace, pod.Name, appBuilderPort)
+		if err != nil {
+			return nil, err
+		}
+		uploader.URL = fmt.Sprintf("http://127.0.0.1:%d", localPort)
+		return uploader, nil
+	}
+
+	// The build was dispatched to the app-builder service, which is reached through one of its Pods.
+	jobURL, err := p.cluster.ForwardURL(ctx, artifact.Status.BuildJob)
+	if err != nil {
+		return nil, err
+	}
+	uploader.URL = jobURL
+	uploader.Job = true
+	return uploader, nil
+}
+
+// appConditionMessage returns the message of the App condition, or an empty string if it has none.
+func appConditionMessage(app *manorv1.App, conditionType manorv1.AppConditionType) string {
+	for _, condition := range app.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Message
+		}
+	}
+	return ""
+}
+
+func appReady(app *manorv1.App) bool {
+	for _, condition := range app.Status.Conditions {
+		if condition.Type == manorv1.AppReady && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
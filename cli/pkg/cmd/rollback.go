@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/cli/pkg/cluster"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+func newRollbackCommand(globalOpts *globalOptions) *cobra.Command {
+	var to string
+
+	cmd := &cobra.Command{
+		Use:   "rollback APP",
+		Short: "Rolls an app back to a previous artifact.",
+		Long: "Pins APP to the artifact it was deployed with before the one it's running, or to the artifact set " +
+			"with --to. The app keeps running the pinned artifact until it's pushed again.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			app := &manorv1.App{}
+			if err := c.Get(ctx, client.ObjectKey{Name: args[0], Namespace: c.Namespace}, app); err != nil {
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("app %s not found", args[0])
+				}
+				return fmt.Errorf("failed to get app %s: %w", args[0], err)
+			}
+			if to == "" {
+				if to = app.PreviousArtifact(); to == "" {
+					return fmt.Errorf("app %s has no previous artifact to roll back to", app.Name)
+				}
+			}
+			if err := checkDeployable(ctx, c, app, to); err != nil {
+				return err
+			}
+
+			app, err = patchApp(ctx, c, app.Name, func(app *manorv1.App) {
+				app.Spec.ArtifactRef = &corev1.LocalObjectReference{Name: to}
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Rolling back app %s to artifact %s\n", app.Name, to)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", "", "The artifact to roll back to. Defaults to the previous artifact the app was deployed with.")
+
+	return cmd
+}
+
+// checkDeployable checks the App can be deployed with the named Artifact, which must have been built
+// successfully for the App.
+func checkDeployable(ctx context.Context, c *cluster.Cluster, app *manorv1.App, name string) error {
+	artifact := &manorv1.Artifact{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: app.Namespace}, artifact); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("artifact %s not found", name)
+		}
+		return fmt.Errorf("failed to get artifact %s: %w", name, err)
+	}
+	return artifact.Deployable(app)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+func newRolloutCommand(globalOpts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Manages the rollouts of apps.",
+		Long:  "Manages the rollouts of new artifacts to apps with the BlueGreen or Canary strategy.",
+	}
+
+	cmd.AddCommand(
+		newRolloutStatusCommand(globalOpts),
+		newRolloutPauseCommand(globalOpts),
+		newRolloutResumeCommand(globalOpts),
+		newRolloutPromoteCommand(globalOpts),
+	)
+
+	return cmd
+}
+
+func newRolloutStatusCommand(globalOpts *globalOptions) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "status APP",
+		Short: "Shows the progress of the latest rollout of an app.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+
+			app := &manorv1.App{}
+			if err := c.Get(cmd.Context(), client.ObjectKey{Name: args[0], Namespace: c.Namespace}, app); err != nil {
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("app %s not found", args[0])
+				}
+				return fmt.Errorf("failed to get app %s: %w", args[0], err)
+			}
+			if app.Status.Rollout == nil {
+				return fmt.Errorf("app %s has no rollout", app.Name)
+			}
+
+			if output != outputTable {
+				return printObject(cmd.OutOrStdout(), output, app.Status.Rollout)
+			}
+			printRollout(cmd.OutOrStdout(), app)
+			return nil
+		},
+	}
+	addOutputFlag(cmd, &output)
+
+	return cmd
+}
+
+func newRolloutPauseCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause APP",
+		Short: "Pauses the rollout of an app.",
+		Long:  "Pauses the rollouts of APP at their current step until they're resumed or promoted.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			app, err := patchApp(cmd.Context(), c, args[0], func(app *manorv1.App) {
+				app.Spec.RolloutPaused = true
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Paused the rollouts of app %s\n", app.Name)
+			return nil
+		},
+	}
+}
+
+func newRolloutResumeCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume APP",
+		Short: "Resumes the paused rollout of an app.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			app, err := patchApp(cmd.Context(), c, args[0], func(app *manorv1.App) {
+				app.Spec.RolloutPaused = false
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Resumed the rollouts of app %s\n", app.Name)
+			return nil
+		},
+	}
+}
+
+func newRolloutPromoteCommand(globalOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "promote APP",
+		Short: "Promotes the rollout of an app past its pause.",
+		Long: "Moves the rollout of APP on to its next step once the replicas of its current step are healthy, " +
+			"even when it's paused.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := globalOpts.cluster()
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+			app := &manorv1.App{}
+			if err := c.Get(ctx, client.ObjectKey{Name: args[0], Namespace: c.Namespace}, app); err != nil {
+				if apierrors.IsNotFound(err) {
+					return fmt.Errorf("app %s not found", args[0])
+				}
+				return fmt.Errorf("failed to get app %s: %w", args[0], err)
+			}
+			rollout := app.Status.Rollout
+			if rollout == nil || rollout.Phase == manorv1.RolloutCompleted || rollout.Phase == manorv1.RolloutAborted {
+				return fmt.Errorf("app %s has no rollout in progress", app.Name)
+			}
+
+			app, err = patchApp(ctx, c, app.Name, func(app *manorv1.App) {
+				if app.Annotations == nil {
+					app.Annotations = make(map[string]string)
+				}
+				app.Annotations[manorv1.PromotedAtAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Promoting the rollout of artifact %s to app %s\n", rollout.Artifact, app.Name)
+			return nil
+		},
+	}
+}
+
+func printRollout(out io.Writer, app *manorv1.App) {
+	rollout := app.Status.Rollout
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Artifact:\t%s\n", rollout.Artifact)
+	fmt.Fprintf(w, "Strategy:\t%s\n", rollout.Strategy)
+	phase := string(rollout.Phase)
+	if app.Spec.RolloutPaused && rollout.Phase != manorv1.RolloutCompleted && rollout.Phase != manorv1.RolloutAborted {
+		phase += " (paused by the user)"
+	}
+	fmt.Fprintf(w, "Phase:\t%s\n", phase)
+	if rollout.Strategy == manorv1.CanaryStrategyType && app.Spec.Strategy != nil && app.Spec.Strategy.Canary != nil {
+		fmt.Fprintf(w, "Step:\t%d/%d\n", rollout.Step, len(app.Spec.Strategy.Canary.Steps))
+	}
+	fmt.Fprintf(w, "Weight:\t%d%%\n", rollout.Weight)
+	fmt.Fprintf(w, "Started:\t%s\n", age(&rollout.StartedAt))
+	if rollout.PausedAt != nil {
+		fmt.Fprintf(w, "Paused:\t%s\n", age(rollout.PausedAt))
+	}
+	if rollout.CompletedAt != nil {
+		fmt.Fprintf(w, "Completed:\t%s\n", age(rollout.CompletedAt))
+	}
+	w.Flush()
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cmd implements the commands of the manor CLI.
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/codelogia/manor/cli/pkg/cluster"
+)
+
+// globalOptions are the options shared by all the commands.
+type globalOptions struct {
+	kubeconfig string
+	context    string
+	namespace  string
+}
+
+// cluster returns the cluster the commands operate on.
+func (o *globalOptions) cluster() (*cluster.Cluster, error) {
+	return cluster.New(o.kubeconfig, o.context, o.namespace)
+}
+
+// New constructs a new manor command.
+func New() *cobra.Command {
+	opts := &globalOptions{}
+
+	cmd := &cobra.Command{
+		Use:          "manor",
+		Short:        "Manages apps running on manor.",
+		SilenceUsage: true,
+	}
+
+	cmd.PersistentFlags().StringVar(&opts.kubeconfig, "kubeconfig", "", "The path to the kubeconfig file. Defaults to the kubectl one.")
+	cmd.PersistentFlags().StringVar(&opts.context, "context", "", "The kubeconfig context to use. Defaults to the current context.")
+	cmd.PersistentFlags().StringVarP(&opts.namespace, "namespace", "n", "", "The namespace of the apps. Defaults to the namespace of the kubeconfig context.")
+
+	cmd.AddCommand(
+		newPushCommand(opts),
+		newLogsCommand(opts),
+		newAppsCommand(opts),
+		newAppCommand(opts),
+		newScaleCommand(opts),
+		newRestartCommand(opts),
+		newStopCommand(opts),
+		newStartCommand(opts),
+		newEnvCommand(opts),
+		newRollbackCommand(opts),
+		newRolloutCommand(opts),
+	)
+
+	return cmd
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logs implements multiplexing the logs of many sources, such as the replicas of an app and
+// its build, into a single output.
+package logs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat is the format of the printed timestamps.
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Line is a line of a log.
+type Line struct {
+	// The source of the line, e.g. the name of a Pod.
+	Source string
+	// When the line was written, or the zero time if it's unknown.
+	Time time.Time
+	// The text of the line, without the line ending.
+	Text string
+}
+
+// Scan calls fn with every line read from r until it ends. When timestamped is true, every line
+// starts with its RFC 3339 timestamp followed by a space, as the Kubernetes API writes the logs
+// requested with timestamps.
+func Scan(r io.Reader, source string, timestamped bool, fn func(Line)) error {
+	br := bufio.NewReader(r)
+	for {
+		text, err := br.ReadString('\n')
+		if text != "" {
+			line := Line{Source: source, Text: strings.TrimRight(text, "\r\n")}
+			if timestamped {
+				if i := strings.IndexByte(line.Text, ' '); i > 0 {
+					if t, err := time.Parse(time.RFC3339Nano, line.Text[:i]); err == nil {
+						line.Time = t
+						line.Text = line.Text[i+1:]
+					}
+				}
+			}
+			fn(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Sort sorts the lines chronologically. The lines written at the same time, or whose time is
+// unknown, keep their order.
+func Sort(lines []Line) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+}
+
+// Printer prints the lines of many sources as they come, prefixed with their source. It's safe for
+// concurrent use, and lines are never interleaved.
+type Printer struct {
+	out        io.Writer
+	timestamps bool
+
+	mu sync.Mutex
+}
+
+// NewPrinter constructs a new Printer writing to out. When timestamps is true, the lines are also
+// prefixed with their time, if it's known.
+func NewPrinter(out io.Writer, timestamps bool) *Printer {
+	return &Printer{out: out, timestamps: timestamps}
+}
+
+// Print prints a line.
+func (p *Printer) Print(line Line) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timestamps && !line.Time.IsZero() {
+		fmt.Fprintf(p.out, "%s [%s] %s\n", line.Time.Local().Format(timeFormat), line.Source, line.Text)
+		return
+	}
+	fmt.Fprintf(p.out, "[%s] %s\n", line.Source, line.Text)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logs_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/cli/pkg/logs"
+)
+
+// failingReader returns the data and then fails.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+var _ = Describe("Logs", func() {
+	t0 := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.UTC)
+
+	scan := func(data string, timestamped bool) []logs.Line {
+		var lines []logs.Line
+		Expect(logs.Scan(strings.NewReader(data), "web-1", timestamped, func(line logs.Line) {
+			lines = append(lines, line)
+		})).To(Succeed())
+		return lines
+	}
+
+	DescribeTable("scanning lines",
+		func(data string, timestamped bool, expected []logs.Line) {
+			Expect(scan(data, timestamped)).To(Equal(expected))
+		},
+		Entry("no lines", "", false, nil),
+		Entry("untimestamped lines", "a\nb\n", false, []logs.Line{
+			{Source: "web-1", Text: "a"},
+			{Source: "web-1", Text: "b"},
+		}),
+		Entry("a last line without a line ending", "a\nb", false, []logs.Line{
+			{Source: "web-1", Text: "a"},
+			{Source: "web-1", Text: "b"},
+		}),
+		Entry("CRLF line endings", "a\r\n\r\n", false, []logs.Line{
+			{Source: "web-1", Text: "a"},
+			{Source: "web-1", Text: ""},
+		}),
+		Entry("timestamped lines", "2021-03-04T05:06:07.89Z a b\n2021-03-04T05:06:08Z c\n", true, []logs.Line{
+			{Source: "web-1", Time: t0, Text: "a b"},
+			{Source: "web-1", Time: t0.Add(110 * time.Millisecond), Text: "c"},
+		}),
+		Entry("a timestamped line with an offset", "2021-03-04T06:06:07.89+01:00 a\n", true, []logs.Line{
+			{Source: "web-1", Time: time.Date(2021, 3, 4, 6, 6, 7, 890000000, time.FixedZone("", 3600)), Text: "a"},
+		}),
+		Entry("a line without a timestamp in a timestamped log", "not a time\n", true, []logs.Line{
+			{Source: "web-1", Text: "not a time"},
+		}),
+		Entry("a timestamp without text", "2021-03-04T05:06:07.89Z\n", true, []logs.Line{
+			{Source: "web-1", Text: "2021-03-04T05:06:07.89Z"},
+		}),
+		Entry("a timestamp in an untimestamped log", "2021-03-04T05:06:07.89Z a\n", false, []logs.Line{
+			{Source: "web-1", Text: "2021-03-04T05:06:07.89Z a"},
+		}),
+	)
+
+	It("returns the read errors after the lines read", func() {
+		var lines []logs.Line
+		err := logs.Scan(&failingReader{r: strings.NewReader("a\nb"), err: errors.New("broken")}, "web-1", false, func(line logs.Line) {
+			lines = append(lines, line)
+		})
+		Expect(err).To(MatchError("broken"))
+		Expect(lines).To(Equal([]logs.Line{{Source: "web-1", Text: "a"}, {Source: "web-1", Text: "b"}}))
+	})
+
+	It("sorts the lines chronologically, keeping the order of the lines written at the same time", func() {
+		lines := []logs.Line{
+			{Source: "web-1", Time: t0.Add(2 * time.Second), Text: "c"},
+			{Source: "web-2", Time: t0, Text: "a"},
+			{Source: "web-1", Time: t0.Add(time.Second), Text: "b1"},
+			{Source: "web-2", Time: t0.Add(time.Second), Text: "b2"},
+		}
+		logs.Sort(lines)
+		Expect(lines).To(Equal([]logs.Line{
+			{Source: "web-2", Time: t0, Text: "a"},
+			{Source: "web-1", Time: t0.Add(time.Second), Text: "b1"},
+			{Source: "web-2", Time: t0.Add(time.Second), Text: "b2"},
+			{Source: "web-1", Time: t0.Add(2 * time.Second), Text: "c"},
+		}))
+	})
+
+	It("sorts the lines of unknown time first, keeping their order", func() {
+		lines := []logs.Line{
+			{Source: "web-1", Time: t0, Text: "a"},
+			{Source: "build/web-1", Text: "x"},
+			{Source: "build/web-1", Text: "y"},
+		}
+		logs.Sort(lines)
+		Expect(lines).To(Equal([]logs.Line{
+			{Source: "build/web-1", Text: "x"},
+			{Source: "build/web-1", Text: "y"},
+			{Source: "web-1", Time: t0, Text: "a"},
+		}))
+	})
+
+	Context("printing", func() {
+		var out *bytes.Buffer
+
+		BeforeEach(func() {
+			out = &bytes.Buffer{}
+		})
+
+		It("prefixes the lines with their source", func() {
+			p := logs.NewPrinter(out, false)
+			p.Print(logs.Line{Source: "web-1", Time: t0, Text: "a"})
+			p.Print(logs.Line{Source: "build/web-1", Text: "b"})
+			Expect(out.String()).To(Equal("[web-1] a\n[build/web-1] b\n"))
+		})
+
+		It("prefixes the lines with their local time when it's known", func() {
+			p := logs.NewPrinter(out, true)
+			p.Print(logs.Line{Source: "web-1", Time: t0, Text: "a"})
+			p.Print(logs.Line{Source: "build/web-1", Text: "b"})
+			Expect(out.String()).To(Equal(t0.Local().Format("2006-01-02T15:04:05.000Z07:00") + " [web-1] a\n[build/web-1] b\n"))
+		})
+
+		It("never interleaves the lines printed concurrently", func() {
+			p := logs.NewPrinter(out, false)
+			text := strings.Repeat("x", 4096)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						p.Print(logs.Line{Source: "web-1", Text: text})
+					}
+				}()
+			}
+			wg.Wait()
+			printed := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			Expect(printed).To(HaveLen(100))
+			for _, line := range printed {
+				Expect(line).To(Equal("[web-1] " + text))
+			}
+		})
+	})
+})
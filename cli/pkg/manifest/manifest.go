@@ -0,0 +1,417 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manifest implements the manor.yml manifest, which declares the apps built from a source
+// next to their code.
+//
+// A manifest looks like:
+//
+//	version: 1
+//	apps:
+//	- name: web
+//	  path: services/web
+//	  env:
+//	    LOG_LEVEL: info
+//	  replicas: 2
+//	  ports:
+//	  - name: http
+//	    port: 8080
+//	  healthCheck:
+//	    type: http
+//	    path: /healthz
+//	  routes:
+//	  - host: web.example.com
+//
+// The JSON Schema of the format is generated into manifest.v1.schema.json.
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// Version is the version of the manifest format implemented by this package.
+const Version = 1
+
+// FileNames are the names of the manifest file looked up in a source directory, by precedence.
+var FileNames = []string{"manor.yml", "manor.yaml"}
+
+// Manifest declares the apps built from a source.
+type Manifest struct {
+	// The version of the manifest format.
+	// +kubebuilder:validation:Enum=1
+	Version int `json:"version"`
+	// The apps built from the source.
+	// +kubebuilder:validation:MinItems=1
+	Apps []App `json:"apps"`
+}
+
+// App declares an app built from the source.
+type App struct {
+	// The name of the app.
+	Name string `json:"name"`
+	// The directory of the app within the source, relative to the manifest.
+	// Defaults to the directory of the manifest.
+	Path string `json:"path,omitempty"`
+	// The globs of the files, relative to the app directory, that are built. A "**" matches any
+	// number of directories. Defaults to all the files.
+	Include []string `json:"include,omitempty"`
+	// The globs of the files, relative to the app directory, that are left out of the build.
+	Exclude []string `json:"exclude,omitempty"`
+	// The buildpacks builder image building the app.
+	Builder string `json:"builder,omitempty"`
+	// The environment variables of the app.
+	Env map[string]string `json:"env,omitempty"`
+	// The number of replicas of the app.
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+	// The compute resources of each replica.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// The ports the app listens on. The first port is exposed to the app through the PORT
+	// environment variable and receives the traffic of the routes.
+	// Defaults to a single http port 8080.
+	Ports []Port `json:"ports,omitempty"`
+	// The health check of the app replicas.
+	// Defaults to the process check.
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+	// The routes exposing the app outside of the cluster.
+	Routes []Route `json:"routes,omitempty"`
+}
+
+// Port is a port the app listens on.
+type Port struct {
+	// The name of the port, unique within the app.
+	Name string `json:"name"`
+	// The port number.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// The protocol of the port.
+	// Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// HealthCheck is the health check of the app replicas.
+type HealthCheck struct {
+	// The type of the health check: http requests the path, port connects to the port and process
+	// only checks the app is running.
+	// +kubebuilder:validation:Enum=http;port;process
+	Type string `json:"type"`
+	// The path requested by http health checks.
+	// Defaults to /.
+	Path string `json:"path,omitempty"`
+	// The name of the port checked.
+	// Defaults to the first port.
+	Port string `json:"port,omitempty"`
+	// The number of seconds after a replica started before it's checked.
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// The number of seconds after which a check times out.
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// The number of seconds between checks.
+	// Defaults to 10.
+	// +kubebuilder:validation:Minimum=0
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// The number of consecutive failed checks after which a replica is restarted.
+	// Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// Route exposes the app outside of the cluster.
+type Route struct {
+	// The host name of the route.
+	Host string `json:"host"`
+	// The path prefix of the route.
+	// Defaults to /.
+	Path string `json:"path,omitempty"`
+}
+
+// Find returns the path of the manifest in dir, or an empty string if there is none.
+func Find(dir string) (string, error) {
+	for _, name := range FileNames {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		} else if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to find manifest: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// Load reads and validates the manifest at path.
+func Load(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %w", err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse parses and validates a manifest. Unknown fields are rejected.
+func Parse(data []byte) (*Manifest, error) {
+	// The version is read first, so a manifest of another version is reported as such instead of
+	// failing on its fields.
+	var versioned struct {
+		Version *int `json:"version"`
+	}
+	if err := yaml.Unmarshal(data, &versioned); err != nil {
+		return nil, err
+	}
+	if versioned.Version == nil {
+		return nil, fmt.Errorf("missing version, the supported version is %d", Version)
+	}
+	if *versioned.Version != Version {
+		return nil, fmt.Errorf("unsupported version %d, the supported version is %d", *versioned.Version, Version)
+	}
+
+	m := &Manifest{}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate validates the manifest, returning all the errors found.
+func (m *Manifest) Validate() error {
+	var errs field.ErrorList
+	if m.Version != Version {
+		errs = append(errs, field.NotSupported(field.NewPath("version"), m.Version, []string{fmt.Sprint(Version)}))
+	}
+	appsPath := field.NewPath("apps")
+	if len(m.Apps) == 0 {
+		errs = append(errs, field.Required(appsPath, "at least one app must be declared"))
+	}
+	names := make(map[string]bool)
+	for i := range m.Apps {
+		app := &m.Apps[i]
+		appPath := appsPath.Index(i)
+		if names[app.Name] {
+			errs = append(errs, field.Duplicate(appPath.Child("name"), app.Name))
+		}
+		names[app.Name] = true
+		errs = append(errs, app.validate(appPath)...)
+	}
+	return errs.ToAggregate()
+}
+
+// App returns the app named name, or nil if the manifest doesn't declare it.
+func (m *Manifest) App(name string) *App {
+	for i := range m.Apps {
+		if m.Apps[i].Name == name {
+			return &m.Apps[i]
+		}
+	}
+	return nil
+}
+
+func (a *App) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for _, msg := range validation.IsDNS1123Label(a.Name) {
+		errs = append(errs, field.Invalid(path.Child("name"), a.Name, msg))
+	}
+	if err := a.Filter().Validate(); err != nil {
+		errs = append(errs, field.Invalid(path.Child("path"), a.Path, err.Error()))
+	}
+	for _, name := range a.envNames() {
+		for _, msg := range validation.IsEnvVarName(name) {
+			errs = append(errs, field.Invalid(path.Child("env").Key(name), name, msg))
+		}
+		if name == "PORT" {
+			errs = append(errs, field.Forbidden(path.Child("env").Key(name), "PORT is set to the first port of the app"))
+		}
+	}
+	if a.Replicas != nil && *a.Replicas < 0 {
+		errs = append(errs, field.Invalid(path.Child("replicas"), *a.Replicas, "must be greater than or equal to 0"))
+	}
+
+	portNames := make(map[string]string)
+	for i, port := range a.Ports {
+		portPath := path.Child("ports").Index(i)
+		for _, msg := range validation.IsValidPortName(port.Name) {
+			errs = append(errs, field.Invalid(portPath.Child("name"), port.Name, msg))
+		}
+		if _, ok := portNames[port.Name]; ok {
+			errs = append(errs, field.Duplicate(portPath.Child("name"), port.Name))
+		}
+		for _, msg := range validation.IsValidPortNum(int(port.Port)) {
+			errs = append(errs, field.Invalid(portPath.Child("port"), port.Port, msg))
+		}
+		switch corev1.Protocol(port.Protocol) {
+		case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			errs = append(errs, field.NotSupported(portPath.Child("protocol"), port.Protocol, []string{"TCP", "UDP", "SCTP"}))
+		}
+		portNames[port.Name] = port.Protocol
+	}
+
+	if hc := a.HealthCheck; hc != nil {
+		hcPath := path.Child("healthCheck")
+		switch manorv1.HealthCheckType(hc.Type) {
+		case manorv1.HealthCheckHTTP, manorv1.HealthCheckPort, manorv1.HealthCheckProcess:
+		default:
+			errs = append(errs, field.NotSupported(hcPath.Child("type"), hc.Type, []string{"http", "port", "process"}))
+		}
+		if hc.Path != "" && hc.Path[0] != '/' {
+			errs = append(errs, field.Invalid(hcPath.Child("path"), hc.Path, "must start with /"))
+		}
+		if hc.Type != string(manorv1.HealthCheckProcess) {
+			// The first port is checked by default, which is a TCP http port when none is declared.
+			checkedPort := hc.Port
+			if checkedPort == "" && len(a.Ports) > 0 {
+				checkedPort = a.Ports[0].Name
+			}
+			if len(a.Ports) == 0 {
+				portNames = map[string]string{"http": string(corev1.ProtocolTCP)}
+			}
+			if checkedPort != "" {
+				protocol, ok := portNames[checkedPort]
+				switch {
+				case !ok:
+					errs = append(errs, field.NotFound(hcPath.Child("port"), checkedPort))
+				case protocol != "" && protocol != string(corev1.ProtocolTCP):
+					errs = append(errs, field.Invalid(hcPath.Child("port"), checkedPort, "must be a TCP port"))
+				}
+			}
+		}
+		for _, f := range []struct {
+			name  string
+			value int32
+		}{
+			{"initialDelaySeconds", hc.InitialDelaySeconds},
+			{"timeoutSeconds", hc.TimeoutSeconds},
+			{"periodSeconds", hc.PeriodSeconds},
+			{"failureThreshold", hc.FailureThreshold},
+		} {
+			if f.value < 0 {
+				errs = append(errs, field.Invalid(hcPath.Child(f.name), f.value, "must be greater than or equal to 0"))
+			}
+		}
+	}
+
+	for i, route := range a.Routes {
+		routePath := path.Child("routes").Index(i)
+		for _, msg := range validation.IsDNS1123Subdomain(route.Host) {
+			errs = append(errs, field.Invalid(routePath.Child("host"), route.Host, msg))
+		}
+		if route.Path != "" && route.Path[0] != '/' {
+			errs = append(errs, field.Invalid(routePath.Child("path"), route.Path, "must start with /"))
+		}
+	}
+
+	return errs
+}
+
+// Filter returns the filter selecting the source of the app, relative to the manifest directory.
+func (a *App) Filter() build.Filter {
+	return build.Filter{Path: a.Path, Include: a.Include, Exclude: a.Exclude}
+}
+
+// AppSpec translates the app into the spec of its App.
+func (a *App) AppSpec() manorv1.AppSpec {
+	spec := manorv1.AppSpec{
+		Replicas:  a.Replicas,
+		Resources: a.Resources,
+	}
+
+	for _, name := range a.envNames() {
+		spec.Env = append(spec.Env, corev1.EnvVar{Name: name, Value: a.Env[name]})
+	}
+
+	for _, port := range a.Ports {
+		spec.Ports = append(spec.Ports, manorv1.AppPort{
+			Name:     port.Name,
+			Port:     port.Port,
+			Protocol: corev1.Protocol(port.Protocol),
+		})
+	}
+
+	if hc := a.HealthCheck; hc != nil {
+		spec.HealthCheck = &manorv1.HealthCheck{
+			Type:                manorv1.HealthCheckType(hc.Type),
+			Path:                hc.Path,
+			Port:                hc.Port,
+			InitialDelaySeconds: hc.InitialDelaySeconds,
+			TimeoutSeconds:      hc.TimeoutSeconds,
+			PeriodSeconds:       hc.PeriodSeconds,
+			FailureThreshold:    hc.FailureThreshold,
+		}
+	}
+
+	for _, route := range a.Routes {
+		spec.Routes = append(spec.Routes, manorv1.Route{Host: route.Host, Path: route.Path})
+	}
+
+	return spec
+}
+
+// envNames returns the sorted names of the environment variables, so the errors and the translated
+// spec are stable.
+func (a *App) envNames() []string {
+	names := make([]string, 0, len(a.Env))
+	for name := range a.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ArtifactSpec translates the app into the spec of an Artifact building it.
+func (a *App) ArtifactSpec() manorv1.ArtifactSpec {
+	return manorv1.ArtifactSpec{
+		App:     a.Name,
+		Path:    a.Path,
+		Include: a.Include,
+		Exclude: a.Exclude,
+		Builder: a.Builder,
+	}
+}
+
+// ToApp translates the app into an App in namespace.
+func (a *App) ToApp(namespace string) *manorv1.App {
+	return &manorv1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      a.Name,
+			Namespace: namespace,
+		},
+		Spec: a.AppSpec(),
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/codelogia/manor/cli/pkg/manifest"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("Manifest", func() {
+	Context("finding the manifest", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "manifest")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("returns no path when there is no manifest", func() {
+			Expect(manifest.Find(dir)).To(BeEmpty())
+		})
+
+		It("prefers manor.yml over manor.yaml", func() {
+			for _, name := range []string{"manor.yml", "manor.yaml"} {
+				Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte("version: 1\n"), 0644)).To(Succeed())
+			}
+			Expect(manifest.Find(dir)).To(Equal(filepath.Join(dir, "manor.yml")))
+		})
+
+		It("finds manor.yaml", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "manor.yaml"), []byte("version: 1\n"), 0644)).To(Succeed())
+			Expect(manifest.Find(dir)).To(Equal(filepath.Join(dir, "manor.yaml")))
+		})
+
+		It("loads the manifest, reporting its path when it's invalid", func() {
+			path := filepath.Join(dir, "manor.yml")
+			Expect(ioutil.WriteFile(path, []byte("version: 1\napps:\n- name: web\n"), 0644)).To(Succeed())
+			m, err := manifest.Load(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.App("web")).NotTo(BeNil())
+			Expect(m.App("api")).To(BeNil())
+
+			Expect(ioutil.WriteFile(path, []byte("version: 1\napps: []\n"), 0644)).To(Succeed())
+			_, err = manifest.Load(path)
+			Expect(err).To(MatchError(ContainSubstring("invalid manifest " + path)))
+		})
+	})
+
+	DescribeTable("parsing",
+		func(data string, errSubstring string) {
+			m, err := manifest.Parse([]byte(data))
+			if errSubstring == "" {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Version).To(Equal(manifest.Version))
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(errSubstring)))
+		},
+		Entry("a minimal manifest", "version: 1\napps:\n- name: web\n", ""),
+		Entry("a full manifest", `
+version: 1
+apps:
+- name: web
+  path: services/web
+  include: ["src/**"]
+  exclude: ["*_test.go"]
+  builder: paketobuildpacks/builder:tiny
+  env:
+    LOG_LEVEL: info
+  replicas: 2
+  resources:
+    limits:
+      memory: 256Mi
+  ports:
+  - name: http
+    port: 8080
+  - name: metrics
+    port: 9090
+    protocol: TCP
+  healthCheck:
+    type: http
+    path: /healthz
+    port: metrics
+    failureThreshold: 5
+  routes:
+  - host: web.example.com
+    path: /app
+- name: worker
+  replicas: 0
+  healthCheck:
+    type: process
+`, ""),
+		Entry("a missing version", "apps:\n- name: web\n", "missing version, the supported version is 1"),
+		Entry("another version", "version: 2\napps: 3\n", "unsupported version 2, the supported version is 1"),
+		Entry("invalid YAML", "version: [\n", "error converting YAML to JSON"),
+		Entry("an unknown field", "version: 1\napps:\n- name: web\n  image: nginx\n", `unknown field "image"`),
+		Entry("no apps", "version: 1\n", "apps: Required value"),
+		Entry("a duplicate app", "version: 1\napps:\n- name: web\n- name: web\n", `apps[1].name: Duplicate value: "web"`),
+		Entry("an invalid app name", "version: 1\napps:\n- name: Web\n", `apps[0].name: Invalid value: "Web"`),
+		Entry("a path out of the source", "version: 1\napps:\n- name: web\n  path: ../web\n", `apps[0].path: Invalid value: "../web"`),
+		Entry("an absolute path", "version: 1\napps:\n- name: web\n  path: /web\n", `apps[0].path: Invalid value: "/web"`),
+		Entry("an invalid glob", "version: 1\napps:\n- name: web\n  exclude: [\"[\"]\n", `invalid glob "["`),
+		Entry("an invalid env name", "version: 1\napps:\n- name: web\n  env:\n    1A: x\n", `apps[0].env[1A]: Invalid value: "1A"`),
+		Entry("the PORT env", "version: 1\napps:\n- name: web\n  env:\n    PORT: \"80\"\n", "apps[0].env[PORT]: Forbidden"),
+		Entry("negative replicas", "version: 1\napps:\n- name: web\n  replicas: -1\n", "apps[0].replicas: Invalid value: -1"),
+		Entry("an invalid port name", "version: 1\napps:\n- name: web\n  ports:\n  - name: HTTP_1\n    port: 80\n", `apps[0].ports[0].name: Invalid value: "HTTP_1"`),
+		Entry("a duplicate port", "version: 1\napps:\n- name: web\n  ports:\n  - name: http\n    port: 80\n  - name: http\n    port: 81\n", `apps[0].ports[1].name: Duplicate value: "http"`),
+		Entry("an invalid port number", "version: 1\napps:\n- name: web\n  ports:\n  - name: http\n    port: 70000\n", "apps[0].ports[0].port: Invalid value: 70000"),
+		Entry("an invalid protocol", "version: 1\napps:\n- name: web\n  ports:\n  - name: http\n    port: 80\n    protocol: ICMP\n", `apps[0].ports[0].protocol: Unsupported value: "ICMP"`),
+		Entry("an invalid health check type", "version: 1\napps:\n- name: web\n  healthCheck:\n    type: exec\n", `apps[0].healthCheck.type: Unsupported value: "exec"`),
+		Entry("a relative health check path", "version: 1\napps:\n- name: web\n  healthCheck:\n    type: http\n    path: healthz\n", `apps[0].healthCheck.path: Invalid value: "healthz"`),
+		Entry("an unknown health check port", "version: 1\napps:\n- name: web\n  healthCheck:\n    type: port\n    port: admin\n", `apps[0].healthCheck.port: Not found: "admin"`),
+		Entry("a health check of a UDP port", "version: 1\napps:\n- name: web\n  ports:\n  - name: dns\n    port: 53\n    protocol: UDP\n  healthCheck:\n    type: port\n", `apps[0].healthCheck.port: Invalid value: "dns": must be a TCP port`),
+		Entry("a negative health check period", "version: 1\napps:\n- name: web\n  healthCheck:\n    type: process\n    periodSeconds: -1\n", "apps[0].healthCheck.periodSeconds: Invalid value: -1"),
+		Entry("an invalid route host", "version: 1\napps:\n- name: web\n  routes:\n  - host: web_example\n", `apps[0].routes[0].host: Invalid value: "web_example"`),
+		Entry("a relative route path", "version: 1\napps:\n- name: web\n  routes:\n  - host: web.example.com\n    path: app\n", `apps[0].routes[0].path: Invalid value: "app"`),
+	)
+
+	It("reports all the errors of the manifest", func() {
+		_, err := manifest.Parse([]byte("version: 1\napps:\n- name: Web\n  replicas: -1\n"))
+		Expect(err).To(MatchError(And(
+			ContainSubstring("apps[0].name"),
+			ContainSubstring("apps[0].replicas"),
+		)))
+	})
+
+	Context("translating an app", func() {
+		replicas := int32(2)
+		app := manifest.App{
+			Name:     "web",
+			Path:     "services/web",
+			Include:  []string{"src/**"},
+			Exclude:  []string{"*_test.go"},
+			Builder:  "paketobuildpacks/builder:tiny",
+			Env:      map[string]string{"LOG_LEVEL": "info", "DEBUG": "false"},
+			Replicas: &replicas,
+			Resources: &corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			},
+			Ports: []manifest.Port{{Name: "http", Port: 8080}, {Name: "dns", Port: 53, Protocol: "UDP"}},
+			HealthCheck: &manifest.HealthCheck{
+				Type:             "http",
+				Path:             "/healthz",
+				Port:             "http",
+				PeriodSeconds:    5,
+				FailureThreshold: 2,
+			},
+			Routes: []manifest.Route{{Host: "web.example.com", Path: "/app"}},
+		}
+
+		It("translates it into an App, sorting the environment variables", func() {
+			a := app.ToApp("space")
+			Expect(a.Name).To(Equal("web"))
+			Expect(a.Namespace).To(Equal("space"))
+			Expect(a.Spec).To(Equal(manorv1.AppSpec{
+				Env:       []corev1.EnvVar{{Name: "DEBUG", Value: "false"}, {Name: "LOG_LEVEL", Value: "info"}},
+				Replicas:  &replicas,
+				Resources: app.Resources,
+				Ports: []manorv1.AppPort{
+					{Name: "http", Port: 8080},
+					{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+				},
+				HealthCheck: &manorv1.HealthCheck{
+					Type:             manorv1.HealthCheckHTTP,
+					Path:             "/healthz",
+					Port:             "http",
+					PeriodSeconds:    5,
+					FailureThreshold: 2,
+				},
+				Routes: []manorv1.Route{{Host: "web.example.com", Path: "/app"}},
+			}))
+		})
+
+		It("translates it into the spec of an Artifact", func() {
+			Expect(app.ArtifactSpec()).To(Equal(manorv1.ArtifactSpec{
+				App:     "web",
+				Path:    "services/web",
+				Include: []string{"src/**"},
+				Exclude: []string{"*_test.go"},
+				Builder: "paketobuildpacks/builder:tiny",
+			}))
+		})
+
+		It("leaves the defaults to the App", func() {
+			minimal := manifest.App{Name: "web"}
+			Expect(minimal.AppSpec()).To(Equal(manorv1.AppSpec{}))
+		})
+	})
+})
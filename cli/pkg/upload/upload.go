@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package upload implements uploading a source to an app-builder, sending only the files missing
+// from its source cache.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/sourcecache"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+)
+
+// maxAttempts is the number of times the blobs are uploaded when some go missing from the cache
+// before the build starts, e.g. because they were pruned.
+const maxAttempts = 3
+
+// Uploader uploads a source to an app-builder.
+type Uploader struct {
+	// The URL of the app-builder Pod, or of the job on the app-builder service.
+	URL string
+	// The token of the Artifact.
+	Token string
+	// Whether URL is a job on the app-builder service.
+	Job bool
+}
+
+// Upload uploads the files of dir selected by the filter and starts the build, writing the build
+// output to out until the build completes.
+func (u *Uploader) Upload(ctx context.Context, dir string, filter build.Filter, out io.Writer) error {
+	manifest, err := sourcecache.BuildManifest(dir, filter)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to upload source: %w", err)
+	}
+
+	missing, err := u.missingBlobs(ctx, body)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		if len(missing) > 0 {
+			fmt.Fprintf(out, "Uploading %d of %d files...\n", len(missing), len(manifest.Hashes()))
+			if err := u.uploadBlobs(ctx, dir, manifest, missing); err != nil {
+				return err
+			}
+		}
+
+		res, err := u.startBuild(ctx, body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusPreconditionFailed || attempt == maxAttempts {
+			return u.streamOutput(ctx, res, out)
+		}
+		missing, err = decodeMissingBlobs(res)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (u *Uploader) missingBlobs(ctx context.Context, manifest []byte) ([]string, error) {
+	res, err := u.do(ctx, http.MethodPost, "/manifest", sourcecache.ContentType, bytes.NewReader(manifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload source manifest: %w", err)
+	}
+	if err := checkResponse(res); err != nil {
+		return nil, fmt.Errorf("failed to upload source manifest: %w", err)
+	}
+	return decodeMissingBlobs(res)
+}
+
+func decodeMissingBlobs(res *http.Response) ([]string, error) {
+	defer res.Body.Close()
+	var missing sourcecache.MissingBlobs
+	if err := json.NewDecoder(res.Body).Decode(&missing); err != nil {
+		return nil, fmt.Errorf("failed to decode missing blobs: %w", err)
+	}
+	return missing.Hashes, nil
+}
+
+func (u *Uploader) uploadBlobs(ctx context.Context, dir string, manifest sourcecache.Manifest, hashes []string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(sourcecache.WriteBlobs(pw, dir, manifest, hashes))
+	}()
+	res, err := u.do(ctx, http.MethodPost, "/blobs", "application/gzip", pr)
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("failed to upload source: %w", err)
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return fmt.Errorf("failed to upload source: %w", err)
+	}
+	return nil
+}
+
+func (u *Uploader) startBuild(ctx context.Context, manifest []byte) (*http.Response, error) {
+	method, path := http.MethodPost, "/build"
+	if u.Job {
+		method, path = http.MethodPut, "/source"
+	}
+	res, err := u.do(ctx, method, path, sourcecache.ContentType, bytes.NewReader(manifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start build: %w", err)
+	}
+	return res, nil
+}
+
+// streamOutput writes the build output to out. The app-builder Pod streams it in the response to
+// the build request, while the service streams it from the job logs.
+func (u *Uploader) streamOutput(ctx context.Context, res *http.Response, out io.Writer) error {
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return fmt.Errorf("failed to start build: %w", err)
+	}
+	if u.Job {
+		res.Body.Close()
+		logs, err := u.do(ctx, http.MethodGet, "/logs?follow=true", "", nil)
+		if err != nil {
+			return fmt.Errorf("failed to stream build output: %w", err)
+		}
+		defer logs.Body.Close()
+		if err := checkResponse(logs); err != nil {
+			return fmt.Errorf("failed to stream build output: %w", err)
+		}
+		res = logs
+	}
+	if _, err := io.Copy(out, res.Body); err != nil {
+		return fmt.Errorf("failed to stream build output: %w", err)
+	}
+	return nil
+}
+
+func (u *Uploader) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(u.URL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+u.Token)
+	tracing.InjectHTTP(ctx, req.Header)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(msg)))
+}
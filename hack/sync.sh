@@ -41,6 +41,9 @@ bazel run --run_under="cd '${project_root}/operator' && " \
         paths="./..." \
         output:crd:artifacts:config=config/crd
 
+bazel run --run_under="cd '${project_root}' && " \
+    //cli/hack/manifest-schema -- cli/pkg/manifest/manifest.v1.schema.json
+
 bazel run //:gazelle
 
 bazel run //:go_fmt
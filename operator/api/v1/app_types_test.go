@@ -0,0 +1,41 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App", func() {
+	DescribeTable("PreviousArtifact",
+		func(artifact string, history []string, expected string) {
+			app := &App{Status: AppStatus{Artifact: artifact}}
+			for _, name := range history {
+				app.Status.History = append(app.Status.History, AppDeployment{Artifact: name})
+			}
+			Expect(app.PreviousArtifact()).To(Equal(expected))
+		},
+		Entry("without history", "", nil, ""),
+		Entry("deployed once", "app-1", []string{"app-1"}, ""),
+		Entry("deployed with the previous Artifact", "app-2", []string{"app-2", "app-1"}, "app-1"),
+		Entry("redeployed with the running Artifact", "app-2", []string{"app-2", "app-2", "app-1"}, "app-1"),
+		Entry("rolled back", "app-1", []string{"app-1", "app-2", "app-1"}, "app-2"),
+		Entry("not running the latest deployment yet", "app-2", []string{"app-3", "app-2", "app-1"}, "app-3"),
+	)
+})
@@ -0,0 +1,272 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of the App.
+func (r *App) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-manor-codelogia-com-v1-app,mutating=true,failurePolicy=fail,groups=manor.codelogia.com,resources=apps,verbs=create;update,versions=v1,sideEffects=None,admissionReviewVersions=v1beta1,name=mapp.manor.codelogia.com
+
+var _ webhook.Defaulter = &App{}
+
+// Default sets the defaults of the App spec.
+func (r *App) Default() {
+	spec := &r.Spec
+	if spec.State == "" {
+		spec.State = AppStarted
+	}
+	if spec.Replicas == nil {
+		spec.Replicas = new(int32)
+		*spec.Replicas = 1
+	}
+	if spec.ImagePullPolicy == "" {
+		spec.ImagePullPolicy = corev1.PullIfNotPresent
+	}
+	if spec.SucceededArtifactHistoryLimit == nil {
+		spec.SucceededArtifactHistoryLimit = new(int32)
+		*spec.SucceededArtifactHistoryLimit = DefaultSucceededArtifactHistoryLimit
+	}
+	if spec.FailedArtifactHistoryLimit == nil {
+		spec.FailedArtifactHistoryLimit = new(int32)
+		*spec.FailedArtifactHistoryLimit = DefaultFailedArtifactHistoryLimit
+	}
+	if spec.ProgressDeadlineSeconds == nil {
+		spec.ProgressDeadlineSeconds = new(int32)
+		*spec.ProgressDeadlineSeconds = DefaultProgressDeadlineSeconds
+	}
+	if spec.Strategy != nil && spec.Strategy.Type == "" {
+		spec.Strategy.Type = RollingUpdateStrategyType
+	}
+	for i := range spec.Ports {
+		if spec.Ports[i].Protocol == "" {
+			spec.Ports[i].Protocol = corev1.ProtocolTCP
+		}
+	}
+	if healthCheck := spec.HealthCheck; healthCheck != nil {
+		if healthCheck.Type == HealthCheckHTTP && healthCheck.Path == "" {
+			healthCheck.Path = "/"
+		}
+		if healthCheck.TimeoutSeconds == 0 {
+			healthCheck.TimeoutSeconds = 1
+		}
+		if healthCheck.PeriodSeconds == 0 {
+			healthCheck.PeriodSeconds = 10
+		}
+		if healthCheck.FailureThreshold == 0 {
+			healthCheck.FailureThreshold = 3
+		}
+	}
+	for i := range spec.Routes {
+		if spec.Routes[i].Path == "" {
+			spec.Routes[i].Path = "/"
+		}
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-manor-codelogia-com-v1-app,mutating=false,failurePolicy=fail,groups=manor.codelogia.com,resources=apps,versions=v1,sideEffects=None,admissionReviewVersions=v1beta1,name=vapp.manor.codelogia.com
+
+var _ webhook.Validator = &App{}
+
+// ValidateCreate validates the App on creation.
+func (r *App) ValidateCreate() error {
+	return r.validate()
+}
+
+// ValidateUpdate validates the App on update.
+func (r *App) ValidateUpdate(old runtime.Object) error {
+	return r.validate()
+}
+
+// ValidateDelete validates the App on deletion.
+func (r *App) ValidateDelete() error {
+	return nil
+}
+
+func (r *App) validate() error {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	errs = append(errs, validateImageRegistry(spec.Child("imageRegistry"), r.Spec.ImageRegistry)...)
+	if r.Spec.Replicas != nil && *r.Spec.Replicas < 0 {
+		errs = append(errs, field.Invalid(spec.Child("replicas"), *r.Spec.Replicas, "must be greater than or equal to 0"))
+	}
+	if r.Spec.ProgressDeadlineSeconds != nil && *r.Spec.ProgressDeadlineSeconds < 1 {
+		errs = append(errs, field.Invalid(spec.Child("progressDeadlineSeconds"), *r.Spec.ProgressDeadlineSeconds, "must be greater than 0"))
+	}
+
+	for i, envVar := range r.Spec.Env {
+		path := spec.Child("env").Index(i).Child("name")
+		if envVar.Name == "PORT" {
+			errs = append(errs, field.Forbidden(path, "PORT is set by manor to the first port of the App"))
+			continue
+		}
+		for _, msg := range validation.IsEnvVarName(envVar.Name) {
+			errs = append(errs, field.Invalid(path, envVar.Name, msg))
+		}
+	}
+
+	// The ports of the App, by name, defaulting to a single http port like the reconciler does.
+	ports := map[string]corev1.Protocol{"http": corev1.ProtocolTCP}
+	if len(r.Spec.Ports) > 0 {
+		ports = map[string]corev1.Protocol{}
+	}
+	numbers := sets.NewString()
+	for i, port := range r.Spec.Ports {
+		path := spec.Child("ports").Index(i)
+		for _, msg := range validation.IsValidPortName(port.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), port.Name, msg))
+		}
+		if _, ok := ports[port.Name]; ok {
+			errs = append(errs, field.Duplicate(path.Child("name"), port.Name))
+		}
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		switch protocol {
+		case corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			errs = append(errs, field.NotSupported(path.Child("protocol"), protocol, []string{
+				string(corev1.ProtocolTCP), string(corev1.ProtocolUDP), string(corev1.ProtocolSCTP),
+			}))
+		}
+		number := fmt.Sprintf("%d/%s", port.Port, protocol)
+		if numbers.Has(number) {
+			errs = append(errs, field.Duplicate(path.Child("port"), port.Port))
+		}
+		numbers.Insert(number)
+		ports[port.Name] = protocol
+	}
+
+	if healthCheck := r.Spec.HealthCheck; healthCheck != nil && healthCheck.Port != "" {
+		path := spec.Child("healthCheck", "port")
+		protocol, ok := ports[healthCheck.Port]
+		switch {
+		case !ok:
+			errs = append(errs, field.NotFound(path, healthCheck.Port))
+		case healthCheck.Type != HealthCheckProcess && protocol != corev1.ProtocolTCP:
+			errs = append(errs, field.Invalid(path, healthCheck.Port, "must be a TCP port"))
+		}
+	}
+
+	for i, route := range r.Spec.Routes {
+		path := spec.Child("routes").Index(i)
+		host := strings.TrimPrefix(route.Host, "*.")
+		for _, msg := range validation.IsDNS1123Subdomain(host) {
+			errs = append(errs, field.Invalid(path.Child("host"), route.Host, msg))
+		}
+		if route.Path != "" && !strings.HasPrefix(route.Path, "/") {
+			errs = append(errs, field.Invalid(path.Child("path"), route.Path, "must start with /"))
+		}
+	}
+
+	if r.Spec.Network != nil {
+		for i, rule := range r.Spec.Network.Ingress {
+			for j, port := range rule.Ports {
+				if _, ok := ports[port]; !ok {
+					errs = append(errs, field.NotFound(spec.Child("network", "ingress").Index(i).Child("ports").Index(j), port))
+				}
+			}
+		}
+	}
+
+	if r.Spec.Strategy != nil {
+		errs = append(errs, validateAppStrategy(spec.Child("strategy"), r.Spec.Strategy)...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("App").GroupKind(), r.Name, errs)
+}
+
+// validateAppStrategy validates the rollout strategy of an App, whose parameters must match its
+// type.
+func validateAppStrategy(path *field.Path, strategy *AppStrategy) field.ErrorList {
+	var errs field.ErrorList
+
+	strategyType := strategy.Type
+	if strategyType == "" {
+		strategyType = RollingUpdateStrategyType
+	}
+	if strategy.BlueGreen != nil && strategyType != BlueGreenStrategyType {
+		errs = append(errs, field.Forbidden(path.Child("blueGreen"), "may only be set with the BlueGreen type"))
+	}
+	if strategy.Canary != nil && strategyType != CanaryStrategyType {
+		errs = append(errs, field.Forbidden(path.Child("canary"), "may only be set with the Canary type"))
+	}
+	if strategyType == CanaryStrategyType && (strategy.Canary == nil || len(strategy.Canary.Steps) == 0) {
+		errs = append(errs, field.Required(path.Child("canary", "steps"), "must have at least one step"))
+	}
+
+	if rollingUpdate := strategy.RollingUpdate; rollingUpdate != nil {
+		path := path.Child("rollingUpdate")
+		// The values are defaulted to 25% when unset.
+		maxUnavailable, maxSurge := 1, 1
+		if rollingUpdate.MaxUnavailable != nil {
+			var err error
+			maxUnavailable, err = intstr.GetValueFromIntOrPercent(rollingUpdate.MaxUnavailable, 100, true)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("maxUnavailable"), rollingUpdate.MaxUnavailable.String(), err.Error()))
+			}
+		}
+		if rollingUpdate.MaxSurge != nil {
+			var err error
+			maxSurge, err = intstr.GetValueFromIntOrPercent(rollingUpdate.MaxSurge, 100, true)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("maxSurge"), rollingUpdate.MaxSurge.String(), err.Error()))
+			}
+		}
+		if maxUnavailable == 0 && maxSurge == 0 {
+			errs = append(errs, field.Invalid(path.Child("maxUnavailable"), rollingUpdate.MaxUnavailable.String(), "may not be 0 when maxSurge is 0"))
+		}
+	}
+
+	return errs
+}
+
+// validateImageRegistry validates an image registry, which must be in the format
+// <name>.<namespace>.svc when it's a Service of the cluster.
+func validateImageRegistry(path *field.Path, imageRegistry string) field.ErrorList {
+	if !strings.HasSuffix(imageRegistry, ".svc") {
+		return nil
+	}
+	split := strings.Split(imageRegistry, ".")
+	if len(split) != 3 || split[0] == "" || split[1] == "" {
+		return field.ErrorList{field.Invalid(path, imageRegistry, "must be in the format <name>.<namespace>.svc")}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("App webhooks", func() {
+	ctx := context.Background()
+
+	newApp := func(name string) *App {
+		return &App{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	}
+
+	It("stores the defaults on the App", func() {
+		app := newApp("defaults")
+		app.Spec.Ports = []AppPort{{Name: "http", Port: 8080}}
+		app.Spec.HealthCheck = &HealthCheck{Type: HealthCheckHTTP}
+		app.Spec.Routes = []Route{{Host: "defaults.example.com"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		stored := &App{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, stored)).To(Succeed())
+		Expect(stored.Spec.State).To(Equal(AppStarted))
+		Expect(stored.Spec.Replicas).NotTo(BeNil())
+		Expect(*stored.Spec.Replicas).To(Equal(int32(1)))
+		Expect(stored.Spec.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+		Expect(stored.Spec.ArtifactHistoryLimit).To(BeNil())
+		Expect(*stored.Spec.SucceededArtifactHistoryLimit).To(Equal(DefaultSucceededArtifactHistoryLimit))
+		Expect(*stored.Spec.FailedArtifactHistoryLimit).To(Equal(DefaultFailedArtifactHistoryLimit))
+		Expect(stored.Spec.Ports[0].Protocol).To(Equal(corev1.ProtocolTCP))
+		Expect(stored.Spec.HealthCheck.Path).To(Equal("/"))
+		Expect(stored.Spec.HealthCheck.TimeoutSeconds).To(Equal(int32(1)))
+		Expect(stored.Spec.HealthCheck.PeriodSeconds).To(Equal(int32(10)))
+		Expect(stored.Spec.HealthCheck.FailureThreshold).To(Equal(int32(3)))
+		Expect(stored.Spec.Routes[0].Path).To(Equal("/"))
+		Expect(stored.Spec.Strategy).To(BeNil())
+		Expect(*stored.Spec.ProgressDeadlineSeconds).To(Equal(DefaultProgressDeadlineSeconds))
+	})
+
+	It("defaults the type of the App strategy", func() {
+		app := newApp("strategy")
+		app.Spec.Strategy = &AppStrategy{}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		stored := &App{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, stored)).To(Succeed())
+		Expect(stored.Spec.Strategy.Type).To(Equal(RollingUpdateStrategyType))
+	})
+
+	It("keeps the values that are set", func() {
+		app := newApp("values")
+		app.Spec.State = AppStopped
+		app.Spec.Replicas = new(int32)
+		app.Spec.ImagePullPolicy = corev1.PullAlways
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		stored := &App{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, stored)).To(Succeed())
+		Expect(stored.Spec.State).To(Equal(AppStopped))
+		Expect(*stored.Spec.Replicas).To(Equal(int32(0)))
+		Expect(stored.Spec.ImagePullPolicy).To(Equal(corev1.PullAlways))
+	})
+
+	DescribeTable("rejecting invalid specs with field errors",
+		func(mutate func(*App), field string) {
+			app := newApp("invalid")
+			mutate(app)
+			err := k8sClient.Create(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("a malformed cluster image registry", func(app *App) {
+			app.Spec.ImageRegistry = "registry.svc"
+		}, "spec.imageRegistry"),
+		Entry("a reserved environment variable", func(app *App) {
+			app.Spec.Env = []corev1.EnvVar{{Name: "PORT", Value: "80"}}
+		}, "spec.env[0].name"),
+		Entry("an invalid environment variable name", func(app *App) {
+			app.Spec.Env = []corev1.EnvVar{{Name: "1=", Value: "x"}}
+		}, "spec.env[0].name"),
+		Entry("duplicate port names", func(app *App) {
+			app.Spec.Ports = []AppPort{{Name: "http", Port: 8080}, {Name: "http", Port: 9090}}
+		}, "spec.ports[1].name"),
+		Entry("duplicate port numbers", func(app *App) {
+			app.Spec.Ports = []AppPort{{Name: "http", Port: 8080}, {Name: "admin", Port: 8080}}
+		}, "spec.ports[1].port"),
+		Entry("an unknown health check port", func(app *App) {
+			app.Spec.HealthCheck = &HealthCheck{Type: HealthCheckPort, Port: "admin"}
+		}, "spec.healthCheck.port"),
+		Entry("a health check of a UDP port", func(app *App) {
+			app.Spec.Ports = []AppPort{{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP}}
+			app.Spec.HealthCheck = &HealthCheck{Type: HealthCheckPort, Port: "dns"}
+		}, "spec.healthCheck.port"),
+		Entry("an invalid route host", func(app *App) {
+			app.Spec.Routes = []Route{{Host: "Not A Host"}}
+		}, "spec.routes[0].host"),
+		Entry("a relative route path", func(app *App) {
+			app.Spec.Routes = []Route{{Host: "example.com", Path: "api"}}
+		}, "spec.routes[0].path"),
+		Entry("a network ingress rule of an unknown port", func(app *App) {
+			app.Spec.Network = &AppNetwork{Ingress: []NetworkIngressRule{{Ports: []string{"admin"}}}}
+		}, "spec.network.ingress[0].ports[0]"),
+		Entry("a zero progress deadline", func(app *App) {
+			app.Spec.ProgressDeadlineSeconds = new(int32)
+		}, "spec.progressDeadlineSeconds"),
+		Entry("a Canary strategy without steps", func(app *App) {
+			app.Spec.Strategy = &AppStrategy{Type: CanaryStrategyType}
+		}, "spec.strategy.canary.steps"),
+		Entry("Canary parameters of another strategy", func(app *App) {
+			app.Spec.Strategy = &AppStrategy{
+				Type:   BlueGreenStrategyType,
+				Canary: &CanaryStrategy{Steps: []CanaryStep{{Weight: 10}}},
+			}
+		}, "spec.strategy.canary"),
+		Entry("a rolling update without surge nor unavailable replicas", func(app *App) {
+			zero := intstr.FromInt(0)
+			app.Spec.Strategy = &AppStrategy{
+				RollingUpdate: &RollingUpdateStrategy{MaxUnavailable: &zero, MaxSurge: &zero},
+			}
+		}, "spec.strategy.rollingUpdate.maxUnavailable"),
+	)
+
+	It("validates the App on update", func() {
+		app := newApp("update")
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		app.Spec.ImageRegistry = "too.many.parts.svc"
+		err := k8sClient.Update(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.imageRegistry"))
+	})
+})
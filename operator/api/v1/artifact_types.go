@@ -17,22 +17,48 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ArtifactLabel is the label set on the app-builder resources of an Artifact, with the name of the
+// Artifact.
+const ArtifactLabel = "manor.codelogia.com/artifact"
+
 // ArtifactSpec defines the desired state of Artifact.
 type ArtifactSpec struct {
 	// The name of the App the artifact is tied to.
 	App string `json:"app,omitempty"`
 	// The image registry to override the default Image Registry.
 	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// The directory of the app root within the uploaded source, for sources holding many apps.
+	// Defaults to the source root.
+	Path string `json:"path,omitempty"`
+	// The globs of the files, relative to the app root, that end up in the build context. A "**"
+	// matches any number of directories. Defaults to all the files.
+	Include []string `json:"include,omitempty"`
+	// The globs of the files, relative to the app root, that are left out of the build context.
+	Exclude []string `json:"exclude,omitempty"`
+	// The buildpacks builder image building the Artifact.
+	// Defaults to the app-builder default builder.
+	Builder string `json:"builder,omitempty"`
 }
 
 // ArtifactStatus defines the observed state of Artifact.
 type ArtifactStatus struct {
 	// Current service state of Artifact.
 	Conditions []ArtifactCondition `json:"conditions,omitempty"`
+	// The reference to the stored build log, if build logs are persisted.
+	LogRef string `json:"logRef,omitempty"`
+	// The URL of the job on the app-builder service, when the build is dispatched to it instead of
+	// an app-builder Pod.
+	BuildJob string `json:"buildJob,omitempty"`
+	// The image built for the Artifact, tagged with the Artifact name.
+	Image string `json:"image,omitempty"`
+	// The digest of the image, set once the build succeeded.
+	Digest string `json:"digest,omitempty"`
 }
 
 // ArtifactCondition represents Artifact conditions.
@@ -54,9 +80,12 @@ const (
 	ArtifactInProgress ArtifactConditionType = "In progress"
 	// ArtifactCompleted means the Artifact is completed.
 	ArtifactCompleted ArtifactConditionType = "Completed"
+	// ArtifactFailed means the Artifact build failed.
+	ArtifactFailed ArtifactConditionType = "Failed"
 )
 
 // +kubebuilder:object:root=true
+// +kubebuilder:storageversion
 // +kubebuilder:subresource:status
 
 // Artifact is the Schema for the artifacts API.
@@ -68,6 +97,30 @@ type Artifact struct {
 	Status ArtifactStatus `json:"status,omitempty"`
 }
 
+// HasCondition returns whether the Artifact has the condition type with a true status.
+func (a *Artifact) HasCondition(conditionType ArtifactConditionType) bool {
+	for _, condition := range a.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// Deployable returns why the App can't be deployed with the Artifact, as it belongs to another App
+// or wasn't built successfully, or nil if it can.
+func (a *Artifact) Deployable(app *App) error {
+	switch {
+	case a.Spec.App != app.Name:
+		return fmt.Errorf("Artifact %s belongs to App %s", a.Name, a.Spec.App)
+	case !a.HasCondition(ArtifactCompleted):
+		return fmt.Errorf("Artifact %s is not completed", a.Name)
+	case a.HasCondition(ArtifactFailed) || a.Status.Digest == "":
+		return fmt.Errorf("Artifact %s was not built successfully", a.Name)
+	}
+	return nil
+}
+
 // +kubebuilder:object:root=true
 
 // ArtifactList contains a list of Artifact.
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Artifact", func() {
+	DescribeTable("Deployable",
+		func(app string, conditions []ArtifactCondition, digest string, expected string) {
+			artifact := &Artifact{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default"},
+				Spec:       ArtifactSpec{App: app},
+				Status:     ArtifactStatus{Conditions: conditions, Digest: digest},
+			}
+			err := artifact.Deployable(&App{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}})
+			if expected == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expected))
+			}
+		},
+		Entry("succeeded",
+			"app",
+			[]ArtifactCondition{{Type: ArtifactCompleted, Status: corev1.ConditionTrue}},
+			"sha256:aaaa",
+			"",
+		),
+		Entry("of another App",
+			"other",
+			[]ArtifactCondition{{Type: ArtifactCompleted, Status: corev1.ConditionTrue}},
+			"sha256:aaaa",
+			"Artifact app-1 belongs to App other",
+		),
+		Entry("in progress",
+			"app",
+			[]ArtifactCondition{{Type: ArtifactCompleted, Status: corev1.ConditionFalse}},
+			"",
+			"Artifact app-1 is not completed",
+		),
+		Entry("failed",
+			"app",
+			[]ArtifactCondition{
+				{Type: ArtifactCompleted, Status: corev1.ConditionTrue},
+				{Type: ArtifactFailed, Status: corev1.ConditionTrue},
+			},
+			"",
+			"Artifact app-1 was not built successfully",
+		),
+		Entry("completed without digest",
+			"app",
+			[]ArtifactCondition{{Type: ArtifactCompleted, Status: corev1.ConditionTrue}},
+			"",
+			"Artifact app-1 was not built successfully",
+		),
+	)
+})
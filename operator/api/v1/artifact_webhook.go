@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of the Artifact.
+func (r *Artifact) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-manor-codelogia-com-v1-artifact,mutating=true,failurePolicy=fail,groups=manor.codelogia.com,resources=artifacts,verbs=create;update,versions=v1,sideEffects=None,admissionReviewVersions=v1beta1,name=martifact.manor.codelogia.com
+
+var _ webhook.Defaulter = &Artifact{}
+
+// Default sets the defaults of the Artifact spec, and labels it with its App so the Artifacts of
+// an App can be selected.
+func (r *Artifact) Default() {
+	if r.Spec.Builder == "" {
+		r.Spec.Builder = build.DefaultBuilder
+	}
+	if r.Spec.App != "" {
+		if r.Labels == nil {
+			r.Labels = map[string]string{}
+		}
+		r.Labels[AppLabel] = r.Spec.App
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-manor-codelogia-com-v1-artifact,mutating=false,failurePolicy=fail,groups=manor.codelogia.com,resources=artifacts,versions=v1,sideEffects=None,admissionReviewVersions=v1beta1,name=vartifact.manor.codelogia.com
+
+var _ webhook.Validator = &Artifact{}
+
+// ValidateCreate validates the Artifact on creation.
+func (r *Artifact) ValidateCreate() error {
+	return r.validate(nil)
+}
+
+// ValidateUpdate validates the Artifact on update. The spec of an Artifact can't change once it's
+// created, as it describes a build that already started.
+func (r *Artifact) ValidateUpdate(old runtime.Object) error {
+	return r.validate(old.(*Artifact))
+}
+
+// ValidateDelete validates the Artifact on deletion.
+func (r *Artifact) ValidateDelete() error {
+	return nil
+}
+
+func (r *Artifact) validate(old *Artifact) error {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	if r.Spec.App == "" {
+		errs = append(errs, field.Required(spec.Child("app"), "the name of the App is required"))
+	}
+	errs = append(errs, validateImageRegistry(spec.Child("imageRegistry"), r.Spec.ImageRegistry)...)
+	if err := (build.Filter{Path: r.Spec.Path}).Validate(); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("path"), r.Spec.Path, err.Error()))
+	}
+	for i, pattern := range r.Spec.Include {
+		if err := (build.Filter{Include: []string{pattern}}).Validate(); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("include").Index(i), pattern, err.Error()))
+		}
+	}
+	for i, pattern := range r.Spec.Exclude {
+		if err := (build.Filter{Exclude: []string{pattern}}).Validate(); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("exclude").Index(i), pattern, err.Error()))
+		}
+	}
+
+	if old != nil && !equality.Semantic.DeepEqual(r.Spec, old.Spec) {
+		// The builder defaulted on update doesn't change the Artifacts created before the webhook.
+		oldSpec := old.Spec
+		if oldSpec.Builder == "" {
+			oldSpec.Builder = r.Spec.Builder
+		}
+		if !equality.Semantic.DeepEqual(r.Spec, oldSpec) {
+			errs = append(errs, field.Forbidden(spec, "the spec of an Artifact is immutable"))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Artifact").GroupKind(), r.Name, errs)
+}
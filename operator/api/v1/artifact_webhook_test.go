@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+)
+
+var _ = Describe("Artifact webhooks", func() {
+	ctx := context.Background()
+
+	newArtifact := func(name string) *Artifact {
+		return &Artifact{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       ArtifactSpec{App: "app"},
+		}
+	}
+
+	It("stores the defaults on the Artifact", func() {
+		artifact := newArtifact("defaults")
+		Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+
+		stored := &Artifact{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: artifact.Name, Namespace: artifact.Namespace}, stored)).To(Succeed())
+		Expect(stored.Spec.Builder).To(Equal(build.DefaultBuilder))
+		Expect(stored.Labels).To(HaveKeyWithValue(AppLabel, "app"))
+	})
+
+	DescribeTable("rejecting invalid specs with field errors",
+		func(mutate func(*Artifact), field string) {
+			artifact := newArtifact("invalid")
+			mutate(artifact)
+			err := k8sClient.Create(ctx, artifact)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("an empty App", func(artifact *Artifact) {
+			artifact.Spec.App = ""
+		}, "spec.app"),
+		Entry("a malformed cluster image registry", func(artifact *Artifact) {
+			artifact.Spec.ImageRegistry = ".svc"
+		}, "spec.imageRegistry"),
+		Entry("a path outside of the source", func(artifact *Artifact) {
+			artifact.Spec.Path = "../other"
+		}, "spec.path"),
+		Entry("an invalid include glob", func(artifact *Artifact) {
+			artifact.Spec.Include = []string{"src/**", "["}
+		}, "spec.include[1]"),
+		Entry("an invalid exclude glob", func(artifact *Artifact) {
+			artifact.Spec.Exclude = []string{""}
+		}, "spec.exclude[0]"),
+	)
+
+	It("forbids changing the spec of an Artifact", func() {
+		artifact := newArtifact("immutable")
+		Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+
+		artifact.Spec.Path = "other"
+		err := k8sClient.Update(ctx, artifact)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec"))
+
+		By("changing its metadata")
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: artifact.Name, Namespace: artifact.Namespace}, artifact)).To(Succeed())
+		artifact.Annotations = map[string]string{"example.com/note": "kept"}
+		Expect(k8sClient.Update(ctx, artifact)).To(Succeed())
+	})
+})
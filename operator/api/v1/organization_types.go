@@ -0,0 +1,103 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OrganizationSpec defines the desired state of Organization.
+type OrganizationSpec struct {
+	// The human-readable name of the Organization.
+	DisplayName string `json:"displayName,omitempty"`
+	// The members of the Organization, who are granted their role in all its Spaces.
+	Members []Member `json:"members,omitempty"`
+}
+
+// Member is a user, group or service account granted a role in a Space.
+type Member struct {
+	// The kind of the member.
+	// Defaults to User.
+	// +kubebuilder:validation:Enum=User;Group;ServiceAccount
+	Kind string `json:"kind,omitempty"`
+	// The name of the member.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// The namespace of the service account.
+	// Defaults to the namespace of the Space.
+	Namespace string `json:"namespace,omitempty"`
+	// The role of the member.
+	// Defaults to Developer.
+	Role SpaceRole `json:"role,omitempty"`
+}
+
+// SpaceRole is the role of a member in a Space.
+// +kubebuilder:validation:Enum=Admin;Developer;Viewer
+type SpaceRole string
+
+const (
+	// SpaceAdmin can manage all the resources of a Space, including the access to it.
+	SpaceAdmin SpaceRole = "Admin"
+	// SpaceDeveloper can manage the apps of a Space.
+	SpaceDeveloper SpaceRole = "Developer"
+	// SpaceViewer can only read the resources of a Space.
+	SpaceViewer SpaceRole = "Viewer"
+)
+
+const (
+	// MemberUser is the kind of the user members.
+	MemberUser = "User"
+	// MemberGroup is the kind of the group members.
+	MemberGroup = "Group"
+	// MemberServiceAccount is the kind of the service account members.
+	MemberServiceAccount = "ServiceAccount"
+)
+
+// OrganizationStatus defines the observed state of Organization.
+type OrganizationStatus struct {
+	// The names of the Spaces of the Organization.
+	Spaces []string `json:"spaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Display Name",type=string,JSONPath=`.spec.displayName`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Organization is the Schema for the organizations API. An Organization is a tenant of manor,
+// grouping the Spaces of a team.
+type Organization struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OrganizationSpec   `json:"spec,omitempty"`
+	Status OrganizationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OrganizationList contains a list of Organization.
+type OrganizationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Organization `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Organization{}, &OrganizationList{})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceBindingLabel is the label set on the Secret projected into an App by a ServiceBinding,
+// with the name of the ServiceBinding.
+const ServiceBindingLabel = "manor.codelogia.com/service-binding"
+
+// ServiceBindingSpec defines the desired state of ServiceBinding.
+type ServiceBindingSpec struct {
+	// The name of the App the credentials are bound to.
+	// +kubebuilder:validation:MinLength=1
+	App string `json:"app"`
+	// The name of the binding, which is the name of its directory in SERVICE_BINDING_ROOT and of its
+	// entry in VCAP_SERVICES.
+	// Defaults to the name of the ServiceBinding.
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name,omitempty"`
+	// The type of the service, e.g. postgresql, overriding the type entry of the credentials.
+	Type string `json:"type,omitempty"`
+	// The provider of the service, overriding the provider entry of the credentials.
+	Provider string `json:"provider,omitempty"`
+	// The name of the Secret holding the credentials. Either the Secret or the service must be set.
+	Secret string `json:"secret,omitempty"`
+	// The provisioned service holding the credentials, whose status.binding.name is the name of the
+	// Secret holding them.
+	Service *ProvisionedServiceReference `json:"service,omitempty"`
+}
+
+// ProvisionedServiceReference references a provisioned service in the namespace of the
+// ServiceBinding.
+type ProvisionedServiceReference struct {
+	// The API version of the service.
+	APIVersion string `json:"apiVersion"`
+	// The kind of the service.
+	Kind string `json:"kind"`
+	// The name of the service.
+	Name string `json:"name"`
+}
+
+// ServiceBindingStatus defines the observed state of ServiceBinding.
+type ServiceBindingStatus struct {
+	// Current service state of ServiceBinding.
+	Conditions []ServiceBindingCondition `json:"conditions,omitempty"`
+	// The name of the Secret projected into the App, holding the credentials along with the type and
+	// provider of the service.
+	Secret string `json:"secret,omitempty"`
+}
+
+// ServiceBindingCondition represents ServiceBinding conditions.
+type ServiceBindingCondition struct {
+	// Type is the type of the condition.
+	Type ServiceBindingConditionType `json:"type"`
+	// Status is the status of the condition.
+	// Can be True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// Message is the reason of the status, if it's not True.
+	Message string `json:"message,omitempty"`
+}
+
+// ServiceBindingConditionType represents ServiceBinding condition types.
+type ServiceBindingConditionType string
+
+const (
+	// ServiceBindingReady means the credentials are ready to be projected into the App.
+	ServiceBindingReady ServiceBindingConditionType = "Ready"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="App",type=string,JSONPath=`.spec.app`
+// +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.status.secret`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServiceBinding is the Schema for the servicebindings API. A ServiceBinding projects the
+// credentials of a service into an App, following the Service Binding specification: they're
+// mounted in the directory of the SERVICE_BINDING_ROOT environment variable, set in the
+// VCAP_SERVICES environment variable, and provided to the buildpacks building the App.
+type ServiceBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceBindingSpec   `json:"spec,omitempty"`
+	Status ServiceBindingStatus `json:"status,omitempty"`
+}
+
+// BindingName returns the name of the binding.
+func (b *ServiceBinding) BindingName() string {
+	if b.Spec.Name != "" {
+		return b.Spec.Name
+	}
+	return b.Name
+}
+
+// +kubebuilder:object:root=true
+
+// ServiceBindingList contains a list of ServiceBinding.
+type ServiceBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceBinding{}, &ServiceBindingList{})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceBrokerSpec defines the desired state of ServiceBroker.
+type ServiceBrokerSpec struct {
+	// The URL of the Open Service Broker API v2 broker.
+	// +kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+	// The Secret holding the basic authentication credentials of the broker in its username and
+	// password keys. The requests aren't authenticated when not set.
+	CredentialsSecret *corev1.SecretReference `json:"credentialsSecret,omitempty"`
+}
+
+// ServiceBrokerStatus defines the observed state of ServiceBroker.
+type ServiceBrokerStatus struct {
+	// Current service state of ServiceBroker.
+	Conditions []ServiceBrokerCondition `json:"conditions,omitempty"`
+	// The services offered by the broker, as of the last catalog fetch.
+	Services []BrokerService `json:"services,omitempty"`
+	// When the catalog was last fetched.
+	CatalogFetchedAt *metav1.Time `json:"catalogFetchedAt,omitempty"`
+}
+
+// BrokerService is a service offered by a broker.
+type BrokerService struct {
+	// The ID of the service in the broker.
+	ID string `json:"id"`
+	// The name of the service.
+	Name string `json:"name"`
+	// The description of the service.
+	Description string `json:"description,omitempty"`
+	// The tags of the service.
+	Tags []string `json:"tags,omitempty"`
+	// Whether the instances of the service can be bound.
+	Bindable bool `json:"bindable,omitempty"`
+	// The plans of the service.
+	Plans []BrokerPlan `json:"plans,omitempty"`
+}
+
+// BrokerPlan is a plan of a service offered by a broker.
+type BrokerPlan struct {
+	// The ID of the plan in the broker.
+	ID string `json:"id"`
+	// The name of the plan.
+	Name string `json:"name"`
+	// The description of the plan.
+	Description string `json:"description,omitempty"`
+	// Whether the plan is free.
+	Free *bool `json:"free,omitempty"`
+	// Whether the instances of the plan can be bound, overriding the service.
+	Bindable *bool `json:"bindable,omitempty"`
+}
+
+// ServiceBrokerCondition represents ServiceBroker conditions.
+type ServiceBrokerCondition struct {
+	// Type is the type of the condition.
+	Type ServiceBrokerConditionType `json:"type"`
+	// Status is the status of the condition.
+	// Can be True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// Message is the reason of the status, if it's not True.
+	Message string `json:"message,omitempty"`
+}
+
+// ServiceBrokerConditionType represents ServiceBroker condition types.
+type ServiceBrokerConditionType string
+
+const (
+	// ServiceBrokerReady means the catalog of the broker was fetched.
+	ServiceBrokerReady ServiceBrokerConditionType = "Ready"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.spec.url`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServiceBroker is the Schema for the servicebrokers API. A ServiceBroker registers an Open Service
+// Broker API broker, whose services can be provisioned with ServiceInstances in all the namespaces.
+type ServiceBroker struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceBrokerSpec   `json:"spec,omitempty"`
+	Status ServiceBrokerStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ServiceBrokerList contains a list of ServiceBroker.
+type ServiceBrokerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceBroker `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceBroker{}, &ServiceBrokerList{})
+}
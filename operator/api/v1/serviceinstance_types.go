@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ServiceInstanceLabel is the label set on the Secret holding the credentials of a
+// ServiceInstance, with the name of the ServiceInstance.
+const ServiceInstanceLabel = "manor.codelogia.com/service-instance"
+
+// ServiceInstanceSpec defines the desired state of ServiceInstance.
+type ServiceInstanceSpec struct {
+	// The name of the ServiceBroker offering the service.
+	// +kubebuilder:validation:MinLength=1
+	Broker string `json:"broker"`
+	// The name of the service in the catalog of the broker.
+	// +kubebuilder:validation:MinLength=1
+	Service string `json:"service"`
+	// The name of the plan of the service. The plan can't be changed once the instance is
+	// provisioned.
+	// +kubebuilder:validation:MinLength=1
+	Plan string `json:"plan"`
+	// The service specific configuration parameters.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Parameters *runtime.RawExtension `json:"parameters,omitempty"`
+}
+
+// ServiceInstanceStatus defines the observed state of ServiceInstance.
+type ServiceInstanceStatus struct {
+	// Current service state of ServiceInstance.
+	Conditions []ServiceInstanceCondition `json:"conditions,omitempty"`
+	// The ID of the service the instance was provisioned with.
+	ServiceID string `json:"serviceID,omitempty"`
+	// The ID of the plan the instance was provisioned with.
+	PlanID string `json:"planID,omitempty"`
+	// The asynchronous operation in progress in the broker, if any.
+	Operation *ServiceInstanceOperation `json:"operation,omitempty"`
+	// Whether the instance is provisioned in the broker.
+	Provisioned bool `json:"provisioned,omitempty"`
+	// The URL of the dashboard of the instance, if the broker provides one.
+	DashboardURL string `json:"dashboardURL,omitempty"`
+	// The Secret holding the credentials of the instance, once it's bound. It makes the
+	// ServiceInstance a provisioned service of the Service Binding specification, so it can be
+	// referenced by ServiceBindings.
+	Binding *corev1.LocalObjectReference `json:"binding,omitempty"`
+}
+
+// ServiceInstanceOperation is an asynchronous operation on a service instance.
+type ServiceInstanceOperation struct {
+	// The type of the operation.
+	// +kubebuilder:validation:Enum=Provision;Deprovision
+	Type string `json:"type"`
+	// The ID of the operation in the broker, if it provided one.
+	ID string `json:"id,omitempty"`
+}
+
+const (
+	// OperationProvision is the asynchronous provisioning of a service instance.
+	OperationProvision = "Provision"
+	// OperationDeprovision is the asynchronous deprovisioning of a service instance.
+	OperationDeprovision = "Deprovision"
+)
+
+// ServiceInstanceCondition represents ServiceInstance conditions.
+type ServiceInstanceCondition struct {
+	// Type is the type of the condition.
+	Type ServiceInstanceConditionType `json:"type"`
+	// Status is the status of the condition.
+	// Can be True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// Message is the reason of the status, if it's not True.
+	Message string `json:"message,omitempty"`
+}
+
+// ServiceInstanceConditionType represents ServiceInstance condition types.
+type ServiceInstanceConditionType string
+
+const (
+	// ServiceInstanceReady means the instance is provisioned and its credentials, if it's
+	// bindable, are in the binding Secret.
+	ServiceInstanceReady ServiceInstanceConditionType = "Ready"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Broker",type=string,JSONPath=`.spec.broker`
+// +kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.service`
+// +kubebuilder:printcolumn:name="Plan",type=string,JSONPath=`.spec.plan`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ServiceInstance is the Schema for the serviceinstances API. A ServiceInstance provisions a
+// service of a ServiceBroker and binds it, so its credentials can be bound to Apps with a
+// ServiceBinding.
+type ServiceInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceInstanceSpec   `json:"spec,omitempty"`
+	Status ServiceInstanceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ServiceInstanceList contains a list of ServiceInstance.
+type ServiceInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ServiceInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceInstance{}, &ServiceInstanceList{})
+}
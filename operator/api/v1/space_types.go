@@ -0,0 +1,119 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// OrganizationLabel is the label set on the namespace of a Space with the name of its
+	// Organization.
+	OrganizationLabel = "manor.codelogia.com/organization"
+	// SpaceLabel is the label set on the namespace of a Space with the name of the Space.
+	SpaceLabel = "manor.codelogia.com/space"
+)
+
+// SpaceSpec defines the desired state of Space.
+type SpaceSpec struct {
+	// The name of the Organization the Space belongs to.
+	// +kubebuilder:validation:MinLength=1
+	Organization string `json:"organization"`
+	// The members of the Space, in addition to the members of the Organization.
+	Members []Member `json:"members,omitempty"`
+	// The hard limits of the resources used by the Space, e.g. requests.cpu or pods. The Space is
+	// not limited when empty.
+	Quota corev1.ResourceList `json:"quota,omitempty"`
+	// The default resources of the containers of the Space that don't set their own.
+	DefaultResources *corev1.ResourceRequirements `json:"defaultResources,omitempty"`
+	// The network isolation of the Space.
+	Network SpaceNetwork `json:"network,omitempty"`
+}
+
+// SpaceNetwork is the network isolation of a Space.
+type SpaceNetwork struct {
+	// Whether the Pods of the Space only accept traffic from the Space, the allowed namespaces and
+	// the namespaces of manor and of the router configured in the operator.
+	// Defaults to true.
+	Isolated *bool `json:"isolated,omitempty"`
+	// The selectors of the namespaces allowed to reach the Pods of an isolated Space, e.g. the
+	// namespace of the ingress controller.
+	AllowedNamespaces []metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+}
+
+// SpaceStatus defines the observed state of Space.
+type SpaceStatus struct {
+	// Current service state of Space.
+	Conditions []SpaceCondition `json:"conditions,omitempty"`
+	// The namespace of the Space.
+	Namespace string `json:"namespace,omitempty"`
+	// The resources used by the Space, for the resources limited by its quota.
+	Usage corev1.ResourceList `json:"usage,omitempty"`
+	// The number of Apps in the Space.
+	Apps int32 `json:"apps,omitempty"`
+}
+
+// SpaceCondition represents Space conditions.
+type SpaceCondition struct {
+	// Type is the type of the condition.
+	Type SpaceConditionType `json:"type"`
+	// Status is the status of the condition.
+	// Can be True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// Message is the reason of the status, if it's not True.
+	Message string `json:"message,omitempty"`
+}
+
+// SpaceConditionType represents Space condition types.
+type SpaceConditionType string
+
+const (
+	// SpaceReady means the namespace of the Space and its policies are set up.
+	SpaceReady SpaceConditionType = "Ready"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Organization",type=string,JSONPath=`.spec.organization`
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.status.namespace`
+// +kubebuilder:printcolumn:name="Apps",type=integer,JSONPath=`.status.apps`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Space is the Schema for the spaces API. A Space is an environment of an Organization, e.g. the
+// staging environment of a team, backed by a namespace of the same name.
+type Space struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SpaceSpec   `json:"spec,omitempty"`
+	Status SpaceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SpaceList contains a list of Space.
+type SpaceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Space `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Space{}, &SpaceList{})
+}
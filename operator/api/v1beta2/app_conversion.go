@@ -0,0 +1,299 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// ConvertTo converts the App to the v1 hub version.
+func (src *App) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*manorv1.App)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = manorv1.AppSpec{
+		State:                         manorv1.AppState(src.Spec.State),
+		ImageRegistry:                 src.Spec.ImageRegistry,
+		ArtifactRef:                   src.Spec.ArtifactRef,
+		ImagePullPolicy:               src.Spec.ImagePullPolicy,
+		Replicas:                      src.Spec.Replicas,
+		KeepImages:                    src.Spec.KeepImages,
+		Strategy:                      strategyToV1(src.Spec.Strategy),
+		RolloutPaused:                 src.Spec.RolloutPaused,
+		ProgressDeadlineSeconds:       src.Spec.ProgressDeadlineSeconds,
+		Resources:                     src.Spec.Process.Resources,
+		ArtifactHistoryLimit:          src.Spec.ArtifactHistoryLimit,
+		SucceededArtifactHistoryLimit: src.Spec.SucceededArtifactHistoryLimit,
+		FailedArtifactHistoryLimit:    src.Spec.FailedArtifactHistoryLimit,
+		Entrypoint:                    src.Spec.Process.Entrypoint,
+		Args:                          src.Spec.Process.Args,
+		Env:                           src.Spec.Process.Env,
+	}
+	if src.Spec.Process.Ports != nil {
+		dst.Spec.Ports = make([]manorv1.AppPort, len(src.Spec.Process.Ports))
+		for i, p := range src.Spec.Process.Ports {
+			dst.Spec.Ports[i] = manorv1.AppPort(p)
+		}
+	}
+	if hc := src.Spec.HealthCheck; hc != nil {
+		dst.Spec.HealthCheck = &manorv1.HealthCheck{
+			Type:                manorv1.HealthCheckType(hc.Type),
+			Path:                hc.Path,
+			Port:                hc.Port,
+			InitialDelaySeconds: hc.InitialDelaySeconds,
+			TimeoutSeconds:      hc.TimeoutSeconds,
+			PeriodSeconds:       hc.PeriodSeconds,
+			FailureThreshold:    hc.FailureThreshold,
+		}
+	}
+	if src.Spec.Routes != nil {
+		dst.Spec.Routes = make([]manorv1.Route, len(src.Spec.Routes))
+		for i, r := range src.Spec.Routes {
+			dst.Spec.Routes[i] = manorv1.Route(r)
+		}
+	}
+	if n := src.Spec.Network; n != nil {
+		dst.Spec.Network = &manorv1.AppNetwork{DefaultDeny: n.DefaultDeny}
+		if n.Ingress != nil {
+			dst.Spec.Network.Ingress = make([]manorv1.NetworkIngressRule, len(n.Ingress))
+			for i, r := range n.Ingress {
+				dst.Spec.Network.Ingress[i] = manorv1.NetworkIngressRule(r)
+			}
+		}
+	}
+
+	dst.Status = manorv1.AppStatus{
+		Artifact:          src.Status.Artifact,
+		Image:             src.Status.Image,
+		Digest:            src.Status.Digest,
+		DeployedAt:        src.Status.DeployedAt,
+		LastReadyArtifact: src.Status.LastReadyArtifact,
+		Replicas:          src.Status.Replicas,
+		ReadyReplicas:     src.Status.ReadyReplicas,
+		URLs:              src.Status.URLs,
+		Rollout:           rolloutToV1(src.Status.Rollout),
+	}
+	if src.Status.History != nil {
+		dst.Status.History = make([]manorv1.AppDeployment, len(src.Status.History))
+		for i, d := range src.Status.History {
+			dst.Status.History[i] = manorv1.AppDeployment(d)
+		}
+	}
+	if src.Status.Conditions != nil {
+		dst.Status.Conditions = make([]manorv1.AppCondition, len(src.Status.Conditions))
+		for i, c := range src.Status.Conditions {
+			dst.Status.Conditions[i] = manorv1.AppCondition{
+				Type:    manorv1.AppConditionType(c.Type),
+				Status:  c.Status,
+				Reason:  c.Reason,
+				Message: c.Message,
+			}
+		}
+	}
+
+	data := &conversionData{}
+	if hasConditionDetails(src.Status.Conditions) {
+		data.Conditions = src.Status.Conditions
+	}
+	return setConversionData(dst, data)
+}
+
+// ConvertFrom converts the App from the v1 hub version.
+func (dst *App) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*manorv1.App)
+
+	dst.ObjectMeta = src.ObjectMeta
+	data, err := popConversionData(dst)
+	if err != nil {
+		return err
+	}
+
+	dst.Spec = AppSpec{
+		State:                         AppState(src.Spec.State),
+		ImageRegistry:                 src.Spec.ImageRegistry,
+		ArtifactRef:                   src.Spec.ArtifactRef,
+		ImagePullPolicy:               src.Spec.ImagePullPolicy,
+		Replicas:                      src.Spec.Replicas,
+		KeepImages:                    src.Spec.KeepImages,
+		Strategy:                      strategyFromV1(src.Spec.Strategy),
+		RolloutPaused:                 src.Spec.RolloutPaused,
+		ProgressDeadlineSeconds:       src.Spec.ProgressDeadlineSeconds,
+		ArtifactHistoryLimit:          src.Spec.ArtifactHistoryLimit,
+		SucceededArtifactHistoryLimit: src.Spec.SucceededArtifactHistoryLimit,
+		FailedArtifactHistoryLimit:    src.Spec.FailedArtifactHistoryLimit,
+		Process: AppProcess{
+			Entrypoint: src.Spec.Entrypoint,
+			Args:       src.Spec.Args,
+			Env:        src.Spec.Env,
+			Resources:  src.Spec.Resources,
+		},
+	}
+	if src.Spec.Ports != nil {
+		dst.Spec.Process.Ports = make([]AppPort, len(src.Spec.Ports))
+		for i, p := range src.Spec.Ports {
+			dst.Spec.Process.Ports[i] = AppPort(p)
+		}
+	}
+	if hc := src.Spec.HealthCheck; hc != nil {
+		dst.Spec.HealthCheck = &HealthCheck{
+			Type:                HealthCheckType(hc.Type),
+			Path:                hc.Path,
+			Port:                hc.Port,
+			InitialDelaySeconds: hc.InitialDelaySeconds,
+			TimeoutSeconds:      hc.TimeoutSeconds,
+			PeriodSeconds:       hc.PeriodSeconds,
+			FailureThreshold:    hc.FailureThreshold,
+		}
+	}
+	if src.Spec.Routes != nil {
+		dst.Spec.Routes = make([]Route, len(src.Spec.Routes))
+		for i, r := range src.Spec.Routes {
+			dst.Spec.Routes[i] = Route(r)
+		}
+	}
+	if n := src.Spec.Network; n != nil {
+		dst.Spec.Network = &AppNetwork{DefaultDeny: n.DefaultDeny}
+		if n.Ingress != nil {
+			dst.Spec.Network.Ingress = make([]NetworkIngressRule, len(n.Ingress))
+			for i, r := range n.Ingress {
+				dst.Spec.Network.Ingress[i] = NetworkIngressRule(r)
+			}
+		}
+	}
+
+	dst.Status = AppStatus{
+		Artifact:          src.Status.Artifact,
+		Image:             src.Status.Image,
+		Digest:            src.Status.Digest,
+		DeployedAt:        src.Status.DeployedAt,
+		LastReadyArtifact: src.Status.LastReadyArtifact,
+		Replicas:          src.Status.Replicas,
+		ReadyReplicas:     src.Status.ReadyReplicas,
+		URLs:              src.Status.URLs,
+		Rollout:           rolloutFromV1(src.Status.Rollout),
+	}
+	if src.Status.History != nil {
+		dst.Status.History = make([]AppDeployment, len(src.Status.History))
+		for i, d := range src.Status.History {
+			dst.Status.History[i] = AppDeployment(d)
+		}
+	}
+	if src.Status.Conditions != nil {
+		dst.Status.Conditions = make([]Condition, len(src.Status.Conditions))
+		for i, c := range src.Status.Conditions {
+			// The reason and message of the v1 conditions take precedence over the stored ones, which
+			// may be outdated.
+			condition := data.restoreCondition(i, string(c.Type), c.Status)
+			condition.Reason = c.Reason
+			condition.Message = c.Message
+			dst.Status.Conditions[i] = condition
+		}
+	}
+
+	return nil
+}
+
+func strategyToV1(src *AppStrategy) *manorv1.AppStrategy {
+	if src == nil {
+		return nil
+	}
+	dst := &manorv1.AppStrategy{
+		Type:          manorv1.AppStrategyType(src.Type),
+		RollingUpdate: (*manorv1.RollingUpdateStrategy)(src.RollingUpdate),
+	}
+	if src.BlueGreen != nil {
+		dst.BlueGreen = &manorv1.BlueGreenStrategy{Pause: (*manorv1.RolloutPause)(src.BlueGreen.Pause)}
+	}
+	if src.Canary != nil {
+		dst.Canary = &manorv1.CanaryStrategy{}
+		if src.Canary.Steps != nil {
+			dst.Canary.Steps = make([]manorv1.CanaryStep, len(src.Canary.Steps))
+			for i, step := range src.Canary.Steps {
+				dst.Canary.Steps[i] = manorv1.CanaryStep{
+					Weight: step.Weight,
+					Pause:  (*manorv1.RolloutPause)(step.Pause),
+				}
+			}
+		}
+	}
+	return dst
+}
+
+func strategyFromV1(src *manorv1.AppStrategy) *AppStrategy {
+	if src == nil {
+		return nil
+	}
+	dst := &AppStrategy{
+		Type:          AppStrategyType(src.Type),
+		RollingUpdate: (*RollingUpdateStrategy)(src.RollingUpdate),
+	}
+	if src.BlueGreen != nil {
+		dst.BlueGreen = &BlueGreenStrategy{Pause: (*RolloutPause)(src.BlueGreen.Pause)}
+	}
+	if src.Canary != nil {
+		dst.Canary = &CanaryStrategy{}
+		if src.Canary.Steps != nil {
+			dst.Canary.Steps = make([]CanaryStep, len(src.Canary.Steps))
+			for i, step := range src.Canary.Steps {
+				dst.Canary.Steps[i] = CanaryStep{
+					Weight: step.Weight,
+					Pause:  (*RolloutPause)(step.Pause),
+				}
+			}
+		}
+	}
+	return dst
+}
+
+func rolloutToV1(src *AppRollout) *manorv1.AppRollout {
+	if src == nil {
+		return nil
+	}
+	return &manorv1.AppRollout{
+		Artifact:    src.Artifact,
+		Image:       src.Image,
+		Strategy:    manorv1.AppStrategyType(src.Strategy),
+		Phase:       manorv1.AppRolloutPhase(src.Phase),
+		Step:        src.Step,
+		Weight:      src.Weight,
+		StartedAt:   src.StartedAt,
+		PausedAt:    src.PausedAt,
+		CompletedAt: src.CompletedAt,
+		PromotedAt:  src.PromotedAt,
+	}
+}
+
+func rolloutFromV1(src *manorv1.AppRollout) *AppRollout {
+	if src == nil {
+		return nil
+	}
+	return &AppRollout{
+		Artifact:    src.Artifact,
+		Image:       src.Image,
+		Strategy:    AppStrategyType(src.Strategy),
+		Phase:       AppRolloutPhase(src.Phase),
+		Step:        src.Step,
+		Weight:      src.Weight,
+		StartedAt:   src.StartedAt,
+		PausedAt:    src.PausedAt,
+		CompletedAt: src.CompletedAt,
+		PromotedAt:  src.PromotedAt,
+	}
+}
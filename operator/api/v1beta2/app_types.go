@@ -0,0 +1,396 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// AppState is the state of an App.
+// +kubebuilder:validation:Enum=Started;Stopped
+type AppState string
+
+const (
+	// AppStarted means the App runs its replicas.
+	AppStarted AppState = "Started"
+	// AppStopped means the App runs no replicas, while keeping its configuration.
+	AppStopped AppState = "Stopped"
+)
+
+// AppSpec defines the desired state of App.
+type AppSpec struct {
+	// The state of the App. A Stopped App is scaled to zero replicas.
+	// Defaults to Started.
+	State AppState `json:"state,omitempty"`
+	// The image registry to override the default Image Registry.
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// The Artifact the App runs, which must have been built successfully for the App. Pinning a
+	// previous Artifact rolls the App back to it.
+	// Defaults to the latest Artifact of the App built successfully.
+	ArtifactRef *corev1.LocalObjectReference `json:"artifactRef,omitempty"`
+	// Image pull policy.
+	// One of Always, Never, IfNotPresent.
+	// Defaults to IfNotPresent.
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// The number of replicas for the App.
+	// Defaults to 1.
+	Replicas *int32 `json:"replicas,omitempty"`
+	// The process each replica of the App runs.
+	Process AppProcess `json:"process,omitempty"`
+	// The health check of the App replicas.
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+	// The routes exposing the App outside of the cluster.
+	Routes []Route `json:"routes,omitempty"`
+	// The network access to the App. The App accepts traffic from anywhere when it has no ingress
+	// rules and doesn't deny traffic by default.
+	Network *AppNetwork `json:"network,omitempty"`
+	// The strategy rolling out new Artifacts to the App replicas.
+	// Defaults to RollingUpdate.
+	Strategy *AppStrategy `json:"strategy,omitempty"`
+	// Whether the rollout of a new Artifact with the BlueGreen or Canary strategy is paused at its
+	// current step, until it's resumed.
+	RolloutPaused bool `json:"rolloutPaused,omitempty"`
+	// The number of seconds a new Artifact has to be rolled out to ready replicas before it fails,
+	// and the App is rolled back to the last Artifact it was ready with. Replicas crash looping or
+	// failing to pull the image fail the Artifact sooner. The App pinned to an Artifact, e.g. by a
+	// manual rollback, is not rolled back automatically.
+	// Defaults to 600.
+	// +kubebuilder:validation:Minimum=1
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// The number of the most recent images of the App kept in the image registry once the App is
+	// deleted, when the operator cleans up the registry.
+	// Defaults to 0, deleting all the images of the App.
+	// +kubebuilder:validation:Minimum=0
+	KeepImages int32 `json:"keepImages,omitempty"`
+	// The number of the most recent finished Artifacts of the App kept, whether they succeeded or
+	// failed. The older Artifacts are pruned with their build logs and images.
+	// Defaults to no limit other than the succeeded and failed limits.
+	// +kubebuilder:validation:Minimum=0
+	ArtifactHistoryLimit *int32 `json:"artifactHistoryLimit,omitempty"`
+	// The number of the most recent succeeded Artifacts of the App kept. The Artifact the App runs,
+	// and the Artifacts of its deployment history, which it can be rolled back to, are never pruned.
+	// Defaults to 10.
+	// +kubebuilder:validation:Minimum=0
+	SucceededArtifactHistoryLimit *int32 `json:"succeededArtifactHistoryLimit,omitempty"`
+	// The number of the most recent failed Artifacts of the App kept.
+	// Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	FailedArtifactHistoryLimit *int32 `json:"failedArtifactHistoryLimit,omitempty"`
+}
+
+// AppProcess is the process each replica of the App runs.
+type AppProcess struct {
+	// The entrypoint command of the process.
+	Entrypoint string `json:"entrypoint,omitempty"`
+	// The arguments for the entrypoint command.
+	Args []string `json:"args,omitempty"`
+	// The environment variables of the process.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// The ports the process listens on. The first port is exposed to the process through the PORT
+	// environment variable and receives the traffic of the routes.
+	// Defaults to a single http port 8080.
+	Ports []AppPort `json:"ports,omitempty"`
+	// Compute Resources required by the process.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// AppPort is a port the App listens on.
+type AppPort struct {
+	// The name of the port. It must be unique within the App.
+	Name string `json:"name"`
+	// The port number.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// The protocol of the port. One of TCP, UDP, SCTP.
+	// Defaults to TCP.
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+}
+
+// HealthCheckType is the type of a health check.
+// +kubebuilder:validation:Enum=http;port;process
+type HealthCheckType string
+
+const (
+	// HealthCheckHTTP checks the App with an HTTP GET request, which must succeed.
+	HealthCheckHTTP HealthCheckType = "http"
+	// HealthCheckPort checks the App port accepts TCP connections.
+	HealthCheckPort HealthCheckType = "port"
+	// HealthCheckProcess only checks the App process is running.
+	HealthCheckProcess HealthCheckType = "process"
+)
+
+// HealthCheck is the health check of the App replicas.
+type HealthCheck struct {
+	// The type of the health check.
+	Type HealthCheckType `json:"type"`
+	// The path requested by http health checks.
+	// Defaults to /.
+	Path string `json:"path,omitempty"`
+	// The name of the port checked. Defaults to the first port of the App.
+	Port string `json:"port,omitempty"`
+	// The number of seconds after the replica started before it's checked.
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// The number of seconds after which a check times out.
+	// Defaults to 1.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// The number of seconds between checks.
+	// Defaults to 10.
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// The number of consecutive failed checks after which the replica is restarted.
+	// Defaults to 3.
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// Route exposes the App outside of the cluster.
+type Route struct {
+	// The host name of the route.
+	Host string `json:"host"`
+	// The path prefix of the route.
+	// Defaults to /.
+	Path string `json:"path,omitempty"`
+}
+
+// AppNetwork is the network access to the App, enforced by a NetworkPolicy.
+type AppNetwork struct {
+	// Whether the App denies the traffic that is not allowed by its ingress rules, even when it has
+	// none. The App is isolated as soon as it has an ingress rule.
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
+	// The rules allowing traffic to the App.
+	Ingress []NetworkIngressRule `json:"ingress,omitempty"`
+}
+
+// NetworkIngressRule allows traffic to the App. A rule with no Apps, namespaces or router allows
+// all the sources.
+type NetworkIngressRule struct {
+	// The names of the Apps in the namespace of the App allowed to connect.
+	Apps []string `json:"apps,omitempty"`
+	// The namespaces whose Pods are allowed to connect. The namespaces are selected by their
+	// kubernetes.io/metadata.name label, which Kubernetes sets from 1.21 on and the operator sets on
+	// the namespaces of the Spaces. The other namespaces must carry it on older clusters.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Whether the router, serving the routes of the App, is allowed to connect.
+	Router bool `json:"router,omitempty"`
+	// The names of the ports of the App the rule allows.
+	// Defaults to all the ports.
+	Ports []string `json:"ports,omitempty"`
+}
+
+// AppStrategyType is the type of the rollout strategy of an App.
+// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen;Canary
+type AppStrategyType string
+
+const (
+	// RollingUpdateStrategyType replaces the replicas of the App progressively with replicas running
+	// the new Artifact.
+	RollingUpdateStrategyType AppStrategyType = "RollingUpdate"
+	// BlueGreenStrategyType runs a full set of replicas of the new Artifact alongside the current
+	// ones, and switches the traffic to them once they are healthy.
+	BlueGreenStrategyType AppStrategyType = "BlueGreen"
+	// CanaryStrategyType shifts the traffic to replicas of the new Artifact in weighted steps,
+	// splitting it by the ratio of the replicas running each Artifact.
+	CanaryStrategyType AppStrategyType = "Canary"
+)
+
+// AppStrategy is the strategy rolling out new Artifacts to the App replicas.
+type AppStrategy struct {
+	// The type of the strategy.
+	// Defaults to RollingUpdate.
+	Type AppStrategyType `json:"type,omitempty"`
+	// The parameters of the rolling updates of the App replicas. The BlueGreen and Canary
+	// strategies also roll the promoted Artifact out to the App replicas with them.
+	RollingUpdate *RollingUpdateStrategy `json:"rollingUpdate,omitempty"`
+	// The parameters of the BlueGreen strategy.
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+	// The parameters of the Canary strategy, required by it.
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+}
+
+// RollingUpdateStrategy are the parameters of the rolling updates of the App replicas.
+type RollingUpdateStrategy struct {
+	// The maximum number of replicas that can be unavailable during the update, as a number or a
+	// percentage of the replicas.
+	// Defaults to 25%.
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// The maximum number of replicas that can be created above the desired number of replicas
+	// during the update, as a number or a percentage of the replicas.
+	// Defaults to 25%.
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+}
+
+// BlueGreenStrategy are the parameters of the BlueGreen strategy.
+type BlueGreenStrategy struct {
+	// The pause once the replicas of the new Artifact are healthy, before the traffic is switched
+	// to them.
+	// Defaults to switching the traffic as soon as they are healthy.
+	Pause *RolloutPause `json:"pause,omitempty"`
+}
+
+// CanaryStrategy are the parameters of the Canary strategy.
+type CanaryStrategy struct {
+	// The steps of the rollout. The new Artifact is rolled out to all the replicas of the App after
+	// the last step.
+	// +kubebuilder:validation:MinItems=1
+	Steps []CanaryStep `json:"steps"`
+}
+
+// CanaryStep is a step of a Canary rollout.
+type CanaryStep struct {
+	// The percentage of the App replicas running the new Artifact, which receive the same share of
+	// the traffic.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+	// The pause once the replicas of the step are healthy, before the next step.
+	// Defaults to moving on to the next step as soon as they are healthy.
+	Pause *RolloutPause `json:"pause,omitempty"`
+}
+
+// RolloutPause pauses a rollout until it's promoted, or for a duration.
+type RolloutPause struct {
+	// How long the rollout is paused for, unless it's promoted before. The rollout is paused until
+	// it's promoted when unset.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// AppStatus defines the observed state of App.
+type AppStatus struct {
+	// The latest observations of the state of the App.
+	Conditions []Condition `json:"conditions,omitempty"`
+	// The name of the Artifact the App is running.
+	Artifact string `json:"artifact,omitempty"`
+	// The image the App is running.
+	Image string `json:"image,omitempty"`
+	// The digest of the image the App is running.
+	Digest string `json:"digest,omitempty"`
+	// When the App was last deployed with a new Artifact.
+	DeployedAt *metav1.Time `json:"deployedAt,omitempty"`
+	// The name of the last Artifact all the App replicas were ready with, which the App is rolled
+	// back to when a new Artifact fails.
+	LastReadyArtifact string `json:"lastReadyArtifact,omitempty"`
+	// The most recent deployments of the App, the most recent first, starting with the Artifact
+	// the App is running.
+	History []AppDeployment `json:"history,omitempty"`
+	// The progress of the latest rollout of an Artifact with the BlueGreen or Canary strategy.
+	Rollout *AppRollout `json:"rollout,omitempty"`
+	// The number of replicas the App should run.
+	Replicas int32 `json:"replicas,omitempty"`
+	// The number of replicas of the App that are ready.
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// The URLs of the routes of the App.
+	URLs []string `json:"urls,omitempty"`
+}
+
+// AppRolloutPhase is the phase of the rollout of an Artifact to an App.
+type AppRolloutPhase string
+
+const (
+	// RolloutProgressing means the replicas of the current step of the rollout are being deployed.
+	RolloutProgressing AppRolloutPhase = "Progressing"
+	// RolloutPaused means the rollout waits until it's promoted or resumed, or for the duration of
+	// the pause of its current step.
+	RolloutPaused AppRolloutPhase = "Paused"
+	// RolloutPromoting means the Artifact is being rolled out to all the App replicas.
+	RolloutPromoting AppRolloutPhase = "Promoting"
+	// RolloutCompleted means the App runs the Artifact.
+	RolloutCompleted AppRolloutPhase = "Completed"
+	// RolloutAborted means the rollout was abandoned before it completed, as the App was set to run
+	// another Artifact.
+	RolloutAborted AppRolloutPhase = "Aborted"
+)
+
+// AppRollout is the progress of the rollout of an Artifact to the App with the BlueGreen or Canary
+// strategy.
+type AppRollout struct {
+	// The name of the Artifact rolled out.
+	Artifact string `json:"artifact"`
+	// The image of the Artifact rolled out.
+	Image string `json:"image"`
+	// The strategy of the rollout.
+	Strategy AppStrategyType `json:"strategy"`
+	// The phase of the rollout.
+	Phase AppRolloutPhase `json:"phase"`
+	// The index of the current Canary step. It's the number of steps once they were all passed.
+	Step int32 `json:"step,omitempty"`
+	// The percentage of the App replicas running the Artifact.
+	Weight int32 `json:"weight,omitempty"`
+	// When the rollout started.
+	StartedAt metav1.Time `json:"startedAt"`
+	// When the rollout reached the pause it waits at.
+	PausedAt *metav1.Time `json:"pausedAt,omitempty"`
+	// When the rollout completed.
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// The value of the promotion annotation of the App the rollout last handled.
+	PromotedAt string `json:"promotedAt,omitempty"`
+}
+
+// AppDeployment is a deployment of an App with an Artifact.
+type AppDeployment struct {
+	// The name of the Artifact the App was deployed with.
+	Artifact string `json:"artifact"`
+	// The digest of the image of the Artifact.
+	Digest string `json:"digest,omitempty"`
+	// When the App was deployed with the Artifact.
+	DeployedAt metav1.Time `json:"deployedAt"`
+}
+
+const (
+	// AppInitialized means that all replicas have been initialized but are not running yet.
+	AppInitialized = "Initialized"
+	// AppReady means the App is able to handle requests.
+	AppReady = "Ready"
+	// AppArtifactResolved means the Artifact referenced by the App was built successfully for the
+	// App, which can be deployed with it.
+	AppArtifactResolved = "ArtifactResolved"
+	// AppRolledBack means the App was rolled back to the last Artifact it was ready with, as the
+	// rollout of a new Artifact failed.
+	AppRolledBack = "RolledBack"
+	// AppImagesCleanedUp means the images of the deleted App were deleted from the image registry.
+	AppImagesCleanedUp = "ImagesCleanedUp"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.spec.state`
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+// +kubebuilder:printcolumn:name="Artifact",type=string,JSONPath=`.status.artifact`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// App is the Schema for the apps API.
+type App struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AppSpec   `json:"spec,omitempty"`
+	Status AppStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AppList contains a list of App.
+type AppList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []App `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&App{}, &AppList{})
+}
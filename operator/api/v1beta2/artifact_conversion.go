@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// ConvertTo converts the Artifact to the v1 hub version.
+func (src *Artifact) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*manorv1.Artifact)
+
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = manorv1.ArtifactSpec{
+		App:           src.Spec.App,
+		ImageRegistry: src.Spec.ImageRegistry,
+		Path:          src.Spec.Source.Path,
+		Include:       src.Spec.Source.Include,
+		Exclude:       src.Spec.Source.Exclude,
+		Builder:       src.Spec.Builder,
+	}
+
+	dst.Status = manorv1.ArtifactStatus{
+		LogRef:   src.Status.LogRef,
+		BuildJob: src.Status.BuildJob,
+		Image:    src.Status.Image,
+		Digest:   src.Status.Digest,
+	}
+	if src.Status.Conditions != nil {
+		dst.Status.Conditions = make([]manorv1.ArtifactCondition, len(src.Status.Conditions))
+		for i, c := range src.Status.Conditions {
+			dst.Status.Conditions[i] = manorv1.ArtifactCondition{
+				Type:   artifactConditionTypeToV1(c.Type),
+				Status: c.Status,
+			}
+		}
+	}
+
+	data := &conversionData{}
+	if hasConditionDetails(src.Status.Conditions) || hasConditionMessages(src.Status.Conditions) {
+		data.Conditions = src.Status.Conditions
+	}
+	return setConversionData(dst, data)
+}
+
+// ConvertFrom converts the Artifact from the v1 hub version.
+func (dst *Artifact) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*manorv1.Artifact)
+
+	dst.ObjectMeta = src.ObjectMeta
+	data, err := popConversionData(dst)
+	if err != nil {
+		return err
+	}
+
+	dst.Spec = ArtifactSpec{
+		App:           src.Spec.App,
+		ImageRegistry: src.Spec.ImageRegistry,
+		Source: ArtifactSource{
+			Path:    src.Spec.Path,
+			Include: src.Spec.Include,
+			Exclude: src.Spec.Exclude,
+		},
+		Builder: src.Spec.Builder,
+	}
+
+	dst.Status = ArtifactStatus{
+		LogRef:   src.Status.LogRef,
+		BuildJob: src.Status.BuildJob,
+		Image:    src.Status.Image,
+		Digest:   src.Status.Digest,
+	}
+	if src.Status.Conditions != nil {
+		dst.Status.Conditions = make([]Condition, len(src.Status.Conditions))
+		for i, c := range src.Status.Conditions {
+			dst.Status.Conditions[i] = data.restoreCondition(i, artifactConditionTypeFromV1(c.Type), c.Status)
+		}
+	}
+
+	return nil
+}
+
+// artifactConditionTypeToV1 returns the v1 type of an Artifact condition. The v1 InProgress type
+// isn't in CamelCase.
+func artifactConditionTypeToV1(conditionType string) manorv1.ArtifactConditionType {
+	if conditionType == ArtifactInProgress {
+		return manorv1.ArtifactInProgress
+	}
+	return manorv1.ArtifactConditionType(conditionType)
+}
+
+// artifactConditionTypeFromV1 returns the v1beta2 type of a v1 Artifact condition.
+func artifactConditionTypeFromV1(conditionType manorv1.ArtifactConditionType) string {
+	if conditionType == manorv1.ArtifactInProgress {
+		return ArtifactInProgress
+	}
+	return string(conditionType)
+}
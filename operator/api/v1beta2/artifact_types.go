@@ -0,0 +1,97 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ArtifactSpec defines the desired state of Artifact.
+type ArtifactSpec struct {
+	// The name of the App the artifact is tied to.
+	App string `json:"app,omitempty"`
+	// The image registry to override the default Image Registry.
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// The files of the uploaded source the Artifact is built from.
+	Source ArtifactSource `json:"source,omitempty"`
+	// The buildpacks builder image building the Artifact.
+	// Defaults to the app-builder default builder.
+	Builder string `json:"builder,omitempty"`
+}
+
+// ArtifactSource selects the files of the uploaded source the Artifact is built from.
+type ArtifactSource struct {
+	// The directory of the app root within the uploaded source, for sources holding many apps.
+	// Defaults to the source root.
+	Path string `json:"path,omitempty"`
+	// The globs of the files, relative to the app root, that end up in the build context. A "**"
+	// matches any number of directories. Defaults to all the files.
+	Include []string `json:"include,omitempty"`
+	// The globs of the files, relative to the app root, that are left out of the build context.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ArtifactStatus defines the observed state of Artifact.
+type ArtifactStatus struct {
+	// The latest observations of the state of the Artifact.
+	Conditions []Condition `json:"conditions,omitempty"`
+	// The reference to the stored build log, if build logs are persisted.
+	LogRef string `json:"logRef,omitempty"`
+	// The URL of the job on the app-builder service, when the build is dispatched to it instead of
+	// an app-builder Pod.
+	BuildJob string `json:"buildJob,omitempty"`
+	// The image built for the Artifact, tagged with the Artifact name.
+	Image string `json:"image,omitempty"`
+	// The digest of the image, set once the build succeeded.
+	Digest string `json:"digest,omitempty"`
+}
+
+const (
+	// ArtifactInitialized means that the Artifact was initialized but is not ready yet.
+	ArtifactInitialized = "Initialized"
+	// ArtifactInProgress means that the Artifact is in progress.
+	ArtifactInProgress = "InProgress"
+	// ArtifactCompleted means the Artifact is completed.
+	ArtifactCompleted = "Completed"
+	// ArtifactFailed means the Artifact build failed.
+	ArtifactFailed = "Failed"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Artifact is the Schema for the artifacts API.
+type Artifact struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ArtifactSpec   `json:"spec,omitempty"`
+	Status ArtifactStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ArtifactList contains a list of Artifact.
+type ArtifactList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Artifact `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Artifact{}, &ArtifactList{})
+}
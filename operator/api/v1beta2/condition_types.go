@@ -0,0 +1,39 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition is an observation of the state of a resource.
+type Condition struct {
+	// The type of the condition, in CamelCase.
+	Type string `json:"type"`
+	// The status of the condition.
+	// Can be True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// The generation of the resource the condition was set for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// When the condition last transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// The reason of the last transition, in CamelCase.
+	Reason string `json:"reason,omitempty"`
+	// A human readable message with the details of the last transition.
+	Message string `json:"message,omitempty"`
+}
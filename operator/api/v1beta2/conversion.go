@@ -0,0 +1,122 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conversionDataAnnotation holds, on the v1 objects converted from v1beta2, the v1beta2 fields v1
+// can't represent, so they are not lost when the objects are converted back to v1beta2.
+const conversionDataAnnotation = "manor.codelogia.com/v1beta2-conversion-data"
+
+// conversionData is the content of the conversion data annotation.
+type conversionData struct {
+	// The conditions of the v1beta2 object, when any of them holds details v1 can't represent.
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// hasConditionDetails returns whether any of the conditions has an observed generation or a
+// transition time, which the v1 conditions can't hold.
+func hasConditionDetails(conditions []Condition) bool {
+	for _, c := range conditions {
+		if c.ObservedGeneration != 0 || !c.LastTransitionTime.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasConditionMessages returns whether any of the conditions has a reason or a message, which the
+// v1 Artifact conditions can't hold.
+func hasConditionMessages(conditions []Condition) bool {
+	for _, c := range conditions {
+		if c.Reason != "" || c.Message != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreCondition returns the i-th condition, of the given type and status, of a v1 object with
+// the details stored by the conversion data.
+func (d *conversionData) restoreCondition(i int, conditionType string, status corev1.ConditionStatus) Condition {
+	if i < len(d.Conditions) && d.Conditions[i].Type == conditionType && d.Conditions[i].Status == status {
+		return d.Conditions[i]
+	}
+	for _, c := range d.Conditions {
+		if c.Type == conditionType && c.Status == status {
+			return c
+		}
+	}
+	return Condition{Type: conditionType, Status: status}
+}
+
+// setConversionData stores the conversion data in the annotations of the v1 object, unless there
+// is nothing to store.
+func setConversionData(obj metav1.Object, data *conversionData) error {
+	if len(data.Conditions) == 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the conversion data: %w", err)
+	}
+
+	// The annotations are shared with the source object, so they are copied rather than updated.
+	annotations := make(map[string]string, len(obj.GetAnnotations())+1)
+	for k, v := range obj.GetAnnotations() {
+		annotations[k] = v
+	}
+	annotations[conversionDataAnnotation] = string(value)
+	obj.SetAnnotations(annotations)
+
+	return nil
+}
+
+// popConversionData returns the conversion data stored in the annotations of the object converted
+// from v1, and removes them from its annotations.
+func popConversionData(obj metav1.Object) (*conversionData, error) {
+	data := &conversionData{}
+
+	value, ok := obj.GetAnnotations()[conversionDataAnnotation]
+	if !ok {
+		return data, nil
+	}
+
+	annotations := make(map[string]string, len(obj.GetAnnotations())-1)
+	for k, v := range obj.GetAnnotations() {
+		if k != conversionDataAnnotation {
+			annotations[k] = v
+		}
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+
+	if err := json.Unmarshal([]byte(value), data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the conversion data: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"math/rand"
+
+	fuzz "github.com/google/gofuzz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// fuzzIterations is the number of random objects round-tripped per kind and direction.
+const fuzzIterations = 1000
+
+var _ = Describe("Conversion", func() {
+	var f *fuzz.Fuzzer
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).To(Succeed())
+		Expect(manorv1.AddToScheme(scheme)).To(Succeed())
+		f = fuzzer.FuzzerFor(metafuzzer.Funcs, rand.NewSource(GinkgoRandomSeed()), serializer.NewCodecFactory(scheme))
+	})
+
+	DescribeTable("round-tripping random objects through the hub",
+		func(spoke conversion.Convertible, hub conversion.Hub) {
+			for i := 0; i < fuzzIterations; i++ {
+				By("converting the spoke to the hub and back")
+				f.Fuzz(spoke)
+				original := spoke.DeepCopyObject()
+				Expect(spoke.ConvertTo(hub)).To(Succeed())
+				converted := original.DeepCopyObject().(conversion.Convertible)
+				Expect(converted.ConvertFrom(hub)).To(Succeed())
+				Expect(apiequality.Semantic.DeepEqual(original, converted)).To(BeTrue(),
+					"fields lost: %s", diff.ObjectReflectDiff(original, converted))
+
+				By("converting the hub to the spoke and back")
+				f.Fuzz(hub)
+				originalHub := hub.DeepCopyObject()
+				Expect(spoke.ConvertFrom(hub)).To(Succeed())
+				convertedHub := originalHub.DeepCopyObject().(conversion.Hub)
+				Expect(spoke.ConvertTo(convertedHub)).To(Succeed())
+				Expect(apiequality.Semantic.DeepEqual(originalHub, convertedHub)).To(BeTrue(),
+					"fields lost: %s", diff.ObjectReflectDiff(originalHub, convertedHub))
+			}
+		},
+		Entry("App", &App{}, &manorv1.App{}),
+		Entry("Artifact", &Artifact{}, &manorv1.Artifact{}),
+	)
+
+	It("keeps the details of the conditions in an annotation of the hub", func() {
+		transitioned := metav1.Unix(1600000000, 0)
+		artifact := &Artifact{
+			ObjectMeta: metav1.ObjectMeta{Name: "artifact", Annotations: map[string]string{"example.com/note": "kept"}},
+			Status: ArtifactStatus{Conditions: []Condition{{
+				Type:               ArtifactInProgress,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: transitioned,
+				Reason:             "Building",
+				Message:            "building with the default builder",
+			}}},
+		}
+
+		hub := &manorv1.Artifact{}
+		Expect(artifact.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Status.Conditions).To(Equal([]manorv1.ArtifactCondition{
+			{Type: manorv1.ArtifactInProgress, Status: corev1.ConditionTrue},
+		}))
+		Expect(hub.Annotations).To(HaveKey(conversionDataAnnotation))
+		Expect(artifact.Annotations).NotTo(HaveKey(conversionDataAnnotation))
+
+		By("dropping the details of the conditions whose status changed on the hub")
+		hub.Status.Conditions = append(hub.Status.Conditions, manorv1.ArtifactCondition{
+			Type:   manorv1.ArtifactCompleted,
+			Status: corev1.ConditionTrue,
+		})
+		hub.Status.Conditions[0].Status = corev1.ConditionFalse
+		converted := &Artifact{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.Annotations).To(Equal(map[string]string{"example.com/note": "kept"}))
+		Expect(converted.Status.Conditions).To(Equal([]Condition{
+			{Type: ArtifactInProgress, Status: corev1.ConditionFalse},
+			{Type: ArtifactCompleted, Status: corev1.ConditionTrue},
+		}))
+	})
+})
@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
@@ -26,15 +27,25 @@ import (
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
 	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/stringutil"
 )
 
 // AppReconciler reconciles an App object.
@@ -42,24 +53,100 @@ type AppReconciler struct {
 	client.Client
 	Log                  logr.Logger
 	Scheme               *runtime.Scheme
+	Recorder             record.EventRecorder
 	DefaultImageRegistry string
+	// RouterNamespace is the namespace of the router the network ingress rules of the Apps can
+	// allow.
+	RouterNamespace string
+	// CleanupRegistry is whether the images of the deleted Apps are deleted from the image
+	// registry.
+	CleanupRegistry bool
+	// RegistryCABundle is the CA bundle verifying the certificates of the image registries the
+	// images are deleted from. The system roots are used when empty.
+	RegistryCABundle []byte
 }
 
 // SetupAppReconciler sets up the App reconciler.
-func SetupAppReconciler(mgr ctrl.Manager, defaultImageRegistry string) error {
+func SetupAppReconciler(
+	mgr ctrl.Manager,
+	defaultImageRegistry string,
+	routerNamespace string,
+	cleanupRegistry bool,
+	registryCABundle []byte,
+) error {
 	r := &AppReconciler{
 		Client:               mgr.GetClient(),
 		Log:                  ctrl.Log.WithName("controllers").WithName("App"),
 		Scheme:               mgr.GetScheme(),
+		Recorder:             mgr.GetEventRecorderFor("app-controller"),
 		DefaultImageRegistry: defaultImageRegistry,
+		RouterNamespace:      routerNamespace,
+		CleanupRegistry:      cleanupRegistry,
+		RegistryCABundle:     registryCABundle,
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&manorv1.App{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&networkingv1beta1.Ingress{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&corev1.Secret{}).
+		Watches(
+			&source.Kind{Type: &manorv1.Artifact{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(artifactToApp)},
+		).
+		Watches(
+			&source.Kind{Type: &manorv1.ServiceBinding{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(serviceBindingToApp)},
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(bindingSecretToApp)},
+		).
 		Complete(r)
 }
 
+// artifactToApp maps an Artifact to the App it's tied to.
+func artifactToApp(obj handler.MapObject) []reconcile.Request {
+	artifact, ok := obj.Object.(*manorv1.Artifact)
+	if !ok || artifact.Spec.App == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: artifact.Spec.App, Namespace: artifact.Namespace},
+	}}
+}
+
+// serviceBindingToApp maps a ServiceBinding to the App its credentials are bound to.
+func serviceBindingToApp(obj handler.MapObject) []reconcile.Request {
+	binding, ok := obj.Object.(*manorv1.ServiceBinding)
+	if !ok || binding.Spec.App == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: binding.Spec.App, Namespace: binding.Namespace},
+	}}
+}
+
+// bindingSecretToApp maps a Secret projected by a ServiceBinding to the App it's projected into.
+func bindingSecretToApp(obj handler.MapObject) []reconcile.Request {
+	labels := obj.Meta.GetLabels()
+	if _, ok := labels[manorv1.ServiceBindingLabel]; !ok || labels[manorv1.AppLabel] == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: labels[manorv1.AppLabel], Namespace: obj.Meta.GetNamespace()},
+	}}
+}
+
 // +kubebuilder:rbac:groups=manor.codelogia.com,resources=apps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=manor.codelogia.com,resources=apps/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=servicebindings,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile reconciles the App resources.
 func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -72,16 +159,76 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	if err := r.Get(ctx, req.NamespacedName, app); err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("App resource deleted")
+			forgetAppReplicas(req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	var imageRegistry string
-	if app.Spec.ImageRegistry != "" {
-		imageRegistry = app.Spec.ImageRegistry
+	if !app.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, log, app)
+	}
+
+	if r.CleanupRegistry && !stringutil.Contains(app.Finalizers, registryCleanupFinalizer) {
+		log.Info(
+			"Adding App finalizer",
+			"App.Namespace", app.Namespace,
+			"App.Name", app.Name,
+		)
+		app.Finalizers = append(app.Finalizers, registryCleanupFinalizer)
+		if err := r.Update(ctx, app); err != nil {
+			log.Error(
+				err, "Failed to add App finalizer",
+				"App.Namespace", app.Namespace,
+				"App.Name", app.Name,
+			)
+			return ctrl.Result{}, err
+		}
+		// The update triggers another reconcile.
+		return ctrl.Result{}, nil
+	}
+
+	var artifact *manorv1.Artifact
+	var err error
+	if app.Spec.ArtifactRef != nil {
+		var message string
+		artifact, message, err = r.referencedArtifact(ctx, app)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if artifact == nil {
+			log.Info(
+				"Refusing to deploy the App Artifact",
+				"message", message,
+				"App.Namespace", app.Namespace,
+				"App.Name", app.Name,
+			)
+			if setAppCondition(app, manorv1.AppArtifactResolved, corev1.ConditionFalse, "ArtifactNotDeployable", message) {
+				r.Recorder.Event(app, corev1.EventTypeWarning, EventArtifactNotDeployable, message)
+				if err := r.Status().Update(ctx, app); err != nil {
+					log.Error(
+						err, "Failed to update App status",
+						"App.Namespace", app.Namespace,
+						"App.Name", app.Name,
+					)
+					return ctrl.Result{}, err
+				}
+			}
+			// Do not requeue as a change to the App or the Artifact will trigger another event.
+			return ctrl.Result{}, nil
+		}
 	} else {
-		imageRegistry = r.DefaultImageRegistry
+		artifact, err = r.latestArtifact(ctx, app)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The image is pulled from the registry the Artifact was pushed to, which may differ from the
+	// current one of the App.
+	imageRegistry := r.imageRegistry(app)
+	if artifact != nil && artifact.Spec.ImageRegistry != "" {
+		imageRegistry = artifact.Spec.ImageRegistry
 	}
 
 	if strings.HasSuffix(imageRegistry, ".svc") {
@@ -98,6 +245,8 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		if err := r.Get(ctx, imageRegistryNamespacedName, imageRegistryService); err != nil {
 			if errors.IsNotFound(err) {
 				log.Info("Image registry not found, retrying...")
+				r.Recorder.Eventf(app, corev1.EventTypeWarning, EventRegistryUnavailable,
+					"Image registry Service %s not found", imageRegistryNamespacedName)
 				return ctrl.Result{RequeueAfter: time.Second * 3}, nil
 			}
 			return ctrl.Result{}, err
@@ -111,6 +260,18 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		imageRegistry = fmt.Sprintf("127.0.0.1:%d", nodePort)
 	}
 
+	// An App without any Artifact built yet, e.g. an App whose image is pushed to the registry by
+	// other means, runs the image tagged with its name.
+	image := build.Image(imageRegistry, app.Namespace, app.Name, "")
+	if artifact != nil {
+		image = fmt.Sprintf("%s@%s", image, artifact.Status.Digest)
+	}
+	// The reconciles of the App join the trace of the push only while the Artifact is rolled out;
+	// once the App is ready with it, they are no longer part of the push.
+	if artifact != nil && app.Status.LastReadyArtifact != artifact.Name {
+		ctx = tracing.ExtractAnnotations(ctx, artifact.Annotations)
+	}
+
 	imagePullPolicy := app.Spec.ImagePullPolicy
 	if imagePullPolicy == "" {
 		imagePullPolicy = corev1.PullIfNotPresent
@@ -121,6 +282,15 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		replicas = new(int32)
 		*replicas = 1
 	}
+	if app.Spec.State == manorv1.AppStopped {
+		replicas = new(int32)
+	}
+
+	// The replicas are restarted whenever the restart annotation of the App changes.
+	var podAnnotations map[string]string
+	if restartedAt := app.Annotations[manorv1.RestartedAtAnnotation]; restartedAt != "" {
+		podAnnotations = map[string]string{manorv1.RestartedAtAnnotation: restartedAt}
+	}
 
 	resources := app.Spec.Resources
 	if resources == nil {
@@ -137,97 +307,227 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		args = app.Spec.Args
 	}
 
-	labels := map[string]string{"manor.codelogia.com/app": app.Name}
+	labels := map[string]string{manorv1.AppLabel: app.Name}
 
-	desiredAppContainerPort := corev1.ContainerPort{
-		Name:          "http",
-		Protocol:      corev1.ProtocolTCP,
-		ContainerPort: 8080,
+	containerPorts := appContainerPorts(app)
+	probe, err := appProbe(app, containerPorts)
+	if err != nil {
+		err := fmt.Errorf("invalid spec: %w, not requeueing", err)
+		return ctrl.Result{Requeue: false}, err
+	}
+	networkPolicySpec, err := r.appNetworkPolicySpec(app, labels, containerPorts)
+	if err != nil {
+		err := fmt.Errorf("invalid spec: %w, not requeueing", err)
+		return ctrl.Result{Requeue: false}, err
 	}
 
-	desiredDeployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      app.Name,
-			Namespace: app.Namespace,
-			Labels:    labels,
+	env := []corev1.EnvVar{
+		{
+			Name:  "PORT",
+			Value: fmt.Sprintf("%d", containerPorts[0].ContainerPort),
 		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
+	}
+	for _, envVar := range app.Spec.Env {
+		// The API server defaults the version of field references, which must be matched for the
+		// Deployment not to be updated on every reconcile.
+		if envVar.ValueFrom != nil && envVar.ValueFrom.FieldRef != nil && envVar.ValueFrom.FieldRef.APIVersion == "" {
+			envVar = *envVar.DeepCopy()
+			envVar.ValueFrom.FieldRef.APIVersion = "v1"
+		}
+		env = append(env, envVar)
+	}
+
+	bindings, err := r.reconcileServiceBindings(ctx, log, app, labels)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	if volume := serviceBindingsVolume(bindings); volume != nil {
+		volumes = append(volumes, *volume)
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: bindingsRoot,
+			ReadOnly:  true,
+		})
+		env = append(env,
+			corev1.EnvVar{
+				Name:  "SERVICE_BINDING_ROOT",
+				Value: bindingsRoot,
 			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Image:           fmt.Sprintf("%s/%s/%s", imageRegistry, app.Namespace, app.Name),
-						ImagePullPolicy: imagePullPolicy,
-						Name:            app.Name,
-						Command:         command,
-						Args:            args,
-						// TODO(f0rmiga): remove this and add a sidecar for mTLS with the router.
-						Ports: []corev1.ContainerPort{desiredAppContainerPort},
-						Env: []corev1.EnvVar{
-							{
-								Name:  "PORT",
-								Value: fmt.Sprintf("%d", desiredAppContainerPort.ContainerPort),
-							},
-						},
-					}},
+			corev1.EnvVar{
+				Name: "VCAP_SERVICES",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: vcapServicesSecretName(app)},
+						Key:                  vcapServicesKey,
+					},
 				},
 			},
-		},
+		)
+		// The replicas are restarted whenever the credentials change, as the environment isn't
+		// updated in place.
+		if podAnnotations == nil {
+			podAnnotations = map[string]string{}
+		}
+		podAnnotations[bindingsHashAnnotation] = serviceBindingsHash(bindings)
 	}
 
-	if err := ctrl.SetControllerReference(app, desiredDeployment, r.Scheme); err != nil {
+	currentPrimary, err := r.getDeployment(ctx, app.Name, app.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	currentCanary, err := r.getDeployment(ctx, canaryDeploymentName(app), app.Namespace)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	currentDeployment := &appsv1.Deployment{}
-	if err := r.Get(ctx, types.NamespacedName{Name: desiredDeployment.Name, Namespace: desiredDeployment.Namespace}, currentDeployment); err != nil {
-		if !errors.IsNotFound(err) {
+	// A new Artifact failing to roll out is rolled back, unless the App was never ready with another
+	// Artifact. The Artifact the App is pinned to, e.g. by manor rollback --to, was chosen by the
+	// user, so it's not replaced.
+	if lastReady := app.Status.LastReadyArtifact; artifact != nil && app.Spec.ArtifactRef == nil &&
+		lastReady != "" && lastReady != artifact.Name {
+		reason, message, err := r.rolloutFailure(ctx, app, image, currentPrimary, currentCanary)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
+		if reason != "" {
+			return r.rollBack(ctx, log, app, artifact, reason, message)
+		}
+	}
 
-		log.Info(
-			"Creating Deployment",
-			"Deployment.Namespace", desiredDeployment.Namespace,
-			"Deployment.Name", desiredDeployment.Name,
-		)
-
-		if err := r.Create(ctx, desiredDeployment); err != nil {
+	// The progress of the rollout is recorded before the Deployments are changed, as the next step
+	// of the rollout is planned from it.
+	previousRollout := app.Status.Rollout.DeepCopy()
+	previousImage := app.Status.Image
+	plan, deployed, checkAfter := rolloutPlan{primaryImage: image, primaryReplicas: *replicas}, true, time.Duration(0)
+	if artifact != nil {
+		plan, deployed, checkAfter = advanceRollout(app, artifact, image, *replicas, currentPrimary, currentCanary, metav1.Now())
+	}
+	if !equality.Semantic.DeepEqual(previousRollout, app.Status.Rollout) || previousImage != app.Status.Image {
+		if err := r.Status().Update(ctx, app); err != nil {
 			log.Error(
-				err, "Failed to create Deployment",
-				"Deployment.Namespace", desiredDeployment.Namespace,
-				"Deployment.Name", desiredDeployment.Name,
+				err, "Failed to update App rollout status",
+				"App.Namespace", app.Namespace,
+				"App.Name", app.Name,
 			)
 			return ctrl.Result{}, err
 		}
+		if rollout := app.Status.Rollout; rollout != nil && rollout.Phase == manorv1.RolloutCompleted &&
+			(previousRollout == nil || previousRollout.Phase != manorv1.RolloutCompleted) {
+			observeRolloutCompleted(rollout)
+		}
+	}
 
-		return ctrl.Result{Requeue: true}, nil
+	progressDeadlineSeconds := manorv1.DefaultProgressDeadlineSeconds
+	if app.Spec.ProgressDeadlineSeconds != nil {
+		progressDeadlineSeconds = *app.Spec.ProgressDeadlineSeconds
+	}
+	// The replicas carry the track of their Deployment. Adding the track to the template of the
+	// primary Deployments created before the tracks existed rolls every App once, on the upgrade of
+	// the operator. The Service only selects the primary track once those replicas are replaced.
+	newDeployment := func(name, track, image string, replicas int32, selector map[string]string) *appsv1.Deployment {
+		podLabels := map[string]string{trackLabel: track}
+		for k, v := range labels {
+			podLabels[k] = v
+		}
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: app.Namespace,
+				Labels:    labels,
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{
+					MatchLabels: selector,
+				},
+				Strategy:                deploymentStrategy(app),
+				ProgressDeadlineSeconds: &progressDeadlineSeconds,
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels:      podLabels,
+						Annotations: podAnnotations,
+					},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Image:           image,
+							ImagePullPolicy: imagePullPolicy,
+							Name:            app.Name,
+							Command:         command,
+							Args:            args,
+							// TODO(f0rmiga): remove this and add a sidecar for mTLS with the router.
+							Ports:          containerPorts,
+							Env:            env,
+							Resources:      *resources,
+							LivenessProbe:  probe,
+							ReadinessProbe: probe,
+							VolumeMounts:   volumeMounts,
+						}},
+						Volumes: volumes,
+					},
+				},
+			},
+		}
+	}
+
+	// The selector of the primary Deployment predates the tracks, and is immutable.
+	desiredPrimary := newDeployment(app.Name, primaryTrack, plan.primaryImage, plan.primaryReplicas, labels)
+	if requeue, err := r.reconcileDeployment(ctx, log, app, desiredPrimary, currentPrimary); err != nil || requeue {
+		return ctrl.Result{Requeue: requeue}, err
 	}
 
-	if message, needsUpdate := r.deploymentNeedsUpdate(desiredDeployment, currentDeployment); needsUpdate {
+	if plan.canaryImage != "" {
+		canarySelector := map[string]string{trackLabel: canaryTrack}
+		for k, v := range labels {
+			canarySelector[k] = v
+		}
+		desiredCanary := newDeployment(canaryDeploymentName(app), canaryTrack, plan.canaryImage, plan.canaryReplicas, canarySelector)
+		if requeue, err := r.reconcileDeployment(ctx, log, app, desiredCanary, currentCanary); err != nil || requeue {
+			return ctrl.Result{Requeue: requeue}, err
+		}
+	} else if currentCanary != nil {
 		log.Info(
-			"Updating Deployment",
-			"message", message,
-			"Deployment.Namespace", desiredDeployment.Namespace,
-			"Deployment.Name", desiredDeployment.Name,
+			"Deleting canary Deployment",
+			"Deployment.Namespace", currentCanary.Namespace,
+			"Deployment.Name", currentCanary.Name,
 		)
-		if err := r.Update(ctx, desiredDeployment); err != nil {
+		if err := r.Delete(ctx, currentCanary); err != nil && !errors.IsNotFound(err) {
 			log.Error(
-				err, "Failed to update Deployment",
-				"Deployment.Namespace", desiredDeployment.Namespace,
-				"Deployment.Name", desiredDeployment.Name,
+				err, "Failed to delete canary Deployment",
+				"Deployment.Namespace", currentCanary.Namespace,
+				"Deployment.Name", currentCanary.Name,
 			)
 			return ctrl.Result{}, err
 		}
+		currentCanary = nil
+	}
 
-		return ctrl.Result{Requeue: true}, nil
+	servicePorts := make([]corev1.ServicePort, 0, len(containerPorts))
+	for _, port := range containerPorts {
+		servicePorts = append(servicePorts, corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       port.ContainerPort,
+			TargetPort: intstr.FromInt(int(port.ContainerPort)),
+		})
 	}
 
+	// The Service selects the replicas of the App by their track, so it leaves out the other Pods
+	// carrying the App label, e.g. its app-builder Pods. The primary replicas predating the tracks
+	// are selected by the App label alone until they are all replaced, so they keep serving.
+	serviceTrack := plan.serviceTrack
+	if serviceTrack == "" && currentPrimary != nil && currentPrimary.Spec.Template.Labels[trackLabel] == primaryTrack &&
+		deploymentReady(currentPrimary, plan.primaryImage, plan.primaryReplicas) {
+		serviceTrack = primaryTrack
+	}
+	serviceSelector := labels
+	if serviceTrack != "" {
+		serviceSelector = map[string]string{trackLabel: serviceTrack}
+		for k, v := range labels {
+			serviceSelector[k] = v
+		}
+	}
 	desiredService := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      app.Name,
@@ -235,14 +535,9 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
-			Type: corev1.ServiceTypeClusterIP,
-			Ports: []corev1.ServicePort{{
-				Name:       desiredAppContainerPort.Name,
-				Protocol:   desiredAppContainerPort.Protocol,
-				Port:       desiredAppContainerPort.ContainerPort,
-				TargetPort: intstr.FromInt(int(desiredAppContainerPort.ContainerPort)),
-			}},
-			Selector: labels,
+			Type:     corev1.ServiceTypeClusterIP,
+			Ports:    servicePorts,
+			Selector: serviceSelector,
 		},
 	}
 
@@ -270,62 +565,286 @@ func (r *AppReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, EventServiceCreated, "Created Service %s", desiredService.Name)
 
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	if message, needsRecreate := r.serviceNeedsRecreate(desiredService, currentService); needsRecreate {
+	if message, needsUpdate := r.serviceNeedsUpdate(desiredService, currentService); needsUpdate {
 		log.Info(
-			"Recreating Service",
+			"Updating Service",
 			"message", message,
 			"Service.Namespace", desiredService.Namespace,
 			"Service.Name", desiredService.Name,
 		)
-
-		if err := r.Delete(ctx, desiredService); err != nil {
+		// The cluster IP is allocated by the API server and immutable.
+		desiredService.ResourceVersion = currentService.ResourceVersion
+		desiredService.Spec.ClusterIP = currentService.Spec.ClusterIP
+		if err := r.Update(ctx, desiredService); err != nil {
 			log.Error(
-				err, "Failed to delete Service",
+				err, "Failed to update Service",
 				"Service.Namespace", desiredService.Namespace,
 				"Service.Name", desiredService.Name,
 			)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, EventServiceUpdated,
+			"Updated Service %s: %s", desiredService.Name, message)
 
-		// If the service fails to recreate with the same IP, the service will get a new ClusterIP
-		// once the reconciler notices a missing service in the next reconcile loop.
-		desiredService.Spec.ClusterIP = currentService.Spec.ClusterIP
-		if err := r.Create(ctx, desiredService); err != nil {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if requeue, err := r.reconcileIngress(ctx, log, app, labels, servicePorts[0]); err != nil || requeue {
+		return ctrl.Result{Requeue: requeue}, err
+	}
+
+	if requeue, err := r.reconcileNetworkPolicy(ctx, log, app, labels, networkPolicySpec); err != nil || requeue {
+		return ctrl.Result{Requeue: requeue}, err
+	}
+
+	// The App is ready once all its replicas run the Artifacts planned by its rollout.
+	ready := deploymentReady(currentPrimary, plan.primaryImage, plan.primaryReplicas) &&
+		deploymentReady(currentCanary, plan.canaryImage, plan.canaryReplicas)
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	// The ready replicas are the ones the Service routes the traffic to.
+	var readyReplicas int32
+	if plan.serviceTrack != canaryTrack {
+		readyReplicas += currentPrimary.Status.ReadyReplicas
+	}
+	if currentCanary != nil && plan.serviceTrack != primaryTrack {
+		readyReplicas += currentCanary.Status.ReadyReplicas
+	}
+	statusChanged := setAppCondition(app, manorv1.AppReady, readyStatus, "", "")
+	statusChanged = setAppCondition(app, manorv1.AppArtifactResolved, corev1.ConditionTrue, "", "") || statusChanged
+	switch {
+	case deployed && artifact != nil:
+		statusChanged = setDeployedArtifact(app, artifact, image, metav1.Now()) || statusChanged
+	case artifact == nil && app.Status.Image != image:
+		app.Status.Image = image
+		statusChanged = true
+	}
+	rolledOut := artifact != nil && ready && deployed && app.Status.LastReadyArtifact != artifact.Name
+	if rolledOut {
+		app.Status.LastReadyArtifact = artifact.Name
+		// The App is no longer rolled back once it's ready with another Artifact.
+		if appConditionTrue(app, manorv1.AppRolledBack) {
+			setAppCondition(app, manorv1.AppRolledBack, corev1.ConditionFalse, "", "")
+		}
+		statusChanged = true
+	}
+	if app.Status.Replicas != *replicas || app.Status.ReadyReplicas != readyReplicas {
+		app.Status.Replicas = *replicas
+		app.Status.ReadyReplicas = readyReplicas
+		statusChanged = true
+	}
+	if urls := appURLs(app); !equalStringSlice(urls, app.Status.URLs) {
+		app.Status.URLs = urls
+		statusChanged = true
+	}
+	observeAppReplicas(app)
+	if statusChanged {
+		if err := r.Status().Update(ctx, app); err != nil {
 			log.Error(
-				err, "Failed to recreate Service",
-				"Service.Namespace", desiredService.Namespace,
-				"Service.Name", desiredService.Name,
+				err, "Failed to update App status",
+				"App.Namespace", app.Namespace,
+				"App.Name", app.Name,
 			)
 			return ctrl.Result{}, err
 		}
+	}
+	if rolledOut {
+		traceRollout(ctx, app, artifact, time.Now())
+	}
 
-		return ctrl.Result{Requeue: true}, nil
+	requeueAfter := time.Second * 15
+	if checkAfter > 0 && checkAfter < requeueAfter {
+		requeueAfter = checkAfter
 	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
 
-	if message, needsUpdate := r.serviceNeedsUpdate(desiredService, currentService); needsUpdate {
+// getDeployment returns the named Deployment, or nil if it doesn't exist.
+func (r *AppReconciler) getDeployment(ctx context.Context, name, namespace string) (*appsv1.Deployment, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, deployment); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// reconcileDeployment creates the desired Deployment of the App when there is no current one, or
+// updates the current one when it doesn't match. It returns whether the App must be reconciled
+// again, as the Deployment changed.
+func (r *AppReconciler) reconcileDeployment(
+	ctx context.Context,
+	log logr.Logger,
+	app *manorv1.App,
+	desired, current *appsv1.Deployment,
+) (bool, error) {
+	if err := ctrl.SetControllerReference(app, desired, r.Scheme); err != nil {
+		return false, err
+	}
+
+	if current == nil {
 		log.Info(
-			"Updating Service",
+			"Creating Deployment",
+			"Deployment.Namespace", desired.Namespace,
+			"Deployment.Name", desired.Name,
+		)
+
+		if err := traced(ctx, "Deployment create", desired, func(ctx context.Context) error {
+			return r.Create(ctx, desired)
+		}); err != nil {
+			log.Error(
+				err, "Failed to create Deployment",
+				"Deployment.Namespace", desired.Namespace,
+				"Deployment.Name", desired.Name,
+			)
+			return false, err
+		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, EventDeploymentCreated, "Created Deployment %s", desired.Name)
+
+		return true, nil
+	}
+
+	if message, needsUpdate := r.deploymentNeedsUpdate(desired, current); needsUpdate {
+		log.Info(
+			"Updating Deployment",
 			"message", message,
-			"Service.Namespace", desiredService.Namespace,
-			"Service.Name", desiredService.Name,
+			"Deployment.Namespace", desired.Namespace,
+			"Deployment.Name", desired.Name,
 		)
-		if err := r.Update(ctx, desiredService); err != nil {
+		if err := traced(ctx, "Deployment update", desired, func(ctx context.Context) error {
+			return r.Update(ctx, desired)
+		}); err != nil {
 			log.Error(
-				err, "Failed to update Service",
-				"Service.Namespace", desiredService.Namespace,
-				"Service.Name", desiredService.Name,
+				err, "Failed to update Deployment",
+				"Deployment.Namespace", desired.Namespace,
+				"Deployment.Name", desired.Name,
 			)
-			return ctrl.Result{}, err
+			return false, err
 		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, EventDeploymentUpdated,
+			"Updated Deployment %s: %s", desired.Name, message)
 
-		return ctrl.Result{Requeue: true}, nil
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// imageRegistry returns the image registry of the App.
+func (r *AppReconciler) imageRegistry(app *manorv1.App) string {
+	if app.Spec.ImageRegistry != "" {
+		return app.Spec.ImageRegistry
+	}
+	return r.DefaultImageRegistry
+}
+
+// latestArtifact returns the most recent Artifact of the App that was built successfully, or nil if
+// there is none.
+func (r *AppReconciler) latestArtifact(ctx context.Context, app *manorv1.App) (*manorv1.Artifact, error) {
+	artifacts := &manorv1.ArtifactList{}
+	if err := r.List(ctx, artifacts, client.InNamespace(app.Namespace)); err != nil {
+		return nil, err
+	}
+	var latest *manorv1.Artifact
+	for i := range artifacts.Items {
+		artifact := &artifacts.Items[i]
+		if artifact.Spec.App != app.Name || artifact.Status.Digest == "" {
+			continue
+		}
+		if latest == nil || artifactNewer(artifact, latest) {
+			latest = artifact
+		}
+	}
+	return latest, nil
+}
+
+// referencedArtifact returns the Artifact referenced by the App. When the App can't be deployed
+// with it, as it doesn't exist, belongs to another App or wasn't built successfully, nil is returned
+// with a message explaining why.
+func (r *AppReconciler) referencedArtifact(ctx context.Context, app *manorv1.App) (*manorv1.Artifact, string, error) {
+	name := app.Spec.ArtifactRef.Name
+	artifact := &manorv1.Artifact{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, artifact); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Artifact %s not found", name), nil
+		}
+		return nil, "", err
 	}
+	if err := artifact.Deployable(app); err != nil {
+		return nil, err.Error(), nil
+	}
+	return artifact, "", nil
+}
+
+// recordAppDeployment records the deployment of the App with the Artifact at the top of its
+// history, dropping the oldest deployments beyond the history limit.
+func recordAppDeployment(app *manorv1.App, artifact *manorv1.Artifact, deployedAt metav1.Time) {
+	history := make([]manorv1.AppDeployment, 0, len(app.Status.History)+1)
+	history = append(history, manorv1.AppDeployment{
+		Artifact:   artifact.Name,
+		Digest:     artifact.Status.Digest,
+		DeployedAt: deployedAt,
+	})
+	history = append(history, app.Status.History...)
+	if len(history) > manorv1.AppHistoryLimit {
+		history = history[:manorv1.AppHistoryLimit]
+	}
+	app.Status.History = history
+}
 
-	return ctrl.Result{RequeueAfter: time.Second * 15}, nil
+// artifactNewer returns whether the Artifact a was created after b. The Artifacts created at the
+// same time are ordered by name.
+func artifactNewer(a, b *manorv1.Artifact) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return b.CreationTimestamp.Before(&a.CreationTimestamp)
+	}
+	return a.Name > b.Name
+}
+
+// appConditionTrue returns whether the App condition is true.
+func appConditionTrue(app *manorv1.App, conditionType manorv1.AppConditionType) bool {
+	for _, condition := range app.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// setAppCondition sets the status, reason and message of the App condition, returning whether it
+// changed.
+func setAppCondition(
+	app *manorv1.App,
+	conditionType manorv1.AppConditionType,
+	status corev1.ConditionStatus,
+	reason, message string,
+) bool {
+	desired := manorv1.AppCondition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+	for i, condition := range app.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition == desired {
+				return false
+			}
+			app.Status.Conditions[i] = desired
+			return true
+		}
+	}
+	app.Status.Conditions = append(app.Status.Conditions, desired)
+	return true
 }
 
 func (r *AppReconciler) deploymentNeedsUpdate(desired, current *appsv1.Deployment) (string, bool) {
@@ -343,6 +862,40 @@ func (r *AppReconciler) deploymentNeedsUpdate(desired, current *appsv1.Deploymen
 		), true
 	}
 
+	if !reflect.DeepEqual(desired.Spec.Template.Labels, current.Spec.Template.Labels) {
+		return fmt.Sprintf(
+			"current pod labels %v don't match desired %v",
+			current.Spec.Template.Labels, desired.Spec.Template.Labels,
+		), true
+	}
+
+	if !equality.Semantic.DeepEqual(desired.Spec.Strategy, current.Spec.Strategy) {
+		return "current strategy doesn't match desired", true
+	}
+
+	if !equality.Semantic.DeepEqual(desired.Spec.ProgressDeadlineSeconds, current.Spec.ProgressDeadlineSeconds) {
+		return "current progress deadline doesn't match desired", true
+	}
+
+	desiredRestartedAt := desired.Spec.Template.Annotations[manorv1.RestartedAtAnnotation]
+	currentRestartedAt := current.Spec.Template.Annotations[manorv1.RestartedAtAnnotation]
+	if desiredRestartedAt != currentRestartedAt {
+		return fmt.Sprintf(
+			"current restart time %q doesn't match desired %q",
+			currentRestartedAt, desiredRestartedAt,
+		), true
+	}
+
+	desiredBindingsHash := desired.Spec.Template.Annotations[bindingsHashAnnotation]
+	currentBindingsHash := current.Spec.Template.Annotations[bindingsHashAnnotation]
+	if desiredBindingsHash != currentBindingsHash {
+		return "current service bindings don't match desired", true
+	}
+
+	if !equality.Semantic.DeepEqual(desired.Spec.Template.Spec.Volumes, current.Spec.Template.Spec.Volumes) {
+		return "current volumes don't match desired", true
+	}
+
 	if len(desired.Spec.Template.Spec.Containers) != len(current.Spec.Template.Spec.Containers) {
 		return fmt.Sprintf(
 			"current containers size %d doesn't match desired %d",
@@ -380,10 +933,38 @@ func (r *AppReconciler) deploymentNeedsUpdate(desired, current *appsv1.Deploymen
 		), true
 	}
 
+	if !reflect.DeepEqual(desiredAppContainer.Env, currentAppContainer.Env) {
+		return "current container env doesn't match desired", true
+	}
+
+	if !equality.Semantic.DeepEqual(desiredAppContainer.VolumeMounts, currentAppContainer.VolumeMounts) {
+		return "current container volume mounts don't match desired", true
+	}
+
+	if !reflect.DeepEqual(desiredAppContainer.Ports, currentAppContainer.Ports) {
+		return fmt.Sprintf(
+			"current container ports %v doesn't match desired %v",
+			currentAppContainer.Ports, desiredAppContainer.Ports,
+		), true
+	}
+
+	if !equality.Semantic.DeepEqual(desiredAppContainer.Resources, currentAppContainer.Resources) {
+		return fmt.Sprintf(
+			"current container resources %v doesn't match desired %v",
+			currentAppContainer.Resources, desiredAppContainer.Resources,
+		), true
+	}
+
+	if !reflect.DeepEqual(desiredAppContainer.LivenessProbe, currentAppContainer.LivenessProbe) ||
+		!reflect.DeepEqual(desiredAppContainer.ReadinessProbe, currentAppContainer.ReadinessProbe) {
+		return "current container health check doesn't match desired", true
+	}
+
 	return "", false
 }
 
-func (r *AppReconciler) serviceNeedsRecreate(desired, current *corev1.Service) (string, bool) {
+func (r *AppReconciler) serviceNeedsUpdate(desired, current *corev1.Service) (string, bool) {
+	// The selector is updated in place, so the traffic is switched without dropping the Service.
 	if !reflect.DeepEqual(desired.Spec.Selector, current.Spec.Selector) {
 		return fmt.Sprintf(
 			"current selector %v doesn't match desired %v",
@@ -391,10 +972,6 @@ func (r *AppReconciler) serviceNeedsRecreate(desired, current *corev1.Service) (
 		), true
 	}
 
-	return "", false
-}
-
-func (r *AppReconciler) serviceNeedsUpdate(desired, current *corev1.Service) (string, bool) {
 	if len(desired.Spec.Ports) != len(current.Spec.Ports) {
 		return fmt.Sprintf(
 			"current number of ports %d doesn't match desired %d",
@@ -402,9 +979,16 @@ func (r *AppReconciler) serviceNeedsUpdate(desired, current *corev1.Service) (st
 		), true
 	}
 
-	desiredPort := desired.Spec.Ports[0]
-	currentPort := current.Spec.Ports[0]
+	for i := range desired.Spec.Ports {
+		if message, needsUpdate := servicePortNeedsUpdate(desired.Spec.Ports[i], current.Spec.Ports[i]); needsUpdate {
+			return message, true
+		}
+	}
+
+	return "", false
+}
 
+func servicePortNeedsUpdate(desiredPort, currentPort corev1.ServicePort) (string, bool) {
 	if desiredPort.Name != currentPort.Name {
 		return fmt.Sprintf(
 			"current port name %s doesn't match desired %s",
@@ -449,3 +1033,479 @@ func equalStringSlice(a, b []string) bool {
 
 	return true
 }
+
+// appURLs returns the URLs of the routes of the App.
+func appURLs(app *manorv1.App) []string {
+	var urls []string
+	for _, route := range app.Spec.Routes {
+		urls = append(urls, "http://"+route.Host+route.Path)
+	}
+	return urls
+}
+
+// appContainerPorts returns the container ports of the App, defaulting to a single http port 8080.
+func appContainerPorts(app *manorv1.App) []corev1.ContainerPort {
+	if len(app.Spec.Ports) == 0 {
+		return []corev1.ContainerPort{{
+			Name:          "http",
+			Protocol:      corev1.ProtocolTCP,
+			ContainerPort: 8080,
+		}}
+	}
+	ports := make([]corev1.ContainerPort, 0, len(app.Spec.Ports))
+	for _, port := range app.Spec.Ports {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		ports = append(ports, corev1.ContainerPort{
+			Name:          port.Name,
+			Protocol:      protocol,
+			ContainerPort: port.Port,
+		})
+	}
+	return ports
+}
+
+// appProbe returns the probe checking the health of the App replicas, or nil when only the process
+// is checked. All the fields defaulted by the API server are set, so the probe can be compared with
+// the current one.
+func appProbe(app *manorv1.App, ports []corev1.ContainerPort) (*corev1.Probe, error) {
+	healthCheck := app.Spec.HealthCheck
+	if healthCheck == nil || healthCheck.Type == manorv1.HealthCheckProcess {
+		return nil, nil
+	}
+
+	port := ports[0]
+	if healthCheck.Port != "" {
+		found := false
+		for _, p := range ports {
+			if p.Name == healthCheck.Port {
+				port = p
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("health check port %q is not a port of the App", healthCheck.Port)
+		}
+	}
+	if port.Protocol != corev1.ProtocolTCP {
+		return nil, fmt.Errorf("health check port %q is not a TCP port", port.Name)
+	}
+
+	probe := &corev1.Probe{
+		InitialDelaySeconds: healthCheck.InitialDelaySeconds,
+		TimeoutSeconds:      healthCheck.TimeoutSeconds,
+		PeriodSeconds:       healthCheck.PeriodSeconds,
+		SuccessThreshold:    1,
+		FailureThreshold:    healthCheck.FailureThreshold,
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = 1
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = 10
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = 3
+	}
+
+	switch healthCheck.Type {
+	case manorv1.HealthCheckHTTP:
+		path := healthCheck.Path
+		if path == "" {
+			path = "/"
+		}
+		probe.HTTPGet = &corev1.HTTPGetAction{
+			Path:   path,
+			Port:   intstr.FromString(port.Name),
+			Scheme: corev1.URISchemeHTTP,
+		}
+	case manorv1.HealthCheckPort:
+		probe.TCPSocket = &corev1.TCPSocketAction{
+			Port: intstr.FromString(port.Name),
+		}
+	default:
+		return nil, fmt.Errorf("unknown health check type %q", healthCheck.Type)
+	}
+	return probe, nil
+}
+
+// reconcileIngress exposes the App through its routes, using an Ingress to the given Service port.
+// The Ingress is deleted when the App has no routes. It returns whether the App must be requeued.
+func (r *AppReconciler) reconcileIngress(
+	ctx context.Context,
+	log logr.Logger,
+	app *manorv1.App,
+	labels map[string]string,
+	servicePort corev1.ServicePort,
+) (bool, error) {
+	currentIngress := &networkingv1beta1.Ingress{}
+	err := r.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, currentIngress)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	if len(app.Spec.Routes) == 0 {
+		if !exists || !metav1.IsControlledBy(currentIngress, app) {
+			return false, nil
+		}
+
+		log.Info(
+			"Deleting Ingress",
+			"Ingress.Namespace", currentIngress.Namespace,
+			"Ingress.Name", currentIngress.Name,
+		)
+
+		if err := r.Delete(ctx, currentIngress); err != nil && !errors.IsNotFound(err) {
+			log.Error(
+				err, "Failed to delete Ingress",
+				"Ingress.Namespace", currentIngress.Namespace,
+				"Ingress.Name", currentIngress.Name,
+			)
+			return false, err
+		}
+		return false, nil
+	}
+
+	// The paths of the routes sharing a host are grouped in the same rule.
+	pathType := networkingv1beta1.PathTypePrefix
+	var rules []networkingv1beta1.IngressRule
+	ruleIndex := make(map[string]int)
+	for _, route := range app.Spec.Routes {
+		path := route.Path
+		if path == "" {
+			path = "/"
+		}
+		i, ok := ruleIndex[route.Host]
+		if !ok {
+			i = len(rules)
+			ruleIndex[route.Host] = i
+			rules = append(rules, networkingv1beta1.IngressRule{
+				Host: route.Host,
+				IngressRuleValue: networkingv1beta1.IngressRuleValue{
+					HTTP: &networkingv1beta1.HTTPIngressRuleValue{},
+				},
+			})
+		}
+		rules[i].HTTP.Paths = append(rules[i].HTTP.Paths, networkingv1beta1.HTTPIngressPath{
+			Path:     path,
+			PathType: &pathType,
+			Backend: networkingv1beta1.IngressBackend{
+				ServiceName: app.Name,
+				ServicePort: intstr.FromString(servicePort.Name),
+			},
+		})
+	}
+
+	desiredIngress := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1beta1.IngressSpec{
+			Rules: rules,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(app, desiredIngress, r.Scheme); err != nil {
+		return false, err
+	}
+
+	if !exists {
+		log.Info(
+			"Creating Ingress",
+			"Ingress.Namespace", desiredIngress.Namespace,
+			"Ingress.Name", desiredIngress.Name,
+		)
+
+		if err := r.Create(ctx, desiredIngress); err != nil {
+			log.Error(
+				err, "Failed to create Ingress",
+				"Ingress.Namespace", desiredIngress.Namespace,
+				"Ingress.Name", desiredIngress.Name,
+			)
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	if !reflect.DeepEqual(desiredIngress.Spec.Rules, currentIngress.Spec.Rules) {
+		log.Info(
+			"Updating Ingress",
+			"Ingress.Namespace", desiredIngress.Namespace,
+			"Ingress.Name", desiredIngress.Name,
+		)
+
+		if err := r.Update(ctx, desiredIngress); err != nil {
+			log.Error(
+				err, "Failed to update Ingress",
+				"Ingress.Namespace", desiredIngress.Namespace,
+				"Ingress.Name", desiredIngress.Name,
+			)
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// namespaceNameLabel is the label Kubernetes sets on the namespaces with their name, from 1.21 on.
+// The operator sets it on the namespaces of the Spaces, while the other namespaces the NetworkPolicies
+// select must be labeled on older clusters.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// appNetworkPolicySpec returns the spec of the NetworkPolicy enforcing the network access to the
+// App, or nil when the App accepts traffic from anywhere.
+func (r *AppReconciler) appNetworkPolicySpec(
+	app *manorv1.App,
+	labels map[string]string,
+	ports []corev1.ContainerPort,
+) (*networkingv1.NetworkPolicySpec, error) {
+	network := app.Spec.Network
+	if network == nil || !network.DefaultDeny && len(network.Ingress) == 0 {
+		return nil, nil
+	}
+
+	var rules []networkingv1.NetworkPolicyIngressRule
+	for i, ingress := range network.Ingress {
+		var rule networkingv1.NetworkPolicyIngressRule
+		for _, name := range ingress.Ports {
+			var port *corev1.ContainerPort
+			for j := range ports {
+				if ports[j].Name == name {
+					port = &ports[j]
+				}
+			}
+			if port == nil {
+				return nil, fmt.Errorf("the network ingress rule %d allows the unknown port %q", i, name)
+			}
+			protocol := port.Protocol
+			portNumber := intstr.FromInt(int(port.ContainerPort))
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{
+				Protocol: &protocol,
+				Port:     &portNumber,
+			})
+		}
+		for _, appName := range ingress.Apps {
+			rule.From = append(rule.From, networkingv1.NetworkPolicyPeer{
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{manorv1.AppLabel: appName},
+				},
+			})
+		}
+		for _, namespace := range ingress.Namespaces {
+			rule.From = append(rule.From, networkingv1.NetworkPolicyPeer{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: namespace},
+				},
+			})
+		}
+		if ingress.Router {
+			if r.RouterNamespace == "" {
+				return nil, fmt.Errorf("the network ingress rule %d allows the router, whose namespace is not configured", i)
+			}
+			rule.From = append(rule.From, networkingv1.NetworkPolicyPeer{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: r.RouterNamespace},
+				},
+			})
+		}
+		rules = append(rules, rule)
+	}
+
+	return &networkingv1.NetworkPolicySpec{
+		// The app-builder Pods carrying the App label are not restricted with its replicas.
+		PodSelector: metav1.LabelSelector{
+			MatchLabels: labels,
+			MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      manorv1.ComponentLabel,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			}},
+		},
+		Ingress:     rules,
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+	}, nil
+}
+
+// reconcileNetworkPolicy creates, updates or deletes the NetworkPolicy of the App, so it matches
+// the spec, which is nil when the App has no network restrictions.
+func (r *AppReconciler) reconcileNetworkPolicy(
+	ctx context.Context,
+	log logr.Logger,
+	app *manorv1.App,
+	labels map[string]string,
+	spec *networkingv1.NetworkPolicySpec,
+) (bool, error) {
+	currentNetworkPolicy := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, currentNetworkPolicy)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	if spec == nil {
+		if !exists || !metav1.IsControlledBy(currentNetworkPolicy, app) {
+			return false, nil
+		}
+
+		log.Info(
+			"Deleting NetworkPolicy",
+			"NetworkPolicy.Namespace", currentNetworkPolicy.Namespace,
+			"NetworkPolicy.Name", currentNetworkPolicy.Name,
+		)
+
+		if err := r.Delete(ctx, currentNetworkPolicy); err != nil && !errors.IsNotFound(err) {
+			log.Error(
+				err, "Failed to delete NetworkPolicy",
+				"NetworkPolicy.Namespace", currentNetworkPolicy.Namespace,
+				"NetworkPolicy.Name", currentNetworkPolicy.Name,
+			)
+			return false, err
+		}
+		return false, nil
+	}
+
+	desiredNetworkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Labels:    labels,
+		},
+		Spec: *spec,
+	}
+
+	if err := ctrl.SetControllerReference(app, desiredNetworkPolicy, r.Scheme); err != nil {
+		return false, err
+	}
+
+	if !exists {
+		log.Info(
+			"Creating NetworkPolicy",
+			"NetworkPolicy.Namespace", desiredNetworkPolicy.Namespace,
+			"NetworkPolicy.Name", desiredNetworkPolicy.Name,
+		)
+
+		if err := r.Create(ctx, desiredNetworkPolicy); err != nil {
+			log.Error(
+				err, "Failed to create NetworkPolicy",
+				"NetworkPolicy.Namespace", desiredNetworkPolicy.Namespace,
+				"NetworkPolicy.Name", desiredNetworkPolicy.Name,
+			)
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	if !equality.Semantic.DeepEqual(desiredNetworkPolicy.Spec, currentNetworkPolicy.Spec) {
+		log.Info(
+			"Updating NetworkPolicy",
+			"NetworkPolicy.Namespace", desiredNetworkPolicy.Namespace,
+			"NetworkPolicy.Name", desiredNetworkPolicy.Name,
+		)
+
+		if err := r.Update(ctx, desiredNetworkPolicy); err != nil {
+			log.Error(
+				err, "Failed to update NetworkPolicy",
+				"NetworkPolicy.Namespace", desiredNetworkPolicy.Namespace,
+				"NetworkPolicy.Name", desiredNetworkPolicy.Name,
+			)
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+const (
+	// vcapServicesKey is the key of the VCAP_SERVICES Secret of an App.
+	vcapServicesKey = "VCAP_SERVICES"
+
+	// bindingsHashAnnotation is the annotation of the App replicas with the hash of the credentials
+	// of the service bindings.
+	bindingsHashAnnotation = "manor.codelogia.com/bindings-hash"
+)
+
+// vcapServicesSecretName returns the name of the Secret holding the VCAP_SERVICES of the App.
+func vcapServicesSecretName(app *manorv1.App) string {
+	return app.Name + "-vcap-services"
+}
+
+// vcapService is an entry of VCAP_SERVICES.
+type vcapService struct {
+	Name        string            `json:"name"`
+	Label       string            `json:"label"`
+	Provider    string            `json:"provider,omitempty"`
+	Tags        []string          `json:"tags"`
+	Credentials map[string]string `json:"credentials"`
+}
+
+// reconcileServiceBindings returns the ready ServiceBindings of the App, and creates, updates or
+// deletes the Secret holding their credentials in the VCAP_SERVICES format.
+func (r *AppReconciler) reconcileServiceBindings(
+	ctx context.Context,
+	log logr.Logger,
+	app *manorv1.App,
+	labels map[string]string,
+) ([]appServiceBinding, error) {
+	bindings, err := appServiceBindings(ctx, r.Client, app.Namespace, app.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vcapServicesSecretName(app),
+			Namespace: app.Namespace,
+		},
+	}
+	if len(bindings) == 0 {
+		return nil, deleteOwned(ctx, r.Client, log, app, secret)
+	}
+
+	vcapServices, err := renderVCAPServices(bindings)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyOwned(ctx, r.Client, r.Scheme, log, app, secret, func() {
+		secret.Labels = labels
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{vcapServicesKey: vcapServices}
+	}); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// renderVCAPServices renders the credentials of the bindings in the VCAP_SERVICES format, grouped
+// by the type of their service. The type and provider entries aren't part of the credentials.
+func renderVCAPServices(bindings []appServiceBinding) ([]byte, error) {
+	services := map[string][]vcapService{}
+	for _, b := range bindings {
+		label := string(b.secret.Data[bindingTypeKey])
+		service := vcapService{
+			Name:        b.binding.BindingName(),
+			Label:       label,
+			Provider:    string(b.secret.Data[bindingProviderKey]),
+			Tags:        []string{label},
+			Credentials: map[string]string{},
+		}
+		for key, value := range b.secret.Data {
+			if key != bindingTypeKey && key != bindingProviderKey {
+				service.Credentials[key] = string(value)
+			}
+		}
+		services[label] = append(services[label], service)
+	}
+	return json.Marshal(services)
+}
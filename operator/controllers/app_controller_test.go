@@ -0,0 +1,340 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// createBuiltArtifact creates an Artifact of the App whose image was pushed with the digest.
+func createBuiltArtifact(ctx context.Context, name, app, digest string, spec manorv1.ArtifactSpec) *manorv1.Artifact {
+	spec.App = app
+	artifact := &manorv1.Artifact{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       spec,
+	}
+	Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+	artifact.Status.Conditions = []manorv1.ArtifactCondition{
+		{Type: manorv1.ArtifactInitialized, Status: corev1.ConditionTrue},
+		{Type: manorv1.ArtifactCompleted, Status: corev1.ConditionTrue},
+	}
+	artifact.Status.Digest = digest
+	Expect(k8sClient.Status().Update(ctx, artifact)).To(Succeed())
+	return artifact
+}
+
+// markDeploymentReady marks all the replicas of the Deployment updated and available.
+func markDeploymentReady(ctx context.Context, key types.NamespacedName) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{}
+	Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+	replicas := *deployment.Spec.Replicas
+	deployment.Status.ObservedGeneration = deployment.Generation
+	deployment.Status.Replicas = replicas
+	deployment.Status.UpdatedReplicas = replicas
+	deployment.Status.AvailableReplicas = replicas
+	deployment.Status.ReadyReplicas = replicas
+	Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+	return deployment
+}
+
+var _ = Describe("AppReconciler", func() {
+	ctx := context.Background()
+
+	var reconciler *AppReconciler
+
+	BeforeEach(func() {
+		reconciler = &AppReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("App"),
+			Scheme:               scheme.Scheme,
+			Recorder:             record.NewFakeRecorder(100),
+			DefaultImageRegistry: "registry.example.com",
+		}
+	})
+
+	// deployedImage returns the image of the Deployment of the App.
+	deployedImage := func(key types.NamespacedName) string {
+		deployment := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+		return deployment.Spec.Template.Spec.Containers[0].Image
+	}
+
+	It("deploys the image tagged with the App name until an Artifact is built", func() {
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "image-tag", Namespace: "default"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(deployedImage(key)).To(Equal("registry.example.com/default/image-tag"))
+		Expect(k8sClient.Get(ctx, key, app)).To(Succeed())
+		Expect(app.Status.Image).To(Equal("registry.example.com/default/image-tag"))
+		Expect(app.Status.Artifact).To(BeEmpty())
+
+		createBuiltArtifact(ctx, "image-tag-1", app.Name, "sha256:abc", manorv1.ArtifactSpec{})
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(deployedImage(key)).To(Equal("registry.example.com/default/image-tag@sha256:abc"))
+		Expect(k8sClient.Get(ctx, key, app)).To(Succeed())
+		Expect(app.Status.Artifact).To(Equal("image-tag-1"))
+	})
+
+	It("pulls the image from the registry the Artifact was pushed to", func() {
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "image-registry", Namespace: "default"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+		createBuiltArtifact(ctx, "image-registry-1", app.Name, "sha256:abc",
+			manorv1.ArtifactSpec{ImageRegistry: "other.example.com"})
+
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(deployedImage(key)).To(Equal("other.example.com/default/image-registry@sha256:abc"))
+	})
+
+	It("leaves the app-builder Pods out of the Service and the NetworkPolicy", func() {
+		app := &manorv1.App{
+			ObjectMeta: metav1.ObjectMeta{Name: "replicas", Namespace: "default"},
+			Spec: manorv1.AppSpec{
+				Network: &manorv1.AppNetwork{Ingress: []manorv1.NetworkIngressRule{{Apps: []string{"other"}}}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+		createBuiltArtifact(ctx, "replicas-1", app.Name, "sha256:abc", manorv1.ArtifactSpec{})
+
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		deployment := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+		Expect(deployment.Spec.Template.Labels).To(HaveKeyWithValue(trackLabel, primaryTrack))
+		policy := &networkingv1.NetworkPolicy{}
+		Expect(k8sClient.Get(ctx, key, policy)).To(Succeed())
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selector.Matches(labels.Set(deployment.Spec.Template.Labels))).To(BeTrue())
+		Expect(selector.Matches(labels.Set{manorv1.AppLabel: app.Name, manorv1.ComponentLabel: "app-builder"})).To(BeFalse())
+
+		// The replicas are selected by the App label alone until they all run with their track.
+		service := &corev1.Service{}
+		Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+		Expect(service.Spec.Selector).To(Equal(map[string]string{manorv1.AppLabel: app.Name}))
+
+		markDeploymentReady(ctx, key)
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(k8sClient.Get(ctx, key, service)).To(Succeed())
+		Expect(service.Spec.Selector).To(Equal(map[string]string{manorv1.AppLabel: app.Name, trackLabel: primaryTrack}))
+	})
+
+	Describe("referencedArtifact", func() {
+		pinnedApp := func(name, artifact string) *manorv1.App {
+			return &manorv1.App{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       manorv1.AppSpec{ArtifactRef: &corev1.LocalObjectReference{Name: artifact}},
+			}
+		}
+
+		It("returns the Artifact the App is pinned to", func() {
+			createBuiltArtifact(ctx, "referenced-1", "referenced", "sha256:abc", manorv1.ArtifactSpec{})
+			artifact, message, err := reconciler.referencedArtifact(ctx, pinnedApp("referenced", "referenced-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message).To(BeEmpty())
+			Expect(artifact.Name).To(Equal("referenced-1"))
+			Expect(artifact.Status.Digest).To(Equal("sha256:abc"))
+		})
+
+		It("explains why the App can't be deployed with a missing Artifact", func() {
+			artifact, message, err := reconciler.referencedArtifact(ctx, pinnedApp("referenced", "missing"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(artifact).To(BeNil())
+			Expect(message).To(Equal("Artifact missing not found"))
+		})
+
+		It("explains why the App can't be deployed with the Artifact of another App", func() {
+			createBuiltArtifact(ctx, "other-referenced-1", "other-referenced", "sha256:abc", manorv1.ArtifactSpec{})
+			artifact, message, err := reconciler.referencedArtifact(ctx, pinnedApp("referenced", "other-referenced-1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(artifact).To(BeNil())
+			Expect(message).To(Equal("Artifact other-referenced-1 belongs to App other-referenced"))
+		})
+	})
+})
+
+var _ = Describe("recordAppDeployment", func() {
+	deployedAt := metav1.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	artifact := func(name string) *manorv1.Artifact {
+		return &manorv1.Artifact{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     manorv1.ArtifactStatus{Digest: "sha256:" + name},
+		}
+	}
+
+	It("records the deployment at the top of the history", func() {
+		app := &manorv1.App{}
+		recordAppDeployment(app, artifact("app-1"), deployedAt)
+		recordAppDeployment(app, artifact("app-2"), deployedAt)
+		Expect(app.Status.History).To(Equal([]manorv1.AppDeployment{
+			{Artifact: "app-2", Digest: "sha256:app-2", DeployedAt: deployedAt},
+			{Artifact: "app-1", Digest: "sha256:app-1", DeployedAt: deployedAt},
+		}))
+	})
+
+	It("drops the oldest deployments beyond the history limit", func() {
+		app := &manorv1.App{}
+		for i := 0; i <= manorv1.AppHistoryLimit; i++ {
+			recordAppDeployment(app, artifact(fmt.Sprintf("app-%d", i)), deployedAt)
+		}
+		Expect(app.Status.History).To(HaveLen(manorv1.AppHistoryLimit))
+		Expect(app.Status.History[0].Artifact).To(Equal(fmt.Sprintf("app-%d", manorv1.AppHistoryLimit)))
+		Expect(app.Status.History[manorv1.AppHistoryLimit-1].Artifact).To(Equal("app-1"))
+	})
+})
+
+var _ = Describe("appNetworkPolicySpec", func() {
+	reconciler := &AppReconciler{RouterNamespace: "ingress-nginx"}
+	podLabels := map[string]string{manorv1.AppLabel: "web"}
+	ports := []corev1.ContainerPort{
+		{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP},
+		{Name: "metrics", ContainerPort: 9090, Protocol: corev1.ProtocolTCP},
+	}
+
+	// policySpec returns the spec of the NetworkPolicy of an App with the network.
+	policySpec := func(r *AppReconciler, network *manorv1.AppNetwork) (*networkingv1.NetworkPolicySpec, error) {
+		app := &manorv1.App{Spec: manorv1.AppSpec{Network: network}}
+		return r.appNetworkPolicySpec(app, podLabels, ports)
+	}
+
+	It("doesn't restrict the Apps accepting traffic from anywhere", func() {
+		Expect(policySpec(reconciler, nil)).To(BeNil())
+		Expect(policySpec(reconciler, &manorv1.AppNetwork{})).To(BeNil())
+	})
+
+	It("denies all the traffic by default", func() {
+		spec, err := policySpec(reconciler, &manorv1.AppNetwork{DefaultDeny: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Ingress).To(BeEmpty())
+		Expect(spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeIngress}))
+	})
+
+	It("allows the Apps, namespaces and router of the rules on their ports", func() {
+		spec, err := policySpec(reconciler, &manorv1.AppNetwork{Ingress: []manorv1.NetworkIngressRule{
+			{Apps: []string{"api"}, Namespaces: []string{"monitoring"}, Ports: []string{"metrics"}},
+			{Router: true},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		protocol := corev1.ProtocolTCP
+		port := intstr.FromInt(9090)
+		Expect(spec.Ingress).To(Equal([]networkingv1.NetworkPolicyIngressRule{
+			{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{manorv1.AppLabel: "api"}}},
+					{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "monitoring"}}},
+				},
+			},
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "ingress-nginx"}}},
+				},
+			},
+		}))
+	})
+
+	It("rejects the rules allowing an unknown port", func() {
+		_, err := policySpec(reconciler, &manorv1.AppNetwork{Ingress: []manorv1.NetworkIngressRule{{Ports: []string{"admin"}}}})
+		Expect(err).To(MatchError(ContainSubstring(`unknown port "admin"`)))
+	})
+
+	It("rejects the rules allowing the router when its namespace is not configured", func() {
+		_, err := policySpec(&AppReconciler{}, &manorv1.AppNetwork{Ingress: []manorv1.NetworkIngressRule{{Router: true}}})
+		Expect(err).To(MatchError(ContainSubstring("whose namespace is not configured")))
+	})
+})
+
+var _ = Describe("renderVCAPServices", func() {
+	binding := func(name, bindingName string, data map[string]string) appServiceBinding {
+		secret := &corev1.Secret{Data: map[string][]byte{}}
+		for key, value := range data {
+			secret.Data[key] = []byte(value)
+		}
+		return appServiceBinding{
+			binding: &manorv1.ServiceBinding{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       manorv1.ServiceBindingSpec{Name: bindingName},
+			},
+			secret: secret,
+		}
+	}
+
+	It("groups the bindings by type, without the type and provider in the credentials", func() {
+		vcapServices, err := renderVCAPServices([]appServiceBinding{
+			binding("db-binding", "db", map[string]string{
+				"type":     "postgresql",
+				"provider": "bitnami",
+				"host":     "db.example.com",
+				"password": "secret",
+			}),
+			binding("reports", "", map[string]string{"type": "postgresql", "host": "reports.example.com"}),
+			binding("cache", "", map[string]string{"type": "redis", "uri": "redis://cache:6379"}),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vcapServices).To(MatchJSON(`{
+			"postgresql": [
+				{
+					"name": "db",
+					"label": "postgresql",
+					"provider": "bitnami",
+					"tags": ["postgresql"],
+					"credentials": {"host": "db.example.com", "password": "secret"}
+				},
+				{
+					"name": "reports",
+					"label": "postgresql",
+					"tags": ["postgresql"],
+					"credentials": {"host": "reports.example.com"}
+				}
+			],
+			"redis": [
+				{
+					"name": "cache",
+					"label": "redis",
+					"tags": ["redis"],
+					"credentials": {"uri": "redis://cache:6379"}
+				}
+			]
+		}`))
+	})
+
+	It("renders an empty object without bindings", func() {
+		vcapServices, err := renderVCAPServices(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vcapServices).To(MatchJSON(`{}`))
+	})
+})
@@ -19,31 +19,45 @@ package controllers
 import (
 	"context"
 	"crypto/rand"
+	goerrors "errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	"github.com/codelogia/manor/app-builder/pkg/server"
+	"github.com/codelogia/manor/app-builder/pkg/service"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
 	manorv1 "github.com/codelogia/manor/operator/api/v1"
 )
 
 // ArtifactReconciler reconciles a Artifact object.
 type ArtifactReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 
 	DockerHost           string
 	DefaultImageRegistry string
 	AppBuilderImage      string
+	BuildLogsURL         string
+	BuildService         *service.Client
+	SourceCacheSize      *resource.Quantity
+	TracingEndpoint      string
 }
 
 // SetupArtifactReconciler sets up the Artifact reconciler.
@@ -52,14 +66,32 @@ func SetupArtifactReconciler(
 	dockerHost string,
 	defaultImageRegistry string,
 	appBuilderImage string,
+	buildLogsURL string,
+	buildServiceURL string,
+	buildServiceToken string,
+	sourceCacheSize string,
+	tracingEndpoint string,
 ) error {
 	r := &ArtifactReconciler{
 		Client:               mgr.GetClient(),
 		Log:                  ctrl.Log.WithName("controllers").WithName("Artifact"),
 		Scheme:               mgr.GetScheme(),
+		Recorder:             mgr.GetEventRecorderFor("artifact-controller"),
 		DockerHost:           dockerHost,
 		DefaultImageRegistry: defaultImageRegistry,
 		AppBuilderImage:      appBuilderImage,
+		BuildLogsURL:         buildLogsURL,
+		TracingEndpoint:      tracingEndpoint,
+	}
+	if buildServiceURL != "" {
+		r.BuildService = service.NewClient(buildServiceURL, buildServiceToken)
+	}
+	if sourceCacheSize != "" {
+		size, err := resource.ParseQuantity(sourceCacheSize)
+		if err != nil {
+			return fmt.Errorf("invalid source cache size: %w", err)
+		}
+		r.SourceCacheSize = &size
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&manorv1.Artifact{}).
@@ -68,6 +100,10 @@ func SetupArtifactReconciler(
 
 // +kubebuilder:rbac:groups=manor.codelogia.com,resources=artifacts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=manor.codelogia.com,resources=artifacts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile reconciles the Artifact resources.
 func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -84,19 +120,29 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 		return ctrl.Result{}, err
 	}
+	ctx = tracing.ExtractAnnotations(ctx, artifact.Annotations)
 
 	if artifact.Spec.App == "" {
 		err := fmt.Errorf("spec.App cannot be empty, not requeueing")
 		return ctrl.Result{Requeue: false}, err
 	}
 
+	filter := build.Filter{Path: artifact.Spec.Path, Include: artifact.Spec.Include, Exclude: artifact.Spec.Exclude}
+	if err := filter.Validate(); err != nil {
+		err := fmt.Errorf("invalid spec: %w, not requeueing", err)
+		return ctrl.Result{Requeue: false}, err
+	}
+
 	if len(artifact.Status.Conditions) == 0 {
 		condition := manorv1.ArtifactCondition{
 			Type:   manorv1.ArtifactInitialized,
 			Status: corev1.ConditionTrue,
 		}
 		artifact.Status.Conditions = []manorv1.ArtifactCondition{condition}
-		if err := r.Status().Update(ctx, artifact); err != nil {
+		if r.BuildLogsURL != "" {
+			artifact.Status.LogRef = logstore.URL(r.BuildLogsURL, logstore.Key(artifact.Namespace, artifact.Name))
+		}
+		if err := r.updateStatus(ctx, artifact); err != nil {
 			log.Error(
 				err, "Failed to update Artifact status",
 				"Artifact.Namespace", artifact.Namespace,
@@ -108,9 +154,12 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, nil
 	}
 
-	labels := map[string]string{"manor.codelogia.com/app": artifact.Spec.App}
+	labels := map[string]string{
+		manorv1.AppLabel:      artifact.Spec.App,
+		manorv1.ArtifactLabel: artifact.Name,
+	}
 
-	secretName := fmt.Sprintf("%s-app-builder-creds", artifact.Spec.App)
+	secretName := appBuilderSecretName(artifact)
 
 	currentSecret := &corev1.Secret{}
 	if err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: artifact.Namespace}, currentSecret); err != nil {
@@ -163,21 +212,26 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	podName := fmt.Sprintf("%s-app-builder", artifact.Spec.App)
+	if r.BuildService != nil {
+		return r.reconcileBuildJob(ctx, log, artifact, string(currentSecret.Data["token"]))
+	}
+
+	podName := appBuilderPodName(artifact)
 	podAddrPort := 8081
 
-	var imageRegistry string
-	if artifact.Spec.ImageRegistry != "" {
-		imageRegistry = artifact.Spec.ImageRegistry
-	} else {
-		imageRegistry = r.DefaultImageRegistry
-	}
+	imageRegistry := r.imageRegistry(artifact)
 
 	desiredPod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podName,
 			Namespace: artifact.Namespace,
-			Labels:    labels,
+			// The component label keeps the Pod from being taken for a replica of the App, e.g. by
+			// the App Service.
+			Labels: map[string]string{
+				manorv1.AppLabel:       artifact.Spec.App,
+				manorv1.ArtifactLabel:  artifact.Name,
+				manorv1.ComponentLabel: "app-builder",
+			},
 		},
 		Spec: corev1.PodSpec{
 			RestartPolicy: corev1.RestartPolicyNever,
@@ -232,10 +286,19 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 						Name:  "IMAGE_REGISTRY",
 						Value: imageRegistry,
 					},
+					{
+						Name:  "IMAGE_TAG",
+						Value: artifact.Name,
+					},
+					{
+						Name:  "TERMINATION_MESSAGE_PATH",
+						Value: corev1.TerminationMessagePathDefault,
+					},
 				},
 				ReadinessProbe: &corev1.Probe{
 					Handler: corev1.Handler{
-						TCPSocket: &corev1.TCPSocketAction{
+						HTTPGet: &corev1.HTTPGetAction{
+							Path: "/readyz",
 							Port: intstr.FromInt(podAddrPort),
 						},
 					},
@@ -244,7 +307,8 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				},
 				LivenessProbe: &corev1.Probe{
 					Handler: corev1.Handler{
-						TCPSocket: &corev1.TCPSocketAction{
+						HTTPGet: &corev1.HTTPGetAction{
+							Path: "/healthz",
 							Port: intstr.FromInt(podAddrPort),
 						},
 					},
@@ -264,6 +328,95 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		},
 	}
 
+	if artifact.Spec.Path != "" {
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "SOURCE_PATH",
+			Value: artifact.Spec.Path,
+		})
+	}
+	if len(artifact.Spec.Include) > 0 {
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "SOURCE_INCLUDE",
+			Value: build.EncodeGlobs(artifact.Spec.Include),
+		})
+	}
+	if len(artifact.Spec.Exclude) > 0 {
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "SOURCE_EXCLUDE",
+			Value: build.EncodeGlobs(artifact.Spec.Exclude),
+		})
+	}
+	if artifact.Spec.Builder != "" {
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "BUILDER",
+			Value: artifact.Spec.Builder,
+		})
+	}
+
+	if r.BuildLogsURL != "" {
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env,
+			corev1.EnvVar{
+				Name:  "LOG_STORE",
+				Value: r.BuildLogsURL,
+			},
+			corev1.EnvVar{
+				Name:  "LOG_KEY",
+				Value: logstore.Key(artifact.Namespace, artifact.Name),
+			},
+		)
+	}
+
+	if r.TracingEndpoint != "" {
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  tracing.EndpointEnv,
+			Value: r.TracingEndpoint,
+		})
+	}
+
+	bindings, err := appServiceBindings(ctx, r.Client, artifact.Namespace, artifact.Spec.App)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if volume := serviceBindingsVolume(bindings); volume != nil {
+		desiredPod.Spec.Volumes = append(desiredPod.Spec.Volumes, *volume)
+		desiredPod.Spec.Containers[0].VolumeMounts = append(desiredPod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: bindingsRoot,
+			ReadOnly:  true,
+		})
+		desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "BINDINGS_DIR",
+			Value: bindingsRoot,
+		})
+	}
+
+	if r.SourceCacheSize != nil {
+		claimName, requeue, err := r.reconcileSourceCache(ctx, log, artifact)
+		if err != nil || requeue {
+			return ctrl.Result{Requeue: requeue}, err
+		}
+		if claimName != "" {
+			desiredPod.Spec.SecurityContext = &corev1.PodSecurityContext{
+				// Allows the app-builder user to write to the volume.
+				FSGroup: func(v int64) *int64 { return &v }(1000),
+			}
+			desiredPod.Spec.Volumes = append(desiredPod.Spec.Volumes, corev1.Volume{
+				Name: "source-cache",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+				},
+			})
+			desiredPod.Spec.Containers[0].VolumeMounts = append(desiredPod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+				Name:      "source-cache",
+				MountPath: "/var/cache/manor",
+			})
+			desiredPod.Spec.Containers[0].Env = append(desiredPod.Spec.Containers[0].Env, corev1.EnvVar{
+				Name:  "SOURCE_CACHE",
+				Value: "/var/cache/manor/source",
+			})
+		}
+	}
+
 	if err := ctrl.SetControllerReference(artifact, desiredPod, r.Scheme); err != nil {
 		return ctrl.Result{}, err
 	}
@@ -302,12 +455,17 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			}
 		}
 		if !buildCompleted {
-			condition := manorv1.ArtifactCondition{
-				Type:   manorv1.ArtifactCompleted,
-				Status: corev1.ConditionTrue,
+			// The app-builder reports the digest of the pushed image through its termination message.
+			var digest string
+			if currentPod.Status.Phase == corev1.PodSucceeded {
+				for _, containerStatus := range currentPod.Status.ContainerStatuses {
+					if containerStatus.State.Terminated != nil {
+						digest = strings.TrimSpace(containerStatus.State.Terminated.Message)
+					}
+				}
 			}
-			artifact.Status.Conditions = append(artifact.Status.Conditions, condition)
-			if err := r.Status().Update(ctx, artifact); err != nil {
+			r.completeArtifact(artifact, digest)
+			if err := r.updateStatus(ctx, artifact); err != nil {
 				log.Error(
 					err, "Failed to update Artifact status",
 					"Artifact.Namespace", artifact.Namespace,
@@ -315,35 +473,40 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 				)
 				return ctrl.Result{}, err
 			}
+			r.recordBuildCompleted(artifact)
+			observeArtifactBuild(artifact, time.Now())
 		}
 		return ctrl.Result{}, nil
 	}
 
-	podReady := false
-	for _, condition := range currentPod.Status.Conditions {
-		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
-			podReady = true
-			break
-		}
+	if currentPod.Status.Phase != corev1.PodRunning || currentPod.Status.PodIP == "" {
+		return ctrl.Result{RequeueAfter: time.Second}, nil
 	}
-	if !podReady {
-		return ctrl.Result{Requeue: true}, nil
+
+	if artifact.HasCondition(manorv1.ArtifactInProgress) {
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 	}
 
-	buildInProgress := false
-	for _, condition := range artifact.Status.Conditions {
-		if condition.Type == manorv1.ArtifactInProgress && condition.Status == corev1.ConditionTrue {
-			buildInProgress = true
-			break
-		}
+	// The build is in progress once the app-builder started receiving the source.
+	status, err := server.GetStatus(
+		ctx, fmt.Sprintf("http://%s:%d", currentPod.Status.PodIP, podAddrPort), string(currentSecret.Data["token"]),
+	)
+	if err != nil {
+		log.V(1).Info(
+			"Failed to get the app-builder status",
+			"Pod.Namespace", currentPod.Namespace,
+			"Pod.Name", currentPod.Name,
+			"error", err.Error(),
+		)
+		return ctrl.Result{RequeueAfter: 2 * time.Second}, nil
 	}
-	if !buildInProgress {
+	if status.Phase != build.PhasePending {
 		condition := manorv1.ArtifactCondition{
 			Type:   manorv1.ArtifactInProgress,
 			Status: corev1.ConditionTrue,
 		}
 		artifact.Status.Conditions = append(artifact.Status.Conditions, condition)
-		if err := r.Status().Update(ctx, artifact); err != nil {
+		if err := r.updateStatus(ctx, artifact); err != nil {
 			log.Error(
 				err, "Failed to update Artifact status",
 				"Artifact.Namespace", artifact.Namespace,
@@ -351,8 +514,224 @@ func (r *ArtifactReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			)
 			return ctrl.Result{}, err
 		}
+		r.Recorder.Eventf(artifact, corev1.EventTypeNormal, EventBuildStarted, "Started building in Pod %s", currentPod.Name)
 		// Do not requeue as the artifact update will trigger another event.
 		return ctrl.Result{}, nil
 	}
 	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 }
+
+// reconcileBuildJob dispatches the build of the Artifact to the app-builder service and tracks the
+// progress of the job.
+func (r *ArtifactReconciler) reconcileBuildJob(
+	ctx context.Context,
+	log logr.Logger,
+	artifact *manorv1.Artifact,
+	token string,
+) (ctrl.Result, error) {
+	if artifact.Status.BuildJob == "" {
+		log.Info(
+			"Dispatching build job to the app-builder service",
+			"Artifact.Namespace", artifact.Namespace,
+			"Artifact.Name", artifact.Name,
+		)
+
+		req := service.JobRequest{
+			Namespace:     artifact.Namespace,
+			App:           artifact.Spec.App,
+			Artifact:      artifact.Name,
+			ImageRegistry: r.imageRegistry(artifact),
+			Token:         token,
+			Path:          artifact.Spec.Path,
+			Include:       artifact.Spec.Include,
+			Exclude:       artifact.Spec.Exclude,
+			Builder:       artifact.Spec.Builder,
+		}
+		if r.BuildLogsURL != "" {
+			req.LogKey = logstore.Key(artifact.Namespace, artifact.Name)
+		}
+		bindings, err := appServiceBindings(ctx, r.Client, artifact.Namespace, artifact.Spec.App)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		for _, b := range bindings {
+			if req.Bindings == nil {
+				req.Bindings = map[string]map[string][]byte{}
+			}
+			req.Bindings[b.binding.BindingName()] = b.secret.Data
+		}
+		job, err := r.BuildService.CreateJob(ctx, req)
+		if err != nil {
+			log.Error(
+				err, "Failed to dispatch build job to the app-builder service",
+				"Artifact.Namespace", artifact.Namespace,
+				"Artifact.Name", artifact.Name,
+			)
+			return ctrl.Result{}, err
+		}
+
+		artifact.Status.BuildJob = r.BuildService.JobURL(job.ID)
+		if err := r.updateStatus(ctx, artifact); err != nil {
+			log.Error(
+				err, "Failed to update Artifact status",
+				"Artifact.Namespace", artifact.Namespace,
+				"Artifact.Name", artifact.Name,
+			)
+			return ctrl.Result{}, err
+		}
+		// Do not requeue as the artifact update will trigger another event.
+		return ctrl.Result{}, nil
+	}
+
+	if artifact.HasCondition(manorv1.ArtifactCompleted) {
+		return ctrl.Result{}, nil
+	}
+
+	job, err := r.BuildService.GetJob(ctx, artifact.Status.BuildJob)
+	if err != nil && !goerrors.Is(err, service.ErrJobNotFound) {
+		return ctrl.Result{}, err
+	}
+	// A job that is gone, e.g. because the app-builder service was restarted, is never completing.
+	if job != nil && !job.Phase.Completed() {
+		// The build is in progress once the job started receiving the source.
+		if job.Phase != build.PhasePending && !artifact.HasCondition(manorv1.ArtifactInProgress) {
+			artifact.Status.Conditions = append(artifact.Status.Conditions, manorv1.ArtifactCondition{
+				Type:   manorv1.ArtifactInProgress,
+				Status: corev1.ConditionTrue,
+			})
+			if err := r.updateStatus(ctx, artifact); err != nil {
+				log.Error(
+					err, "Failed to update Artifact status",
+					"Artifact.Namespace", artifact.Namespace,
+					"Artifact.Name", artifact.Name,
+				)
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(artifact, corev1.EventTypeNormal, EventBuildStarted, "Started building in job %s", job.ID)
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	var digest string
+	if job != nil && job.Phase == build.PhaseSucceeded {
+		digest = job.Digest
+	}
+	r.completeArtifact(artifact, digest)
+	if err := r.updateStatus(ctx, artifact); err != nil {
+		log.Error(
+			err, "Failed to update Artifact status",
+			"Artifact.Namespace", artifact.Namespace,
+			"Artifact.Name", artifact.Name,
+		)
+		return ctrl.Result{}, err
+	}
+	r.recordBuildCompleted(artifact)
+	observeArtifactBuild(artifact, time.Now())
+	return ctrl.Result{}, nil
+}
+
+// reconcileSourceCache ensures the PersistentVolumeClaim of the source cache of the App exists. The
+// claim is owned by the App, so the cache is kept across its Artifacts. It returns an empty claim
+// name when the App doesn't exist.
+func (r *ArtifactReconciler) reconcileSourceCache(
+	ctx context.Context,
+	log logr.Logger,
+	artifact *manorv1.Artifact,
+) (string, bool, error) {
+	app := &manorv1.App{}
+	if err := r.Get(ctx, types.NamespacedName{Name: artifact.Spec.App, Namespace: artifact.Namespace}, app); err != nil {
+		if errors.IsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	claimName := fmt.Sprintf("%s-source-cache", app.Name)
+	currentClaim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: claimName, Namespace: app.Namespace}, currentClaim); err == nil {
+		return claimName, false, nil
+	} else if !errors.IsNotFound(err) {
+		return "", false, err
+	}
+
+	desiredClaim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: app.Namespace,
+			Labels:    map[string]string{manorv1.AppLabel: app.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: *r.SourceCacheSize},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(app, desiredClaim, r.Scheme); err != nil {
+		return "", false, err
+	}
+
+	log.Info(
+		"Creating PersistentVolumeClaim for the source cache",
+		"PersistentVolumeClaim.Namespace", desiredClaim.Namespace,
+		"PersistentVolumeClaim.Name", desiredClaim.Name,
+	)
+
+	if err := r.Create(ctx, desiredClaim); err != nil {
+		log.Error(
+			err, "Failed to create PersistentVolumeClaim for the source cache",
+			"PersistentVolumeClaim.Namespace", desiredClaim.Namespace,
+			"PersistentVolumeClaim.Name", desiredClaim.Name,
+		)
+		return "", false, err
+	}
+
+	return "", true, nil
+}
+
+// completeArtifact marks the Artifact as completed. The build failed unless the digest of the
+// pushed image is known.
+func (r *ArtifactReconciler) completeArtifact(artifact *manorv1.Artifact, digest string) {
+	artifact.Status.Conditions = append(artifact.Status.Conditions, manorv1.ArtifactCondition{
+		Type:   manorv1.ArtifactCompleted,
+		Status: corev1.ConditionTrue,
+	})
+	if digest == "" {
+		artifact.Status.Conditions = append(artifact.Status.Conditions, manorv1.ArtifactCondition{
+			Type:   manorv1.ArtifactFailed,
+			Status: corev1.ConditionTrue,
+		})
+		return
+	}
+	artifact.Status.Image = build.Image(r.imageRegistry(artifact), artifact.Namespace, artifact.Spec.App, artifact.Name)
+	artifact.Status.Digest = digest
+}
+
+// recordBuildCompleted records the outcome of the build of a completed Artifact.
+func (r *ArtifactReconciler) recordBuildCompleted(artifact *manorv1.Artifact) {
+	if artifact.HasCondition(manorv1.ArtifactFailed) {
+		r.Recorder.Event(artifact, corev1.EventTypeWarning, EventBuildFailed, "Failed to build the App image")
+		return
+	}
+	r.Recorder.Eventf(artifact, corev1.EventTypeNormal, EventBuildSucceeded,
+		"Pushed image %s@%s", artifact.Status.Image, artifact.Status.Digest)
+}
+
+// imageRegistry returns the image registry the image of the Artifact is pushed to.
+func (r *ArtifactReconciler) imageRegistry(artifact *manorv1.Artifact) string {
+	if artifact.Spec.ImageRegistry != "" {
+		return artifact.Spec.ImageRegistry
+	}
+	return r.DefaultImageRegistry
+}
+
+// appBuilderPodName returns the name of the app-builder Pod of an Artifact.
+func appBuilderPodName(artifact *manorv1.Artifact) string {
+	return fmt.Sprintf("%s-app-builder", artifact.Name)
+}
+
+// appBuilderSecretName returns the name of the Secret holding the app-builder credentials of an
+// Artifact.
+func appBuilderSecretName(artifact *manorv1.Artifact) string {
+	return fmt.Sprintf("%s-app-builder-creds", artifact.Name)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/service"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("ArtifactReconciler", func() {
+	ctx := context.Background()
+
+	It("labels the app-builder Pod with the App and its component", func() {
+		reconciler := &ArtifactReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("Artifact"),
+			Scheme:               scheme.Scheme,
+			Recorder:             record.NewFakeRecorder(100),
+			DefaultImageRegistry: "registry.example.com",
+		}
+		artifact := &manorv1.Artifact{
+			ObjectMeta: metav1.ObjectMeta{Name: "builder-pod", Namespace: "default"},
+			Spec:       manorv1.ArtifactSpec{App: "builder-pod-app"},
+		}
+		Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+		key := types.NamespacedName{Name: artifact.Name, Namespace: artifact.Namespace}
+		for i := 0; i < 3; i++ {
+			reconcileUntilSettled(reconciler.Reconcile, key)
+		}
+
+		pod := &corev1.Pod{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: appBuilderPodName(artifact), Namespace: "default"}, pod)).To(Succeed())
+		Expect(pod.Labels).To(Equal(map[string]string{
+			manorv1.AppLabel:               "builder-pod-app",
+			"manor.codelogia.com/artifact": "builder-pod",
+			manorv1.ComponentLabel:         "app-builder",
+		}))
+		Expect(manorv1.AppReplicasSelector("builder-pod-app").Matches(labels.Set(pod.Labels))).To(BeFalse())
+	})
+
+	Context("with the app-builder service", func() {
+		var (
+			buildService *fakeBuildService
+			server       *httptest.Server
+			reconciler   *ArtifactReconciler
+		)
+
+		BeforeEach(func() {
+			buildService = &fakeBuildService{job: service.Job{ID: "job-1", Phase: build.PhasePending}}
+			server = httptest.NewServer(buildService)
+			reconciler = &ArtifactReconciler{
+				Client:               k8sClient,
+				Log:                  ctrl.Log.WithName("controllers").WithName("Artifact"),
+				Scheme:               scheme.Scheme,
+				Recorder:             record.NewFakeRecorder(100),
+				DefaultImageRegistry: "registry.example.com",
+				BuildService:         service.NewClient(server.URL, "token"),
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		// dispatchBuild creates an Artifact and reconciles it until its build job is dispatched.
+		dispatchBuild := func(name string) types.NamespacedName {
+			artifact := &manorv1.Artifact{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       manorv1.ArtifactSpec{App: "build-jobs"},
+			}
+			Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+			key := types.NamespacedName{Name: name, Namespace: "default"}
+			for i := 0; i < 3; i++ {
+				reconcileUntilSettled(reconciler.Reconcile, key)
+			}
+			Expect(k8sClient.Get(ctx, key, artifact)).To(Succeed())
+			Expect(artifact.Status.BuildJob).To(Equal(server.URL + "/jobs/job-1"))
+			return key
+		}
+
+		// expectConditions expects the Artifact to have exactly the true conditions.
+		expectConditions := func(key types.NamespacedName, conditionTypes ...manorv1.ArtifactConditionType) {
+			artifact := &manorv1.Artifact{}
+			Expect(k8sClient.Get(ctx, key, artifact)).To(Succeed())
+			var got []manorv1.ArtifactConditionType
+			for _, condition := range artifact.Status.Conditions {
+				if condition.Status == corev1.ConditionTrue {
+					got = append(got, condition.Type)
+				}
+			}
+			Expect(got).To(ConsistOf(conditionTypes))
+		}
+
+		It("keeps checking the job while it's in progress", func() {
+			key := dispatchBuild("build-jobs-in-progress")
+
+			buildService.setJob(build.PhaseBuilding, "")
+			result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).NotTo(BeZero())
+			expectConditions(key, manorv1.ArtifactInitialized, manorv1.ArtifactInProgress)
+		})
+
+		It("completes the Artifact with the digest of a succeeded job", func() {
+			key := dispatchBuild("build-jobs-succeeded")
+
+			buildService.setJob(build.PhaseSucceeded, "sha256:abc")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			expectConditions(key, manorv1.ArtifactInitialized, manorv1.ArtifactCompleted)
+			artifact := &manorv1.Artifact{}
+			Expect(k8sClient.Get(ctx, key, artifact)).To(Succeed())
+			Expect(artifact.Status.Digest).To(Equal("sha256:abc"))
+		})
+
+		It("fails the Artifact of a failed job", func() {
+			key := dispatchBuild("build-jobs-failed")
+
+			buildService.setJob(build.PhaseFailed, "")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			expectConditions(key, manorv1.ArtifactInitialized, manorv1.ArtifactCompleted, manorv1.ArtifactFailed)
+		})
+
+		It("fails the Artifact of a canceled job", func() {
+			key := dispatchBuild("build-jobs-canceled")
+
+			buildService.setJob(build.PhaseCanceled, "")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			expectConditions(key, manorv1.ArtifactInitialized, manorv1.ArtifactCompleted, manorv1.ArtifactFailed)
+		})
+
+		It("fails the Artifact of a job that is gone", func() {
+			key := dispatchBuild("build-jobs-gone")
+
+			buildService.setJob(build.PhaseBuilding, "")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			buildService.removeJob()
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			expectConditions(
+				key,
+				manorv1.ArtifactInitialized,
+				manorv1.ArtifactInProgress,
+				manorv1.ArtifactCompleted,
+				manorv1.ArtifactFailed,
+			)
+		})
+	})
+})
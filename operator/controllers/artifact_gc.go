@@ -0,0 +1,279 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/registry"
+)
+
+// ArtifactGarbageCollector periodically prunes the Artifacts of the Apps beyond their history
+// limits, along with their build logs and images. The app-builder Pods and Secrets of the pruned
+// Artifacts are deleted with them, as they are owned by the Artifacts. The Artifact an App runs,
+// the one it's about to run, and the ones of its deployment history are never pruned.
+type ArtifactGarbageCollector struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Interval time.Duration
+	// LogStore is the store of the build logs, or nil if the build logs are not persisted.
+	LogStore             logstore.Store
+	DefaultImageRegistry string
+	// CleanupRegistry is whether the images of the pruned Artifacts are deleted from the image
+	// registry.
+	CleanupRegistry bool
+	// RegistryCABundle is the CA bundle verifying the certificates of the image registries the
+	// images are deleted from. The system roots are used when empty.
+	RegistryCABundle []byte
+}
+
+// SetupArtifactGarbageCollector sets up the Artifact garbage collector. The build logs are not
+// deleted when the log store URL is empty.
+func SetupArtifactGarbageCollector(
+	mgr ctrl.Manager,
+	interval time.Duration,
+	defaultImageRegistry string,
+	logStoreURL string,
+	cleanupRegistry bool,
+	registryCABundle []byte,
+) error {
+	gc := &ArtifactGarbageCollector{
+		Client:               mgr.GetClient(),
+		Log:                  ctrl.Log.WithName("artifactgc"),
+		Recorder:             mgr.GetEventRecorderFor("artifact-gc"),
+		Interval:             interval,
+		DefaultImageRegistry: defaultImageRegistry,
+		CleanupRegistry:      cleanupRegistry,
+		RegistryCABundle:     registryCABundle,
+	}
+	if logStoreURL != "" {
+		store, err := logstore.New(logStoreURL)
+		if err != nil {
+			return err
+		}
+		gc.LogStore = store
+	}
+	return mgr.Add(gc)
+}
+
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=artifacts,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Start prunes the Artifacts every interval until the stop channel is closed.
+func (gc *ArtifactGarbageCollector) Start(stop <-chan struct{}) error {
+	gc.Log.Info("Pruning Artifacts periodically", "interval", gc.Interval)
+	wait.Until(func() {
+		if err := gc.collect(); err != nil {
+			gc.Log.Error(err, "Failed to prune Artifacts")
+		}
+	}, gc.Interval, stop)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The Artifacts are only pruned by
+// the leader.
+func (gc *ArtifactGarbageCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// collect prunes the Artifacts of every App. The pruning of an App failing doesn't prevent the
+// others from being pruned.
+func (gc *ArtifactGarbageCollector) collect() error {
+	ctx := context.Background()
+
+	apps := &manorv1.AppList{}
+	if err := gc.List(ctx, apps); err != nil {
+		return fmt.Errorf("failed to list Apps: %w", err)
+	}
+	artifacts := &manorv1.ArtifactList{}
+	if err := gc.List(ctx, artifacts); err != nil {
+		return fmt.Errorf("failed to list Artifacts: %w", err)
+	}
+
+	appArtifacts := map[types.NamespacedName][]*manorv1.Artifact{}
+	for i := range artifacts.Items {
+		artifact := &artifacts.Items[i]
+		key := types.NamespacedName{Name: artifact.Spec.App, Namespace: artifact.Namespace}
+		appArtifacts[key] = append(appArtifacts[key], artifact)
+	}
+
+	var failed int
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		if !app.DeletionTimestamp.IsZero() {
+			continue
+		}
+		key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+		if err := gc.prune(ctx, app, appArtifacts[key]); err != nil {
+			gc.Log.Error(
+				err, "Failed to prune App Artifacts",
+				"App.Namespace", app.Namespace,
+				"App.Name", app.Name,
+			)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to prune the Artifacts of %d Apps", failed)
+	}
+
+	return nil
+}
+
+// prune deletes the Artifacts of the App beyond its history limits.
+func (gc *ArtifactGarbageCollector) prune(ctx context.Context, app *manorv1.App, artifacts []*manorv1.Artifact) error {
+	pruned, kept := prunedArtifacts(app, artifacts)
+
+	// The digests of the kept Artifacts are not deleted, as Artifacts built from the same source
+	// may share their image.
+	keptDigests := map[string]bool{}
+	for _, artifact := range kept {
+		if artifact.Status.Digest != "" {
+			keptDigests[artifact.Status.Digest] = true
+		}
+	}
+
+	for _, artifact := range pruned {
+		if err := gc.pruneArtifact(ctx, app, artifact, keptDigests); err != nil {
+			gc.Recorder.Eventf(app, corev1.EventTypeWarning, EventArtifactPruneFailed,
+				"Failed to prune Artifact %s: %v", artifact.Name, err)
+			return err
+		}
+		gc.Recorder.Eventf(app, corev1.EventTypeNormal, EventArtifactPruned, "Pruned Artifact %s", artifact.Name)
+	}
+
+	return nil
+}
+
+// prunedArtifacts returns the finished Artifacts of the App beyond its history limits, and the
+// Artifacts that are kept, which include the Artifacts in progress.
+func prunedArtifacts(app *manorv1.App, artifacts []*manorv1.Artifact) ([]*manorv1.Artifact, []*manorv1.Artifact) {
+	sorted := make([]*manorv1.Artifact, len(artifacts))
+	copy(sorted, artifacts)
+	sort.Slice(sorted, func(i, j int) bool {
+		return artifactNewer(sorted[i], sorted[j])
+	})
+
+	succeededLimit := manorv1.DefaultSucceededArtifactHistoryLimit
+	if app.Spec.SucceededArtifactHistoryLimit != nil {
+		succeededLimit = *app.Spec.SucceededArtifactHistoryLimit
+	}
+	failedLimit := manorv1.DefaultFailedArtifactHistoryLimit
+	if app.Spec.FailedArtifactHistoryLimit != nil {
+		failedLimit = *app.Spec.FailedArtifactHistoryLimit
+	}
+
+	// The Artifacts of the deployment history are kept for the App to be rolled back to. The history
+	// is capped at AppHistoryLimit deployments, which bounds the Artifacts kept this way.
+	deployed := map[string]bool{}
+	for _, deployment := range app.Status.History {
+		deployed[deployment.Artifact] = true
+	}
+
+	var pruned, kept []*manorv1.Artifact
+	var succeeded, failed, finished int32
+	latestSucceeded := true
+	for _, artifact := range sorted {
+		if !artifact.HasCondition(manorv1.ArtifactCompleted) || !artifact.DeletionTimestamp.IsZero() {
+			kept = append(kept, artifact)
+			continue
+		}
+
+		withinLimits := app.Spec.ArtifactHistoryLimit == nil || finished < *app.Spec.ArtifactHistoryLimit
+		if artifact.HasCondition(manorv1.ArtifactFailed) {
+			withinLimits = withinLimits && failed < failedLimit
+			failed++
+		} else {
+			// The App runs, or is about to run, its latest succeeded Artifact or the one it's pinned to,
+			// and is rolled back to the last Artifact it was ready with or to a previous deployment.
+			protected := artifact.Name == app.Status.Artifact || latestSucceeded ||
+				app.Spec.ArtifactRef != nil && artifact.Name == app.Spec.ArtifactRef.Name ||
+				artifact.Name == app.Status.LastReadyArtifact || deployed[artifact.Name]
+			latestSucceeded = false
+			withinLimits = protected || withinLimits && succeeded < succeededLimit
+			succeeded++
+		}
+		finished++
+
+		if withinLimits {
+			kept = append(kept, artifact)
+		} else {
+			pruned = append(pruned, artifact)
+		}
+	}
+
+	return pruned, kept
+}
+
+// pruneArtifact deletes the image and the build log of the Artifact, then the Artifact itself.
+// The image is kept when its digest is in keptDigests.
+func (gc *ArtifactGarbageCollector) pruneArtifact(
+	ctx context.Context,
+	app *manorv1.App,
+	artifact *manorv1.Artifact,
+	keptDigests map[string]bool,
+) error {
+	log := gc.Log.WithValues("Artifact.Namespace", artifact.Namespace, "Artifact.Name", artifact.Name)
+
+	if gc.CleanupRegistry && artifact.Status.Digest != "" && !keptDigests[artifact.Status.Digest] {
+		imageRegistry := gc.DefaultImageRegistry
+		if artifact.Spec.ImageRegistry != "" {
+			imageRegistry = artifact.Spec.ImageRegistry
+		}
+		registryClient, err := registry.NewClient(imageRegistry, gc.RegistryCABundle)
+		if err != nil {
+			return err
+		}
+		log.Info("Deleting Artifact image from the image registry", "digest", artifact.Status.Digest)
+		repository := fmt.Sprintf("%s/%s", app.Namespace, app.Name)
+		if err := registryClient.DeleteManifest(ctx, repository, artifact.Status.Digest); err != nil {
+			return err
+		}
+	}
+
+	if gc.LogStore != nil {
+		log.Info("Deleting Artifact build log")
+		if err := gc.LogStore.Delete(ctx, logstore.Key(artifact.Namespace, artifact.Name)); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Deleting Artifact")
+	// The app-builder Pod and Secret owned by the Artifact are deleted with it.
+	if err := gc.Delete(ctx, artifact, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete Artifact")
+		return err
+	}
+
+	return nil
+}
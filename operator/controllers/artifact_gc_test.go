@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("prunedArtifacts", func() {
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// artifacts returns Artifacts of the given names, created a minute apart in order. The names
+	// starting with s succeeded, with f failed, with d succeeded but are being deleted, and the
+	// others are in progress.
+	artifacts := func(names ...string) []*manorv1.Artifact {
+		result := make([]*manorv1.Artifact, 0, len(names))
+		for i, name := range names {
+			artifact := &manorv1.Artifact{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              name,
+					Namespace:         "default",
+					CreationTimestamp: metav1.NewTime(created.Add(time.Duration(i) * time.Minute)),
+				},
+				Spec: manorv1.ArtifactSpec{App: "app"},
+			}
+			switch name[0] {
+			case 's', 'd':
+				artifact.Status.Conditions = []manorv1.ArtifactCondition{
+					{Type: manorv1.ArtifactCompleted, Status: corev1.ConditionTrue},
+				}
+			case 'f':
+				artifact.Status.Conditions = []manorv1.ArtifactCondition{
+					{Type: manorv1.ArtifactCompleted, Status: corev1.ConditionTrue},
+					{Type: manorv1.ArtifactFailed, Status: corev1.ConditionTrue},
+				}
+			}
+			if name[0] == 'd' {
+				deleted := metav1.NewTime(created)
+				artifact.DeletionTimestamp = &deleted
+			}
+			result = append(result, artifact)
+		}
+		return result
+	}
+
+	limit := func(n int32) *int32 {
+		return &n
+	}
+
+	names := func(artifacts []*manorv1.Artifact) []string {
+		result := make([]string, 0, len(artifacts))
+		for _, artifact := range artifacts {
+			result = append(result, artifact.Name)
+		}
+		return result
+	}
+
+	table.DescribeTable("prunes the Artifacts beyond the limits, newest first",
+		func(app *manorv1.App, all []*manorv1.Artifact, expected []string) {
+			pruned, kept := prunedArtifacts(app, all)
+			Expect(names(pruned)).To(Equal(expected))
+			Expect(len(pruned) + len(kept)).To(Equal(len(all)))
+			for _, artifact := range kept {
+				Expect(expected).NotTo(ContainElement(artifact.Name))
+			}
+		},
+		table.Entry("no Artifacts", &manorv1.App{}, nil, []string{}),
+		table.Entry("succeeded Artifacts beyond the default limit",
+			&manorv1.App{},
+			artifacts("s01", "s02", "s03", "s04", "s05", "s06", "s07", "s08", "s09", "s10", "s11", "s12"),
+			[]string{"s02", "s01"},
+		),
+		table.Entry("failed Artifacts beyond the default limit",
+			&manorv1.App{},
+			artifacts("f1", "f2", "f3", "f4", "f5", "s6"),
+			[]string{"f2", "f1"},
+		),
+		table.Entry("succeeded Artifacts beyond the succeeded limit",
+			&manorv1.App{Spec: manorv1.AppSpec{SucceededArtifactHistoryLimit: limit(2)}},
+			artifacts("s1", "f2", "s3", "s4"),
+			[]string{"s1"},
+		),
+		table.Entry("failed Artifacts beyond the failed limit",
+			&manorv1.App{Spec: manorv1.AppSpec{FailedArtifactHistoryLimit: limit(0)}},
+			artifacts("s1", "f2", "s3", "f4"),
+			[]string{"f4", "f2"},
+		),
+		table.Entry("finished Artifacts beyond the overall limit",
+			&manorv1.App{Spec: manorv1.AppSpec{ArtifactHistoryLimit: limit(2)}},
+			artifacts("s1", "f2", "s3", "f4"),
+			[]string{"f2", "s1"},
+		),
+		table.Entry("never the Artifacts in progress or being deleted",
+			&manorv1.App{Spec: manorv1.AppSpec{ArtifactHistoryLimit: limit(0)}},
+			artifacts("d1", "p2", "s3", "p4"),
+			[]string{},
+		),
+		table.Entry("never the latest succeeded Artifact",
+			&manorv1.App{Spec: manorv1.AppSpec{SucceededArtifactHistoryLimit: limit(0)}},
+			artifacts("s1", "s2", "f3"),
+			[]string{"s1"},
+		),
+		table.Entry("never the Artifacts the App runs, is pinned to, was last ready with or was deployed with",
+			&manorv1.App{
+				Spec: manorv1.AppSpec{
+					SucceededArtifactHistoryLimit: limit(0),
+					ArtifactRef:                   &corev1.LocalObjectReference{Name: "s3"},
+				},
+				Status: manorv1.AppStatus{
+					Artifact:          "s2",
+					LastReadyArtifact: "s4",
+					History: []manorv1.AppDeployment{
+						{Artifact: "s2"},
+						{Artifact: "s5"},
+					},
+				},
+			},
+			artifacts("s1", "s2", "s3", "s4", "s5", "s6", "s7"),
+			[]string{"s6", "s1"},
+		),
+	)
+})
@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// failedReplicaReasons are the reasons of the waiting App containers that fail the Artifact they
+// run, as they don't recover without a change.
+var failedReplicaReasons = map[string]bool{
+	"CrashLoopBackOff": true,
+	"ImagePullBackOff": true,
+	"InvalidImageName": true,
+}
+
+// failedReplicaRestarts is the number of restarts after which a crash looping App container fails
+// the Artifact it runs, so the replicas crashing once while they start don't.
+const failedReplicaRestarts = 3
+
+// rolloutFailure returns the reason and the message of the failure of the rollout of the image to
+// the App Deployments, which are nil when they don't exist, or empty strings if it didn't fail.
+func (r *AppReconciler) rolloutFailure(
+	ctx context.Context,
+	app *manorv1.App,
+	image string,
+	deployments ...*appsv1.Deployment,
+) (string, string, error) {
+	for _, deployment := range deployments {
+		if deployment == nil || deployment.Spec.Template.Spec.Containers[0].Image != image {
+			continue
+		}
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentProgressing &&
+				condition.Status == corev1.ConditionFalse &&
+				condition.Reason == "ProgressDeadlineExceeded" {
+				return condition.Reason, fmt.Sprintf("Deployment %s did not progress: %s", deployment.Name, condition.Message), nil
+			}
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(app.Namespace), client.MatchingLabels{manorv1.AppLabel: app.Name}); err != nil {
+		return "", "", err
+	}
+	for _, pod := range pods.Items {
+		if len(pod.Spec.Containers) == 0 || pod.Spec.Containers[0].Image != image {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			waiting := status.State.Waiting
+			if waiting == nil || !failedReplicaReasons[waiting.Reason] {
+				continue
+			}
+			if waiting.Reason == "CrashLoopBackOff" && status.RestartCount < failedReplicaRestarts {
+				continue
+			}
+			return waiting.Reason, fmt.Sprintf("Replica %s is in %s: %s", pod.Name, waiting.Reason, waiting.Message), nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// rollBack rolls the App back to the last Artifact it was ready with, as the rollout of the
+// Artifact failed. The App is pinned to it, so the failed Artifact is not rolled out again until a
+// new Artifact is pushed.
+func (r *AppReconciler) rollBack(
+	ctx context.Context,
+	log logr.Logger,
+	app *manorv1.App,
+	artifact *manorv1.Artifact,
+	reason, message string,
+) (ctrl.Result, error) {
+	lastReady := app.Status.LastReadyArtifact
+	message = fmt.Sprintf("Rolled back from Artifact %s to %s: %s", artifact.Name, lastReady, message)
+	log.Info(
+		"Rolling back App",
+		"message", message,
+		"App.Namespace", app.Namespace,
+		"App.Name", app.Name,
+	)
+
+	app.Spec.ArtifactRef = &corev1.LocalObjectReference{Name: lastReady}
+	if err := r.Update(ctx, app); err != nil {
+		log.Error(
+			err, "Failed to roll back App",
+			"App.Namespace", app.Namespace,
+			"App.Name", app.Name,
+		)
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Event(app, corev1.EventTypeWarning, EventRolledBack, message)
+
+	setAppCondition(app, manorv1.AppRolledBack, corev1.ConditionTrue, reason, message)
+	if err := r.Status().Update(ctx, app); err != nil {
+		log.Error(
+			err, "Failed to update App status",
+			"App.Namespace", app.Namespace,
+			"App.Name", app.Name,
+		)
+		return ctrl.Result{}, err
+	}
+
+	// The update triggers another reconcile.
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("Auto rollback", func() {
+	ctx := context.Background()
+
+	var reconciler *AppReconciler
+
+	BeforeEach(func() {
+		reconciler = &AppReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("App"),
+			Scheme:               scheme.Scheme,
+			Recorder:             record.NewFakeRecorder(100),
+			DefaultImageRegistry: "registry.example.com",
+		}
+	})
+
+	Describe("rolloutFailure", func() {
+		const image = "registry.example.com/default/failure@sha256:abc"
+
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "failure", Namespace: "default"}}
+
+		deployment := func(image string, conditions ...appsv1.DeploymentCondition) *appsv1.Deployment {
+			deployment := readyDeployment(image, 1)
+			deployment.Name = "failure"
+			deployment.Status.Conditions = conditions
+			return deployment
+		}
+		deadlineExceeded := appsv1.DeploymentCondition{
+			Type:    appsv1.DeploymentProgressing,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ProgressDeadlineExceeded",
+			Message: `ReplicaSet "failure-1" has timed out progressing.`,
+		}
+
+		// createReplica creates a replica of the App running the image, whose container waits for
+		// the reason after restarting.
+		createReplica := func(name, image, reason string, restarts int32) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+					Labels:    map[string]string{manorv1.AppLabel: app.Name},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name:         "app",
+				Image:        image,
+				RestartCount: restarts,
+				State: corev1.ContainerState{
+					Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "back-off restarting failed container"},
+				},
+			}}
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+		}
+
+		AfterEach(func() {
+			Expect(k8sClient.DeleteAllOf(ctx, &corev1.Pod{},
+				client.InNamespace("default"), client.MatchingLabels{manorv1.AppLabel: app.Name})).To(Succeed())
+		})
+
+		It("fails the rollout of a Deployment past its progress deadline", func() {
+			reason, message, err := reconciler.rolloutFailure(ctx, app, image, nil, deployment(image, deadlineExceeded))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(Equal("ProgressDeadlineExceeded"))
+			Expect(message).To(Equal(`Deployment failure did not progress: ReplicaSet "failure-1" has timed out progressing.`))
+		})
+
+		It("ignores the progress deadline of the Deployments running another image", func() {
+			reason, _, err := reconciler.rolloutFailure(ctx, app, image, deployment("other", deadlineExceeded))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+		})
+
+		It("fails the rollout once a replica crash loops past the restart threshold", func() {
+			createReplica("failure-crashing", image, "CrashLoopBackOff", failedReplicaRestarts-1)
+			reason, _, err := reconciler.rolloutFailure(ctx, app, image, deployment(image))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+
+			createReplica("failure-crashed", image, "CrashLoopBackOff", failedReplicaRestarts)
+			reason, message, err := reconciler.rolloutFailure(ctx, app, image, deployment(image))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(Equal("CrashLoopBackOff"))
+			Expect(message).To(Equal("Replica failure-crashed is in CrashLoopBackOff: back-off restarting failed container"))
+		})
+
+		It("fails the rollout as soon as the image of a replica can't be pulled", func() {
+			createReplica("failure-pull", image, "ImagePullBackOff", 0)
+			reason, _, err := reconciler.rolloutFailure(ctx, app, image, deployment(image))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(Equal("ImagePullBackOff"))
+		})
+
+		It("ignores the replicas running another image", func() {
+			createReplica("failure-other", "other", "CrashLoopBackOff", failedReplicaRestarts)
+			reason, _, err := reconciler.rolloutFailure(ctx, app, image, deployment(image))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+		})
+	})
+
+	// deployFailing deploys the first Artifact of the App and marks it ready, then pushes a second
+	// Artifact whose Deployment exceeds its progress deadline, pinning the App to it first when pin
+	// is true. It returns the App after the failure was reconciled.
+	deployFailing := func(name string, pin bool) *manorv1.App {
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+
+		createBuiltArtifact(ctx, name+"-1", name, "sha256:abc", manorv1.ArtifactSpec{})
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		markDeploymentReady(ctx, key)
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(k8sClient.Get(ctx, key, app)).To(Succeed())
+		Expect(app.Status.LastReadyArtifact).To(Equal(name + "-1"))
+
+		createBuiltArtifact(ctx, name+"-2", name, "sha256:def", manorv1.ArtifactSpec{})
+		if pin {
+			app.Spec.ArtifactRef = &corev1.LocalObjectReference{Name: name + "-2"}
+			Expect(k8sClient.Update(ctx, app)).To(Succeed())
+		}
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		deployment := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+		Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(HaveSuffix("@sha256:def"))
+		deployment.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:    appsv1.DeploymentProgressing,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ProgressDeadlineExceeded",
+			Message: "timed out progressing",
+		}}
+		Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, key, app)).To(Succeed())
+		return app
+	}
+
+	It("rolls a failed Artifact back, pinning the App to the last Artifact it was ready with", func() {
+		app := deployFailing("rollback", false)
+		Expect(app.Spec.ArtifactRef).To(Equal(&corev1.LocalObjectReference{Name: "rollback-1"}))
+		Expect(appConditionTrue(app, manorv1.AppRolledBack)).To(BeTrue())
+		for _, condition := range app.Status.Conditions {
+			if condition.Type == manorv1.AppRolledBack {
+				Expect(condition.Reason).To(Equal("ProgressDeadlineExceeded"))
+				Expect(condition.Message).To(Equal(
+					"Rolled back from Artifact rollback-2 to rollback-1: Deployment rollback did not progress: timed out progressing"))
+			}
+		}
+		Expect(recordedEvents(reconciler.Recorder.(*record.FakeRecorder))).To(ContainElement(
+			"Warning RolledBack Rolled back from Artifact rollback-2 to rollback-1: " +
+				"Deployment rollback did not progress: timed out progressing"))
+	})
+
+	It("doesn't roll back the Artifact the App is pinned to", func() {
+		app := deployFailing("pinned-rollback", true)
+		Expect(app.Spec.ArtifactRef).To(Equal(&corev1.LocalObjectReference{Name: "pinned-rollback-2"}))
+		Expect(appConditionTrue(app, manorv1.AppRolledBack)).To(BeFalse())
+	})
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/codelogia/manor/app-builder/pkg/logstore"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// BuildLogsServer serves the build logs persisted in a log store. The app-builders write their
+// build logs to it, and clients read them back, including after the app-builders are gone.
+type BuildLogsServer struct {
+	Reader client.Reader
+	Log    logr.Logger
+	Addr   string
+	Store  logstore.Store
+}
+
+// SetupBuildLogsServer sets up the build logs server.
+func SetupBuildLogsServer(mgr ctrl.Manager, addr, storeURL string) error {
+	store, err := logstore.New(storeURL)
+	if err != nil {
+		return err
+	}
+	s := &BuildLogsServer{
+		// Secrets are read directly from the API server instead of being cached by the manager.
+		Reader: mgr.GetAPIReader(),
+		Log:    ctrl.Log.WithName("buildlogs"),
+		Addr:   addr,
+		Store:  store,
+	}
+	return mgr.Add(s)
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+// Start starts serving the build logs until the stop channel is closed.
+func (s *BuildLogsServer) Start(stop <-chan struct{}) error {
+	router := http.NewServeMux()
+	router.Handle(logstore.HandlerPrefix, logstore.NewHandler(s.Store, s.authorize))
+
+	httpServer := &http.Server{
+		Addr:    s.Addr,
+		Handler: router,
+	}
+
+	go func() {
+		<-stop
+		httpServer.Shutdown(context.Background())
+	}()
+
+	s.Log.Info("Serving build logs", "addr", s.Addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve build logs: %w", err)
+	}
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The build logs are served by every
+// replica of the operator.
+func (s *BuildLogsServer) NeedLeaderElection() bool {
+	return false
+}
+
+// authorize only allows requests carrying the app-builder token of the Artifact.
+func (s *BuildLogsServer) authorize(r *http.Request, namespace, artifactName string) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return fmt.Errorf("missing bearer token")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), reconcileTimeout)
+	defer cancel()
+
+	artifact := &manorv1.Artifact{}
+	if err := s.Reader.Get(ctx, types.NamespacedName{Name: artifactName, Namespace: namespace}, artifact); err != nil {
+		return fmt.Errorf("failed to authorize: %w", err)
+	}
+
+	secret := &corev1.Secret{}
+	secretName := appBuilderSecretName(artifact)
+	if err := s.Reader.Get(ctx, types.NamespacedName{Name: secretName, Namespace: namespace}, secret); err != nil {
+		return fmt.Errorf("failed to authorize: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), secret.Data["token"]) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
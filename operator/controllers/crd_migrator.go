@@ -0,0 +1,245 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// crdMigrationRetryPeriod is the period the CRD migration is retried with until it succeeds.
+	crdMigrationRetryPeriod = time.Minute
+	// crdMigrationPageSize is the number of objects migrated per page.
+	crdMigrationPageSize = 500
+	// crdConversionCheckPeriod is the period the conversion of the CRDs is checked with once
+	// migrated, to follow the rotations of the webhook CA.
+	crdConversionCheckPeriod = 5 * time.Minute
+)
+
+// versionedCRDs are the CRDs served in several API versions, converted by the operator webhook.
+var versionedCRDs = []string{
+	"apps.manor.codelogia.com",
+	"artifacts.manor.codelogia.com",
+}
+
+// CRDMigrator prepares the versioned CRDs for their API versions. It points their conversion at
+// the operator webhook, then migrates the objects stored in older versions to the storage version,
+// so the older versions can eventually stop being served. The conversion keeps being checked
+// afterwards, as the CA of the webhook may be rotated.
+type CRDMigrator struct {
+	Reader client.Reader
+	Client client.Client
+	Log    logr.Logger
+	CRDs   []string
+	// The service of the conversion webhook. The conversion of the CRDs is left as is when nil.
+	WebhookService *apiextensionsv1.ServiceReference
+	// CABundle returns the CA bundle verifying the serving certificate of the conversion webhook.
+	// It's called on every check, to pick up the rotated CAs.
+	CABundle func() ([]byte, error)
+}
+
+// SetupCRDMigrator sets up the CRD migrator. The conversion of the CRDs is left as is when the
+// webhook service, in the namespace/name format, is empty. Otherwise, the CA bundle of the webhook
+// is read from caBundleFile.
+func SetupCRDMigrator(mgr ctrl.Manager, webhookService, caBundleFile string) error {
+	m := &CRDMigrator{
+		// The CRDs and the migrated objects are read directly from the API server instead of being
+		// cached by the manager, as they are only read once.
+		Reader: mgr.GetAPIReader(),
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("crdmigrator"),
+		CRDs:   versionedCRDs,
+	}
+	if webhookService != "" {
+		parts := strings.SplitN(webhookService, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid webhook service %q: must be namespace/name", webhookService)
+		}
+		path := "/convert"
+		// The port is set, as it is defaulted by the API server, for the CRDs to be compared as is.
+		port := int32(443)
+		m.WebhookService = &apiextensionsv1.ServiceReference{Namespace: parts[0], Name: parts[1], Path: &path, Port: &port}
+		m.CABundle = func() ([]byte, error) {
+			caBundle, err := ioutil.ReadFile(caBundleFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the CA bundle of the conversion webhook: %w", err)
+			}
+			if len(caBundle) == 0 {
+				return nil, fmt.Errorf("missing CA bundle for the conversion webhook")
+			}
+			return caBundle, nil
+		}
+		if _, err := m.CABundle(); err != nil {
+			return err
+		}
+	}
+	return mgr.Add(m)
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;update
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=update
+
+// Start migrates the CRDs, retrying until it succeeds or the stop channel is closed. The conversion
+// of the CRDs is then checked periodically until the stop channel is closed.
+func (m *CRDMigrator) Start(stop <-chan struct{}) error {
+	err := wait.PollImmediateUntil(crdMigrationRetryPeriod, func() (bool, error) {
+		for _, name := range m.CRDs {
+			if err := m.migrate(name); err != nil {
+				m.Log.Error(err, "Failed to migrate CRD, retrying", "CRD.Name", name, "retryPeriod", crdMigrationRetryPeriod)
+				return false, nil
+			}
+		}
+		return true, nil
+	}, stop)
+	if err != nil && err != wait.ErrWaitTimeout {
+		return err
+	}
+
+	if m.WebhookService == nil {
+		return nil
+	}
+	wait.Until(func() {
+		for _, name := range m.CRDs {
+			if err := m.updateConversion(name); err != nil {
+				m.Log.Error(err, "Failed to update CRD conversion", "CRD.Name", name, "retryPeriod", crdConversionCheckPeriod)
+			}
+		}
+	}, crdConversionCheckPeriod, stop)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The CRDs are only migrated by the
+// leader.
+func (m *CRDMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+// updateConversion points the conversion of the CRD of the given name at the webhook, with the
+// current CA bundle.
+func (m *CRDMigrator) updateConversion(name string) error {
+	ctx := context.Background()
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.Reader.Get(ctx, types.NamespacedName{Name: name}, crd); err != nil {
+		return fmt.Errorf("failed to get CRD: %w", err)
+	}
+	return m.setConversion(ctx, m.Log.WithValues("CRD.Name", name), crd)
+}
+
+// setConversion points the conversion of the CRD at the webhook, with the current CA bundle.
+func (m *CRDMigrator) setConversion(ctx context.Context, log logr.Logger, crd *apiextensionsv1.CustomResourceDefinition) error {
+	caBundle, err := m.CABundle()
+	if err != nil {
+		return err
+	}
+	conversion := &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig: &apiextensionsv1.WebhookClientConfig{
+				Service:  m.WebhookService,
+				CABundle: caBundle,
+			},
+			// The controller-runtime conversion webhook only serves v1beta1 conversion reviews.
+			ConversionReviewVersions: []string{"v1beta1"},
+		},
+	}
+	if !equality.Semantic.DeepEqual(crd.Spec.Conversion, conversion) {
+		log.Info("Updating CRD conversion", "webhookService", m.WebhookService.Namespace+"/"+m.WebhookService.Name)
+		crd.Spec.Conversion = conversion
+		if err := m.Client.Update(ctx, crd); err != nil {
+			return fmt.Errorf("failed to update CRD conversion: %w", err)
+		}
+	}
+	return nil
+
+}
+
+// migrate migrates the CRD of the given name.
+func (m *CRDMigrator) migrate(name string) error {
+	ctx := context.Background()
+	log := m.Log.WithValues("CRD.Name", name)
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.Reader.Get(ctx, types.NamespacedName{Name: name}, crd); err != nil {
+		return fmt.Errorf("failed to get CRD: %w", err)
+	}
+
+	if m.WebhookService != nil {
+		if err := m.setConversion(ctx, log, crd); err != nil {
+			return err
+		}
+	}
+
+	storageVersion := ""
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			storageVersion = v.Name
+		}
+	}
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == storageVersion {
+		log.Info("CRD objects already stored in the storage version", "storageVersion", storageVersion)
+		return nil
+	}
+
+	log.Info("Migrating CRD objects to the storage version",
+		"storedVersions", crd.Status.StoredVersions, "storageVersion", storageVersion)
+	gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: storageVersion, Kind: crd.Spec.Names.ListKind}
+	migrated := 0
+	continueToken := ""
+	for {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk)
+		if err := m.Reader.List(ctx, list, client.Limit(crdMigrationPageSize), client.Continue(continueToken)); err != nil {
+			return fmt.Errorf("failed to list CRD objects: %w", err)
+		}
+		for i := range list.Items {
+			// Writing the object back as is stores it in the storage version. Objects that were
+			// deleted or written in the meantime don't need to be migrated anymore.
+			if err := m.Client.Update(ctx, &list.Items[i]); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+				return fmt.Errorf("failed to migrate %s/%s: %w", list.Items[i].GetNamespace(), list.Items[i].GetName(), err)
+			}
+			migrated++
+		}
+		continueToken = list.GetContinue()
+		if continueToken == "" {
+			break
+		}
+	}
+
+	crd.Status.StoredVersions = []string{storageVersion}
+	if err := m.Client.Status().Update(ctx, crd); err != nil {
+		return fmt.Errorf("failed to update CRD stored versions: %w", err)
+	}
+
+	log.Info("Migrated CRD objects to the storage version", "storageVersion", storageVersion, "objects", migrated)
+
+	return nil
+}
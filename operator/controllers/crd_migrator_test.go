@@ -0,0 +1,158 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("CRDMigrator", func() {
+	ctx := context.Background()
+
+	var (
+		migrator *CRDMigrator
+		caBundle []byte
+	)
+
+	BeforeEach(func() {
+		caBundle = []byte("ca-1")
+		migrator = &CRDMigrator{
+			Reader: k8sClient,
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("crdmigrator"),
+			CABundle: func() ([]byte, error) {
+				return caBundle, nil
+			},
+		}
+	})
+
+	// createCRD creates a cluster-scoped Widget CRD of the group, served in the v1alpha1 and v1
+	// versions and stored in v1, with the given stored versions.
+	createCRD := func(group string, storedVersions ...string) *apiextensionsv1.CustomResourceDefinition {
+		preserveUnknownFields := true
+		schema := &apiextensionsv1.CustomResourceValidation{
+			OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+				Type:                   "object",
+				XPreserveUnknownFields: &preserveUnknownFields,
+			},
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "widgets." + group},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Group: group,
+				Names: apiextensionsv1.CustomResourceDefinitionNames{
+					Plural:   "widgets",
+					Singular: "widget",
+					Kind:     "Widget",
+					ListKind: "WidgetList",
+				},
+				Scope: apiextensionsv1.ClusterScoped,
+				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+					{Name: "v1alpha1", Served: true, Schema: schema},
+					{Name: "v1", Served: true, Storage: true, Schema: schema},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, crd)).To(Succeed())
+		crd.Status.StoredVersions = storedVersions
+		Expect(k8sClient.Status().Update(ctx, crd)).To(Succeed())
+		return crd
+	}
+
+	// createWidget creates a Widget of the group, once its CRD is established.
+	createWidget := func(group, name string) {
+		widget := &unstructured.Unstructured{}
+		widget.SetAPIVersion(group + "/v1")
+		widget.SetKind("Widget")
+		widget.SetName(name)
+		Eventually(func() error {
+			return k8sClient.Create(ctx, widget)
+		}).Should(Succeed())
+	}
+
+	// getCRD returns the CRD of the given name.
+	getCRD := func(name string) *apiextensionsv1.CustomResourceDefinition {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, crd)).To(Succeed())
+		return crd
+	}
+
+	It("migrates the objects stored in older versions to the storage version", func() {
+		// The objects are written in the storage version, so the CRD records the older version as
+		// if the storage version was changed from it.
+		crd := createCRD("migrated.manor.codelogia.com", "v1alpha1", "v1")
+		createWidget("migrated.manor.codelogia.com", "widget-1")
+		createWidget("migrated.manor.codelogia.com", "widget-2")
+
+		Expect(migrator.migrate(crd.Name)).To(Succeed())
+
+		Expect(getCRD(crd.Name).Status.StoredVersions).To(Equal([]string{"v1"}))
+		widgets := &unstructured.UnstructuredList{}
+		widgets.SetAPIVersion("migrated.manor.codelogia.com/v1")
+		widgets.SetKind("WidgetList")
+		Expect(k8sClient.List(ctx, widgets)).To(Succeed())
+		var names []string
+		for _, widget := range widgets.Items {
+			names = append(names, widget.GetName())
+		}
+		Expect(names).To(ConsistOf("widget-1", "widget-2"))
+		// The conversion is left as is without a webhook service.
+		Expect(getCRD(crd.Name).Spec.Conversion.Strategy).To(Equal(apiextensionsv1.NoneConverter))
+
+		By("leaving the migrated CRD as is")
+		resourceVersion := getCRD(crd.Name).ResourceVersion
+		Expect(migrator.migrate(crd.Name)).To(Succeed())
+		Expect(getCRD(crd.Name).ResourceVersion).To(Equal(resourceVersion))
+	})
+
+	It("keeps the CA bundle of the conversion webhook in sync", func() {
+		crd := createCRD("converted.manor.codelogia.com", "v1")
+		path := "/convert"
+		port := int32(443)
+		migrator.WebhookService = &apiextensionsv1.ServiceReference{
+			Namespace: "manor-system",
+			Name:      "manor-webhook-service",
+			Path:      &path,
+			Port:      &port,
+		}
+
+		Expect(migrator.migrate(crd.Name)).To(Succeed())
+		conversion := getCRD(crd.Name).Spec.Conversion
+		Expect(conversion.Strategy).To(Equal(apiextensionsv1.WebhookConverter))
+		Expect(conversion.Webhook.ClientConfig.Service).To(Equal(migrator.WebhookService))
+		Expect(conversion.Webhook.ClientConfig.CABundle).To(Equal([]byte("ca-1")))
+		Expect(conversion.Webhook.ConversionReviewVersions).To(Equal([]string{"v1beta1"}))
+
+		By("leaving the conversion as is while the CA is the same")
+		resourceVersion := getCRD(crd.Name).ResourceVersion
+		Expect(migrator.updateConversion(crd.Name)).To(Succeed())
+		Expect(getCRD(crd.Name).ResourceVersion).To(Equal(resourceVersion))
+
+		By("following the rotation of the CA")
+		caBundle = []byte("ca-2")
+		Expect(migrator.updateConversion(crd.Name)).To(Succeed())
+		Expect(getCRD(crd.Name).Spec.Conversion.Webhook.ClientConfig.CABundle).To(Equal([]byte("ca-2")))
+	})
+})
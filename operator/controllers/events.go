@@ -0,0 +1,57 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+// The reasons of the Events recorded on the App and Artifact resources. They show up in
+// `kubectl describe`, so they are part of the user-facing surface of the operator.
+const (
+	// EventBuildStarted is recorded on an Artifact when the app-builder starts building it.
+	EventBuildStarted = "BuildStarted"
+	// EventBuildSucceeded is recorded on an Artifact when its image is pushed to the registry.
+	EventBuildSucceeded = "BuildSucceeded"
+	// EventBuildFailed is recorded on an Artifact when its build completes without an image.
+	EventBuildFailed = "BuildFailed"
+
+	// EventDeploymentCreated is recorded on an App when one of its Deployments is created.
+	EventDeploymentCreated = "DeploymentCreated"
+	// EventDeploymentUpdated is recorded on an App when one of its Deployments is updated.
+	EventDeploymentUpdated = "DeploymentUpdated"
+	// EventServiceCreated is recorded on an App when its Service is created.
+	EventServiceCreated = "ServiceCreated"
+	// EventServiceUpdated is recorded on an App when its Service is updated. The Service is
+	// updated in place, including its selector, so it's never recreated.
+	EventServiceUpdated = "ServiceUpdated"
+	// EventRegistryUnavailable is recorded on an App when the in-cluster image registry Service it
+	// pulls from doesn't exist.
+	EventRegistryUnavailable = "RegistryUnavailable"
+
+	// EventArtifactNotDeployable is recorded on an App when its pinned Artifact can't be deployed.
+	EventArtifactNotDeployable = "ArtifactNotDeployable"
+	// EventRolledBack is recorded on an App when it's rolled back to its last ready Artifact.
+	EventRolledBack = "RolledBack"
+
+	// EventRegistryCleanedUp is recorded on an App when its images are deleted from the registry.
+	EventRegistryCleanedUp = "RegistryCleanedUp"
+	// EventRegistryCleanupFailed is recorded on an App when its images can't be deleted from the
+	// registry.
+	EventRegistryCleanupFailed = "RegistryCleanupFailed"
+	// EventArtifactPruned is recorded on an App when one of its Artifacts is garbage collected.
+	EventArtifactPruned = "ArtifactPruned"
+	// EventArtifactPruneFailed is recorded on an App when one of its Artifacts can't be garbage
+	// collected.
+	EventArtifactPruneFailed = "ArtifactPruneFailed"
+)
@@ -0,0 +1,244 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/service"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// recordedEvents drains the events recorded so far by the fake recorder.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// reconcileFunc reconciles a resource, e.g. the Reconcile method of a reconciler.
+type reconcileFunc func(ctrl.Request) (ctrl.Result, error)
+
+// reconcileUntilSettled reconciles the resource until the reconciler stops asking for an immediate
+// requeue.
+func reconcileUntilSettled(r reconcileFunc, name types.NamespacedName) {
+	for i := 0; i < 20; i++ {
+		result, err := r(ctrl.Request{NamespacedName: name})
+		Expect(err).NotTo(HaveOccurred())
+		if !result.Requeue {
+			return
+		}
+	}
+	Fail("the reconciler kept requeueing")
+}
+
+// fakeBuildService serves the build jobs API of the app-builder service with a single job.
+type fakeBuildService struct {
+	mu   sync.Mutex
+	job  service.Job
+	gone bool
+}
+
+func (s *fakeBuildService) setJob(phase build.Phase, digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.job.Phase = phase
+	s.job.Digest = digest
+}
+
+// removeJob makes the job gone, e.g. as if the app-builder service was restarted.
+func (s *fakeBuildService) removeJob() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gone = true
+}
+
+func (s *fakeBuildService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gone {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.job)
+}
+
+var _ = Describe("Events", func() {
+	ctx := context.Background()
+
+	Context("of Artifacts", func() {
+		var (
+			buildService *fakeBuildService
+			server       *httptest.Server
+			recorder     *record.FakeRecorder
+			reconciler   *ArtifactReconciler
+		)
+
+		BeforeEach(func() {
+			buildService = &fakeBuildService{job: service.Job{ID: "job-1", Phase: build.PhasePending}}
+			server = httptest.NewServer(buildService)
+			recorder = record.NewFakeRecorder(100)
+			reconciler = &ArtifactReconciler{
+				Client:               k8sClient,
+				Log:                  ctrl.Log.WithName("controllers").WithName("Artifact"),
+				Scheme:               scheme.Scheme,
+				Recorder:             recorder,
+				DefaultImageRegistry: "registry.example.com",
+				BuildService:         service.NewClient(server.URL, "token"),
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		// startBuild creates an Artifact and reconciles it until its build job is in progress.
+		startBuild := func(name string) types.NamespacedName {
+			artifact := &manorv1.Artifact{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       manorv1.ArtifactSpec{App: "events"},
+			}
+			Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+			key := types.NamespacedName{Name: name, Namespace: "default"}
+
+			// Initializes the Artifact, creates its credentials and dispatches its build job.
+			for i := 0; i < 3; i++ {
+				reconcileUntilSettled(reconciler.Reconcile, key)
+			}
+			Expect(recordedEvents(recorder)).To(BeEmpty())
+
+			buildService.setJob(build.PhaseBuilding, "")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			Expect(recordedEvents(recorder)).To(ConsistOf("Normal BuildStarted Started building in job job-1"))
+			return key
+		}
+
+		It("records the start and the success of the build", func() {
+			key := startBuild("events-succeeded")
+
+			buildService.setJob(build.PhaseSucceeded, "sha256:abc")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			Expect(recordedEvents(recorder)).To(ConsistOf(
+				"Normal BuildSucceeded Pushed image registry.example.com/default/events:events-succeeded@sha256:abc",
+			))
+
+			// The outcome is recorded once.
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			Expect(recordedEvents(recorder)).To(BeEmpty())
+		})
+
+		It("records the failure of the build", func() {
+			key := startBuild("events-failed")
+
+			buildService.setJob(build.PhaseFailed, "")
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			Expect(recordedEvents(recorder)).To(ConsistOf("Warning BuildFailed Failed to build the App image"))
+		})
+	})
+
+	Context("of Apps", func() {
+		var (
+			recorder   *record.FakeRecorder
+			reconciler *AppReconciler
+		)
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(100)
+			reconciler = &AppReconciler{
+				Client:               k8sClient,
+				Log:                  ctrl.Log.WithName("controllers").WithName("App"),
+				Scheme:               scheme.Scheme,
+				Recorder:             recorder,
+				DefaultImageRegistry: "registry.example.com",
+			}
+		})
+
+		It("records the creation and the update of the Deployment and the Service", func() {
+			app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "events-app", Namespace: "default"}}
+			Expect(k8sClient.Create(ctx, app)).To(Succeed())
+			key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+
+			artifact := &manorv1.Artifact{
+				ObjectMeta: metav1.ObjectMeta{Name: "events-app-1", Namespace: "default"},
+				Spec:       manorv1.ArtifactSpec{App: app.Name},
+			}
+			Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+			artifact.Status.Conditions = []manorv1.ArtifactCondition{
+				{Type: manorv1.ArtifactInitialized, Status: corev1.ConditionTrue},
+				{Type: manorv1.ArtifactCompleted, Status: corev1.ConditionTrue},
+			}
+			artifact.Status.Digest = "sha256:abc"
+			Expect(k8sClient.Status().Update(ctx, artifact)).To(Succeed())
+
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			Expect(recordedEvents(recorder)).To(ConsistOf(
+				"Normal DeploymentCreated Created Deployment events-app",
+				"Normal ServiceCreated Created Service events-app",
+			))
+
+			Expect(k8sClient.Get(ctx, key, app)).To(Succeed())
+			app.Spec.Replicas = func(v int32) *int32 { return &v }(2)
+			Expect(k8sClient.Update(ctx, app)).To(Succeed())
+
+			reconcileUntilSettled(reconciler.Reconcile, key)
+			Expect(recordedEvents(recorder)).To(ConsistOf(
+				"Normal DeploymentUpdated Updated Deployment events-app: current number of replicas 1 doesn't match desired 2",
+			))
+
+			deployment := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+			Expect(*deployment.Spec.Replicas).To(Equal(int32(2)))
+		})
+
+		It("records the in-cluster image registry being unavailable", func() {
+			app := &manorv1.App{
+				ObjectMeta: metav1.ObjectMeta{Name: "events-registry", Namespace: "default"},
+				Spec:       manorv1.AppSpec{ImageRegistry: "registry.manor-system.svc"},
+			}
+			Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+			result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: app.Name, Namespace: app.Namespace}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).NotTo(BeZero())
+			Expect(recordedEvents(recorder)).To(ConsistOf(
+				"Warning RegistryUnavailable Image registry Service manor-system/registry not found",
+			))
+		})
+	})
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// The builder labels of the Artifact builds. The builders are not used as labels as is, as any
+// image can be a builder, which would make the number of series unbounded.
+const (
+	// defaultBuilder is the builder label of the Artifacts built with the default builder.
+	defaultBuilder = "default"
+	// customBuilder is the builder label of the Artifacts built with any other builder.
+	customBuilder = "custom"
+)
+
+var (
+	// artifactBuildDuration is the time from the creation of the Artifacts to the completion of
+	// their build, which includes the upload of their source.
+	artifactBuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "manor_artifact_build_duration_seconds",
+		Help:    "Time from the creation of the Artifacts to the completion of their build, by outcome.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	}, []string{"outcome"})
+
+	// artifactBuilds counts the completed Artifact builds by outcome and builder, i.e. whether they
+	// used the default builder.
+	artifactBuilds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "manor_artifact_builds_total",
+		Help: "Number of completed Artifact builds by outcome and builder, default or custom.",
+	}, []string{"outcome", "builder"})
+
+	// appReplicas is the desired number of replicas of the Apps.
+	appReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "manor_app_replicas",
+		Help: "Desired number of replicas of the Apps.",
+	}, []string{"namespace", "app"})
+
+	// appReadyReplicas is the number of ready replicas of the Apps the traffic is routed to.
+	appReadyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "manor_app_ready_replicas",
+		Help: "Number of ready replicas of the Apps.",
+	}, []string{"namespace", "app"})
+
+	// appRolloutDuration is the time from the start to the completion of the rollouts of the Apps
+	// with the BlueGreen and Canary strategies.
+	appRolloutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "manor_app_rollout_duration_seconds",
+		Help:    "Time from the start to the completion of the BlueGreen and Canary App rollouts, by strategy.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 12),
+	}, []string{"strategy"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		artifactBuildDuration,
+		artifactBuilds,
+		appReplicas,
+		appReadyReplicas,
+		appRolloutDuration,
+	)
+}
+
+// observeArtifactBuild records the build of a completed Artifact.
+func observeArtifactBuild(artifact *manorv1.Artifact, now time.Time) {
+	outcome := "Succeeded"
+	if artifact.HasCondition(manorv1.ArtifactFailed) {
+		outcome = "Failed"
+	}
+	builder := customBuilder
+	if artifact.Spec.Builder == "" || artifact.Spec.Builder == build.DefaultBuilder {
+		builder = defaultBuilder
+	}
+	artifactBuilds.WithLabelValues(outcome, builder).Inc()
+	artifactBuildDuration.WithLabelValues(outcome).Observe(now.Sub(artifact.CreationTimestamp.Time).Seconds())
+}
+
+// observeAppReplicas records the desired and ready replicas of an App.
+func observeAppReplicas(app *manorv1.App) {
+	appReplicas.WithLabelValues(app.Namespace, app.Name).Set(float64(app.Status.Replicas))
+	appReadyReplicas.WithLabelValues(app.Namespace, app.Name).Set(float64(app.Status.ReadyReplicas))
+}
+
+// forgetAppReplicas stops reporting the replicas of a deleted App.
+func forgetAppReplicas(namespace, name string) {
+	appReplicas.DeleteLabelValues(namespace, name)
+	appReadyReplicas.DeleteLabelValues(namespace, name)
+}
+
+// observeRolloutCompleted records the duration of a completed rollout.
+func observeRolloutCompleted(rollout *manorv1.AppRollout) {
+	if rollout.CompletedAt == nil {
+		return
+	}
+	appRolloutDuration.WithLabelValues(string(rollout.Strategy)).
+		Observe(rollout.CompletedAt.Sub(rollout.StartedAt.Time).Seconds())
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("Metrics", func() {
+	It("counts the builds of the Artifacts by outcome and default or custom builder", func() {
+		now := time.Now()
+		artifact := &manorv1.Artifact{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "metrics-1",
+				Namespace:         "default",
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+			},
+			Spec: manorv1.ArtifactSpec{App: "metrics", Builder: "paketobuildpacks/builder:tiny"},
+			Status: manorv1.ArtifactStatus{Conditions: []manorv1.ArtifactCondition{
+				{Type: manorv1.ArtifactCompleted, Status: corev1.ConditionTrue},
+				{Type: manorv1.ArtifactFailed, Status: corev1.ConditionTrue},
+			}},
+		}
+		failed := artifactBuilds.WithLabelValues("Failed", customBuilder)
+		succeeded := artifactBuilds.WithLabelValues("Succeeded", defaultBuilder)
+		failedBefore, succeededBefore := testutil.ToFloat64(failed), testutil.ToFloat64(succeeded)
+
+		observeArtifactBuild(artifact, now)
+		Expect(testutil.ToFloat64(failed)).To(Equal(failedBefore + 1))
+
+		artifact.Spec.Builder = ""
+		artifact.Status.Conditions = artifact.Status.Conditions[:1]
+		observeArtifactBuild(artifact, now)
+		Expect(testutil.ToFloat64(succeeded)).To(Equal(succeededBefore + 1))
+
+		// The default builder set explicitly is labeled as default as well.
+		artifact.Spec.Builder = build.DefaultBuilder
+		observeArtifactBuild(artifact, now)
+		Expect(testutil.ToFloat64(succeeded)).To(Equal(succeededBefore + 2))
+	})
+
+	It("reports the replicas of the Apps until they are deleted", func() {
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "default"}}
+		app.Status.Replicas = 3
+		app.Status.ReadyReplicas = 2
+
+		observeAppReplicas(app)
+		Expect(testutil.ToFloat64(appReplicas.WithLabelValues("default", "metrics"))).To(Equal(float64(3)))
+		Expect(testutil.ToFloat64(appReadyReplicas.WithLabelValues("default", "metrics"))).To(Equal(float64(2)))
+
+		forgetAppReplicas(app.Namespace, app.Name)
+		// The series were already deleted.
+		Expect(appReplicas.DeleteLabelValues(app.Namespace, app.Name)).To(BeFalse())
+		Expect(appReadyReplicas.DeleteLabelValues(app.Namespace, app.Name)).To(BeFalse())
+	})
+})
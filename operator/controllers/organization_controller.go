@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// OrganizationReconciler reconciles an Organization object.
+type OrganizationReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// SetupOrganizationReconciler sets up the Organization reconciler.
+func SetupOrganizationReconciler(mgr ctrl.Manager) error {
+	r := &OrganizationReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("Organization"),
+		Scheme: mgr.GetScheme(),
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&manorv1.Organization{}).
+		Watches(
+			&source.Kind{Type: &manorv1.Space{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(spaceToOrganization)},
+		).
+		Complete(r)
+}
+
+// spaceToOrganization maps a Space to the Organization it belongs to.
+func spaceToOrganization(obj handler.MapObject) []reconcile.Request {
+	space, ok := obj.Object.(*manorv1.Space)
+	if !ok || space.Spec.Organization == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: space.Spec.Organization},
+	}}
+}
+
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=organizations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=organizations/status,verbs=get;update;patch
+
+// Reconcile reconciles the Organization resources.
+func (r *OrganizationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	log := r.Log.WithValues("organization", req.Name)
+
+	organization := &manorv1.Organization{}
+	if err := r.Get(ctx, req.NamespacedName, organization); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Organization resource deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	spaces := &manorv1.SpaceList{}
+	if err := r.List(ctx, spaces); err != nil {
+		return ctrl.Result{}, err
+	}
+	var names []string
+	for _, space := range spaces.Items {
+		if space.Spec.Organization == organization.Name && space.DeletionTimestamp == nil {
+			names = append(names, space.Name)
+		}
+	}
+	sort.Strings(names)
+
+	if !equalStringSlice(names, organization.Status.Spaces) {
+		organization.Status.Spaces = names
+		if err := r.Status().Update(ctx, organization); err != nil {
+			log.Error(err, "Failed to update Organization status", "Organization.Name", organization.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
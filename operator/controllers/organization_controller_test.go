@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("OrganizationReconciler", func() {
+	ctx := context.Background()
+
+	var (
+		reconciler      *OrganizationReconciler
+		spaceReconciler *SpaceReconciler
+	)
+
+	BeforeEach(func() {
+		reconciler = &OrganizationReconciler{
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("controllers").WithName("Organization"),
+			Scheme: scheme.Scheme,
+		}
+		spaceReconciler = &SpaceReconciler{
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("controllers").WithName("Space"),
+			Scheme: scheme.Scheme,
+		}
+	})
+
+	// createSpace creates a Space of the Organization and reconciles it.
+	createSpace := func(name, organization string, spec manorv1.SpaceSpec) *manorv1.Space {
+		spec.Organization = organization
+		space := &manorv1.Space{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+		Expect(k8sClient.Create(ctx, space)).To(Succeed())
+		reconcileUntilSettled(spaceReconciler.Reconcile, types.NamespacedName{Name: name})
+		return space
+	}
+
+	// reconcileOrganization reconciles the Organization, returning it.
+	reconcileOrganization := func(name string) *manorv1.Organization {
+		reconcileUntilSettled(reconciler.Reconcile, types.NamespacedName{Name: name})
+		organization := &manorv1.Organization{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, organization)).To(Succeed())
+		return organization
+	}
+
+	It("lists the Spaces of the Organization in its status", func() {
+		Expect(k8sClient.Create(ctx, &manorv1.Organization{ObjectMeta: metav1.ObjectMeta{Name: "acme"}})).To(Succeed())
+		createSpace("acme-web", "acme", manorv1.SpaceSpec{})
+		space := createSpace("acme-api", "acme", manorv1.SpaceSpec{})
+		createSpace("other-web", "other", manorv1.SpaceSpec{})
+
+		Expect(reconcileOrganization("acme").Status.Spaces).To(Equal([]string{"acme-api", "acme-web"}))
+
+		// The Organization is reconciled on the changes of its Spaces.
+		Expect(spaceToOrganization(handler.MapObject{Meta: space, Object: space})).To(ConsistOf(
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: "acme"}},
+		))
+	})
+
+	It("drops the deleted Spaces from its status", func() {
+		Expect(k8sClient.Create(ctx, &manorv1.Organization{ObjectMeta: metav1.ObjectMeta{Name: "globex"}})).To(Succeed())
+		createSpace("globex-web", "globex", manorv1.SpaceSpec{})
+		space := createSpace("globex-api", "globex", manorv1.SpaceSpec{})
+		Expect(reconcileOrganization("globex").Status.Spaces).To(Equal([]string{"globex-api", "globex-web"}))
+
+		Expect(k8sClient.Delete(ctx, space)).To(Succeed())
+		Expect(reconcileOrganization("globex").Status.Spaces).To(Equal([]string{"globex-web"}))
+	})
+
+	It("creates the namespaces of its Spaces, bound to its members and within their quota", func() {
+		organization := &manorv1.Organization{
+			ObjectMeta: metav1.ObjectMeta{Name: "initech"},
+			Spec: manorv1.OrganizationSpec{
+				Members: []manorv1.Member{
+					{Name: "alice"},
+					{Kind: manorv1.MemberGroup, Name: "auditors", Role: manorv1.SpaceViewer},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, organization)).To(Succeed())
+		space := createSpace("initech-web", "initech", manorv1.SpaceSpec{
+			Members: []manorv1.Member{{Name: "bob", Role: manorv1.SpaceAdmin}},
+			Quota:   corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+		})
+
+		namespace := &corev1.Namespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "initech-web"}, namespace)).To(Succeed())
+		Expect(namespace.Labels).To(HaveKeyWithValue(manorv1.OrganizationLabel, "initech"))
+		Expect(namespace.Labels).To(HaveKeyWithValue(manorv1.SpaceLabel, "initech-web"))
+		Expect(metav1.IsControlledBy(namespace, space)).To(BeTrue())
+
+		quota := &corev1.ResourceQuota{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: spaceQuotaName, Namespace: "initech-web"}, quota)).To(Succeed())
+		Expect(quota.Spec.Hard.Pods().String()).To(Equal("10"))
+
+		// roleBindingSubjects returns the subjects bound to the ClusterRole of a role.
+		roleBindingSubjects := func(name, clusterRole string) []rbacv1.Subject {
+			roleBinding := &rbacv1.RoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "initech-web"}, roleBinding)).To(Succeed())
+			Expect(roleBinding.RoleRef.Name).To(Equal(clusterRole))
+			return roleBinding.Subjects
+		}
+		Expect(roleBindingSubjects("manor-admin", "admin")).To(Equal([]rbacv1.Subject{
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "bob"},
+		}))
+		Expect(roleBindingSubjects("manor-developer", "edit")).To(Equal([]rbacv1.Subject{
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "alice"},
+		}))
+		Expect(roleBindingSubjects("manor-viewer", "view")).To(Equal([]rbacv1.Subject{
+			{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "auditors"},
+		}))
+
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: space.Name}, space)).To(Succeed())
+		Expect(space.Status.Namespace).To(Equal("initech-web"))
+		Expect(space.Status.Conditions).To(Equal([]manorv1.SpaceCondition{{Type: manorv1.SpaceReady, Status: corev1.ConditionTrue}}))
+
+		// The Spaces are reconciled on the changes of their Organization, e.g. of its members.
+		Expect(spaceReconciler.organizationToSpaces(handler.MapObject{Meta: organization, Object: organization})).To(ConsistOf(
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: "initech-web"}},
+		))
+
+		By("removing the quota of the Space")
+		space.Spec.Quota = nil
+		Expect(k8sClient.Update(ctx, space)).To(Succeed())
+		reconcileUntilSettled(spaceReconciler.Reconcile, types.NamespacedName{Name: space.Name})
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: spaceQuotaName, Namespace: "initech-web"}, quota)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("marks the Spaces of a deleted Organization not ready", func() {
+		organization := &manorv1.Organization{ObjectMeta: metav1.ObjectMeta{Name: "hooli"}}
+		Expect(k8sClient.Create(ctx, organization)).To(Succeed())
+		space := createSpace("hooli-web", "hooli", manorv1.SpaceSpec{})
+
+		Expect(k8sClient.Delete(ctx, organization)).To(Succeed())
+		reconcileUntilSettled(reconciler.Reconcile, types.NamespacedName{Name: "hooli"})
+		reconcileUntilSettled(spaceReconciler.Reconcile, types.NamespacedName{Name: space.Name})
+
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: space.Name}, space)).To(Succeed())
+		Expect(space.Status.Conditions).To(Equal([]manorv1.SpaceCondition{{
+			Type:    manorv1.SpaceReady,
+			Status:  corev1.ConditionFalse,
+			Message: `organization "hooli" not found`,
+		}}))
+	})
+})
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// object is a Kubernetes object.
+type object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// applyOwned creates or updates an object controlled by owner, mutate setting its desired state.
+func applyOwned(
+	ctx context.Context,
+	c client.Client,
+	scheme *runtime.Scheme,
+	log logr.Logger,
+	owner object,
+	obj object,
+	mutate func(),
+) error {
+	kind := objectKind(obj)
+	result, err := controllerutil.CreateOrUpdate(ctx, c, obj, func() error {
+		mutate()
+		return ctrl.SetControllerReference(owner, obj, scheme)
+	})
+	if err != nil {
+		log.Error(
+			err, "Failed to reconcile "+kind,
+			kind+".Namespace", obj.GetNamespace(),
+			kind+".Name", obj.GetName(),
+		)
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info(
+			fmt.Sprintf("%s %s", strings.Title(string(result)), kind),
+			kind+".Namespace", obj.GetNamespace(),
+			kind+".Name", obj.GetName(),
+		)
+	}
+	return nil
+}
+
+// deleteOwned deletes an object if it exists and is controlled by owner.
+func deleteOwned(ctx context.Context, c client.Client, log logr.Logger, owner object, obj object) error {
+	kind := objectKind(obj)
+	if err := c.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !metav1.IsControlledBy(obj, owner) {
+		return nil
+	}
+
+	log.Info(
+		"Deleting "+kind,
+		kind+".Namespace", obj.GetNamespace(),
+		kind+".Name", obj.GetName(),
+	)
+	if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		log.Error(
+			err, "Failed to delete "+kind,
+			kind+".Namespace", obj.GetNamespace(),
+			kind+".Name", obj.GetName(),
+		)
+		return err
+	}
+	return nil
+}
+
+// objectKind returns the kind of a typed object, e.g. Secret.
+func objectKind(obj object) string {
+	return reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/registry"
+	"github.com/codelogia/manor/operator/stringutil"
+)
+
+// registryCleanupFinalizer is the finalizer deleting the images of the Apps from the image
+// registry. It can be removed by hand from an App whose registry can't be cleaned up.
+const registryCleanupFinalizer = "manor.codelogia.com/registry-cleanup"
+
+// finalize deletes the images of the deleted App from the image registry, unless the registry
+// cleanup is disabled, then releases the finalizer of the App.
+func (r *AppReconciler) finalize(ctx context.Context, log logr.Logger, app *manorv1.App) (ctrl.Result, error) {
+	if !stringutil.Contains(app.Finalizers, registryCleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if r.CleanupRegistry {
+		deleted, err := r.cleanupRegistry(ctx, log, app)
+		if err != nil {
+			log.Error(
+				err, "Failed to delete the App images from the image registry",
+				"App.Namespace", app.Namespace,
+				"App.Name", app.Name,
+			)
+			r.Recorder.Eventf(app, corev1.EventTypeWarning, EventRegistryCleanupFailed,
+				"Failed to delete the images from the image registry: %v", err)
+			if setAppCondition(app, manorv1.AppImagesCleanedUp, corev1.ConditionFalse, "RegistryCleanupFailed", err.Error()) {
+				if err := r.Status().Update(ctx, app); err != nil {
+					log.Error(
+						err, "Failed to update App status",
+						"App.Namespace", app.Namespace,
+						"App.Name", app.Name,
+					)
+				}
+			}
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, EventRegistryCleanedUp,
+			"Deleted %d images from the image registry, kept %d", deleted, app.Spec.KeepImages)
+	}
+
+	log.Info(
+		"Removing App finalizer",
+		"App.Namespace", app.Namespace,
+		"App.Name", app.Name,
+	)
+	app.Finalizers = stringutil.Remove(app.Finalizers, registryCleanupFinalizer)
+	if err := r.Update(ctx, app); err != nil {
+		log.Error(
+			err, "Failed to remove App finalizer",
+			"App.Namespace", app.Namespace,
+			"App.Name", app.Name,
+		)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// cleanupRegistry deletes the images of the App from the image registry, except the most recent
+// ones the App keeps. It returns the number of deleted images.
+func (r *AppReconciler) cleanupRegistry(ctx context.Context, log logr.Logger, app *manorv1.App) (int, error) {
+	registryClient, err := registry.NewClient(r.imageRegistry(app), r.RegistryCABundle)
+	if err != nil {
+		return 0, err
+	}
+	repository := fmt.Sprintf("%s/%s", app.Namespace, app.Name)
+
+	tags, err := registryClient.Tags(ctx, repository)
+	if err != nil {
+		return 0, err
+	}
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	// The images are tagged with the name of their Artifact, so the tags are ordered by the
+	// creation of their Artifacts, the most recent first. The tags without an Artifact are the
+	// oldest.
+	artifacts := &manorv1.ArtifactList{}
+	if err := r.List(ctx, artifacts, client.InNamespace(app.Namespace)); err != nil {
+		return 0, err
+	}
+	created := make(map[string]*manorv1.Artifact, len(artifacts.Items))
+	for i := range artifacts.Items {
+		if artifacts.Items[i].Spec.App == app.Name {
+			created[artifacts.Items[i].Name] = &artifacts.Items[i]
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := created[tags[i]], created[tags[j]]
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return artifactNewer(a, b)
+	})
+
+	// The digests are deleted once, as many tags may share the digest of an image.
+	kept := map[string]bool{}
+	deletable := map[string]bool{}
+	var digests []string
+	for _, tag := range tags {
+		digest, err := registryClient.Digest(ctx, repository, tag)
+		if err != nil {
+			if errors.Is(err, registry.ErrNotFound) {
+				continue
+			}
+			return 0, err
+		}
+		if kept[digest] || deletable[digest] {
+			continue
+		}
+		if len(kept) < int(app.Spec.KeepImages) {
+			kept[digest] = true
+			continue
+		}
+		deletable[digest] = true
+		digests = append(digests, digest)
+	}
+
+	deleted := 0
+	for _, digest := range digests {
+		log.Info(
+			"Deleting App image from the image registry",
+			"App.Namespace", app.Namespace,
+			"App.Name", app.Name,
+			"repository", repository,
+			"digest", digest,
+		)
+		if err := registryClient.DeleteManifest(ctx, repository, digest); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
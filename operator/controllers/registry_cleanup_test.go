@@ -0,0 +1,229 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// fakeImageRegistry is an in-memory image registry serving the tags of the repositories and
+// deleting their manifests.
+type fakeImageRegistry struct {
+	mu sync.Mutex
+	// tags are the digests of the tags, by repository.
+	tags map[string]map[string]string
+	// deleteDisabled makes the manifest deletes fail, as in registries without deletes enabled.
+	deleteDisabled bool
+}
+
+func (f *fakeImageRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list") && r.Method == http.MethodGet:
+		var tags []string
+		for tag := range f.tags[strings.TrimSuffix(path, "/tags/list")] {
+			tags = append(tags, tag)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"tags": tags})
+	case strings.Contains(path, "/manifests/") && r.Method == http.MethodHead:
+		parts := strings.SplitN(path, "/manifests/", 2)
+		digest, ok := f.tags[parts[0]][parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	case strings.Contains(path, "/manifests/") && r.Method == http.MethodDelete:
+		if f.deleteDisabled {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []map[string]string{{"code": "UNSUPPORTED", "message": "the operation is unsupported"}},
+			})
+			return
+		}
+		parts := strings.SplitN(path, "/manifests/", 2)
+		for tag, digest := range f.tags[parts[0]] {
+			if digest == parts[1] {
+				delete(f.tags[parts[0]], tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// repositoryTags returns the tags left in a repository, with their digests.
+func (f *fakeImageRegistry) repositoryTags(repository string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tags := make(map[string]string, len(f.tags[repository]))
+	for tag, digest := range f.tags[repository] {
+		tags[tag] = digest
+	}
+	return tags
+}
+
+var _ = Describe("Registry cleanup", func() {
+	ctx := context.Background()
+
+	var (
+		reconciler   *AppReconciler
+		recorder     *record.FakeRecorder
+		fakeRegistry *fakeImageRegistry
+		httpServer   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		fakeRegistry = &fakeImageRegistry{tags: map[string]map[string]string{}}
+		httpServer = httptest.NewTLSServer(fakeRegistry)
+		recorder = record.NewFakeRecorder(100)
+		reconciler = &AppReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("App"),
+			Scheme:               scheme.Scheme,
+			Recorder:             recorder,
+			DefaultImageRegistry: strings.TrimPrefix(httpServer.URL, "https://"),
+			CleanupRegistry:      true,
+			RegistryCABundle:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.Certificate().Raw}),
+		}
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	// createApp creates an App keeping the most recent images, with 3 Artifacts whose images are
+	// pushed to the registry, and reconciles it to add its finalizer.
+	createApp := func(name string, keepImages int32) *manorv1.App {
+		app := &manorv1.App{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       manorv1.AppSpec{KeepImages: keepImages},
+		}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		fakeRegistry.tags["default/"+name] = map[string]string{name: "sha256:3"}
+		for _, n := range []string{"1", "2", "3"} {
+			createBuiltArtifact(ctx, name+"-"+n, name, "sha256:"+n, manorv1.ArtifactSpec{})
+			fakeRegistry.tags["default/"+name][name+"-"+n] = "sha256:" + n
+		}
+
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "default"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, app)).To(Succeed())
+		Expect(app.Finalizers).To(ConsistOf(registryCleanupFinalizer))
+		return app
+	}
+
+	// deleteApp deletes the App and reconciles it.
+	deleteApp := func(app *manorv1.App) error {
+		Expect(k8sClient.Delete(ctx, app)).To(Succeed())
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: app.Name, Namespace: app.Namespace}})
+		return err
+	}
+
+	// expectAppGone expects the App to be deleted, its finalizer released.
+	expectAppGone := func(app *manorv1.App) {
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, &manorv1.App{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	}
+
+	It("deletes the repository of the deleted App, releasing its finalizer", func() {
+		app := createApp("cleaned", 0)
+
+		Expect(deleteApp(app)).To(Succeed())
+		Expect(fakeRegistry.repositoryTags("default/cleaned")).To(BeEmpty())
+		Expect(recordedEvents(recorder)).To(ContainElement(
+			"Normal RegistryCleanedUp Deleted 3 images from the image registry, kept 0",
+		))
+		expectAppGone(app)
+	})
+
+	It("keeps the most recent images of the App", func() {
+		app := createApp("kept", 1)
+
+		Expect(deleteApp(app)).To(Succeed())
+		Expect(fakeRegistry.repositoryTags("default/kept")).To(Equal(map[string]string{
+			"kept":   "sha256:3",
+			"kept-3": "sha256:3",
+		}))
+		Expect(recordedEvents(recorder)).To(ContainElement(
+			"Normal RegistryCleanedUp Deleted 2 images from the image registry, kept 1",
+		))
+		expectAppGone(app)
+	})
+
+	It("keeps the finalizer of the App when the registry can't be cleaned up", func() {
+		app := createApp("uncleaned", 0)
+		fakeRegistry.mu.Lock()
+		fakeRegistry.deleteDisabled = true
+		fakeRegistry.mu.Unlock()
+
+		Expect(deleteApp(app)).To(MatchError(ContainSubstring("UNSUPPORTED")))
+		Expect(fakeRegistry.repositoryTags("default/uncleaned")).To(HaveLen(4))
+		Expect(recordedEvents(recorder)).To(ContainElement(
+			"Warning RegistryCleanupFailed Failed to delete the images from the image registry: " +
+				"failed to delete manifest: registry responded with status 405: UNSUPPORTED: the operation is unsupported",
+		))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, app)).To(Succeed())
+		Expect(app.Finalizers).To(ConsistOf(registryCleanupFinalizer))
+		Expect(app.Status.Conditions).To(ContainElement(manorv1.AppCondition{
+			Type:    manorv1.AppImagesCleanedUp,
+			Status:  corev1.ConditionFalse,
+			Reason:  "RegistryCleanupFailed",
+			Message: "failed to delete manifest: registry responded with status 405: UNSUPPORTED: the operation is unsupported",
+		}))
+
+		By("retrying once the registry deletes the images")
+		fakeRegistry.mu.Lock()
+		fakeRegistry.deleteDisabled = false
+		fakeRegistry.mu.Unlock()
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: app.Name, Namespace: app.Namespace}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeRegistry.repositoryTags("default/uncleaned")).To(BeEmpty())
+		expectAppGone(app)
+	})
+
+	It("releases the finalizer without cleaning up the registry once the cleanup is disabled", func() {
+		app := createApp("opted-out", 0)
+		reconciler.CleanupRegistry = false
+
+		Expect(deleteApp(app)).To(Succeed())
+		Expect(fakeRegistry.repositoryTags("default/opted-out")).To(HaveLen(4))
+		expectAppGone(app)
+	})
+})
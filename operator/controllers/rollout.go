@@ -0,0 +1,290 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+const (
+	// trackLabel is the label of the App replicas with the track of the Deployment running them.
+	trackLabel = "manor.codelogia.com/track"
+	// primaryTrack is the track of the replicas of the primary Deployment of an App, which runs the
+	// Artifact the App was rolled out to.
+	primaryTrack = "primary"
+	// canaryTrack is the track of the replicas of the canary Deployment of an App, which runs the
+	// Artifact rolled out to the App with the BlueGreen or Canary strategy.
+	canaryTrack = "canary"
+)
+
+// rolloutPlan is the desired state of the Deployments of an App.
+type rolloutPlan struct {
+	primaryImage    string
+	primaryReplicas int32
+	// canaryImage is the image of the canary Deployment, or empty when the App has none.
+	canaryImage    string
+	canaryReplicas int32
+	// serviceTrack is the track of the replicas the Service of the App routes the traffic to, or
+	// empty for all the replicas of the App.
+	serviceTrack string
+}
+
+// canaryDeploymentName returns the name of the canary Deployment of the App.
+func canaryDeploymentName(app *manorv1.App) string {
+	return app.Name + "-" + canaryTrack
+}
+
+// appStrategyType returns the type of the rollout strategy of the App.
+func appStrategyType(app *manorv1.App) manorv1.AppStrategyType {
+	if app.Spec.Strategy == nil || app.Spec.Strategy.Type == "" {
+		return manorv1.RollingUpdateStrategyType
+	}
+	return app.Spec.Strategy.Type
+}
+
+// deploymentStrategy returns the strategy of the Deployments of the App, with all the fields
+// defaulted by the API server set, so it can be compared with the current one.
+func deploymentStrategy(app *manorv1.App) appsv1.DeploymentStrategy {
+	maxUnavailable := intstr.FromString("25%")
+	maxSurge := intstr.FromString("25%")
+	if app.Spec.Strategy != nil && app.Spec.Strategy.RollingUpdate != nil {
+		if rollingUpdate := app.Spec.Strategy.RollingUpdate; rollingUpdate.MaxUnavailable != nil {
+			maxUnavailable = *rollingUpdate.MaxUnavailable
+		}
+		if rollingUpdate := app.Spec.Strategy.RollingUpdate; rollingUpdate.MaxSurge != nil {
+			maxSurge = *rollingUpdate.MaxSurge
+		}
+	}
+	return appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxUnavailable: &maxUnavailable,
+			MaxSurge:       &maxSurge,
+		},
+	}
+}
+
+// advanceRollout advances the rollout of the image of the Artifact to the App, given its current
+// primary and canary Deployments, which are nil when they don't exist. The progress of the rollout
+// is recorded in the App status. It returns the plan of the Deployments of the App, whether the App
+// runs the Artifact once they match it, and how soon the rollout must be checked again, zero meaning
+// it's checked when the Deployments change.
+//
+// The Artifacts are rolled out with a rolling update of the primary Deployment, unless the App
+// strategy is BlueGreen or Canary and the App already runs another image. The Artifact is then
+// rolled out to the canary Deployment first, and promoted to the primary Deployment once the
+// canary replicas are healthy and the pauses of the strategy are over.
+func advanceRollout(
+	app *manorv1.App,
+	artifact *manorv1.Artifact,
+	image string,
+	replicas int32,
+	primary, canary *appsv1.Deployment,
+	now metav1.Time,
+) (rolloutPlan, bool, time.Duration) {
+	strategy := appStrategyType(app)
+	rollout := app.Status.Rollout
+
+	if strategy == manorv1.RollingUpdateStrategyType || app.Status.Image == "" || app.Status.Image == image || replicas == 0 {
+		// The rollout in progress is over, as the Artifact is rolled out directly.
+		if rollout != nil && !rolloutFinished(rollout) {
+			if rollout.Image == image {
+				completeRollout(rollout, now)
+			} else {
+				rollout.Phase = manorv1.RolloutAborted
+				rollout.PausedAt = nil
+			}
+		}
+		return rolloutPlan{primaryImage: image, primaryReplicas: replicas}, true, 0
+	}
+
+	promotedAt := app.Annotations[manorv1.PromotedAtAnnotation]
+	if rollout == nil || rollout.Image != image || rollout.Strategy != strategy || rolloutFinished(rollout) {
+		rollout = &manorv1.AppRollout{
+			Image:      image,
+			Strategy:   strategy,
+			Phase:      manorv1.RolloutProgressing,
+			StartedAt:  now,
+			PromotedAt: promotedAt,
+		}
+		app.Status.Rollout = rollout
+	}
+	rollout.Artifact = artifact.Name
+
+	plan := planRollout(app, rollout, image, replicas)
+	if !deploymentReady(primary, plan.primaryImage, plan.primaryReplicas) ||
+		!deploymentReady(canary, plan.canaryImage, plan.canaryReplicas) {
+		if rollout.Phase != manorv1.RolloutPromoting {
+			rollout.Phase = manorv1.RolloutProgressing
+		}
+		return plan, false, 0
+	}
+
+	if rollout.Phase == manorv1.RolloutPromoting {
+		completeRollout(rollout, now)
+		setDeployedArtifact(app, artifact, image, now)
+		return rolloutPlan{primaryImage: image, primaryReplicas: replicas}, true, 0
+	}
+
+	// The promotions are only handled once the replicas of the current step are healthy, so they
+	// are not lost while the step is rolled out.
+	promoted := promotedAt != rollout.PromotedAt
+	rollout.PromotedAt = promotedAt
+	if !promoted {
+		if app.Spec.RolloutPaused {
+			rollout.Phase = manorv1.RolloutPaused
+			return plan, false, 0
+		}
+		if pause := rolloutPause(app, rollout); pause != nil {
+			if rollout.PausedAt == nil {
+				rollout.PausedAt = &now
+			}
+			if pause.Duration == nil {
+				rollout.Phase = manorv1.RolloutPaused
+				return plan, false, 0
+			}
+			if remaining := rollout.PausedAt.Add(pause.Duration.Duration).Sub(now.Time); remaining > 0 {
+				rollout.Phase = manorv1.RolloutPaused
+				return plan, false, remaining
+			}
+		}
+	}
+
+	rollout.PausedAt = nil
+	rollout.Phase = manorv1.RolloutProgressing
+	if strategy == manorv1.CanaryStrategyType {
+		rollout.Step++
+	}
+	if strategy == manorv1.BlueGreenStrategyType || int(rollout.Step) >= len(canarySteps(app)) {
+		rollout.Phase = manorv1.RolloutPromoting
+	}
+	return planRollout(app, rollout, image, replicas), false, 0
+}
+
+// planRollout returns the plan of the Deployments of the App at the current step of its rollout,
+// recording the weight of the rollout.
+func planRollout(app *manorv1.App, rollout *manorv1.AppRollout, image string, replicas int32) rolloutPlan {
+	plan := rolloutPlan{
+		primaryImage:    app.Status.Image,
+		primaryReplicas: replicas,
+		canaryImage:     image,
+		canaryReplicas:  replicas,
+	}
+
+	switch rollout.Strategy {
+	case manorv1.BlueGreenStrategyType:
+		plan.serviceTrack = primaryTrack
+		rollout.Weight = 0
+		if rollout.Phase == manorv1.RolloutPromoting {
+			plan.serviceTrack = canaryTrack
+			rollout.Weight = 100
+		}
+	case manorv1.CanaryStrategyType:
+		var weight int32 = 100
+		if steps := canarySteps(app); len(steps) > 0 {
+			step := int(rollout.Step)
+			if step >= len(steps) {
+				step = len(steps) - 1
+			}
+			weight = steps[step].Weight
+		}
+		plan.canaryReplicas = (replicas*weight + 99) / 100
+		plan.primaryReplicas = replicas - plan.canaryReplicas
+		rollout.Weight = plan.canaryReplicas * 100 / replicas
+	}
+
+	if rollout.Phase == manorv1.RolloutPromoting {
+		plan.primaryImage = image
+		plan.primaryReplicas = replicas
+	}
+	return plan
+}
+
+// canarySteps returns the steps of the Canary strategy of the App.
+func canarySteps(app *manorv1.App) []manorv1.CanaryStep {
+	if app.Spec.Strategy == nil || app.Spec.Strategy.Canary == nil {
+		return nil
+	}
+	return app.Spec.Strategy.Canary.Steps
+}
+
+// rolloutPause returns the pause of the current step of the rollout, or nil if it has none.
+func rolloutPause(app *manorv1.App, rollout *manorv1.AppRollout) *manorv1.RolloutPause {
+	switch rollout.Strategy {
+	case manorv1.BlueGreenStrategyType:
+		if app.Spec.Strategy.BlueGreen != nil {
+			return app.Spec.Strategy.BlueGreen.Pause
+		}
+	case manorv1.CanaryStrategyType:
+		if steps := canarySteps(app); int(rollout.Step) < len(steps) {
+			return steps[rollout.Step].Pause
+		}
+	}
+	return nil
+}
+
+// rolloutFinished returns whether the rollout completed or was aborted.
+func rolloutFinished(rollout *manorv1.AppRollout) bool {
+	return rollout.Phase == manorv1.RolloutCompleted || rollout.Phase == manorv1.RolloutAborted
+}
+
+func completeRollout(rollout *manorv1.AppRollout, now metav1.Time) {
+	rollout.Phase = manorv1.RolloutCompleted
+	rollout.Weight = 100
+	rollout.PausedAt = nil
+	rollout.CompletedAt = &now
+}
+
+// setDeployedArtifact records the App runs the image of the Artifact, returning whether its status
+// changed.
+func setDeployedArtifact(app *manorv1.App, artifact *manorv1.Artifact, image string, now metav1.Time) bool {
+	if app.Status.Artifact == artifact.Name && app.Status.Image == image {
+		return false
+	}
+	if app.Status.Artifact != artifact.Name {
+		recordAppDeployment(app, artifact, now)
+	}
+	app.Status.Artifact = artifact.Name
+	app.Status.Image = image
+	app.Status.Digest = artifact.Status.Digest
+	app.Status.DeployedAt = &now
+	return true
+}
+
+// deploymentReady returns whether all the replicas of the Deployment run the image and are
+// available. A missing Deployment is ready when it's not meant to run any image.
+func deploymentReady(deployment *appsv1.Deployment, image string, replicas int32) bool {
+	if deployment == nil || image == "" {
+		return deployment == nil && image == ""
+	}
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != replicas ||
+		len(deployment.Spec.Template.Spec.Containers) == 0 ||
+		deployment.Spec.Template.Spec.Containers[0].Image != image {
+		return false
+	}
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.AvailableReplicas == replicas &&
+		status.Replicas == replicas
+}
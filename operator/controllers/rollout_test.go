@@ -0,0 +1,379 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+const (
+	runningImage = "registry.example.com/default/app@sha256:running"
+	rolledImage  = "registry.example.com/default/app@sha256:rolled"
+	otherImage   = "registry.example.com/default/app@sha256:other"
+)
+
+var rolloutNow = metav1.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// readyDeployment returns a Deployment whose replicas all run the image and are available.
+func readyDeployment(image string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: image}}},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          replicas,
+			UpdatedReplicas:   replicas,
+			AvailableReplicas: replicas,
+		},
+	}
+}
+
+// rolloutCase is a step of the rollout of the rolledImage to an App running the runningImage with
+// 4 replicas.
+type rolloutCase struct {
+	strategy *manorv1.AppStrategy
+	rollout  *manorv1.AppRollout
+	primary  *appsv1.Deployment
+	canary   *appsv1.Deployment
+	setup    func(app *manorv1.App)
+
+	phase    manorv1.AppRolloutPhase
+	step     int32
+	weight   int32
+	plan     rolloutPlan
+	deployed bool
+	requeue  time.Duration
+	// artifact is the Artifact the App runs afterwards, app-1 when empty.
+	artifact string
+}
+
+var _ = Describe("advanceRollout", func() {
+	blueGreen := func(pause *manorv1.RolloutPause) *manorv1.AppStrategy {
+		return &manorv1.AppStrategy{
+			Type:      manorv1.BlueGreenStrategyType,
+			BlueGreen: &manorv1.BlueGreenStrategy{Pause: pause},
+		}
+	}
+	canary := &manorv1.AppStrategy{
+		Type:   manorv1.CanaryStrategyType,
+		Canary: &manorv1.CanaryStrategy{Steps: []manorv1.CanaryStep{{Weight: 25}, {Weight: 50}}},
+	}
+	rollout := func(strategy manorv1.AppStrategyType, phase manorv1.AppRolloutPhase, step int32) *manorv1.AppRollout {
+		return &manorv1.AppRollout{
+			Artifact:  "app-2",
+			Image:     rolledImage,
+			Strategy:  strategy,
+			Phase:     phase,
+			Step:      step,
+			StartedAt: metav1.NewTime(rolloutNow.Add(-time.Hour)),
+		}
+	}
+	pausedAt := func(rollout *manorv1.AppRollout, ago time.Duration) *manorv1.AppRollout {
+		at := metav1.NewTime(rolloutNow.Add(-ago))
+		rollout.PausedAt = &at
+		return rollout
+	}
+	minute := &metav1.Duration{Duration: time.Minute}
+	blueGreenPlan := rolloutPlan{
+		primaryImage:    runningImage,
+		primaryReplicas: 4,
+		canaryImage:     rolledImage,
+		canaryReplicas:  4,
+		serviceTrack:    primaryTrack,
+	}
+	promotedBlueGreenPlan := rolloutPlan{
+		primaryImage:    rolledImage,
+		primaryReplicas: 4,
+		canaryImage:     rolledImage,
+		canaryReplicas:  4,
+		serviceTrack:    canaryTrack,
+	}
+	directPlan := rolloutPlan{primaryImage: rolledImage, primaryReplicas: 4}
+
+	table.DescribeTable("advances the rollout",
+		func(c rolloutCase) {
+			app := &manorv1.App{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       manorv1.AppSpec{Strategy: c.strategy},
+				Status: manorv1.AppStatus{
+					Artifact: "app-1",
+					Image:    runningImage,
+					Rollout:  c.rollout,
+				},
+			}
+			if c.setup != nil {
+				c.setup(app)
+			}
+			artifact := &manorv1.Artifact{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-2", Namespace: "default"},
+				Status:     manorv1.ArtifactStatus{Digest: "sha256:rolled"},
+			}
+
+			plan, deployed, requeue := advanceRollout(app, artifact, rolledImage, 4, c.primary, c.canary, rolloutNow)
+			Expect(plan).To(Equal(c.plan))
+			Expect(deployed).To(Equal(c.deployed))
+			Expect(requeue).To(Equal(c.requeue))
+			if c.artifact == "" {
+				c.artifact = "app-1"
+			}
+			Expect(app.Status.Artifact).To(Equal(c.artifact))
+			if c.phase == "" {
+				Expect(app.Status.Rollout).To(BeNil())
+				return
+			}
+			Expect(app.Status.Rollout).NotTo(BeNil())
+			Expect(app.Status.Rollout.Artifact).To(Equal("app-2"))
+			Expect(app.Status.Rollout.Phase).To(Equal(c.phase))
+			Expect(app.Status.Rollout.Step).To(Equal(c.step))
+			Expect(app.Status.Rollout.Weight).To(Equal(c.weight))
+			if c.phase != manorv1.RolloutPaused {
+				Expect(app.Status.Rollout.PausedAt).To(BeNil())
+			}
+		},
+		table.Entry("rolls the Artifact out directly with the RollingUpdate strategy", rolloutCase{
+			primary:  readyDeployment(runningImage, 4),
+			plan:     directPlan,
+			deployed: true,
+		}),
+		table.Entry("rolls the first Artifact out directly", rolloutCase{
+			strategy: blueGreen(nil),
+			setup:    func(app *manorv1.App) { app.Status.Image = "" },
+			plan:     directPlan,
+			deployed: true,
+		}),
+		table.Entry("completes the rollout of the image rolled out directly", rolloutCase{
+			rollout:  rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutProgressing, 0),
+			primary:  readyDeployment(runningImage, 4),
+			phase:    manorv1.RolloutCompleted,
+			weight:   100,
+			plan:     directPlan,
+			deployed: true,
+		}),
+		table.Entry("aborts the rollout of another image when the Artifact is rolled out directly", rolloutCase{
+			rollout: func() *manorv1.AppRollout {
+				r := pausedAt(rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPaused, 0), time.Minute)
+				r.Image = otherImage
+				return r
+			}(),
+			primary:  readyDeployment(runningImage, 4),
+			phase:    manorv1.RolloutAborted,
+			plan:     directPlan,
+			deployed: true,
+		}),
+		table.Entry("starts a BlueGreen rollout on the canary Deployment", rolloutCase{
+			strategy: blueGreen(nil),
+			primary:  readyDeployment(runningImage, 4),
+			phase:    manorv1.RolloutProgressing,
+			plan:     blueGreenPlan,
+		}),
+		table.Entry("restarts the rollout of another image", rolloutCase{
+			strategy: blueGreen(nil),
+			rollout: func() *manorv1.AppRollout {
+				r := pausedAt(rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPaused, 0), time.Minute)
+				r.Image = otherImage
+				return r
+			}(),
+			primary: readyDeployment(runningImage, 4),
+			canary:  readyDeployment(otherImage, 4),
+			phase:   manorv1.RolloutProgressing,
+			plan:    blueGreenPlan,
+		}),
+		table.Entry("promotes a BlueGreen rollout once the canary replicas are healthy", rolloutCase{
+			strategy: blueGreen(nil),
+			rollout:  rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutProgressing, 0),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			phase:    manorv1.RolloutPromoting,
+			weight:   100,
+			plan:     promotedBlueGreenPlan,
+		}),
+		table.Entry("pauses a BlueGreen rollout until it's promoted", rolloutCase{
+			strategy: blueGreen(&manorv1.RolloutPause{}),
+			rollout:  rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutProgressing, 0),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			phase:    manorv1.RolloutPaused,
+			plan:     blueGreenPlan,
+		}),
+		table.Entry("pauses a BlueGreen rollout for the rest of the pause duration", rolloutCase{
+			strategy: blueGreen(&manorv1.RolloutPause{Duration: minute}),
+			rollout:  pausedAt(rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPaused, 0), 20*time.Second),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			phase:    manorv1.RolloutPaused,
+			plan:     blueGreenPlan,
+			requeue:  40 * time.Second,
+		}),
+		table.Entry("resumes a BlueGreen rollout once the pause duration is over", rolloutCase{
+			strategy: blueGreen(&manorv1.RolloutPause{Duration: minute}),
+			rollout:  pausedAt(rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPaused, 0), 2*time.Minute),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			phase:    manorv1.RolloutPromoting,
+			weight:   100,
+			plan:     promotedBlueGreenPlan,
+		}),
+		table.Entry("promotes a paused rollout when the promotion annotation changes", rolloutCase{
+			strategy: blueGreen(&manorv1.RolloutPause{}),
+			rollout:  pausedAt(rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPaused, 0), time.Hour),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			setup: func(app *manorv1.App) {
+				app.Annotations = map[string]string{manorv1.PromotedAtAnnotation: "2021-01-01T12:00:00Z"}
+			},
+			phase:  manorv1.RolloutPromoting,
+			weight: 100,
+			plan:   promotedBlueGreenPlan,
+		}),
+		table.Entry("pauses a rollout while the rollouts of the App are paused", rolloutCase{
+			strategy: blueGreen(nil),
+			rollout:  rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutProgressing, 0),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			setup:    func(app *manorv1.App) { app.Spec.RolloutPaused = true },
+			phase:    manorv1.RolloutPaused,
+			plan:     blueGreenPlan,
+		}),
+		table.Entry("keeps promoting until the primary replicas run the Artifact", rolloutCase{
+			strategy: blueGreen(nil),
+			rollout:  rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPromoting, 0),
+			primary:  readyDeployment(runningImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			phase:    manorv1.RolloutPromoting,
+			weight:   100,
+			plan:     promotedBlueGreenPlan,
+		}),
+		table.Entry("completes a promoted rollout once the primary replicas run the Artifact", rolloutCase{
+			strategy: blueGreen(nil),
+			rollout:  rollout(manorv1.BlueGreenStrategyType, manorv1.RolloutPromoting, 0),
+			primary:  readyDeployment(rolledImage, 4),
+			canary:   readyDeployment(rolledImage, 4),
+			phase:    manorv1.RolloutCompleted,
+			weight:   100,
+			plan:     directPlan,
+			deployed: true,
+			artifact: "app-2",
+		}),
+		table.Entry("starts a Canary rollout at its first step", rolloutCase{
+			strategy: canary,
+			primary:  readyDeployment(runningImage, 4),
+			phase:    manorv1.RolloutProgressing,
+			weight:   25,
+			plan: rolloutPlan{
+				primaryImage:    runningImage,
+				primaryReplicas: 3,
+				canaryImage:     rolledImage,
+				canaryReplicas:  1,
+			},
+		}),
+		table.Entry("moves a Canary rollout to its next step once the replicas of the step are healthy", rolloutCase{
+			strategy: canary,
+			rollout:  rollout(manorv1.CanaryStrategyType, manorv1.RolloutProgressing, 0),
+			primary:  readyDeployment(runningImage, 3),
+			canary:   readyDeployment(rolledImage, 1),
+			phase:    manorv1.RolloutProgressing,
+			step:     1,
+			weight:   50,
+			plan: rolloutPlan{
+				primaryImage:    runningImage,
+				primaryReplicas: 2,
+				canaryImage:     rolledImage,
+				canaryReplicas:  2,
+			},
+		}),
+		table.Entry("promotes a Canary rollout after its last step", rolloutCase{
+			strategy: canary,
+			rollout:  rollout(manorv1.CanaryStrategyType, manorv1.RolloutProgressing, 1),
+			primary:  readyDeployment(runningImage, 2),
+			canary:   readyDeployment(rolledImage, 2),
+			phase:    manorv1.RolloutPromoting,
+			step:     2,
+			weight:   50,
+			plan: rolloutPlan{
+				primaryImage:    rolledImage,
+				primaryReplicas: 4,
+				canaryImage:     rolledImage,
+				canaryReplicas:  2,
+			},
+		}),
+	)
+})
+
+var _ = Describe("planRollout", func() {
+	table.DescribeTable("plans the Deployments at the current step of the rollout",
+		func(strategy *manorv1.AppStrategy, phase manorv1.AppRolloutPhase, step, replicas int32, expected rolloutPlan, weight int32) {
+			app := &manorv1.App{
+				Spec:   manorv1.AppSpec{Strategy: strategy},
+				Status: manorv1.AppStatus{Image: runningImage},
+			}
+			rollout := &manorv1.AppRollout{Image: rolledImage, Strategy: strategy.Type, Phase: phase, Step: step}
+			Expect(planRollout(app, rollout, rolledImage, replicas)).To(Equal(expected))
+			Expect(rollout.Weight).To(Equal(weight))
+		},
+		table.Entry("BlueGreen before the promotion",
+			&manorv1.AppStrategy{Type: manorv1.BlueGreenStrategyType}, manorv1.RolloutPaused, int32(0), int32(2),
+			rolloutPlan{primaryImage: runningImage, primaryReplicas: 2, canaryImage: rolledImage, canaryReplicas: 2, serviceTrack: primaryTrack},
+			int32(0),
+		),
+		table.Entry("BlueGreen once promoted",
+			&manorv1.AppStrategy{Type: manorv1.BlueGreenStrategyType}, manorv1.RolloutPromoting, int32(0), int32(2),
+			rolloutPlan{primaryImage: rolledImage, primaryReplicas: 2, canaryImage: rolledImage, canaryReplicas: 2, serviceTrack: canaryTrack},
+			int32(100),
+		),
+		table.Entry("Canary rounding the replicas of the step up",
+			&manorv1.AppStrategy{
+				Type:   manorv1.CanaryStrategyType,
+				Canary: &manorv1.CanaryStrategy{Steps: []manorv1.CanaryStep{{Weight: 10}}},
+			}, manorv1.RolloutProgressing, int32(0), int32(3),
+			rolloutPlan{primaryImage: runningImage, primaryReplicas: 2, canaryImage: rolledImage, canaryReplicas: 1},
+			int32(33),
+		),
+		table.Entry("Canary past its last step",
+			&manorv1.AppStrategy{
+				Type:   manorv1.CanaryStrategyType,
+				Canary: &manorv1.CanaryStrategy{Steps: []manorv1.CanaryStep{{Weight: 10}, {Weight: 60}}},
+			}, manorv1.RolloutProgressing, int32(2), int32(5),
+			rolloutPlan{primaryImage: runningImage, primaryReplicas: 2, canaryImage: rolledImage, canaryReplicas: 3},
+			int32(60),
+		),
+		table.Entry("Canary without steps",
+			&manorv1.AppStrategy{Type: manorv1.CanaryStrategyType}, manorv1.RolloutProgressing, int32(0), int32(3),
+			rolloutPlan{primaryImage: runningImage, primaryReplicas: 0, canaryImage: rolledImage, canaryReplicas: 3},
+			int32(100),
+		),
+		table.Entry("Canary once promoted",
+			&manorv1.AppStrategy{
+				Type:   manorv1.CanaryStrategyType,
+				Canary: &manorv1.CanaryStrategy{Steps: []manorv1.CanaryStep{{Weight: 50}}},
+			}, manorv1.RolloutPromoting, int32(1), int32(4),
+			rolloutPlan{primaryImage: rolledImage, primaryReplicas: 4, canaryImage: rolledImage, canaryReplicas: 2},
+			int32(50),
+		),
+	)
+})
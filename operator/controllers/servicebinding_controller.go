@@ -0,0 +1,390 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+const (
+	// bindingTypeKey and bindingProviderKey are the well-known entries of a binding.
+	bindingTypeKey     = "type"
+	bindingProviderKey = "provider"
+
+	// bindingsRoot is the directory the bindings are mounted in, in the App replicas and the
+	// app-builder Pods.
+	bindingsRoot = "/bindings"
+
+	// unresolvedBindingRequeue is how often a binding whose credentials can't be resolved is retried.
+	unresolvedBindingRequeue = time.Second * 30
+)
+
+// ServiceBindingReconciler reconciles a ServiceBinding object.
+type ServiceBindingReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// SetupServiceBindingReconciler sets up the ServiceBinding reconciler.
+func SetupServiceBindingReconciler(mgr ctrl.Manager) error {
+	r := &ServiceBindingReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("ServiceBinding"),
+		Scheme: mgr.GetScheme(),
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&manorv1.ServiceBinding{}).
+		Owns(&corev1.Secret{}).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.secretToServiceBindings)},
+		).
+		Complete(r)
+}
+
+// secretToServiceBindings maps a Secret to the ServiceBindings whose credentials it may hold.
+func (r *ServiceBindingReconciler) secretToServiceBindings(obj handler.MapObject) []reconcile.Request {
+	if _, ok := obj.Meta.GetLabels()[manorv1.ServiceBindingLabel]; ok {
+		return nil
+	}
+	bindings := &manorv1.ServiceBindingList{}
+	if err := r.List(context.Background(), bindings, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "Failed to list ServiceBindings", "Secret.Namespace", obj.Meta.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, binding := range bindings.Items {
+		// The Secret of a provisioned service is only known once the service is resolved.
+		if binding.Spec.Secret == obj.Meta.GetName() || binding.Spec.Service != nil {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=servicebindings,verbs=get;list;watch
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=servicebindings/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile reconciles the ServiceBinding resources. The credentials are copied into a Secret owned
+// by the ServiceBinding, which is projected into the App. Provisioned services of other kinds than
+// the manor ones need the operator to be granted read access to them.
+func (r *ServiceBindingReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	log := r.Log.WithValues("servicebinding", req.NamespacedName)
+
+	binding := &manorv1.ServiceBinding{}
+	if err := r.Get(ctx, req.NamespacedName, binding); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ServiceBinding resource deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if (binding.Spec.Secret == "") == (binding.Spec.Service == nil) {
+		err := fmt.Errorf("invalid spec: exactly one of secret and service must be set, not requeueing")
+		return ctrl.Result{Requeue: false}, err
+	}
+
+	secretName, message, err := r.sourceSecretName(ctx, binding)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var sourceSecret *corev1.Secret
+	if message == "" {
+		sourceSecret = &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: binding.Namespace}, sourceSecret); err != nil {
+			if !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			message = fmt.Sprintf("Secret %q not found", secretName)
+		}
+	}
+	if message == "" {
+		if _, ok := sourceSecret.Data[bindingTypeKey]; !ok && binding.Spec.Type == "" {
+			message = fmt.Sprintf("the type of the service is neither set nor in Secret %q", secretName)
+		}
+	}
+	if message != "" {
+		log.Info("Credentials of the ServiceBinding not resolved", "message", message)
+		if err := r.setStatus(ctx, log, binding, corev1.ConditionFalse, message); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: unresolvedBindingRequeue}, nil
+	}
+
+	data := make(map[string][]byte, len(sourceSecret.Data)+2)
+	for key, value := range sourceSecret.Data {
+		data[key] = value
+	}
+	if binding.Spec.Type != "" {
+		data[bindingTypeKey] = []byte(binding.Spec.Type)
+	}
+	if binding.Spec.Provider != "" {
+		data[bindingProviderKey] = []byte(binding.Spec.Provider)
+	}
+
+	projectedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceBindingSecretName(binding),
+			Namespace: binding.Namespace,
+		},
+	}
+	if err := applyOwned(ctx, r.Client, r.Scheme, log, binding, projectedSecret, func() {
+		projectedSecret.Labels = map[string]string{
+			manorv1.AppLabel:            binding.Spec.App,
+			manorv1.ServiceBindingLabel: binding.Name,
+		}
+		projectedSecret.Type = corev1.SecretTypeOpaque
+		projectedSecret.Data = data
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	binding.Status.Secret = projectedSecret.Name
+	if err := r.setStatus(ctx, log, binding, corev1.ConditionTrue, ""); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if binding.Spec.Service != nil {
+		// The binding Secret of the provisioned service may change without the service being watched.
+		return ctrl.Result{RequeueAfter: unresolvedBindingRequeue}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// sourceSecretName returns the name of the Secret holding the credentials of the ServiceBinding, or
+// why it can't be resolved yet.
+func (r *ServiceBindingReconciler) sourceSecretName(ctx context.Context, binding *manorv1.ServiceBinding) (string, string, error) {
+	if binding.Spec.Secret != "" {
+		return binding.Spec.Secret, "", nil
+	}
+
+	ref := binding.Spec.Service
+	service := &unstructured.Unstructured{}
+	service.SetGroupVersionKind(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: binding.Namespace}, service); err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Sprintf("%s %q not found", ref.Kind, ref.Name), nil
+		}
+		return "", "", err
+	}
+	secretName, found, err := unstructured.NestedString(service.Object, "status", "binding", "name")
+	if err != nil || !found || secretName == "" {
+		return "", fmt.Sprintf("%s %q has no binding Secret in status.binding.name", ref.Kind, ref.Name), nil
+	}
+	return secretName, "", nil
+}
+
+// setStatus sets the readiness of the ServiceBinding, updating its status when it changed.
+func (r *ServiceBindingReconciler) setStatus(
+	ctx context.Context,
+	log logr.Logger,
+	binding *manorv1.ServiceBinding,
+	status corev1.ConditionStatus,
+	message string,
+) error {
+	changed := setServiceBindingCondition(binding, manorv1.ServiceBindingReady, status, message)
+	if !changed && (status == corev1.ConditionTrue || binding.Status.Secret == "") {
+		return nil
+	}
+	// The App stops projecting the credentials of a binding that isn't ready.
+	if status != corev1.ConditionTrue {
+		binding.Status.Secret = ""
+	}
+	if err := r.Status().Update(ctx, binding); err != nil {
+		log.Error(
+			err, "Failed to update ServiceBinding status",
+			"ServiceBinding.Namespace", binding.Namespace,
+			"ServiceBinding.Name", binding.Name,
+		)
+		return err
+	}
+	return nil
+}
+
+// setServiceBindingCondition sets the status of the ServiceBinding condition, returning whether it
+// changed.
+func setServiceBindingCondition(
+	binding *manorv1.ServiceBinding,
+	conditionType manorv1.ServiceBindingConditionType,
+	status corev1.ConditionStatus,
+	message string,
+) bool {
+	for i, condition := range binding.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition.Status == status && condition.Message == message {
+				return false
+			}
+			binding.Status.Conditions[i].Status = status
+			binding.Status.Conditions[i].Message = message
+			return true
+		}
+	}
+	binding.Status.Conditions = append(binding.Status.Conditions, manorv1.ServiceBindingCondition{
+		Type:    conditionType,
+		Status:  status,
+		Message: message,
+	})
+	return true
+}
+
+// serviceBindingSecretName returns the name of the Secret projected into the App by the
+// ServiceBinding.
+func serviceBindingSecretName(binding *manorv1.ServiceBinding) string {
+	return binding.Name + "-binding"
+}
+
+// isServiceBindingReady returns whether the credentials of the ServiceBinding can be projected.
+func isServiceBindingReady(binding *manorv1.ServiceBinding) bool {
+	if binding.Status.Secret == "" {
+		return false
+	}
+	for _, condition := range binding.Status.Conditions {
+		if condition.Type == manorv1.ServiceBindingReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// appServiceBinding is a ready ServiceBinding of an App, along with its projected Secret.
+type appServiceBinding struct {
+	binding *manorv1.ServiceBinding
+	secret  *corev1.Secret
+}
+
+// appServiceBindings returns the ready ServiceBindings of an App, sorted by binding name. When
+// several ServiceBindings have the same binding name, the oldest one is used.
+func appServiceBindings(ctx context.Context, c client.Client, namespace, app string) ([]appServiceBinding, error) {
+	bindings := &manorv1.ServiceBindingList{}
+	if err := c.List(ctx, bindings, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	sort.Slice(bindings.Items, func(i, j int) bool {
+		a, b := &bindings.Items[i], &bindings.Items[j]
+		if a.BindingName() != b.BindingName() {
+			return a.BindingName() < b.BindingName()
+		}
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Name < b.Name
+	})
+
+	var result []appServiceBinding
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if binding.Spec.App != app || binding.DeletionTimestamp != nil || !isServiceBindingReady(binding) {
+			continue
+		}
+		if len(result) > 0 && result[len(result)-1].binding.BindingName() == binding.BindingName() {
+			continue
+		}
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Name: binding.Status.Secret, Namespace: namespace}, secret); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, appServiceBinding{binding: binding, secret: secret})
+	}
+	return result, nil
+}
+
+// serviceBindingsVolume returns the volume projecting the bindings, each in the directory of its
+// binding name, or nil when there are none.
+func serviceBindingsVolume(bindings []appServiceBinding) *corev1.Volume {
+	if len(bindings) == 0 {
+		return nil
+	}
+	sources := make([]corev1.VolumeProjection, 0, len(bindings))
+	for _, b := range bindings {
+		keys := make([]string, 0, len(b.secret.Data))
+		for key := range b.secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]corev1.KeyToPath, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, corev1.KeyToPath{Key: key, Path: b.binding.BindingName() + "/" + key})
+		}
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: b.secret.Name},
+				Items:                items,
+			},
+		})
+	}
+	// The defaults set by the API server, so the volume can be compared with the current one.
+	defaultMode := corev1.ProjectedVolumeSourceDefaultMode
+	return &corev1.Volume{
+		Name: "service-bindings",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources:     sources,
+				DefaultMode: &defaultMode,
+			},
+		},
+	}
+}
+
+// serviceBindingsHash returns a hash of the credentials of the bindings, which changes whenever
+// the replicas must be restarted to see the new credentials.
+func serviceBindingsHash(bindings []appServiceBinding) string {
+	h := sha256.New()
+	for _, b := range bindings {
+		fmt.Fprintf(h, "%s\x00", b.binding.BindingName())
+		keys := make([]string, 0, len(b.secret.Data))
+		for key := range b.secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(h, "%s\x00%d\x00", key, len(b.secret.Data[key]))
+			h.Write(b.secret.Data[key])
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("ServiceBindingReconciler", func() {
+	ctx := context.Background()
+
+	var (
+		reconciler    *ServiceBindingReconciler
+		appReconciler *AppReconciler
+	)
+
+	BeforeEach(func() {
+		reconciler = &ServiceBindingReconciler{
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("controllers").WithName("ServiceBinding"),
+			Scheme: scheme.Scheme,
+		}
+		appReconciler = &AppReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("App"),
+			Scheme:               scheme.Scheme,
+			Recorder:             record.NewFakeRecorder(100),
+			DefaultImageRegistry: "registry.example.com",
+		}
+	})
+
+	// bindApp creates an App with a ServiceBinding to the credentials of a Secret, and reconciles
+	// both.
+	bindApp := func(name string) (*manorv1.App, *manorv1.ServiceBinding, *corev1.Secret) {
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-db-credentials", Namespace: "default"},
+			Data: map[string][]byte{
+				bindingTypeKey: []byte("postgresql"),
+				"uri":          []byte("postgresql://db:5432"),
+			},
+		}
+		Expect(k8sClient.Create(ctx, credentials)).To(Succeed())
+		binding := &manorv1.ServiceBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-db", Namespace: "default"},
+			Spec: manorv1.ServiceBindingSpec{
+				App:      app.Name,
+				Name:     "db",
+				Provider: "bitnami",
+				Secret:   credentials.Name,
+			},
+		}
+		Expect(k8sClient.Create(ctx, binding)).To(Succeed())
+
+		reconcileUntilSettled(reconciler.Reconcile, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace})
+		reconcileUntilSettled(appReconciler.Reconcile, types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+		return app, binding, credentials
+	}
+
+	// deployment returns the Deployment of the App.
+	deployment := func(app *manorv1.App) *appsv1.Deployment {
+		deployment := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, deployment)).To(Succeed())
+		return deployment
+	}
+
+	It("projects the credentials of the Secret into the App", func() {
+		app, binding, _ := bindApp("bound")
+
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}, binding)).To(Succeed())
+		Expect(binding.Status.Secret).To(Equal("bound-db-binding"))
+		Expect(isServiceBindingReady(binding)).To(BeTrue())
+
+		projected := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: binding.Status.Secret, Namespace: "default"}, projected)).To(Succeed())
+		Expect(projected.Labels).To(Equal(map[string]string{
+			manorv1.AppLabel:            app.Name,
+			manorv1.ServiceBindingLabel: binding.Name,
+		}))
+		Expect(projected.Data).To(Equal(map[string][]byte{
+			bindingTypeKey:     []byte("postgresql"),
+			bindingProviderKey: []byte("bitnami"),
+			"uri":              []byte("postgresql://db:5432"),
+		}))
+		// The projected Secret is garbage collected with the ServiceBinding.
+		Expect(metav1.IsControlledBy(projected, binding)).To(BeTrue())
+
+		// The App maps its projected Secrets back to itself.
+		Expect(bindingSecretToApp(handler.MapObject{Meta: projected, Object: projected})).To(ConsistOf(
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: app.Name, Namespace: app.Namespace}},
+		))
+
+		spec := deployment(app).Spec.Template.Spec
+		Expect(spec.Volumes).To(ContainElement(*serviceBindingsVolume([]appServiceBinding{{binding: binding, secret: projected}})))
+		Expect(spec.Volumes[0].Projected.Sources[0].Secret.Items).To(ConsistOf(
+			corev1.KeyToPath{Key: bindingProviderKey, Path: "db/provider"},
+			corev1.KeyToPath{Key: bindingTypeKey, Path: "db/type"},
+			corev1.KeyToPath{Key: "uri", Path: "db/uri"},
+		))
+		Expect(spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+			Name:      "service-bindings",
+			MountPath: bindingsRoot,
+			ReadOnly:  true,
+		}))
+		Expect(spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "SERVICE_BINDING_ROOT", Value: bindingsRoot}))
+
+		vcapServices := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: vcapServicesSecretName(app), Namespace: app.Namespace}, vcapServices)).To(Succeed())
+		Expect(string(vcapServices.Data[vcapServicesKey])).To(MatchJSON(`{
+			"postgresql": [{
+				"name": "db",
+				"label": "postgresql",
+				"provider": "bitnami",
+				"tags": ["postgresql"],
+				"credentials": {"uri": "postgresql://db:5432"}
+			}]
+		}`))
+	})
+
+	It("rolls the App Deployment when the credentials change", func() {
+		app, binding, credentials := bindApp("rotated")
+		hash := deployment(app).Spec.Template.Annotations[bindingsHashAnnotation]
+		Expect(hash).NotTo(BeEmpty())
+
+		// The Deployment isn't rolled while the credentials are unchanged.
+		reconcileUntilSettled(reconciler.Reconcile, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace})
+		reconcileUntilSettled(appReconciler.Reconcile, types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+		Expect(deployment(app).Spec.Template.Annotations).To(HaveKeyWithValue(bindingsHashAnnotation, hash))
+
+		credentials.Data["uri"] = []byte("postgresql://other-db:5432")
+		Expect(k8sClient.Update(ctx, credentials)).To(Succeed())
+		Expect(reconciler.secretToServiceBindings(handler.MapObject{Meta: credentials, Object: credentials})).To(ContainElement(
+			ctrl.Request{NamespacedName: types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}},
+		))
+		reconcileUntilSettled(reconciler.Reconcile, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace})
+		reconcileUntilSettled(appReconciler.Reconcile, types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+
+		rolled := deployment(app).Spec.Template.Annotations[bindingsHashAnnotation]
+		Expect(rolled).NotTo(BeEmpty())
+		Expect(rolled).NotTo(Equal(hash))
+	})
+
+	It("stops projecting the credentials of a deleted ServiceBinding", func() {
+		app, binding, _ := bindApp("unbound")
+
+		Expect(k8sClient.Delete(ctx, binding)).To(Succeed())
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		reconcileUntilSettled(appReconciler.Reconcile, types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+
+		template := deployment(app).Spec.Template
+		Expect(template.Spec.Volumes).To(BeEmpty())
+		Expect(template.Spec.Containers[0].VolumeMounts).To(BeEmpty())
+		for _, env := range template.Spec.Containers[0].Env {
+			Expect(env.Name).NotTo(BeElementOf("SERVICE_BINDING_ROOT", "VCAP_SERVICES"))
+		}
+		Expect(template.Annotations).NotTo(HaveKey(bindingsHashAnnotation))
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: vcapServicesSecretName(app), Namespace: app.Namespace}, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue(), "unexpected error %v", err)
+	})
+
+	It("retries the ServiceBindings whose credentials can't be resolved", func() {
+		app, binding, credentials := bindApp("unresolved")
+
+		Expect(k8sClient.Delete(ctx, credentials)).To(Succeed())
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(30 * time.Second))
+
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}, binding)).To(Succeed())
+		Expect(binding.Status.Secret).To(BeEmpty())
+		Expect(binding.Status.Conditions).To(ConsistOf(manorv1.ServiceBindingCondition{
+			Type:    manorv1.ServiceBindingReady,
+			Status:  corev1.ConditionFalse,
+			Message: `Secret "unresolved-db-credentials" not found`,
+		}))
+
+		// The App stops projecting the credentials of a ServiceBinding that isn't ready.
+		reconcileUntilSettled(appReconciler.Reconcile, types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+		Expect(deployment(app).Spec.Template.Spec.Volumes).To(BeEmpty())
+	})
+})
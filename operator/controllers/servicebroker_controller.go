@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/osb"
+)
+
+const (
+	// catalogRefreshInterval is how often the catalogs of the brokers are fetched.
+	catalogRefreshInterval = time.Minute * 10
+	// brokerRetryInterval is how often a failed request to a broker is retried.
+	brokerRetryInterval = time.Minute
+)
+
+// ServiceBrokerReconciler reconciles a ServiceBroker object.
+type ServiceBrokerReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// SetupServiceBrokerReconciler sets up the ServiceBroker reconciler.
+func SetupServiceBrokerReconciler(mgr ctrl.Manager) error {
+	r := &ServiceBrokerReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("ServiceBroker"),
+		Scheme: mgr.GetScheme(),
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&manorv1.ServiceBroker{}).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=servicebrokers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=servicebrokers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile reconciles the ServiceBroker resources, keeping their catalog up to date.
+func (r *ServiceBrokerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	log := r.Log.WithValues("servicebroker", req.Name)
+
+	broker := &manorv1.ServiceBroker{}
+	if err := r.Get(ctx, req.NamespacedName, broker); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ServiceBroker resource deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	var catalog *osb.Catalog
+	brokerClient, err := newBrokerClient(ctx, r.Client, broker)
+	if err == nil {
+		catalog, err = brokerClient.Catalog(ctx)
+	}
+	if err != nil {
+		log.Info("Failed to fetch the catalog of the ServiceBroker", "message", err.Error())
+		if setServiceBrokerCondition(broker, manorv1.ServiceBrokerReady, corev1.ConditionFalse, err.Error()) {
+			if err := r.Status().Update(ctx, broker); err != nil {
+				log.Error(err, "Failed to update ServiceBroker status", "ServiceBroker.Name", broker.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: brokerRetryInterval}, nil
+	}
+
+	services := make([]manorv1.BrokerService, 0, len(catalog.Services))
+	for _, service := range catalog.Services {
+		plans := make([]manorv1.BrokerPlan, 0, len(service.Plans))
+		for _, plan := range service.Plans {
+			plans = append(plans, manorv1.BrokerPlan{
+				ID:          plan.ID,
+				Name:        plan.Name,
+				Description: plan.Description,
+				Free:        plan.Free,
+				Bindable:    plan.Bindable,
+			})
+		}
+		services = append(services, manorv1.BrokerService{
+			ID:          service.ID,
+			Name:        service.Name,
+			Description: service.Description,
+			Tags:        service.Tags,
+			Bindable:    service.Bindable,
+			Plans:       plans,
+		})
+	}
+	now := metav1.Now()
+	broker.Status.Services = services
+	broker.Status.CatalogFetchedAt = &now
+	setServiceBrokerCondition(broker, manorv1.ServiceBrokerReady, corev1.ConditionTrue, "")
+	if err := r.Status().Update(ctx, broker); err != nil {
+		log.Error(err, "Failed to update ServiceBroker status", "ServiceBroker.Name", broker.Name)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: catalogRefreshInterval}, nil
+}
+
+// newBrokerClient returns a client of the broker, authenticated with its credentials.
+func newBrokerClient(ctx context.Context, c client.Client, broker *manorv1.ServiceBroker) (*osb.Client, error) {
+	var username, password string
+	if ref := broker.Spec.CredentialsSecret; ref != nil {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get the credentials of the broker: %w", err)
+		}
+		username = string(secret.Data["username"])
+		password = string(secret.Data["password"])
+	}
+	return osb.NewClient(broker.Spec.URL, username, password), nil
+}
+
+// findBrokerPlan returns the service and plan with the given names in the catalog of the broker,
+// or nil when it doesn't offer them.
+func findBrokerPlan(broker *manorv1.ServiceBroker, serviceName, planName string) (*manorv1.BrokerService, *manorv1.BrokerPlan) {
+	for i := range broker.Status.Services {
+		service := &broker.Status.Services[i]
+		if service.Name != serviceName {
+			continue
+		}
+		for j := range service.Plans {
+			if service.Plans[j].Name == planName {
+				return service, &service.Plans[j]
+			}
+		}
+		return service, nil
+	}
+	return nil, nil
+}
+
+// setServiceBrokerCondition sets the status of the ServiceBroker condition, returning whether it
+// changed.
+func setServiceBrokerCondition(
+	broker *manorv1.ServiceBroker,
+	conditionType manorv1.ServiceBrokerConditionType,
+	status corev1.ConditionStatus,
+	message string,
+) bool {
+	for i, condition := range broker.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition.Status == status && condition.Message == message {
+				return false
+			}
+			broker.Status.Conditions[i].Status = status
+			broker.Status.Conditions[i].Message = message
+			return true
+		}
+	}
+	broker.Status.Conditions = append(broker.Status.Conditions, manorv1.ServiceBrokerCondition{
+		Type:    conditionType,
+		Status:  status,
+		Message: message,
+	})
+	return true
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/osb"
+	"github.com/codelogia/manor/operator/osb/fakebroker"
+)
+
+// fakeBrokerCatalog returns the catalog of the fake brokers, offering a bindable PostgreSQL
+// service.
+func fakeBrokerCatalog() osb.Catalog {
+	return osb.Catalog{
+		Services: []osb.Service{{
+			ID:          "postgresql-id",
+			Name:        "postgresql",
+			Description: "PostgreSQL databases",
+			Tags:        []string{"sql"},
+			Bindable:    true,
+			Plans: []osb.Plan{
+				{ID: "small-id", Name: "small", Description: "A small database"},
+				{ID: "unbindable-id", Name: "unbindable", Bindable: new(bool)},
+			},
+		}},
+	}
+}
+
+var _ = Describe("ServiceBrokerReconciler", func() {
+	ctx := context.Background()
+
+	var (
+		reconciler *ServiceBrokerReconciler
+		broker     *fakebroker.Broker
+		httpServer *httptest.Server
+	)
+
+	BeforeEach(func() {
+		reconciler = &ServiceBrokerReconciler{
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("controllers").WithName("ServiceBroker"),
+			Scheme: scheme.Scheme,
+		}
+		broker = fakebroker.New(fakeBrokerCatalog())
+		broker.Username = "admin"
+		broker.Password = "secret"
+		httpServer = httptest.NewServer(broker)
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	// createServiceBroker creates a ServiceBroker of the fake broker, authenticated with the
+	// password, and reconciles it.
+	createServiceBroker := func(name, password string) (*manorv1.ServiceBroker, ctrl.Result) {
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-credentials", Namespace: "default"},
+			Data: map[string][]byte{
+				"username": []byte("admin"),
+				"password": []byte(password),
+			},
+		}
+		Expect(k8sClient.Create(ctx, credentials)).To(Succeed())
+		serviceBroker := &manorv1.ServiceBroker{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: manorv1.ServiceBrokerSpec{
+				URL:               httpServer.URL,
+				CredentialsSecret: &corev1.SecretReference{Name: credentials.Name, Namespace: credentials.Namespace},
+			},
+		}
+		Expect(k8sClient.Create(ctx, serviceBroker)).To(Succeed())
+
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name}, serviceBroker)).To(Succeed())
+		return serviceBroker, result
+	}
+
+	It("fetches the catalog of the broker", func() {
+		serviceBroker, result := createServiceBroker("catalog-broker", "secret")
+
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: catalogRefreshInterval}))
+		Expect(serviceBroker.Status.Services).To(Equal([]manorv1.BrokerService{{
+			ID:          "postgresql-id",
+			Name:        "postgresql",
+			Description: "PostgreSQL databases",
+			Tags:        []string{"sql"},
+			Bindable:    true,
+			Plans: []manorv1.BrokerPlan{
+				{ID: "small-id", Name: "small", Description: "A small database"},
+				{ID: "unbindable-id", Name: "unbindable", Bindable: new(bool)},
+			},
+		}}))
+		Expect(serviceBroker.Status.CatalogFetchedAt).NotTo(BeNil())
+		Expect(serviceBroker.Status.Conditions).To(Equal([]manorv1.ServiceBrokerCondition{{
+			Type:   manorv1.ServiceBrokerReady,
+			Status: corev1.ConditionTrue,
+		}}))
+	})
+
+	It("retries fetching the catalog when the broker rejects the request", func() {
+		serviceBroker, result := createServiceBroker("unauthorized-broker", "wrong")
+
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: brokerRetryInterval}))
+		Expect(serviceBroker.Status.Services).To(BeEmpty())
+		Expect(serviceBroker.Status.CatalogFetchedAt).To(BeNil())
+		Expect(serviceBroker.Status.Conditions).To(HaveLen(1))
+		Expect(serviceBroker.Status.Conditions[0].Status).To(Equal(corev1.ConditionFalse))
+		Expect(serviceBroker.Status.Conditions[0].Message).To(ContainSubstring("invalid credentials"))
+	})
+})
@@ -0,0 +1,501 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/osb"
+	"github.com/codelogia/manor/operator/stringutil"
+)
+
+const (
+	// serviceInstanceFinalizer is the finalizer deprovisioning the service instances in their broker.
+	serviceInstanceFinalizer = "manor.codelogia.com/service-instance"
+
+	// operationPollInterval is how often the asynchronous operations of the brokers are polled.
+	operationPollInterval = time.Second * 5
+)
+
+// ServiceInstanceReconciler reconciles a ServiceInstance object.
+type ServiceInstanceReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// SetupServiceInstanceReconciler sets up the ServiceInstance reconciler.
+func SetupServiceInstanceReconciler(mgr ctrl.Manager) error {
+	r := &ServiceInstanceReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("ServiceInstance"),
+		Scheme: mgr.GetScheme(),
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&manorv1.ServiceInstance{}).
+		Owns(&corev1.Secret{}).
+		Watches(
+			&source.Kind{Type: &manorv1.ServiceBroker{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.brokerToServiceInstances)},
+		).
+		Complete(r)
+}
+
+// brokerToServiceInstances maps a ServiceBroker to the ServiceInstances of its services.
+func (r *ServiceInstanceReconciler) brokerToServiceInstances(obj handler.MapObject) []reconcile.Request {
+	instances := &manorv1.ServiceInstanceList{}
+	if err := r.List(context.Background(), instances); err != nil {
+		r.Log.Error(err, "Failed to list ServiceInstances", "ServiceBroker.Name", obj.Meta.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, instance := range instances.Items {
+		if instance.Spec.Broker == obj.Meta.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=serviceinstances,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=serviceinstances/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=servicebrokers,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile reconciles the ServiceInstance resources. The instance is provisioned in the broker,
+// using the UID of the ServiceInstance as the instance and binding IDs, and bound once it's
+// provisioned if its plan is bindable. The credentials are kept in a Secret owned by the
+// ServiceInstance.
+func (r *ServiceInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	log := r.Log.WithValues("serviceinstance", req.NamespacedName)
+
+	instance := &manorv1.ServiceInstance{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ServiceInstance resource deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	broker := &manorv1.ServiceBroker{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instance.Spec.Broker}, broker); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if instance.DeletionTimestamp != nil {
+			// Without its broker, the instance can't be deprovisioned.
+			log.Info("ServiceBroker not found, leaving the service instance in the broker", "ServiceBroker.Name", instance.Spec.Broker)
+			return ctrl.Result{}, r.removeFinalizer(ctx, log, instance)
+		}
+		message := fmt.Sprintf("ServiceBroker %q not found", instance.Spec.Broker)
+		return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, message)
+	}
+	brokerClient, err := newBrokerClient(ctx, r.Client, broker)
+	if err != nil {
+		return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, err.Error())
+	}
+
+	if instance.DeletionTimestamp != nil {
+		if !stringutil.Contains(instance.Finalizers, serviceInstanceFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		return r.finalize(ctx, log, brokerClient, instance)
+	}
+
+	if !stringutil.Contains(instance.Finalizers, serviceInstanceFinalizer) {
+		instance.Finalizers = append(instance.Finalizers, serviceInstanceFinalizer)
+		if err := r.Update(ctx, instance); err != nil {
+			log.Error(
+				err, "Failed to add ServiceInstance finalizer",
+				"ServiceInstance.Namespace", instance.Namespace,
+				"ServiceInstance.Name", instance.Name,
+			)
+			return ctrl.Result{}, err
+		}
+		// Do not requeue as the instance update will trigger another event.
+		return ctrl.Result{}, nil
+	}
+
+	if instance.Status.Operation != nil {
+		return r.pollOperation(ctx, log, brokerClient, instance)
+	}
+
+	service, plan := findBrokerPlan(broker, instance.Spec.Service, instance.Spec.Plan)
+	if service == nil || plan == nil {
+		message := fmt.Sprintf("ServiceBroker %q doesn't offer the plan %q of the service %q", broker.Name, instance.Spec.Plan, instance.Spec.Service)
+		return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, message)
+	}
+
+	if !instance.Status.Provisioned {
+		return r.provision(ctx, log, brokerClient, instance, service.ID, plan.ID)
+	}
+
+	if service.ID != instance.Status.ServiceID || plan.ID != instance.Status.PlanID {
+		message := "the service and plan of a provisioned instance can't be changed"
+		return ctrl.Result{}, r.setNotReady(ctx, log, instance, message)
+	}
+
+	bindable := service.Bindable
+	if plan.Bindable != nil {
+		bindable = *plan.Bindable
+	}
+	if bindable {
+		if err := r.reconcileBinding(ctx, log, brokerClient, instance, broker.Name); err != nil {
+			message := fmt.Sprintf("failed to bind the instance: %v", err)
+			return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, message)
+		}
+	}
+
+	if setServiceInstanceCondition(instance, manorv1.ServiceInstanceReady, corev1.ConditionTrue, "") {
+		if err := r.updateStatus(ctx, log, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// provision provisions the instance in the broker.
+func (r *ServiceInstanceReconciler) provision(
+	ctx context.Context,
+	log logr.Logger,
+	brokerClient *osb.Client,
+	instance *manorv1.ServiceInstance,
+	serviceID, planID string,
+) (ctrl.Result, error) {
+	log.Info(
+		"Provisioning service instance",
+		"ServiceInstance.Namespace", instance.Namespace,
+		"ServiceInstance.Name", instance.Name,
+	)
+
+	req := osb.ProvisionRequest{
+		ServiceID: serviceID,
+		PlanID:    planID,
+		Context: map[string]interface{}{
+			"platform":      "kubernetes",
+			"namespace":     instance.Namespace,
+			"instance_name": instance.Name,
+		},
+	}
+	if instance.Spec.Parameters != nil {
+		req.Parameters = json.RawMessage(instance.Spec.Parameters.Raw)
+	}
+	res, err := brokerClient.Provision(ctx, string(instance.UID), req)
+	if err != nil {
+		log.Info("Failed to provision service instance", "message", err.Error())
+		return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, err.Error())
+	}
+
+	instance.Status.ServiceID = serviceID
+	instance.Status.PlanID = planID
+	instance.Status.DashboardURL = res.DashboardURL
+	if res.Async {
+		instance.Status.Operation = &manorv1.ServiceInstanceOperation{
+			Type: manorv1.OperationProvision,
+			ID:   res.Operation,
+		}
+		setServiceInstanceCondition(instance, manorv1.ServiceInstanceReady, corev1.ConditionFalse, "provisioning")
+	} else {
+		instance.Status.Provisioned = true
+	}
+	if err := r.updateStatus(ctx, log, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if res.Async {
+		return ctrl.Result{RequeueAfter: operationPollInterval}, nil
+	}
+	// Do not requeue as the instance update will trigger another event.
+	return ctrl.Result{}, nil
+}
+
+// pollOperation polls the asynchronous operation in progress on the instance, recording its
+// outcome once it's completed.
+func (r *ServiceInstanceReconciler) pollOperation(
+	ctx context.Context,
+	log logr.Logger,
+	brokerClient *osb.Client,
+	instance *manorv1.ServiceInstance,
+) (ctrl.Result, error) {
+	operation := instance.Status.Operation
+	lastOperation, err := brokerClient.LastOperation(ctx, string(instance.UID), osb.LastOperationRequest{
+		ServiceID: instance.Status.ServiceID,
+		PlanID:    instance.Status.PlanID,
+		Operation: operation.ID,
+	})
+	switch {
+	case goerrors.Is(err, osb.ErrGone) && operation.Type == manorv1.OperationDeprovision:
+		lastOperation = &osb.LastOperation{State: osb.StateSucceeded}
+	case goerrors.Is(err, osb.ErrGone):
+		lastOperation = &osb.LastOperation{State: osb.StateFailed, Description: "the instance is gone"}
+	case err != nil:
+		log.Info("Failed to poll the operation of the service instance", "message", err.Error())
+		return ctrl.Result{RequeueAfter: operationPollInterval}, nil
+	}
+	if lastOperation.State == osb.StateInProgress {
+		return ctrl.Result{RequeueAfter: operationPollInterval}, nil
+	}
+
+	log.Info(
+		"Operation of service instance completed",
+		"operation", operation.Type,
+		"state", lastOperation.State,
+		"ServiceInstance.Namespace", instance.Namespace,
+		"ServiceInstance.Name", instance.Name,
+	)
+	instance.Status.Operation = nil
+	if lastOperation.State == osb.StateSucceeded {
+		// A deprovisioned instance is finalized in the next reconcile.
+		instance.Status.Provisioned = operation.Type == manorv1.OperationProvision
+		if operation.Type == manorv1.OperationDeprovision {
+			instance.Status.ServiceID = ""
+			instance.Status.PlanID = ""
+		}
+		if err := r.updateStatus(ctx, log, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		// Do not requeue as the instance update will trigger another event.
+		return ctrl.Result{}, nil
+	}
+
+	message := fmt.Sprintf("%s failed", operation.Type)
+	if lastOperation.Description != "" {
+		message += ": " + lastOperation.Description
+	}
+	if operation.Type == manorv1.OperationProvision {
+		instance.Status.Provisioned = false
+	}
+	setServiceInstanceCondition(instance, manorv1.ServiceInstanceReady, corev1.ConditionFalse, message)
+	if err := r.updateStatus(ctx, log, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	// The failed operation is retried.
+	return ctrl.Result{RequeueAfter: brokerRetryInterval}, nil
+}
+
+// reconcileBinding binds the instance, keeping its credentials in its binding Secret, unless the
+// Secret exists already.
+func (r *ServiceInstanceReconciler) reconcileBinding(
+	ctx context.Context,
+	log logr.Logger,
+	brokerClient *osb.Client,
+	instance *manorv1.ServiceInstance,
+	brokerName string,
+) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceInstanceSecretName(instance),
+			Namespace: instance.Namespace,
+		},
+	}
+	err := r.Get(ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && instance.Status.Binding != nil {
+		return nil
+	}
+
+	log.Info(
+		"Binding service instance",
+		"ServiceInstance.Namespace", instance.Namespace,
+		"ServiceInstance.Name", instance.Name,
+	)
+	res, err := brokerClient.Bind(ctx, string(instance.UID), string(instance.UID), osb.BindRequest{
+		ServiceID: instance.Status.ServiceID,
+		PlanID:    instance.Status.PlanID,
+		Context: map[string]interface{}{
+			"platform":  "kubernetes",
+			"namespace": instance.Namespace,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string][]byte, len(res.Credentials)+2)
+	for key, value := range res.Credentials {
+		if s, ok := value.(string); ok {
+			data[key] = []byte(s)
+			continue
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		data[key] = b
+	}
+	if _, ok := data[bindingTypeKey]; !ok {
+		data[bindingTypeKey] = []byte(instance.Spec.Service)
+	}
+	if _, ok := data[bindingProviderKey]; !ok {
+		data[bindingProviderKey] = []byte(brokerName)
+	}
+	if err := applyOwned(ctx, r.Client, r.Scheme, log, instance, secret, func() {
+		secret.Labels = map[string]string{manorv1.ServiceInstanceLabel: instance.Name}
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = data
+	}); err != nil {
+		return err
+	}
+
+	instance.Status.Binding = &corev1.LocalObjectReference{Name: secret.Name}
+	return r.updateStatus(ctx, log, instance)
+}
+
+// finalize unbinds and deprovisions the instance, removing the finalizer once it's gone from the
+// broker.
+func (r *ServiceInstanceReconciler) finalize(
+	ctx context.Context,
+	log logr.Logger,
+	brokerClient *osb.Client,
+	instance *manorv1.ServiceInstance,
+) (ctrl.Result, error) {
+	if instance.Status.Operation != nil {
+		return r.pollOperation(ctx, log, brokerClient, instance)
+	}
+
+	if instance.Status.Binding != nil {
+		log.Info(
+			"Unbinding service instance",
+			"ServiceInstance.Namespace", instance.Namespace,
+			"ServiceInstance.Name", instance.Name,
+		)
+		err := brokerClient.Unbind(ctx, string(instance.UID), string(instance.UID), instance.Status.ServiceID, instance.Status.PlanID)
+		if err != nil {
+			return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, err.Error())
+		}
+		instance.Status.Binding = nil
+		if err := r.updateStatus(ctx, log, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// An instance whose provisioning was never accepted doesn't exist in the broker.
+	if instance.Status.ServiceID == "" {
+		return ctrl.Result{}, r.removeFinalizer(ctx, log, instance)
+	}
+
+	log.Info(
+		"Deprovisioning service instance",
+		"ServiceInstance.Namespace", instance.Namespace,
+		"ServiceInstance.Name", instance.Name,
+	)
+	res, err := brokerClient.Deprovision(ctx, string(instance.UID), instance.Status.ServiceID, instance.Status.PlanID)
+	if err != nil {
+		return ctrl.Result{RequeueAfter: brokerRetryInterval}, r.setNotReady(ctx, log, instance, err.Error())
+	}
+	if !res.Async {
+		return ctrl.Result{}, r.removeFinalizer(ctx, log, instance)
+	}
+	instance.Status.Operation = &manorv1.ServiceInstanceOperation{
+		Type: manorv1.OperationDeprovision,
+		ID:   res.Operation,
+	}
+	setServiceInstanceCondition(instance, manorv1.ServiceInstanceReady, corev1.ConditionFalse, "deprovisioning")
+	if err := r.updateStatus(ctx, log, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: operationPollInterval}, nil
+}
+
+func (r *ServiceInstanceReconciler) removeFinalizer(ctx context.Context, log logr.Logger, instance *manorv1.ServiceInstance) error {
+	instance.Finalizers = stringutil.Remove(instance.Finalizers, serviceInstanceFinalizer)
+	if err := r.Update(ctx, instance); err != nil && !errors.IsNotFound(err) {
+		log.Error(
+			err, "Failed to remove ServiceInstance finalizer",
+			"ServiceInstance.Namespace", instance.Namespace,
+			"ServiceInstance.Name", instance.Name,
+		)
+		return err
+	}
+	return nil
+}
+
+// setNotReady records why the ServiceInstance is not ready in its status.
+func (r *ServiceInstanceReconciler) setNotReady(ctx context.Context, log logr.Logger, instance *manorv1.ServiceInstance, message string) error {
+	if !setServiceInstanceCondition(instance, manorv1.ServiceInstanceReady, corev1.ConditionFalse, message) {
+		return nil
+	}
+	return r.updateStatus(ctx, log, instance)
+}
+
+func (r *ServiceInstanceReconciler) updateStatus(ctx context.Context, log logr.Logger, instance *manorv1.ServiceInstance) error {
+	if err := r.Status().Update(ctx, instance); err != nil {
+		log.Error(
+			err, "Failed to update ServiceInstance status",
+			"ServiceInstance.Namespace", instance.Namespace,
+			"ServiceInstance.Name", instance.Name,
+		)
+		return err
+	}
+	return nil
+}
+
+// setServiceInstanceCondition sets the status of the ServiceInstance condition, returning whether
+// it changed.
+func setServiceInstanceCondition(
+	instance *manorv1.ServiceInstance,
+	conditionType manorv1.ServiceInstanceConditionType,
+	status corev1.ConditionStatus,
+	message string,
+) bool {
+	for i, condition := range instance.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition.Status == status && condition.Message == message {
+				return false
+			}
+			instance.Status.Conditions[i].Status = status
+			instance.Status.Conditions[i].Message = message
+			return true
+		}
+	}
+	instance.Status.Conditions = append(instance.Status.Conditions, manorv1.ServiceInstanceCondition{
+		Type:    conditionType,
+		Status:  status,
+		Message: message,
+	})
+	return true
+}
+
+// serviceInstanceSecretName returns the name of the Secret holding the credentials of the
+// ServiceInstance.
+func serviceInstanceSecretName(instance *manorv1.ServiceInstance) string {
+	return instance.Name + "-credentials"
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	"github.com/codelogia/manor/operator/osb/fakebroker"
+)
+
+var _ = Describe("ServiceInstanceReconciler", func() {
+	ctx := context.Background()
+
+	var (
+		reconciler *ServiceInstanceReconciler
+		broker     *fakebroker.Broker
+		httpServer *httptest.Server
+	)
+
+	BeforeEach(func() {
+		reconciler = &ServiceInstanceReconciler{
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("controllers").WithName("ServiceInstance"),
+			Scheme: scheme.Scheme,
+		}
+		broker = fakebroker.New(fakeBrokerCatalog())
+		httpServer = httptest.NewServer(broker)
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	// reconcileInstance reconciles the ServiceInstance once, refreshing it.
+	reconcileInstance := func(instance *manorv1.ServiceInstance) ctrl.Result {
+		key := types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		current := &manorv1.ServiceInstance{}
+		Expect(k8sClient.Get(ctx, key, current)).To(Succeed())
+		*instance = *current
+		return result
+	}
+
+	// createInstance creates a ServiceInstance of the small PostgreSQL plan, with a ServiceBroker
+	// of the fake broker whose catalog is fetched, and reconciles it to add its finalizer.
+	createInstance := func(name string) *manorv1.ServiceInstance {
+		serviceBroker := &manorv1.ServiceBroker{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-broker"},
+			Spec:       manorv1.ServiceBrokerSpec{URL: httpServer.URL},
+		}
+		Expect(k8sClient.Create(ctx, serviceBroker)).To(Succeed())
+		brokerReconciler := &ServiceBrokerReconciler{
+			Client: k8sClient,
+			Log:    ctrl.Log.WithName("controllers").WithName("ServiceBroker"),
+			Scheme: scheme.Scheme,
+		}
+		_, err := brokerReconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: serviceBroker.Name}})
+		Expect(err).NotTo(HaveOccurred())
+
+		instance := &manorv1.ServiceInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: manorv1.ServiceInstanceSpec{
+				Broker:  serviceBroker.Name,
+				Service: "postgresql",
+				Plan:    "small",
+			},
+		}
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Finalizers).To(ConsistOf(serviceInstanceFinalizer))
+		return instance
+	}
+
+	It("provisions and binds the instance in the broker", func() {
+		instance := createInstance("sync-db")
+		id := string(instance.UID)
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Provisioned).To(BeTrue())
+		Expect(instance.Status.ServiceID).To(Equal("postgresql-id"))
+		Expect(instance.Status.PlanID).To(Equal("small-id"))
+		Expect(instance.Status.DashboardURL).To(Equal("http://dashboard.fake/" + id))
+		brokerInstance, ok := broker.Instance(id)
+		Expect(ok).To(BeTrue())
+		Expect(brokerInstance.Provisioned).To(BeTrue())
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Binding).To(Equal(&corev1.LocalObjectReference{Name: "sync-db-credentials"}))
+		Expect(instance.Status.Conditions).To(Equal([]manorv1.ServiceInstanceCondition{{
+			Type:   manorv1.ServiceInstanceReady,
+			Status: corev1.ConditionTrue,
+		}}))
+		brokerInstance, _ = broker.Instance(id)
+		Expect(brokerInstance.Bindings).To(HaveKey(id))
+
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "sync-db-credentials", Namespace: "default"}, secret)).To(Succeed())
+		Expect(secret.Labels).To(Equal(map[string]string{manorv1.ServiceInstanceLabel: "sync-db"}))
+		Expect(secret.Data).To(HaveKeyWithValue("uri", []byte("fake://postgresql/"+id)))
+		Expect(secret.Data).To(HaveKeyWithValue("username", []byte(id)))
+		Expect(secret.Data).To(HaveKeyWithValue("port", []byte("5432")))
+		Expect(secret.Data).To(HaveKeyWithValue(bindingTypeKey, []byte("postgresql")))
+		Expect(secret.Data).To(HaveKeyWithValue(bindingProviderKey, []byte("sync-db-broker")))
+		Expect(metav1.IsControlledBy(secret, instance)).To(BeTrue())
+	})
+
+	It("polls the asynchronous provisioning until it completes", func() {
+		broker.Async = true
+		broker.PollsToComplete = 2
+		instance := createInstance("async-db")
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		Expect(instance.Status.Provisioned).To(BeFalse())
+		Expect(instance.Status.Operation).To(Equal(&manorv1.ServiceInstanceOperation{Type: manorv1.OperationProvision, ID: "op-1"}))
+		Expect(instance.Status.Conditions).To(Equal([]manorv1.ServiceInstanceCondition{{
+			Type:    manorv1.ServiceInstanceReady,
+			Status:  corev1.ConditionFalse,
+			Message: "provisioning",
+		}}))
+
+		// The first poll finds the operation in progress.
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		Expect(instance.Status.Operation).NotTo(BeNil())
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Operation).To(BeNil())
+		Expect(instance.Status.Provisioned).To(BeTrue())
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Binding).NotTo(BeNil())
+		Expect(instance.Status.Conditions[0].Status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("reports the failed asynchronous provisioning", func() {
+		broker.Async = true
+		broker.PollsToComplete = 1
+		broker.FailProvision = true
+		instance := createInstance("failed-db")
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{RequeueAfter: brokerRetryInterval}))
+		Expect(instance.Status.Operation).To(BeNil())
+		Expect(instance.Status.Provisioned).To(BeFalse())
+		Expect(instance.Status.Conditions).To(Equal([]manorv1.ServiceInstanceCondition{{
+			Type:    manorv1.ServiceInstanceReady,
+			Status:  corev1.ConditionFalse,
+			Message: "Provision failed: provision failed",
+		}}))
+		_, ok := broker.Instance(string(instance.UID))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("unbinds and deprovisions the deleted instance", func() {
+		broker.Async = true
+		broker.PollsToComplete = 1
+		instance := createInstance("deleted-db")
+		id := string(instance.UID)
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Binding).NotTo(BeNil())
+
+		Expect(k8sClient.Delete(ctx, instance)).To(Succeed())
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		Expect(instance.Status.Binding).To(BeNil())
+		Expect(instance.Status.Operation).To(Equal(&manorv1.ServiceInstanceOperation{Type: manorv1.OperationDeprovision, ID: "op-2"}))
+		Expect(instance.Finalizers).To(ConsistOf(serviceInstanceFinalizer))
+		brokerInstance, ok := broker.Instance(id)
+		Expect(ok).To(BeTrue())
+		Expect(brokerInstance.Bindings).To(BeEmpty())
+
+		Expect(reconcileInstance(instance)).To(Equal(ctrl.Result{}))
+		Expect(instance.Status.Operation).To(BeNil())
+		Expect(instance.Status.ServiceID).To(BeEmpty())
+		_, ok = broker.Instance(id)
+		Expect(ok).To(BeFalse())
+
+		// The finalizer is removed once the instance is gone from the broker.
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}})
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, instance)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,387 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+const (
+	// spaceQuotaName is the name of the ResourceQuota of a Space.
+	spaceQuotaName = "manor-quota"
+	// spaceLimitRangeName is the name of the LimitRange of a Space.
+	spaceLimitRangeName = "manor-defaults"
+	// spaceNetworkPolicyName is the name of the NetworkPolicy isolating a Space.
+	spaceNetworkPolicyName = "manor-isolation"
+)
+
+// spaceRoles maps the roles of the members of a Space to the ClusterRoles they're bound to. The
+// default ClusterRoles are aggregated with the permissions on the manor resources.
+var spaceRoles = []struct {
+	role        manorv1.SpaceRole
+	clusterRole string
+}{
+	{manorv1.SpaceAdmin, "admin"},
+	{manorv1.SpaceDeveloper, "edit"},
+	{manorv1.SpaceViewer, "view"},
+}
+
+// SpaceReconciler reconciles a Space object.
+type SpaceReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// SystemNamespaces are the namespaces always allowed to reach the Pods of isolated Spaces: the
+	// namespace of manor, whose operator and API server reach the app-builders, and of the router.
+	SystemNamespaces []string
+}
+
+// SetupSpaceReconciler sets up the Space reconciler.
+func SetupSpaceReconciler(mgr ctrl.Manager, systemNamespaces []string) error {
+	r := &SpaceReconciler{
+		Client:           mgr.GetClient(),
+		Log:              ctrl.Log.WithName("controllers").WithName("Space"),
+		Scheme:           mgr.GetScheme(),
+		SystemNamespaces: systemNamespaces,
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&manorv1.Space{}).
+		Owns(&corev1.Namespace{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Owns(&corev1.ResourceQuota{}).
+		Owns(&corev1.LimitRange{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(
+			&source.Kind{Type: &manorv1.Organization{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.organizationToSpaces)},
+		).
+		Complete(r)
+}
+
+// organizationToSpaces maps an Organization to its Spaces, so they're granted to its members.
+func (r *SpaceReconciler) organizationToSpaces(obj handler.MapObject) []reconcile.Request {
+	spaces := &manorv1.SpaceList{}
+	if err := r.List(context.Background(), spaces); err != nil {
+		r.Log.Error(err, "Failed to list Spaces", "Organization.Name", obj.Meta.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, space := range spaces.Items {
+		if space.Spec.Organization == obj.Meta.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: space.Name}})
+		}
+	}
+	return requests
+}
+
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=spaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=manor.codelogia.com,resources=spaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=limitranges,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=bind,resourceNames=admin;edit;view
+
+// Reconcile reconciles the Space resources.
+func (r *SpaceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	log := r.Log.WithValues("space", req.Name)
+
+	space := &manorv1.Space{}
+	if err := r.Get(ctx, req.NamespacedName, space); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Space resource deleted")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	organization := &manorv1.Organization{}
+	if err := r.Get(ctx, types.NamespacedName{Name: space.Spec.Organization}, organization); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Organization not found", "Organization.Name", space.Spec.Organization)
+			// Do not requeue as creating the Organization will trigger another event.
+			return ctrl.Result{}, r.setNotReady(ctx, log, space, fmt.Sprintf("organization %q not found", space.Spec.Organization))
+		}
+		return ctrl.Result{}, err
+	}
+
+	// The namespace of another tenant, or of the platform, is never taken over.
+	currentNamespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: space.Name}, currentNamespace); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	} else if !metav1.IsControlledBy(currentNamespace, space) {
+		log.Info("Namespace is not owned by the Space", "Namespace.Name", space.Name)
+		return ctrl.Result{}, r.setNotReady(ctx, log, space, fmt.Sprintf("namespace %q already exists", space.Name))
+	}
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: space.Name}}
+	if err := r.apply(ctx, log, space, namespace, func() {
+		if namespace.Labels == nil {
+			namespace.Labels = make(map[string]string)
+		}
+		namespace.Labels[manorv1.OrganizationLabel] = organization.Name
+		namespace.Labels[manorv1.SpaceLabel] = space.Name
+		// Kubernetes only sets the name label itself from 1.21 on, and the NetworkPolicies of the
+		// Apps select the namespaces by it.
+		namespace.Labels[namespaceNameLabel] = space.Name
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+	if namespace.Status.Phase == corev1.NamespaceTerminating {
+		log.Info("Namespace is terminating, retrying...", "Namespace.Name", namespace.Name)
+		return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+	}
+
+	if err := r.reconcileRoleBindings(ctx, log, space, organization); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	quota := &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: spaceQuotaName, Namespace: namespace.Name}}
+	if len(space.Spec.Quota) > 0 {
+		if err := r.apply(ctx, log, space, quota, func() {
+			quota.Spec.Hard = space.Spec.Quota
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if err := r.deleteOwned(ctx, log, space, quota); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	limitRange := &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: spaceLimitRangeName, Namespace: namespace.Name}}
+	if defaults := space.Spec.DefaultResources; defaults != nil {
+		if err := r.apply(ctx, log, space, limitRange, func() {
+			limitRange.Spec.Limits = []corev1.LimitRangeItem{{
+				Type:           corev1.LimitTypeContainer,
+				Default:        defaults.Limits,
+				DefaultRequest: defaults.Requests,
+			}}
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if err := r.deleteOwned(ctx, log, space, limitRange); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	networkPolicy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: spaceNetworkPolicyName, Namespace: namespace.Name}}
+	if isolated := space.Spec.Network.Isolated; isolated == nil || *isolated {
+		// The Pods of the Space accept the traffic from the Pods of the Space, of the system
+		// namespaces and of the allowed namespaces only.
+		peers := []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}
+		if len(r.SystemNamespaces) > 0 {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      namespaceNameLabel,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   r.SystemNamespaces,
+				}},
+			}})
+		}
+		for i := range space.Spec.Network.AllowedNamespaces {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &space.Spec.Network.AllowedNamespaces[i]})
+		}
+		if err := r.apply(ctx, log, space, networkPolicy, func() {
+			networkPolicy.Spec = networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: peers}},
+			}
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else if err := r.deleteOwned(ctx, log, space, networkPolicy); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var usage corev1.ResourceList
+	if len(space.Spec.Quota) > 0 {
+		usage = quota.Status.Used
+	}
+	apps := &manorv1.AppList{}
+	if err := r.List(ctx, apps, client.InNamespace(namespace.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	statusChanged := setSpaceCondition(space, manorv1.SpaceReady, corev1.ConditionTrue, "")
+	if space.Status.Namespace != namespace.Name ||
+		space.Status.Apps != int32(len(apps.Items)) ||
+		!equalResourceLists(space.Status.Usage, usage) {
+		space.Status.Namespace = namespace.Name
+		space.Status.Apps = int32(len(apps.Items))
+		space.Status.Usage = usage
+		statusChanged = true
+	}
+	if statusChanged {
+		if err := r.Status().Update(ctx, space); err != nil {
+			log.Error(err, "Failed to update Space status", "Space.Name", space.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The Apps of the Space are counted periodically rather than watched.
+	return ctrl.Result{RequeueAfter: time.Second * 30}, nil
+}
+
+// reconcileRoleBindings binds the members of the Organization and the Space to the ClusterRole of
+// their role in the namespace of the Space, with a RoleBinding per role.
+func (r *SpaceReconciler) reconcileRoleBindings(
+	ctx context.Context,
+	log logr.Logger,
+	space *manorv1.Space,
+	organization *manorv1.Organization,
+) error {
+	subjects := make(map[manorv1.SpaceRole][]rbacv1.Subject)
+	seen := make(map[manorv1.SpaceRole]map[rbacv1.Subject]bool)
+	members := append(append([]manorv1.Member{}, organization.Spec.Members...), space.Spec.Members...)
+	for _, member := range members {
+		role := member.Role
+		if role == "" {
+			role = manorv1.SpaceDeveloper
+		}
+		subject := memberSubject(member, space.Name)
+		if seen[role] == nil {
+			seen[role] = make(map[rbacv1.Subject]bool)
+		}
+		if seen[role][subject] {
+			continue
+		}
+		seen[role][subject] = true
+		subjects[role] = append(subjects[role], subject)
+	}
+
+	for _, spaceRole := range spaceRoles {
+		roleBinding := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "manor-" + strings.ToLower(string(spaceRole.role)),
+				Namespace: space.Name,
+			},
+		}
+		if len(subjects[spaceRole.role]) == 0 {
+			if err := r.deleteOwned(ctx, log, space, roleBinding); err != nil {
+				return err
+			}
+			continue
+		}
+		clusterRole := spaceRole.clusterRole
+		if err := r.apply(ctx, log, space, roleBinding, func() {
+			roleBinding.Subjects = subjects[spaceRole.role]
+			roleBinding.RoleRef = rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     clusterRole,
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memberSubject returns the RBAC subject of a member of the Space.
+func memberSubject(member manorv1.Member, namespace string) rbacv1.Subject {
+	switch member.Kind {
+	case manorv1.MemberServiceAccount:
+		if member.Namespace != "" {
+			namespace = member.Namespace
+		}
+		return rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: member.Name, Namespace: namespace}
+	case manorv1.MemberGroup:
+		return rbacv1.Subject{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: member.Name}
+	default:
+		return rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: member.Name}
+	}
+}
+
+// apply creates or updates an object owned by the Space, mutate setting its desired state.
+func (r *SpaceReconciler) apply(ctx context.Context, log logr.Logger, space *manorv1.Space, obj object, mutate func()) error {
+	return applyOwned(ctx, r.Client, r.Scheme, log, space, obj, mutate)
+}
+
+// deleteOwned deletes an object if it exists and is owned by the Space.
+func (r *SpaceReconciler) deleteOwned(ctx context.Context, log logr.Logger, space *manorv1.Space, obj object) error {
+	return deleteOwned(ctx, r.Client, log, space, obj)
+}
+
+// setNotReady records why the Space is not ready in its status.
+func (r *SpaceReconciler) setNotReady(ctx context.Context, log logr.Logger, space *manorv1.Space, message string) error {
+	if !setSpaceCondition(space, manorv1.SpaceReady, corev1.ConditionFalse, message) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, space); err != nil {
+		log.Error(err, "Failed to update Space status", "Space.Name", space.Name)
+		return err
+	}
+	return nil
+}
+
+// setSpaceCondition sets the status of the Space condition, returning whether it changed.
+func setSpaceCondition(space *manorv1.Space, conditionType manorv1.SpaceConditionType, status corev1.ConditionStatus, message string) bool {
+	for i, condition := range space.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition.Status == status && condition.Message == message {
+				return false
+			}
+			space.Status.Conditions[i].Status = status
+			space.Status.Conditions[i].Message = message
+			return true
+		}
+	}
+	space.Status.Conditions = append(space.Status.Conditions, manorv1.SpaceCondition{
+		Type:    conditionType,
+		Status:  status,
+		Message: message,
+	})
+	return true
+}
+
+func equalResourceLists(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, quantity := range a {
+		other, ok := b[name]
+		if !ok || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("SpaceReconciler", func() {
+	ctx := context.Background()
+	var reconciler *SpaceReconciler
+
+	BeforeEach(func() {
+		reconciler = &SpaceReconciler{
+			Client:           k8sClient,
+			Log:              ctrl.Log.WithName("controllers").WithName("Space"),
+			Scheme:           scheme.Scheme,
+			SystemNamespaces: []string{"manor-system", "ingress-nginx"},
+		}
+	})
+
+	// createSpace creates a Space of a new Organization and reconciles it.
+	createSpace := func(name string, network manorv1.SpaceNetwork) {
+		organization := &manorv1.Organization{ObjectMeta: metav1.ObjectMeta{Name: name + "-org"}}
+		Expect(k8sClient.Create(ctx, organization)).To(Succeed())
+		space := &manorv1.Space{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       manorv1.SpaceSpec{Organization: organization.Name, Network: network},
+		}
+		Expect(k8sClient.Create(ctx, space)).To(Succeed())
+		reconcileUntilSettled(reconciler.Reconcile, types.NamespacedName{Name: name})
+	}
+
+	It("isolates the Space, letting the system namespaces reach its Pods", func() {
+		createSpace("isolated", manorv1.SpaceNetwork{
+			AllowedNamespaces: []metav1.LabelSelector{{MatchLabels: map[string]string{"team": "monitoring"}}},
+		})
+
+		networkPolicy := &networkingv1.NetworkPolicy{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: spaceNetworkPolicyName, Namespace: "isolated"}, networkPolicy)).To(Succeed())
+		Expect(networkPolicy.Spec.PodSelector).To(Equal(metav1.LabelSelector{}))
+		Expect(networkPolicy.Spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeIngress}))
+		Expect(networkPolicy.Spec.Ingress).To(HaveLen(1))
+		Expect(networkPolicy.Spec.Ingress[0].From).To(Equal([]networkingv1.NetworkPolicyPeer{
+			{PodSelector: &metav1.LabelSelector{}},
+			{NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      namespaceNameLabel,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   []string{"manor-system", "ingress-nginx"},
+				}},
+			}},
+			{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "monitoring"}}},
+		}))
+
+		namespace := &corev1.Namespace{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "isolated"}, namespace)).To(Succeed())
+		Expect(namespace.Labels).To(HaveKeyWithValue(manorv1.SpaceLabel, "isolated"))
+		Expect(namespace.Labels).To(HaveKeyWithValue(namespaceNameLabel, "isolated"))
+	})
+
+	It("doesn't isolate the Space when its isolation is disabled", func() {
+		isolated := false
+		createSpace("open", manorv1.SpaceNetwork{Isolated: &isolated})
+
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: spaceNetworkPolicyName, Namespace: "open"}, &networkingv1.NetworkPolicy{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
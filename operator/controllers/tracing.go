@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+// The reconcilers trace the changes they make for a push in the trace of the push, which the CLI
+// propagates through the annotations of the Artifact.
+
+// traced runs the operation on the object in a span with the given name, e.g. Deployment create.
+func traced(ctx context.Context, name string, obj metav1.Object, op func(context.Context) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, name,
+		trace.WithAttributes(attribute.String("manor.object", obj.GetNamespace()+"/"+obj.GetName())))
+	err := op(ctx)
+	tracing.End(span, err)
+	return err
+}
+
+// updateStatus updates the status of the Artifact.
+func (r *ArtifactReconciler) updateStatus(ctx context.Context, artifact *manorv1.Artifact) error {
+	return traced(ctx, "Artifact status update", artifact, func(ctx context.Context) error {
+		return r.Status().Update(ctx, artifact)
+	})
+}
+
+// traceRollout records the span of the rollout of the Artifact to the App, from the start of the
+// rollout until the App became ready with the Artifact.
+func traceRollout(ctx context.Context, app *manorv1.App, artifact *manorv1.Artifact, now time.Time) {
+	var startedAt time.Time
+	switch rollout := app.Status.Rollout; {
+	case rollout != nil && rollout.Artifact == artifact.Name:
+		startedAt = rollout.StartedAt.Time
+	case app.Status.DeployedAt != nil:
+		// The Artifact was rolled out directly when it was deployed.
+		startedAt = app.Status.DeployedAt.Time
+	default:
+		return
+	}
+	_, span := tracing.Tracer().Start(ctx, "App rollout",
+		trace.WithTimestamp(startedAt),
+		trace.WithAttributes(
+			attribute.String("manor.app", app.Namespace+"/"+app.Name),
+			attribute.String("manor.artifact", artifact.Name),
+			attribute.String("manor.strategy", string(appStrategyType(app))),
+		))
+	span.End(trace.WithTimestamp(now))
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/codelogia/manor/app-builder/pkg/build"
+	"github.com/codelogia/manor/app-builder/pkg/service"
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
+	manorv1 "github.com/codelogia/manor/operator/api/v1"
+)
+
+var _ = Describe("Tracing", func() {
+	ctx := context.Background()
+
+	var (
+		exporter *tracetest.InMemoryExporter
+		shutdown func(context.Context) error
+		// push is the span of the push the Artifacts are created by.
+		push trace.SpanContext
+		// annotations carry the trace context of the push.
+		annotations map[string]string
+	)
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		var err error
+		shutdown, err = tracing.Setup(ctx, tracing.Config{ServiceName: "manor-operator", Exporter: exporter})
+		Expect(err).NotTo(HaveOccurred())
+
+		pushCtx, span := tracing.Tracer().Start(ctx, "manor push")
+		span.End()
+		push = span.SpanContext()
+		annotations = tracing.InjectAnnotations(pushCtx, nil)
+	})
+
+	AfterEach(func() {
+		Expect(shutdown(ctx)).To(Succeed())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	// pushSpans returns the exported spans with the name, expecting them to be children of the push.
+	pushSpans := func(name string) tracetest.SpanStubs {
+		var spans tracetest.SpanStubs
+		for _, span := range exporter.GetSpans() {
+			if span.Name != name {
+				continue
+			}
+			Expect(span.SpanContext.TraceID()).To(Equal(push.TraceID()), "span %s", name)
+			Expect(span.Parent.SpanID()).To(Equal(push.SpanID()), "span %s", name)
+			spans = append(spans, span)
+		}
+		return spans
+	}
+
+	It("propagates the trace context through the annotations of the Artifact", func() {
+		Expect(annotations).To(HaveKey("manor.codelogia.com/traceparent"))
+
+		extracted := trace.SpanContextFromContext(tracing.ExtractAnnotations(ctx, annotations))
+		Expect(extracted.TraceID()).To(Equal(push.TraceID()))
+		Expect(extracted.SpanID()).To(Equal(push.SpanID()))
+		Expect(extracted.IsRemote()).To(BeTrue())
+	})
+
+	It("traces the status updates of the Artifact in the trace of the push", func() {
+		buildService := &fakeBuildService{job: service.Job{ID: "job-1", Phase: build.PhasePending}}
+		server := httptest.NewServer(buildService)
+		defer server.Close()
+		reconciler := &ArtifactReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("Artifact"),
+			Scheme:               scheme.Scheme,
+			Recorder:             record.NewFakeRecorder(100),
+			DefaultImageRegistry: "registry.example.com",
+			BuildService:         service.NewClient(server.URL, "token"),
+		}
+
+		artifact := &manorv1.Artifact{
+			ObjectMeta: metav1.ObjectMeta{Name: "tracing-1", Namespace: "default", Annotations: annotations},
+			Spec:       manorv1.ArtifactSpec{App: "tracing"},
+		}
+		Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+		key := types.NamespacedName{Name: artifact.Name, Namespace: artifact.Namespace}
+
+		// Initializes the Artifact, creates its credentials and dispatches its build job.
+		for i := 0; i < 3; i++ {
+			reconcileUntilSettled(reconciler.Reconcile, key)
+		}
+		buildService.setJob(build.PhaseBuilding, "")
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		buildService.setJob(build.PhaseSucceeded, "sha256:abc")
+		reconcileUntilSettled(reconciler.Reconcile, key)
+
+		// The Artifact was initialized, dispatched, in progress and completed.
+		Expect(pushSpans("Artifact status update")).To(HaveLen(4))
+	})
+
+	It("traces the rollout of the Artifact to the App in the trace of the push", func() {
+		reconciler := &AppReconciler{
+			Client:               k8sClient,
+			Log:                  ctrl.Log.WithName("controllers").WithName("App"),
+			Scheme:               scheme.Scheme,
+			Recorder:             record.NewFakeRecorder(100),
+			DefaultImageRegistry: "registry.example.com",
+		}
+
+		app := &manorv1.App{ObjectMeta: metav1.ObjectMeta{Name: "tracing-app", Namespace: "default"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+
+		artifact := &manorv1.Artifact{
+			ObjectMeta: metav1.ObjectMeta{Name: "tracing-app-1", Namespace: "default", Annotations: annotations},
+			Spec:       manorv1.ArtifactSpec{App: app.Name},
+		}
+		Expect(k8sClient.Create(ctx, artifact)).To(Succeed())
+		artifact.Status.Conditions = []manorv1.ArtifactCondition{
+			{Type: manorv1.ArtifactInitialized, Status: corev1.ConditionTrue},
+			{Type: manorv1.ArtifactCompleted, Status: corev1.ConditionTrue},
+		}
+		artifact.Status.Digest = "sha256:abc"
+		Expect(k8sClient.Status().Update(ctx, artifact)).To(Succeed())
+
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(pushSpans("Deployment create")).To(HaveLen(1))
+		Expect(pushSpans("App rollout")).To(BeEmpty())
+
+		// The rollout is traced once the App is ready with the Artifact.
+		deployment := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+		deployment.Status.ObservedGeneration = deployment.Generation
+		deployment.Status.Replicas = 1
+		deployment.Status.UpdatedReplicas = 1
+		deployment.Status.AvailableReplicas = 1
+		deployment.Status.ReadyReplicas = 1
+		Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		rollouts := pushSpans("App rollout")
+		Expect(rollouts).To(HaveLen(1))
+		Expect(rollouts[0].EndTime).NotTo(BeTemporally("<", rollouts[0].StartTime))
+
+		// The rollout is traced once.
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		Expect(pushSpans("App rollout")).To(HaveLen(1))
+
+		// Once the App is ready with the Artifact, its reconciles no longer join the trace of the push.
+		Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+		deployment.Spec.Template.Spec.Containers[0].Image = "registry.example.com/drifted"
+		Expect(k8sClient.Update(ctx, deployment)).To(Succeed())
+		reconcileUntilSettled(reconciler.Reconcile, key)
+		var updates int
+		for _, span := range exporter.GetSpans() {
+			if span.Name == "Deployment update" {
+				Expect(span.SpanContext.TraceID()).NotTo(Equal(push.TraceID()))
+				updates++
+			}
+		}
+		Expect(updates).To(Equal(1))
+	})
+})
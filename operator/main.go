@@ -17,9 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -27,7 +33,9 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/codelogia/manor/app-builder/pkg/tracing"
 	manorv1 "github.com/codelogia/manor/operator/api/v1"
+	manorv1beta2 "github.com/codelogia/manor/operator/api/v1beta2"
 	"github.com/codelogia/manor/operator/controllers"
 	// +kubebuilder:scaffold:imports
 )
@@ -40,7 +48,10 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+
 	utilruntime.Must(manorv1.AddToScheme(scheme))
+	utilruntime.Must(manorv1beta2.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -50,6 +61,18 @@ func main() {
 	var dockerHost string
 	var defaultImageRegistry string
 	var appBuilderImage string
+	var buildLogStore string
+	var buildLogsAddr string
+	var buildLogsURL string
+	var appBuilderServiceURL string
+	var sourceCacheSize string
+	var routerNamespace string
+	var manorNamespace string
+	var conversionWebhookService string
+	var cleanupRegistry bool
+	var registryCAFile string
+	var artifactGCInterval time.Duration
+	var otlpEndpoint string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
@@ -60,14 +83,85 @@ func main() {
 		"The default Container Image Registry to host the App images used when none is provided in the custom resources.")
 	flag.StringVar(&appBuilderImage, "app-builder-image", "",
 		"The app-builder image.")
+	flag.StringVar(&buildLogStore, "build-log-store", "",
+		"The URL of the store persisting the build logs, e.g. file:///var/lib/manor/build-logs for a mounted volume "+
+			"or local:///var/lib/manor/build-logs for the local object store. Build logs are not persisted when empty.")
+	flag.StringVar(&buildLogsAddr, "build-logs-addr", ":8082", "The address the build logs endpoint binds to.")
+	flag.StringVar(&buildLogsURL, "build-logs-url", "",
+		"The URL the app-builders and clients use to reach the build logs endpoint. Required with --build-log-store.")
+	flag.StringVar(&appBuilderServiceURL, "app-builder-service-url", "",
+		"The URL of the app-builder running in service mode. When set, builds are dispatched to it instead of "+
+			"app-builder Pods, authenticated with the token in the APP_BUILDER_SERVICE_TOKEN environment variable.")
+	flag.StringVar(&sourceCacheSize, "source-cache-size", "",
+		"The size of the volume caching the uploaded sources of each App, e.g. 1Gi, so only the changed files "+
+			"are uploaded by the following builds. The app-builder Pods don't cache the sources when empty, so every "+
+			"build uploads all the files.")
+	flag.StringVar(&routerNamespace, "router-namespace", "",
+		"The namespace of the router, e.g. the ingress controller, that the network ingress rules of the Apps can allow. "+
+			"The router can always reach the Pods of the isolated Spaces. The namespace must carry the "+
+			"kubernetes.io/metadata.name label, which Kubernetes sets from 1.21 on.")
+	flag.StringVar(&manorNamespace, "manor-namespace", "",
+		"The namespace manor runs in, whose Pods, e.g. the operator and the API server, can always reach the Pods of "+
+			"the isolated Spaces. Like the router namespace, it must carry the kubernetes.io/metadata.name label.")
+	flag.StringVar(&conversionWebhookService, "conversion-webhook-service", "",
+		"The namespace/name of the Service of the operator webhooks, which the versioned CRDs are configured to "+
+			"convert their objects with, verified with the ca.crt of the webhook serving certificates. The ca.crt is "+
+			"read again periodically to follow its rotations. The conversion of the CRDs is left as is when empty.")
+	flag.BoolVar(&cleanupRegistry, "cleanup-registry", false,
+		"Delete the images of the deleted Apps from the image registry, except the most recent images the Apps keep. "+
+			"Deleting images must be enabled in the registry.")
+	flag.StringVar(&registryCAFile, "registry-ca-file", "",
+		"The CA bundle verifying the certificates of the image registries the images are deleted from. "+
+			"The system roots are used when empty.")
+	flag.DurationVar(&artifactGCInterval, "artifact-gc-interval", 10*time.Minute,
+		"The interval the Artifacts beyond the history limits of their Apps are pruned at.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv(tracing.EndpointEnv),
+		"The URL of the OTLP/HTTP collector the traces of the operator and the app-builder Pods are exported to, "+
+			"e.g. http://otel-collector:4318. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable. "+
+			"Traces are not exported when empty.")
 	flag.Parse()
 
+	if buildLogStore != "" && buildLogsURL == "" {
+		setupLog.Error(fmt.Errorf("--build-logs-url must be set with --build-log-store"), "invalid flags")
+		os.Exit(1)
+	}
+	if appBuilderServiceURL != "" && os.Getenv("APP_BUILDER_SERVICE_TOKEN") == "" {
+		setupLog.Error(fmt.Errorf("APP_BUILDER_SERVICE_TOKEN must be set with --app-builder-service-url"), "invalid flags")
+		os.Exit(1)
+	}
+	if buildLogStore == "" {
+		// Without a store, there is no build logs endpoint for the app-builders to write to.
+		buildLogsURL = ""
+	}
+
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "manor-operator",
+		Endpoint:    otlpEndpoint,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
+	var registryCABundle []byte
+	if registryCAFile != "" {
+		var err error
+		registryCABundle, err = ioutil.ReadFile(registryCAFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read the registry CA bundle")
+			os.Exit(1)
+		}
+	}
+
+	webhookCertDir := filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		Port:               9443,
+		CertDir:            webhookCertDir,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "4841f04a.codelogia.com",
 	})
@@ -81,19 +175,101 @@ func main() {
 		dockerHost,
 		defaultImageRegistry,
 		appBuilderImage,
+		buildLogsURL,
+		appBuilderServiceURL,
+		os.Getenv("APP_BUILDER_SERVICE_TOKEN"),
+		sourceCacheSize,
+		otlpEndpoint,
 	); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Artifact")
 		os.Exit(1)
 	}
-	if err := controllers.SetupAppReconciler(mgr, defaultImageRegistry); err != nil {
+	if err := controllers.SetupAppReconciler(
+		mgr,
+		defaultImageRegistry,
+		routerNamespace,
+		cleanupRegistry,
+		registryCABundle,
+	); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "App")
 		os.Exit(1)
 	}
+	if err := controllers.SetupOrganizationReconciler(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Organization")
+		os.Exit(1)
+	}
+	var systemNamespaces []string
+	for _, namespace := range []string{manorNamespace, routerNamespace} {
+		if namespace != "" {
+			systemNamespaces = append(systemNamespaces, namespace)
+		}
+	}
+	if err := controllers.SetupSpaceReconciler(mgr, systemNamespaces); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Space")
+		os.Exit(1)
+	}
+	if err := controllers.SetupServiceBindingReconciler(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceBinding")
+		os.Exit(1)
+	}
+	if err := controllers.SetupServiceBrokerReconciler(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceBroker")
+		os.Exit(1)
+	}
+	if err := controllers.SetupServiceInstanceReconciler(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ServiceInstance")
+		os.Exit(1)
+	}
+	// The webhooks can be disabled to run the operator locally, without serving certificates.
+	enableWebhooks := os.Getenv("ENABLE_WEBHOOKS") != "false"
+	if enableWebhooks {
+		if err := (&manorv1.App{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "App")
+			os.Exit(1)
+		}
+		if err := (&manorv1.Artifact{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Artifact")
+			os.Exit(1)
+		}
+	}
+	if !enableWebhooks {
+		conversionWebhookService = ""
+	}
+	if err := controllers.SetupCRDMigrator(mgr, conversionWebhookService, filepath.Join(webhookCertDir, "ca.crt")); err != nil {
+		setupLog.Error(err, "unable to create CRD migrator")
+		os.Exit(1)
+	}
+	if err := controllers.SetupArtifactGarbageCollector(
+		mgr,
+		artifactGCInterval,
+		defaultImageRegistry,
+		buildLogStore,
+		cleanupRegistry,
+		registryCABundle,
+	); err != nil {
+		setupLog.Error(err, "unable to create Artifact garbage collector")
+		os.Exit(1)
+	}
+	if buildLogStore != "" {
+		if err := controllers.SetupBuildLogsServer(mgr, buildLogsAddr, buildLogStore); err != nil {
+			setupLog.Error(err, "unable to create build logs server")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+
+	// The spans of the last reconciles are flushed before exiting.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
+		cancel()
 		os.Exit(1)
 	}
 }
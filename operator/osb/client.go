@@ -0,0 +1,238 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrGone is returned when the service instance or binding doesn't exist in the broker.
+var ErrGone = errors.New("gone")
+
+// Error is a failed response of a broker.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// ErrorCode is the machine readable error code, e.g. AsyncRequired, if any.
+	ErrorCode string
+	// Description is the description of the error, meant for the users.
+	Description string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("broker responded with status %d", e.StatusCode)
+	if e.ErrorCode != "" {
+		msg += ": " + e.ErrorCode
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// Client is a client of an Open Service Broker API v2 broker.
+type Client struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// NewClient constructs a new Client of the broker at url, authenticated with basic authentication
+// when username is not empty.
+func NewClient(url, username, password string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(url, "/"),
+		username:   username,
+		password:   password,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Catalog fetches the catalog of the services offered by the broker.
+func (c *Client) Catalog(ctx context.Context) (*Catalog, error) {
+	catalog := &Catalog{}
+	if _, err := c.do(ctx, http.MethodGet, "/v2/catalog", nil, nil, catalog, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("failed to fetch catalog: %w", err)
+	}
+	return catalog, nil
+}
+
+// Provision provisions a service instance, asynchronously if the broker requires it.
+func (c *Client) Provision(ctx context.Context, instanceID string, req ProvisionRequest) (*ProvisionResponse, error) {
+	res := &ProvisionResponse{}
+	status, err := c.do(
+		ctx, http.MethodPut, "/v2/service_instances/"+url.PathEscape(instanceID),
+		url.Values{"accepts_incomplete": {"true"}}, req, res,
+		http.StatusOK, http.StatusCreated, http.StatusAccepted,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision service instance: %w", err)
+	}
+	res.Async = status == http.StatusAccepted
+	return res, nil
+}
+
+// Deprovision deprovisions a service instance, asynchronously if the broker requires it. An
+// instance that doesn't exist is deprovisioned already.
+func (c *Client) Deprovision(ctx context.Context, instanceID, serviceID, planID string) (*OperationResponse, error) {
+	res := &OperationResponse{}
+	status, err := c.do(
+		ctx, http.MethodDelete, "/v2/service_instances/"+url.PathEscape(instanceID),
+		url.Values{"accepts_incomplete": {"true"}, "service_id": {serviceID}, "plan_id": {planID}}, nil, res,
+		http.StatusOK, http.StatusAccepted, http.StatusGone,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deprovision service instance: %w", err)
+	}
+	res.Async = status == http.StatusAccepted
+	return res, nil
+}
+
+// LastOperation returns the state of the last asynchronous operation on a service instance. It
+// returns ErrGone once an instance being deprovisioned is gone.
+func (c *Client) LastOperation(ctx context.Context, instanceID string, req LastOperationRequest) (*LastOperation, error) {
+	query := url.Values{}
+	if req.ServiceID != "" {
+		query.Set("service_id", req.ServiceID)
+	}
+	if req.PlanID != "" {
+		query.Set("plan_id", req.PlanID)
+	}
+	if req.Operation != "" {
+		query.Set("operation", req.Operation)
+	}
+	op := &LastOperation{}
+	status, err := c.do(
+		ctx, http.MethodGet, "/v2/service_instances/"+url.PathEscape(instanceID)+"/last_operation",
+		query, nil, op,
+		http.StatusOK, http.StatusGone,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last operation: %w", err)
+	}
+	if status == http.StatusGone {
+		return nil, ErrGone
+	}
+	return op, nil
+}
+
+// Bind creates a binding of a service instance, returning its credentials. Only synchronous
+// bindings are supported.
+func (c *Client) Bind(ctx context.Context, instanceID, bindingID string, req BindRequest) (*BindResponse, error) {
+	res := &BindResponse{}
+	_, err := c.do(
+		ctx, http.MethodPut, bindingPath(instanceID, bindingID),
+		nil, req, res,
+		http.StatusOK, http.StatusCreated,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind service instance: %w", err)
+	}
+	return res, nil
+}
+
+// Unbind deletes a binding of a service instance. A binding that doesn't exist is deleted already.
+func (c *Client) Unbind(ctx context.Context, instanceID, bindingID, serviceID, planID string) error {
+	_, err := c.do(
+		ctx, http.MethodDelete, bindingPath(instanceID, bindingID),
+		url.Values{"service_id": {serviceID}, "plan_id": {planID}}, nil, nil,
+		http.StatusOK, http.StatusGone,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unbind service instance: %w", err)
+	}
+	return nil
+}
+
+func bindingPath(instanceID, bindingID string) string {
+	return "/v2/service_instances/" + url.PathEscape(instanceID) + "/service_bindings/" + url.PathEscape(bindingID)
+}
+
+// do sends a request to the broker, decoding the response body into v when its status is one of
+// the expected ones. It returns the status of the response.
+func (c *Client) do(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	in, v interface{},
+	expected ...int,
+) (int, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Broker-API-Version", APIVersion)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	for _, status := range expected {
+		if res.StatusCode != status {
+			continue
+		}
+		if v == nil || status == http.StatusGone {
+			return status, nil
+		}
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil && err != io.EOF {
+			return status, fmt.Errorf("invalid response: %w", err)
+		}
+		return status, nil
+	}
+
+	brokerErr := &Error{StatusCode: res.StatusCode}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	errRes := errorResponse{}
+	if err := json.Unmarshal(msg, &errRes); err == nil {
+		brokerErr.ErrorCode = errRes.Error
+		brokerErr.Description = errRes.Description
+	} else {
+		brokerErr.Description = strings.TrimSpace(string(msg))
+	}
+	return res.StatusCode, brokerErr
+}
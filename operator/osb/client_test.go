@@ -0,0 +1,214 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osb_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/operator/osb"
+	"github.com/codelogia/manor/operator/osb/fakebroker"
+)
+
+var _ = Describe("Client", func() {
+	const (
+		instanceID = "instance-1"
+		bindingID  = "binding-1"
+		serviceID  = "postgresql-id"
+		planID     = "small-id"
+	)
+
+	var broker *fakebroker.Broker
+	var httpServer *httptest.Server
+	var client *osb.Client
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		broker = fakebroker.New(osb.Catalog{
+			Services: []osb.Service{{
+				ID:          serviceID,
+				Name:        "postgresql",
+				Description: "PostgreSQL databases",
+				Bindable:    true,
+				Plans: []osb.Plan{
+					{ID: planID, Name: "small", Description: "A small database"},
+					{ID: "unbindable-id", Name: "unbindable", Bindable: new(bool)},
+				},
+			}},
+		})
+		broker.Username = "admin"
+		broker.Password = "secret"
+		httpServer = httptest.NewServer(broker)
+		client = osb.NewClient(httpServer.URL, "admin", "secret")
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	provisionRequest := osb.ProvisionRequest{
+		ServiceID:  serviceID,
+		PlanID:     planID,
+		Parameters: json.RawMessage(`{"version":"13"}`),
+	}
+
+	Context("fetching the catalog", func() {
+		It("returns the services of the broker", func() {
+			catalog, err := client.Catalog(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			service, plan := catalog.FindPlan("postgresql", "small")
+			Expect(service).NotTo(BeNil())
+			Expect(service.ID).To(Equal(serviceID))
+			Expect(plan).NotTo(BeNil())
+			Expect(plan.ID).To(Equal(planID))
+			Expect(service.PlanBindable(plan)).To(BeTrue())
+
+			_, plan = catalog.FindPlan("postgresql", "unbindable")
+			Expect(service.PlanBindable(plan)).To(BeFalse())
+		})
+
+		It("fails with invalid credentials", func() {
+			client = osb.NewClient(httpServer.URL, "admin", "wrong")
+			_, err := client.Catalog(ctx)
+			var brokerErr *osb.Error
+			Expect(errors.As(err, &brokerErr)).To(BeTrue())
+			Expect(brokerErr.StatusCode).To(Equal(401))
+		})
+	})
+
+	Context("with a synchronous broker", func() {
+		It("provisions, binds, unbinds and deprovisions an instance", func() {
+			res, err := client.Provision(ctx, instanceID, provisionRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Async).To(BeFalse())
+			instance, ok := broker.Instance(instanceID)
+			Expect(ok).To(BeTrue())
+			Expect(instance.Provisioned).To(BeTrue())
+			Expect(instance.Parameters).To(MatchJSON(`{"version":"13"}`))
+
+			By("provisioning it again with the same attributes")
+			res, err = client.Provision(ctx, instanceID, provisionRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Async).To(BeFalse())
+
+			By("provisioning it again with different attributes")
+			_, err = client.Provision(ctx, instanceID, osb.ProvisionRequest{ServiceID: serviceID, PlanID: planID})
+			var brokerErr *osb.Error
+			Expect(errors.As(err, &brokerErr)).To(BeTrue())
+			Expect(brokerErr.StatusCode).To(Equal(409))
+
+			binding, err := client.Bind(ctx, instanceID, bindingID, osb.BindRequest{ServiceID: serviceID, PlanID: planID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("username", bindingID))
+			Expect(binding.Credentials).To(HaveKey("password"))
+
+			By("binding it again")
+			again, err := client.Bind(ctx, instanceID, bindingID, osb.BindRequest{ServiceID: serviceID, PlanID: planID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.Credentials).To(Equal(binding.Credentials))
+
+			Expect(client.Unbind(ctx, instanceID, bindingID, serviceID, planID)).To(Succeed())
+			instance, _ = broker.Instance(instanceID)
+			Expect(instance.Bindings).To(BeEmpty())
+			By("unbinding a binding that is gone")
+			Expect(client.Unbind(ctx, instanceID, bindingID, serviceID, planID)).To(Succeed())
+
+			op, err := client.Deprovision(ctx, instanceID, serviceID, planID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.Async).To(BeFalse())
+			_, ok = broker.Instance(instanceID)
+			Expect(ok).To(BeFalse())
+			By("deprovisioning an instance that is gone")
+			_, err = client.Deprovision(ctx, instanceID, serviceID, planID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails to provision an unknown plan", func() {
+			_, err := client.Provision(ctx, instanceID, osb.ProvisionRequest{ServiceID: serviceID, PlanID: "unknown"})
+			var brokerErr *osb.Error
+			Expect(errors.As(err, &brokerErr)).To(BeTrue())
+			Expect(brokerErr.StatusCode).To(Equal(400))
+		})
+	})
+
+	Context("with an asynchronous broker", func() {
+		BeforeEach(func() {
+			broker.Async = true
+			broker.PollsToComplete = 2
+		})
+
+		It("polls the operations until they complete", func() {
+			res, err := client.Provision(ctx, instanceID, provisionRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Async).To(BeTrue())
+			Expect(res.Operation).NotTo(BeEmpty())
+
+			req := osb.LastOperationRequest{ServiceID: serviceID, PlanID: planID, Operation: res.Operation}
+			op, err := client.LastOperation(ctx, instanceID, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.State).To(Equal(osb.StateInProgress))
+
+			By("binding it while it's being provisioned")
+			_, err = client.Bind(ctx, instanceID, bindingID, osb.BindRequest{ServiceID: serviceID, PlanID: planID})
+			var brokerErr *osb.Error
+			Expect(errors.As(err, &brokerErr)).To(BeTrue())
+			Expect(brokerErr.ErrorCode).To(Equal("ConcurrencyError"))
+
+			op, err = client.LastOperation(ctx, instanceID, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.State).To(Equal(osb.StateSucceeded))
+			instance, _ := broker.Instance(instanceID)
+			Expect(instance.Provisioned).To(BeTrue())
+
+			deprovision, err := client.Deprovision(ctx, instanceID, serviceID, planID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deprovision.Async).To(BeTrue())
+
+			req.Operation = deprovision.Operation
+			op, err = client.LastOperation(ctx, instanceID, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.State).To(Equal(osb.StateInProgress))
+			op, err = client.LastOperation(ctx, instanceID, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.State).To(Equal(osb.StateSucceeded))
+
+			By("polling the instance once it's gone")
+			_, err = client.LastOperation(ctx, instanceID, req)
+			Expect(err).To(Equal(osb.ErrGone))
+		})
+
+		It("reports the failed operations", func() {
+			broker.FailProvision = true
+			res, err := client.Provision(ctx, instanceID, provisionRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			req := osb.LastOperationRequest{ServiceID: serviceID, PlanID: planID, Operation: res.Operation}
+			Eventually(func() (osb.OperationState, error) {
+				op, err := client.LastOperation(ctx, instanceID, req)
+				if err != nil {
+					return "", err
+				}
+				return op.State, nil
+			}).Should(Equal(osb.StateFailed))
+		})
+	})
+})
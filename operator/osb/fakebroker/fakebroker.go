@@ -0,0 +1,360 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakebroker implements an in-memory Open Service Broker API v2 broker, to test the
+// operator and develop against without real backing services.
+package fakebroker
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/codelogia/manor/operator/osb"
+)
+
+// Broker is an in-memory broker. The fields must not be changed while it's serving requests.
+type Broker struct {
+	// Catalog is the catalog of the services offered by the broker.
+	Catalog osb.Catalog
+	// Username and Password are the basic authentication credentials of the broker, which
+	// doesn't authenticate the requests when Username is empty.
+	Username string
+	Password string
+	// Async makes the provision and deprovision operations asynchronous, each completing after its
+	// last operation is polled PollsToComplete times.
+	Async           bool
+	PollsToComplete int
+	// FailProvision makes the provision operations fail.
+	FailProvision bool
+
+	mu        sync.Mutex
+	instances map[string]*Instance
+	nextOp    int
+}
+
+// Instance is a service instance of the broker.
+type Instance struct {
+	ID         string
+	ServiceID  string
+	PlanID     string
+	Parameters json.RawMessage
+	// Provisioned is whether the provision operation completed.
+	Provisioned bool
+	// Bindings are the credentials of the bindings, by binding ID.
+	Bindings map[string]map[string]interface{}
+
+	operation *operation
+}
+
+// operation is an asynchronous operation on an instance.
+type operation struct {
+	id          string
+	deprovision bool
+	polls       int
+	failed      bool
+}
+
+// New constructs a new Broker offering the services of the catalog.
+func New(catalog osb.Catalog) *Broker {
+	return &Broker{Catalog: catalog}
+}
+
+// Instance returns a copy of a service instance, and whether it exists.
+func (b *Broker) Instance(id string) (Instance, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[id]
+	if !ok {
+		return Instance{}, false
+	}
+	copied := *instance
+	copied.Bindings = make(map[string]map[string]interface{}, len(instance.Bindings))
+	for id, credentials := range instance.Bindings {
+		copied.Bindings[id] = credentials
+	}
+	copied.operation = nil
+	return copied, true
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Broker-API-Version") == "" {
+		writeError(w, http.StatusPreconditionFailed, "", "missing X-Broker-API-Version header")
+		return
+	}
+	if b.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != b.Username || password != b.Password {
+			writeError(w, http.StatusUnauthorized, "", "invalid credentials")
+			return
+		}
+	}
+
+	// The routes are /v2/catalog, /v2/service_instances/:id, /v2/service_instances/:id/last_operation
+	// and /v2/service_instances/:id/service_bindings/:binding_id.
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "v2" && parts[1] == "catalog" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, b.Catalog)
+	case len(parts) < 3 || parts[0] != "v2" || parts[1] != "service_instances":
+		http.NotFound(w, r)
+	case len(parts) == 3 && r.Method == http.MethodPut:
+		b.provision(w, r, parts[2])
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		b.deprovision(w, r, parts[2])
+	case len(parts) == 4 && parts[3] == "last_operation" && r.Method == http.MethodGet:
+		b.lastOperation(w, r, parts[2])
+	case len(parts) == 5 && parts[3] == "service_bindings" && r.Method == http.MethodPut:
+		b.bind(w, r, parts[2], parts[4])
+	case len(parts) == 5 && parts[3] == "service_bindings" && r.Method == http.MethodDelete:
+		b.unbind(w, parts[2], parts[4])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *Broker) provision(w http.ResponseWriter, r *http.Request, id string) {
+	req := osb.ProvisionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if service, plan := b.findPlan(req.ServiceID, req.PlanID); service == nil || plan == nil {
+		writeError(w, http.StatusBadRequest, "", "unknown service or plan")
+		return
+	}
+	if b.Async && r.URL.Query().Get("accepts_incomplete") != "true" {
+		writeError(w, http.StatusUnprocessableEntity, "AsyncRequired", "this service plan requires client support for asynchronous service operations")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.instances == nil {
+		b.instances = map[string]*Instance{}
+	}
+	if instance, ok := b.instances[id]; ok {
+		if instance.ServiceID != req.ServiceID || instance.PlanID != req.PlanID ||
+			!reflect.DeepEqual(normalize(instance.Parameters), normalize(req.Parameters)) {
+			writeError(w, http.StatusConflict, "", "the instance already exists with different attributes")
+			return
+		}
+		if instance.operation != nil && !instance.operation.deprovision {
+			writeJSON(w, http.StatusAccepted, osb.ProvisionResponse{Operation: instance.operation.id})
+			return
+		}
+		writeJSON(w, http.StatusOK, osb.ProvisionResponse{})
+		return
+	}
+
+	instance := &Instance{
+		ID:         id,
+		ServiceID:  req.ServiceID,
+		PlanID:     req.PlanID,
+		Parameters: req.Parameters,
+		Bindings:   map[string]map[string]interface{}{},
+	}
+	b.instances[id] = instance
+	if !b.Async {
+		if b.FailProvision {
+			delete(b.instances, id)
+			writeError(w, http.StatusInternalServerError, "", "provision failed")
+			return
+		}
+		instance.Provisioned = true
+		writeJSON(w, http.StatusCreated, osb.ProvisionResponse{DashboardURL: "http://dashboard.fake/" + id})
+		return
+	}
+	instance.operation = b.newOperation(false)
+	writeJSON(w, http.StatusAccepted, osb.ProvisionResponse{
+		DashboardURL: "http://dashboard.fake/" + id,
+		Operation:    instance.operation.id,
+	})
+}
+
+func (b *Broker) deprovision(w http.ResponseWriter, r *http.Request, id string) {
+	if b.Async && r.URL.Query().Get("accepts_incomplete") != "true" {
+		writeError(w, http.StatusUnprocessableEntity, "AsyncRequired", "this service plan requires client support for asynchronous service operations")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[id]
+	if !ok {
+		writeJSON(w, http.StatusGone, struct{}{})
+		return
+	}
+	if instance.operation != nil {
+		if instance.operation.deprovision {
+			writeJSON(w, http.StatusAccepted, osb.OperationResponse{Operation: instance.operation.id})
+			return
+		}
+		writeError(w, http.StatusUnprocessableEntity, "ConcurrencyError", "an operation is in progress")
+		return
+	}
+	if !b.Async {
+		delete(b.instances, id)
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+	instance.operation = b.newOperation(true)
+	writeJSON(w, http.StatusAccepted, osb.OperationResponse{Operation: instance.operation.id})
+}
+
+func (b *Broker) lastOperation(w http.ResponseWriter, r *http.Request, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[id]
+	if !ok {
+		writeJSON(w, http.StatusGone, struct{}{})
+		return
+	}
+	op := instance.operation
+	if op == nil || r.URL.Query().Get("operation") != "" && r.URL.Query().Get("operation") != op.id {
+		writeError(w, http.StatusBadRequest, "", "unknown operation")
+		return
+	}
+
+	op.polls++
+	if op.polls < b.PollsToComplete {
+		writeJSON(w, http.StatusOK, osb.LastOperation{State: osb.StateInProgress})
+		return
+	}
+	instance.operation = nil
+	switch {
+	case op.deprovision:
+		delete(b.instances, id)
+		writeJSON(w, http.StatusOK, osb.LastOperation{State: osb.StateSucceeded})
+	case b.FailProvision:
+		delete(b.instances, id)
+		writeJSON(w, http.StatusOK, osb.LastOperation{State: osb.StateFailed, Description: "provision failed"})
+	default:
+		instance.Provisioned = true
+		writeJSON(w, http.StatusOK, osb.LastOperation{State: osb.StateSucceeded})
+	}
+}
+
+func (b *Broker) bind(w http.ResponseWriter, r *http.Request, instanceID, bindingID string) {
+	req := osb.BindRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	service, plan := b.findPlan(req.ServiceID, req.PlanID)
+	if service == nil || plan == nil {
+		writeError(w, http.StatusBadRequest, "", "unknown service or plan")
+		return
+	}
+	if !service.PlanBindable(plan) {
+		writeError(w, http.StatusBadRequest, "", "the service plan is not bindable")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[instanceID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "instance not found")
+		return
+	}
+	if !instance.Provisioned || instance.operation != nil {
+		writeError(w, http.StatusUnprocessableEntity, "ConcurrencyError", "an operation is in progress")
+		return
+	}
+	if credentials, ok := instance.Bindings[bindingID]; ok {
+		writeJSON(w, http.StatusOK, osb.BindResponse{Credentials: credentials})
+		return
+	}
+
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		writeError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	credentials := map[string]interface{}{
+		"uri":      fmt.Sprintf("fake://%s/%s", service.Name, instanceID),
+		"username": bindingID,
+		"password": fmt.Sprintf("%x", password),
+		"port":     5432,
+	}
+	instance.Bindings[bindingID] = credentials
+	writeJSON(w, http.StatusCreated, osb.BindResponse{Credentials: credentials})
+}
+
+func (b *Broker) unbind(w http.ResponseWriter, instanceID, bindingID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instance, ok := b.instances[instanceID]
+	if !ok {
+		writeJSON(w, http.StatusGone, struct{}{})
+		return
+	}
+	if _, ok := instance.Bindings[bindingID]; !ok {
+		writeJSON(w, http.StatusGone, struct{}{})
+		return
+	}
+	delete(instance.Bindings, bindingID)
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// newOperation starts an asynchronous operation. The lock must be held.
+func (b *Broker) newOperation(deprovision bool) *operation {
+	b.nextOp++
+	return &operation{id: fmt.Sprintf("op-%d", b.nextOp), deprovision: deprovision}
+}
+
+func (b *Broker) findPlan(serviceID, planID string) (*osb.Service, *osb.Plan) {
+	for i := range b.Catalog.Services {
+		service := &b.Catalog.Services[i]
+		if service.ID != serviceID {
+			continue
+		}
+		for j := range service.Plans {
+			if service.Plans[j].ID == planID {
+				return service, &service.Plans[j]
+			}
+		}
+		return service, nil
+	}
+	return nil, nil
+}
+
+// normalize decodes the parameters, so equivalent documents compare equal.
+func normalize(parameters json.RawMessage) interface{} {
+	var v interface{}
+	if len(parameters) > 0 {
+		json.Unmarshal(parameters, &v)
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, struct {
+		Error       string `json:"error,omitempty"`
+		Description string `json:"description"`
+	}{code, description})
+}
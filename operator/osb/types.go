@@ -0,0 +1,152 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package osb implements a client of the Open Service Broker API v2, provisioning and binding the
+// backing services of the Apps.
+package osb
+
+import (
+	"encoding/json"
+)
+
+// APIVersion is the version of the Open Service Broker API spoken by the client.
+const APIVersion = "2.16"
+
+// Catalog is the catalog of the services offered by a broker.
+type Catalog struct {
+	Services []Service `json:"services"`
+}
+
+// Service is a service offered by a broker.
+type Service struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags,omitempty"`
+	Bindable    bool     `json:"bindable"`
+	Plans       []Plan   `json:"plans"`
+}
+
+// Plan is a plan of a service.
+type Plan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Free        *bool  `json:"free,omitempty"`
+	// Bindable overrides whether the service is bindable for this plan.
+	Bindable *bool `json:"bindable,omitempty"`
+}
+
+// PlanBindable returns whether the instances of the plan of the service can be bound.
+func (s *Service) PlanBindable(plan *Plan) bool {
+	if plan.Bindable != nil {
+		return *plan.Bindable
+	}
+	return s.Bindable
+}
+
+// FindPlan returns the service and plan with the given names, or nil when the catalog doesn't
+// offer them.
+func (c *Catalog) FindPlan(serviceName, planName string) (*Service, *Plan) {
+	for i := range c.Services {
+		service := &c.Services[i]
+		if service.Name != serviceName {
+			continue
+		}
+		for j := range service.Plans {
+			if service.Plans[j].Name == planName {
+				return service, &service.Plans[j]
+			}
+		}
+		return service, nil
+	}
+	return nil, nil
+}
+
+// ProvisionRequest is the request to provision a service instance.
+type ProvisionRequest struct {
+	ServiceID string `json:"service_id"`
+	PlanID    string `json:"plan_id"`
+	// Context is the platform specific context of the instance.
+	Context map[string]interface{} `json:"context,omitempty"`
+	// Parameters are the service specific configuration parameters.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ProvisionResponse is the response to a provision request.
+type ProvisionResponse struct {
+	// Async is whether the instance is being provisioned asynchronously, in which case the progress
+	// is polled with LastOperation.
+	Async        bool   `json:"-"`
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	Operation    string `json:"operation,omitempty"`
+}
+
+// OperationResponse is the response to a deprovision request.
+type OperationResponse struct {
+	// Async is whether the instance is being deprovisioned asynchronously, in which case the
+	// progress is polled with LastOperation.
+	Async     bool   `json:"-"`
+	Operation string `json:"operation,omitempty"`
+}
+
+// LastOperationRequest is the request for the state of an asynchronous operation.
+type LastOperationRequest struct {
+	ServiceID string
+	PlanID    string
+	Operation string
+}
+
+// OperationState is the state of an asynchronous operation.
+type OperationState string
+
+const (
+	// StateInProgress means the operation is still running.
+	StateInProgress OperationState = "in progress"
+	// StateSucceeded means the operation succeeded.
+	StateSucceeded OperationState = "succeeded"
+	// StateFailed means the operation failed.
+	StateFailed OperationState = "failed"
+)
+
+// LastOperation is the state of an asynchronous operation.
+type LastOperation struct {
+	State       OperationState `json:"state"`
+	Description string         `json:"description,omitempty"`
+}
+
+// BindRequest is the request to bind a service instance.
+type BindRequest struct {
+	ServiceID string `json:"service_id"`
+	PlanID    string `json:"plan_id"`
+	// BindResource identifies what the credentials are bound to.
+	BindResource map[string]interface{} `json:"bind_resource,omitempty"`
+	// Context is the platform specific context of the binding.
+	Context map[string]interface{} `json:"context,omitempty"`
+	// Parameters are the service specific configuration parameters.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// BindResponse is the response to a bind request.
+type BindResponse struct {
+	Credentials map[string]interface{} `json:"credentials,omitempty"`
+}
+
+// errorResponse is the body of the failed responses.
+type errorResponse struct {
+	Error       string `json:"error,omitempty"`
+	Description string `json:"description,omitempty"`
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package registry implements a client of the OCI distribution API of the image registries the
+// App images are pushed to.
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned when the repository or the manifest doesn't exist in the registry.
+var ErrNotFound = errors.New("not found")
+
+// manifestMediaTypes are the media types of the manifests accepted by the client.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// Error is a failed response of a registry.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the machine readable error code, e.g. MANIFEST_UNKNOWN, if any.
+	Code string
+	// Message is the description of the error.
+	Message string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("registry responded with status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Client is a client of the OCI distribution API of a registry.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient constructs a new Client of the registry at host, served over HTTPS. Its certificate is
+// verified with the CA bundle when not empty, or the system roots otherwise.
+func NewClient(host string, caBundle []byte) (*Client, error) {
+	httpClient := http.DefaultClient
+	if len(caBundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("failed to create registry client: invalid CA bundle")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		httpClient = &http.Client{Transport: transport}
+	}
+	return &Client{
+		url:        "https://" + strings.TrimSuffix(host, "/"),
+		httpClient: httpClient,
+	}, nil
+}
+
+// tagList is the response of the tags list endpoint.
+type tagList struct {
+	Tags []string `json:"tags"`
+}
+
+// Tags returns the tags of a repository. A repository that doesn't exist has no tags.
+func (c *Client) Tags(ctx context.Context, repository string) ([]string, error) {
+	var tags []string
+	next := "/v2/" + repository + "/tags/list"
+	for next != "" {
+		res := &tagList{}
+		header, err := c.do(ctx, http.MethodGet, next, nil, res, http.StatusOK)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to list tags: %w", err)
+		}
+		tags = append(tags, res.Tags...)
+		next = nextLink(header)
+	}
+	return tags, nil
+}
+
+// Digest returns the digest of the manifest of a tag. It returns ErrNotFound when the tag doesn't
+// exist.
+func (c *Client) Digest(ctx context.Context, repository, tag string) (string, error) {
+	header, err := c.do(
+		ctx, http.MethodHead, "/v2/"+repository+"/manifests/"+url.PathEscape(tag),
+		map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")}, nil,
+		http.StatusOK,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest digest: %w", err)
+	}
+	digest := header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("failed to get manifest digest: missing Docker-Content-Digest header")
+	}
+	return digest, nil
+}
+
+// DeleteManifest deletes the manifest of a digest, untagging all its tags. A manifest that doesn't
+// exist is deleted already. The blobs of the manifest are only freed by the garbage collection of
+// the registry.
+func (c *Client) DeleteManifest(ctx context.Context, repository, digest string) error {
+	_, err := c.do(
+		ctx, http.MethodDelete, "/v2/"+repository+"/manifests/"+url.PathEscape(digest),
+		nil, nil,
+		http.StatusOK, http.StatusAccepted,
+	)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete manifest: %w", err)
+	}
+	return nil
+}
+
+// linkRegexp matches the URL of the next page in the Link header of a paginated response.
+var linkRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+
+// nextLink returns the path of the next page of a paginated response, if any.
+func nextLink(header http.Header) string {
+	m := linkRegexp.FindStringSubmatch(header.Get("Link"))
+	if m == nil {
+		return ""
+	}
+	next, err := url.Parse(m[1])
+	if err != nil {
+		return ""
+	}
+	return next.RequestURI()
+}
+
+// errorResponse is the body of a failed response.
+type errorResponse struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// do sends a request to the registry, decoding the response body into res when not nil. It
+// returns ErrNotFound, wrapped, when the registry responds with 404.
+func (c *Client) do(
+	ctx context.Context,
+	method, path string,
+	header map[string]string,
+	res interface{},
+	expectedStatuses ...int,
+) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	for _, status := range expectedStatuses {
+		if resp.StatusCode == status {
+			if res != nil {
+				if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+					return nil, fmt.Errorf("failed to decode response: %w", err)
+				}
+			}
+			return resp.Header, nil
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s: %w", method, path, ErrNotFound)
+	}
+
+	regErr := &Error{StatusCode: resp.StatusCode}
+	errRes := &errorResponse{}
+	if method != http.MethodHead && json.NewDecoder(resp.Body).Decode(errRes) == nil && len(errRes.Errors) > 0 {
+		regErr.Code = errRes.Errors[0].Code
+		regErr.Message = errRes.Errors[0].Message
+	}
+	return nil, regErr
+}
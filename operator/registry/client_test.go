@@ -0,0 +1,187 @@
+/*
+Copyright 2021 Codelogia
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/codelogia/manor/operator/registry"
+)
+
+// fakeRegistry is an in-memory registry serving the tags and manifests of a single repository.
+type fakeRegistry struct {
+	mu            sync.Mutex
+	repository    string
+	tags          map[string]string
+	deleteEnabled bool
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := "/v2/" + f.repository + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch {
+	case path == "tags/list" && r.Method == http.MethodGet:
+		var tags []string
+		for tag := range f.tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		if last := r.URL.Query().Get("last"); last != "" {
+			i := sort.SearchStrings(tags, last)
+			for i < len(tags) && tags[i] <= last {
+				i++
+			}
+			tags = tags[i:]
+		}
+		if n, _ := strconv.Atoi(r.URL.Query().Get("n")); n == 0 && len(tags) > 2 {
+			// The pages are limited to 2 tags to test the pagination.
+			tags = tags[:2]
+			w.Header().Set("Link", fmt.Sprintf(`<%stags/list?last=%s>; rel="next"`, prefix, tags[1]))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": f.repository, "tags": tags})
+	case strings.HasPrefix(path, "manifests/") && r.Method == http.MethodHead:
+		digest, ok := f.tags[strings.TrimPrefix(path, "manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	case strings.HasPrefix(path, "manifests/") && r.Method == http.MethodDelete:
+		if !f.deleteEnabled {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED")
+			return
+		}
+		digest := strings.TrimPrefix(path, "manifests/")
+		found := false
+		for tag, d := range f.tags {
+			if d == digest {
+				delete(f.tags, tag)
+				found = true
+			}
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": strings.ToLower(code)}},
+	})
+}
+
+var _ = Describe("Client", func() {
+	ctx := context.Background()
+
+	var fake *fakeRegistry
+	var server *httptest.Server
+	var client *registry.Client
+
+	BeforeEach(func() {
+		fake = &fakeRegistry{
+			repository: "ns/app",
+			tags: map[string]string{
+				"artifact-1": "sha256:1",
+				"artifact-2": "sha256:2",
+				"artifact-3": "sha256:3",
+				"latest":     "sha256:3",
+			},
+			deleteEnabled: true,
+		}
+		server = httptest.NewTLSServer(fake)
+		caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		var err error
+		client, err = registry.NewClient(strings.TrimPrefix(server.URL, "https://"), caBundle)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("rejects an invalid CA bundle", func() {
+		_, err := registry.NewClient("registry.example.com", []byte("invalid"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("lists the tags of a repository across pages", func() {
+		tags, err := client.Tags(ctx, "ns/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(ConsistOf("artifact-1", "artifact-2", "artifact-3", "latest"))
+	})
+
+	It("lists no tags for a repository that doesn't exist", func() {
+		tags, err := client.Tags(ctx, "ns/other")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(BeEmpty())
+	})
+
+	It("gets the digest of a tag", func() {
+		digest, err := client.Digest(ctx, "ns/app", "latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(digest).To(Equal("sha256:3"))
+
+		_, err = client.Digest(ctx, "ns/app", "missing")
+		Expect(err).To(MatchError(registry.ErrNotFound))
+	})
+
+	It("deletes a manifest with all its tags", func() {
+		Expect(client.DeleteManifest(ctx, "ns/app", "sha256:3")).To(Succeed())
+		Expect(fake.tags).To(HaveLen(2))
+		Expect(fake.tags).NotTo(HaveKey("latest"))
+
+		By("deleting it again")
+		Expect(client.DeleteManifest(ctx, "ns/app", "sha256:3")).To(Succeed())
+	})
+
+	It("returns the registry error when deletes are disabled", func() {
+		fake.deleteEnabled = false
+		err := client.DeleteManifest(ctx, "ns/app", "sha256:1")
+		var regErr *registry.Error
+		Expect(errors.As(err, &regErr)).To(BeTrue(), "unexpected error %v", err)
+		Expect(regErr.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		Expect(regErr.Code).To(Equal("UNSUPPORTED"))
+	})
+})